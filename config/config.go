@@ -6,6 +6,8 @@ import (
 	"io/ioutil"
 	"log"
 	"path/filepath"
+
+	"pproflame/profile"
 )
 
 // sourceConf 各服务 pprof 接口配置
@@ -13,12 +15,21 @@ type sourceConf struct {
 	Host string `json:"host"`
 	Port string `json:"port"`
 
+	UploadTokens []string `json:"upload_tokens"` // 允许向符号仓库上传文件的 token, 为空时不允许上传
+	MaxUpload    int64    `json:"max_upload"`    // 上传文件的大小上限, 单位字节, 0 表示默认的 1GB
+
 	Sources []struct {
-		Name    string `json:"name"`
-		Host    string `json:"host"`
-		Port    string `json:"port"`
-		IsInner bool   `json:"is_inner"`
-		Comment string `json:"comment"`
+		Name    string         `json:"name"`
+		Host    string         `json:"host"`
+		Port    string         `json:"port"`
+		IsInner bool           `json:"is_inner"`
+		Comment string         `json:"comment"`
+		Rules   []profile.Rule `json:"rules"` // 调用栈和标签的改写规则
+
+		SourceRoot string `json:"source_root"` // 服务源码目录, 作为 source 页面的 source_path
+		GitMirror  string `json:"git_mirror"`  // 服务的本地 git 镜像, 按 profile 记录的 revision 读取源码
+		History    string `json:"history"`     // 服务历史 profile 快照的 glob, 供 regressions 报告对比
+		DiffBase   string `json:"diff_base"`   // 对比基准 profile 的路径或 URL, 设置后火焰图为差分火焰图
 	} `json:"sources"`
 }
 
@@ -42,7 +53,11 @@ func LoadConfig() error {
 	}
 
 	for _, item := range Config.Sources {
-		log.Printf("%-16s: %-22s 内网接口: %-5v 备注: %s", item.Name, item.Host+":"+item.Port, item.IsInner, item.Comment)
+		log.Printf("%-16s: %-22s 内网接口: %-5v 规则: %-3d 备注: %s", item.Name, item.Host+":"+item.Port, item.IsInner, len(item.Rules), item.Comment)
+		if err := profile.CheckRules(item.Rules); err != nil {
+			log.Panicln("服务改写规则错误: ", item.Name, err)
+			return err
+		}
 	}
 
 	return nil
@@ -74,6 +89,46 @@ func GetServiceSource(serviceName string) (source string, err error) {
 	return source, err
 }
 
+// GetServiceRules 获取指定服务的调用栈和标签改写规则
+func GetServiceRules(serviceName string) []profile.Rule {
+	for _, v := range Config.Sources {
+		if v.Name == serviceName {
+			return v.Rules
+		}
+	}
+	return nil
+}
+
+// GetServiceDiffBase 获取指定服务对比基准 profile 的路径或 URL, 没有配置时为空
+func GetServiceDiffBase(serviceName string) string {
+	for _, v := range Config.Sources {
+		if v.Name == serviceName {
+			return v.DiffBase
+		}
+	}
+	return ""
+}
+
+// GetServiceVariables 获取指定服务报告变量的默认值, 如源码目录, git 镜像和历史快照
+func GetServiceVariables(serviceName string) map[string]string {
+	vars := make(map[string]string)
+	for _, v := range Config.Sources {
+		if v.Name == serviceName {
+			if v.SourceRoot != "" {
+				vars["source_path"] = v.SourceRoot
+			}
+			if v.GitMirror != "" {
+				vars["git_mirror"] = v.GitMirror
+			}
+			if v.History != "" {
+				vars["history"] = v.History
+			}
+			break
+		}
+	}
+	return vars
+}
+
 // GetHTTPServeHostPort 获取本 pprof 服务的 IP 和端口
 func GetHTTPServeHostPort() string {
 	return Config.Host + ":" + Config.Port
@@ -31,9 +31,15 @@ import (
 
 // SMMPProf acquires a profile, and symbolizes it using a profile
 // manager. Then it generates a report formatted according to the
-// options selected through the flags package.
-func SMMPProf(o *Options, source string, seconds int) (*internaldriver.WebInterface, error) {
-	return internaldriver.SMMPProf(o.internalOptions(), source, seconds)
+// options selected through the flags package. The rules are applied
+// to the profile after it is fetched, and defaults sets the values of
+// the report variables, such as source_path, that the request URLs do
+// not set. If diffBase is set, the profile is compared to the one it
+// names, as with -diff_base. The profile is saved under the name of the
+// service, whose saved profiles are the default history of the
+// regressions report.
+func SMMPProf(o *Options, service, source string, seconds int, rules []profile.Rule, defaults map[string]string, diffBase string) (*internaldriver.WebInterface, error) {
+	return internaldriver.SMMPProf(o.internalOptions(), service, source, seconds, rules, defaults, diffBase)
 }
 
 // SMMPProfRoot dot
@@ -46,6 +52,11 @@ func SMMPProfTop(ui *internaldriver.WebInterface, c *gin.Context) {
 	ui.Top(c)
 }
 
+// SMMPProfTopExport TopExport
+func SMMPProfTopExport(ui *internaldriver.WebInterface, c *gin.Context) {
+	ui.TopExport(c)
+}
+
 // SMMPProfDisasm disasm
 func SMMPProfDisasm(ui *internaldriver.WebInterface, c *gin.Context) {
 	ui.Disasm(c)
@@ -66,6 +77,41 @@ func SMMPProfFlamegraph(ui *internaldriver.WebInterface, c *gin.Context) {
 	ui.Flamegraph(c)
 }
 
+// SMMPProfFlamegraphSVG FlamegraphSVG
+func SMMPProfFlamegraphSVG(ui *internaldriver.WebInterface, c *gin.Context) {
+	ui.FlamegraphSVG(c)
+}
+
+// SMMPProfGraph InteractiveGraph
+func SMMPProfGraph(ui *internaldriver.WebInterface, c *gin.Context) {
+	ui.InteractiveGraph(c)
+}
+
+// SMMPProfGraphJSON GraphJSON
+func SMMPProfGraphJSON(ui *internaldriver.WebInterface, c *gin.Context) {
+	ui.GraphJSON(c)
+}
+
+// SMMPProfSandwich Sandwich
+func SMMPProfSandwich(ui *internaldriver.WebInterface, c *gin.Context) {
+	ui.Sandwich(c)
+}
+
+// SMMPProfPaths Paths
+func SMMPProfPaths(ui *internaldriver.WebInterface, c *gin.Context) {
+	ui.Paths(c)
+}
+
+// SMMPProfRegressions Regressions
+func SMMPProfRegressions(ui *internaldriver.WebInterface, c *gin.Context) {
+	ui.Regressions(c)
+}
+
+// SMMPProfRegressionsJSON RegressionsJSON
+func SMMPProfRegressionsJSON(ui *internaldriver.WebInterface, c *gin.Context) {
+	ui.RegressionsJSON(c)
+}
+
 // SMMCleanTempFiles 清临时文件
 func SMMCleanTempFiles() {
 	internaldriver.SMMCleanupTempFiles()
@@ -0,0 +1,357 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package binutils
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"debug/macho"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+
+	"pproflame/internal/plugin"
+)
+
+// nativeSymbolizer maps addresses to source frames in-process, using
+// the DWARF debug information and the symbol table of a binary, so no
+// external tool is needed.
+type nativeSymbolizer struct {
+	mu   sync.Mutex
+	data *dwarf.Data // nil if the binary has no debug information
+	base uint64
+
+	funcs []nativeFunc // DWARF subprograms, sorted by start address
+	syms  []nativeSym  // symbol table, sorted by address
+	lines map[dwarf.Offset]*lineTable
+}
+
+// nativeFunc is an address range of a DWARF subprogram.
+type nativeFunc struct {
+	low, high uint64
+	cu        *dwarf.Entry
+	offset    dwarf.Offset
+	name      string
+}
+
+// nativeSym is a symbol table entry. If size is zero, the symbol
+// extends to the next one.
+type nativeSym struct {
+	addr, size uint64
+	name       string
+}
+
+// lineTable holds the line number rows of a compilation unit, sorted
+// by address.
+type lineTable struct {
+	rows  []dwarf.LineEntry
+	files []*dwarf.LineFile
+}
+
+// newNativeSymbolizer reads the debug information and symbols of an
+// ELF or Mach-O binary. If the binary is a shared library, base should
+// be the address at which it was mapped in the program under
+// consideration.
+func newNativeSymbolizer(name string, base uint64) (*nativeSymbolizer, error) {
+	s := &nativeSymbolizer{base: base, lines: make(map[dwarf.Offset]*lineTable)}
+	if ef, err := elf.Open(name); err == nil {
+		defer ef.Close()
+		s.data, _ = ef.DWARF()
+		if syms, err := ef.Symbols(); err == nil {
+			for _, sym := range syms {
+				if elf.ST_TYPE(sym.Info) == elf.STT_FUNC && sym.Value != 0 {
+					s.syms = append(s.syms, nativeSym{sym.Value, sym.Size, sym.Name})
+				}
+			}
+		}
+	} else if mf, err := macho.Open(name); err == nil {
+		defer mf.Close()
+		s.data, _ = mf.DWARF()
+		if mf.Symtab != nil {
+			for _, sym := range mf.Symtab.Syms {
+				// Skip debugging entries, keep defined symbols in a section.
+				if sym.Type&0xe0 == 0 && sym.Type&0x0e == 0x0e && sym.Value != 0 {
+					s.syms = append(s.syms, nativeSym{addr: sym.Value, name: sym.Name})
+				}
+			}
+		}
+	} else {
+		return nil, fmt.Errorf("unrecognized binary: %s", name)
+	}
+	sort.SliceStable(s.syms, func(i, j int) bool { return s.syms[i].addr < s.syms[j].addr })
+	if s.data != nil {
+		s.indexFunctions()
+	}
+	return s, nil
+}
+
+// indexFunctions collects the address ranges of all subprograms.
+func (s *nativeSymbolizer) indexFunctions() {
+	r := s.data.Reader()
+	var cu *dwarf.Entry
+	for {
+		e, err := r.Next()
+		if err != nil || e == nil {
+			break
+		}
+		switch e.Tag {
+		case dwarf.TagCompileUnit:
+			cu = e
+		case dwarf.TagSubprogram:
+			ranges, err := s.data.Ranges(e)
+			if err == nil && len(ranges) > 0 {
+				name := s.entryName(e, 0)
+				for _, rg := range ranges {
+					s.funcs = append(s.funcs, nativeFunc{rg[0], rg[1], cu, e.Offset, name})
+				}
+			}
+			// Inlined calls are read on demand, when symbolizing.
+			if e.Children {
+				r.SkipChildren()
+			}
+		}
+	}
+	sort.SliceStable(s.funcs, func(i, j int) bool { return s.funcs[i].low < s.funcs[j].low })
+}
+
+// entryName returns the name of a subprogram or inlined subroutine,
+// preferring the linkage name, as addr2line does without demangling.
+func (s *nativeSymbolizer) entryName(e *dwarf.Entry, depth int) string {
+	for _, a := range []dwarf.Attr{dwarf.AttrLinkageName, 0x2007 /* DW_AT_MIPS_linkage_name */, dwarf.AttrName} {
+		if n, ok := e.Val(a).(string); ok && n != "" {
+			return n
+		}
+	}
+	if depth > 4 {
+		return ""
+	}
+	for _, a := range []dwarf.Attr{dwarf.AttrAbstractOrigin, dwarf.AttrSpecification} {
+		if off, ok := e.Val(a).(dwarf.Offset); ok {
+			r := s.data.Reader()
+			r.Seek(off)
+			if oe, err := r.Next(); err == nil && oe != nil {
+				return s.entryName(oe, depth+1)
+			}
+		}
+	}
+	return ""
+}
+
+// addrInfo returns the stack frame information for a specific program
+// address, innermost frame first. It returns nil if the address could
+// not be identified.
+func (s *nativeSymbolizer) addrInfo(addr uint64) ([]plugin.Frame, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pc := addr - s.base
+	if f := s.findFunc(pc); f != nil {
+		return s.inlineFrames(f, pc), nil
+	}
+	if sym := s.findSym(pc); sym != nil {
+		return []plugin.Frame{{Func: sym.name}}, nil
+	}
+	return nil, nil
+}
+
+func (s *nativeSymbolizer) findFunc(pc uint64) *nativeFunc {
+	i := sort.Search(len(s.funcs), func(i int) bool { return s.funcs[i].low > pc }) - 1
+	if i < 0 || pc >= s.funcs[i].high {
+		return nil
+	}
+	return &s.funcs[i]
+}
+
+func (s *nativeSymbolizer) findSym(pc uint64) *nativeSym {
+	i := sort.Search(len(s.syms), func(i int) bool { return s.syms[i].addr > pc }) - 1
+	if i < 0 {
+		return nil
+	}
+	sym := &s.syms[i]
+	switch {
+	case sym.size != 0 && pc >= sym.addr+sym.size:
+		return nil
+	case sym.size == 0 && i+1 == len(s.syms):
+		return nil
+	}
+	return sym
+}
+
+// inlineFrames returns the frames for pc within the subprogram f,
+// following its chain of inlined calls.
+func (s *nativeSymbolizer) inlineFrames(f *nativeFunc, pc uint64) []plugin.Frame {
+	type call struct {
+		name       string
+		file, line int64 // call site in the caller
+	}
+	chain := []call{{name: f.name}}
+
+	r := s.data.Reader()
+	r.Seek(f.offset)
+	if e, err := r.Next(); err == nil && e != nil && e.Children {
+	walk:
+		for {
+			e, err := r.Next()
+			if err != nil || e == nil || e.Tag == 0 {
+				// No deeper call contains pc.
+				break
+			}
+			if (e.Tag == dwarf.TagInlinedSubroutine || e.Tag == dwarf.TagLexDwarfBlock) && s.contains(e, pc) {
+				if e.Tag == dwarf.TagInlinedSubroutine {
+					file, _ := e.Val(dwarf.AttrCallFile).(int64)
+					line, _ := e.Val(dwarf.AttrCallLine).(int64)
+					chain = append(chain, call{s.entryName(e, 0), file, line})
+				}
+				if !e.Children {
+					break walk
+				}
+				// Descend into the children of e.
+				continue
+			}
+			if e.Children {
+				r.SkipChildren()
+			}
+		}
+	}
+
+	lt := s.lineTable(f.cu)
+	file, line := lt.find(pc)
+	frames := make([]plugin.Frame, len(chain))
+	for i := len(chain) - 1; i >= 0; i-- {
+		frames[len(chain)-1-i] = plugin.Frame{Func: chain[i].name, File: file, Line: line}
+		file, line = lt.fileName(chain[i].file), int(chain[i].line)
+	}
+	return frames
+}
+
+func (s *nativeSymbolizer) contains(e *dwarf.Entry, pc uint64) bool {
+	ranges, err := s.data.Ranges(e)
+	if err != nil {
+		return false
+	}
+	for _, rg := range ranges {
+		if rg[0] <= pc && pc < rg[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// lineTable returns the line table of a compilation unit, reading it on
+// first use.
+func (s *nativeSymbolizer) lineTable(cu *dwarf.Entry) *lineTable {
+	if cu == nil {
+		return &lineTable{}
+	}
+	if lt := s.lines[cu.Offset]; lt != nil {
+		return lt
+	}
+	lt := &lineTable{}
+	s.lines[cu.Offset] = lt
+	lr, err := s.data.LineReader(cu)
+	if err != nil || lr == nil {
+		return lt
+	}
+	lt.files = lr.Files()
+	for {
+		var le dwarf.LineEntry
+		if err := lr.Next(&le); err != nil {
+			break
+		}
+		lt.rows = append(lt.rows, le)
+	}
+	sort.SliceStable(lt.rows, func(i, j int) bool { return lt.rows[i].Address < lt.rows[j].Address })
+	return lt
+}
+
+// find returns the file and line of the row covering pc.
+func (lt *lineTable) find(pc uint64) (string, int) {
+	i := sort.Search(len(lt.rows), func(i int) bool { return lt.rows[i].Address > pc }) - 1
+	// An end of sequence row may share its address with the start of
+	// the next sequence; prefer the latter.
+	for ; i >= 0 && lt.rows[i].EndSequence; i-- {
+		if lt.rows[i].Address < pc {
+			return "", 0
+		}
+	}
+	if i < 0 || lt.rows[i].File == nil {
+		return "", 0
+	}
+	return lt.rows[i].File.Name, lt.rows[i].Line
+}
+
+func (lt *lineTable) fileName(index int64) string {
+	if index < 0 || index >= int64(len(lt.files)) || lt.files[index] == nil {
+		return ""
+	}
+	return lt.files[index].Name
+}
+
+// symbols returns the symbols matching r or containing address, as
+// findSymbols does for the output of nm.
+func (s *nativeSymbolizer) symbols(file string, r *regexp.Regexp, address uint64) []*plugin.Sym {
+	var symbols []*plugin.Sym
+	for i := 0; i < len(s.syms); {
+		start := s.syms[i].addr
+		var names []string
+		end := start + s.syms[i].size
+		for ; i < len(s.syms) && s.syms[i].addr == start; i++ {
+			names = append(names, s.syms[i].name)
+			if e := start + s.syms[i].size; e > end {
+				end = e
+			}
+		}
+		if end == start && i < len(s.syms) {
+			end = s.syms[i].addr
+		}
+		if end == start {
+			end = start + 1
+		}
+		if match := matchSymbol(names, start, end-1, r, address); match != nil {
+			symbols = append(symbols, &plugin.Sym{Name: match, File: file, Start: start, End: end - 1})
+		}
+	}
+	return symbols
+}
+
+// fileNative implements the binutils.ObjFile interface with the
+// in-process nativeSymbolizer.
+type fileNative struct {
+	once sync.Once
+	file
+	sym *nativeSymbolizer
+	err error
+}
+
+func (f *fileNative) init() {
+	f.sym, f.err = newNativeSymbolizer(f.name, f.base)
+}
+
+func (f *fileNative) SourceLine(addr uint64) ([]plugin.Frame, error) {
+	f.once.Do(f.init)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.sym.addrInfo(addr)
+}
+
+func (f *fileNative) Symbols(r *regexp.Regexp, addr uint64) ([]*plugin.Sym, error) {
+	f.once.Do(f.init)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.sym.symbols(f.name, r, addr), nil
+}
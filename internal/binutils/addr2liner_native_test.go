@@ -0,0 +1,72 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package binutils
+
+import (
+	"math"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"pproflame/internal/plugin"
+)
+
+func TestNativeSymbolizer(t *testing.T) {
+	for _, tc := range []struct {
+		desc                 string
+		start, limit, offset uint64
+		addr                 uint64
+	}{
+		{"fake mapping", 0, math.MaxUint64, 0, 0x40052d},
+		{"simulated ASLR address", 0x500000, 0x5006fc, 0, 0x50052d},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			bu := &Binutils{}
+			bu.SetNativeSymbolization(true)
+			f, err := bu.Open(filepath.Join("testdata", "exe_linux_64"), tc.start, tc.limit, tc.offset)
+			if err != nil {
+				t.Fatalf("Open: unexpected error %v", err)
+			}
+			defer f.Close()
+			if _, ok := f.(*fileNative); !ok {
+				t.Fatalf("Open: got %T, want *fileNative", f)
+			}
+			syms, err := f.Symbols(regexp.MustCompile("^main$"), 0)
+			if err != nil {
+				t.Fatalf("Symbols: unexpected error %v", err)
+			}
+			m := findSymbol(syms, "main")
+			if m == nil {
+				t.Fatalf("Symbols: did not find main")
+			}
+			wantFrames := []plugin.Frame{
+				{Func: "main", File: "/tmp/hello.c", Line: 3},
+			}
+			for _, addr := range []uint64{m.Start + f.Base(), tc.addr} {
+				gotFrames, err := f.SourceLine(addr)
+				if err != nil {
+					t.Fatalf("SourceLine: unexpected error %v", err)
+				}
+				if !reflect.DeepEqual(gotFrames, wantFrames) {
+					t.Errorf("SourceLine(%#x): got %v; want %v", addr, gotFrames, wantFrames)
+				}
+			}
+			if frames, _ := f.SourceLine(tc.start + 0x10); len(frames) != 0 {
+				t.Errorf("SourceLine outside of any function: got %v", frames)
+			}
+		})
+	}
+}
@@ -28,6 +28,7 @@ import (
 
 	"pproflame/internal/elfexec"
 	"pproflame/internal/plugin"
+	"pproflame/internal/symbolstore"
 )
 
 // A Binutils implements plugin.ObjTool by invoking the GNU binutils.
@@ -52,6 +53,12 @@ type binrep struct {
 	// if fast, perform symbolization using nm (symbol names only),
 	// instead of file-line detail from the slower addr2line.
 	fast bool
+	// if native, perform symbolization in-process from the DWARF
+	// information, instead of invoking addr2line or llvm-symbolizer.
+	native bool
+
+	// store is consulted to open binaries named by their build ID.
+	store *symbolstore.Store
 }
 
 // get returns the current representation for bu, initializing it if necessary.
@@ -59,7 +66,7 @@ func (bu *Binutils) get() *binrep {
 	bu.mu.Lock()
 	r := bu.rep
 	if r == nil {
-		r = &binrep{}
+		r = &binrep{store: symbolstore.FromEnv()}
 		initTools(r, "")
 		bu.rep = r
 	}
@@ -73,6 +80,7 @@ func (bu *Binutils) update(fn func(r *binrep)) {
 	bu.mu.Lock()
 	defer bu.mu.Unlock()
 	if bu.rep == nil {
+		r.store = symbolstore.FromEnv()
 		initTools(r, "")
 	} else {
 		*r = *bu.rep
@@ -97,8 +105,8 @@ func (bu *Binutils) String() string {
 	if r.objdumpFound {
 		objdump = r.objdump
 	}
-	return fmt.Sprintf("llvm-symbolizer=%q addr2line=%q nm=%q objdump=%q fast=%t",
-		llvmSymbolizer, addr2line, nm, objdump, r.fast)
+	return fmt.Sprintf("llvm-symbolizer=%q addr2line=%q nm=%q objdump=%q fast=%t native=%t",
+		llvmSymbolizer, addr2line, nm, objdump, r.fast, r.native)
 }
 
 // SetFastSymbolization sets a toggle that makes binutils use fast
@@ -108,6 +116,15 @@ func (bu *Binutils) SetFastSymbolization(fast bool) {
 	bu.update(func(r *binrep) { r.fast = fast })
 }
 
+// SetNativeSymbolization sets a toggle that makes binutils symbolize
+// in-process, reading the DWARF debug information of the binaries
+// instead of invoking addr2line or llvm-symbolizer, and disassemble
+// in-process instead of invoking objdump. Native symbolization is also
+// used when neither tool is installed.
+func (bu *Binutils) SetNativeSymbolization(native bool) {
+	bu.update(func(r *binrep) { r.native = native })
+}
+
 // SetTools processes the contents of the tools option. It
 // expects a set of entries separated by commas; each entry is a pair
 // of the form t:path, where cmd will be used to look only for the
@@ -117,6 +134,13 @@ func (bu *Binutils) SetTools(config string) {
 	bu.update(func(r *binrep) { initTools(r, config) })
 }
 
+// SetSymbolStore sets the store used to open binaries named by their
+// build ID. By default, the store is selected by the environment, as
+// described in symbolstore.FromEnv. A nil store disables the lookups.
+func (bu *Binutils) SetSymbolStore(s *symbolstore.Store) {
+	bu.update(func(r *binrep) { r.store = s })
+}
+
 func initTools(b *binrep, config string) {
 	// paths collect paths per tool; Key "" contains the default.
 	paths := make(map[string][]string)
@@ -153,19 +177,34 @@ func findExe(cmd string, paths []string) (string, bool) {
 }
 
 // Disasm returns the assembly instructions for the specified address range
-// of a binary.
+// of a binary. With native symbolization, or if objdump is missing or
+// cannot disassemble the binary, amd64 and arm64 binaries are
+// disassembled in-process.
 func (bu *Binutils) Disasm(file string, start, end uint64) ([]plugin.Inst, error) {
 	b := bu.get()
+	if b.native || !b.objdumpFound {
+		return disassembleNative(file, start, end)
+	}
 	cmd := exec.Command(b.objdump, "-d", "-C", "--no-show-raw-insn", "-l",
 		fmt.Sprintf("--start-address=%#x", start),
 		fmt.Sprintf("--stop-address=%#x", end),
 		file)
 	out, err := cmd.Output()
 	if err != nil {
+		if insts, nerr := disassembleNative(file, start, end); nerr == nil {
+			return insts, nil
+		}
 		return nil, fmt.Errorf("%v: %v", cmd.Args, err)
 	}
 
-	return disassemble(out)
+	insts, err := disassemble(out)
+	if err == nil && len(insts) == 0 {
+		// objdump prints nothing for architectures it does not know.
+		if native, nerr := disassembleNative(file, start, end); nerr == nil {
+			return native, nil
+		}
+	}
+	return insts, err
 }
 
 // Open satisfies the plugin.ObjTool interface.
@@ -185,7 +224,15 @@ func (bu *Binutils) Open(name string, start, limit, offset uint64) (plugin.ObjFi
 		if strings.Contains(b.addr2line, "testdata/") {
 			return &fileAddr2Line{file: file{b: b, name: name}}, nil
 		}
-		return nil, err
+		// A build ID names a file in the symbol store.
+		if !symbolstore.IsBuildID(strings.ToLower(name)) || b.store == nil {
+			return nil, err
+		}
+		path, serr := b.store.Lookup(name)
+		if serr != nil {
+			return nil, serr
+		}
+		name = path
 	}
 
 	if f, err := b.openELF(name, start, limit, offset); err == nil {
@@ -219,10 +266,7 @@ func (b *binrep) openMachO(name string, start, limit, offset uint64) (plugin.Obj
 
 	base := start - textSegment.Addr
 
-	if b.fast || (!b.addr2lineFound && !b.llvmSymbolizerFound) {
-		return &fileNM{file: file{b: b, name: name, base: base}}, nil
-	}
-	return &fileAddr2Line{file: file{b: b, name: name, base: base}}, nil
+	return b.objFile(file{b: b, name: name, base: base}), nil
 }
 
 func (b *binrep) openELF(name string, start, limit, offset uint64) (plugin.ObjFile, error) {
@@ -266,10 +310,19 @@ func (b *binrep) openELF(name string, start, limit, offset uint64) (plugin.ObjFi
 			buildID = fmt.Sprintf("%x", id)
 		}
 	}
-	if b.fast || (!b.addr2lineFound && !b.llvmSymbolizerFound) {
-		return &fileNM{file: file{b, name, base, buildID}}, nil
+	return b.objFile(file{b, name, base, buildID}), nil
+}
+
+// objFile returns the ObjFile implementation selected by the
+// symbolization toggles and the tools available.
+func (b *binrep) objFile(f file) plugin.ObjFile {
+	switch {
+	case b.fast && b.nmFound:
+		return &fileNM{file: f}
+	case b.native || b.fast || (!b.addr2lineFound && !b.llvmSymbolizerFound):
+		return &fileNative{file: f}
 	}
-	return &fileAddr2Line{file: file{b, name, base, buildID}}, nil
+	return &fileAddr2Line{file: f}
 }
 
 // file implements the binutils.ObjFile interface.
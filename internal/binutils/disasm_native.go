@@ -0,0 +1,149 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package binutils
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"fmt"
+	"strings"
+
+	"github.com/ianlancetaylor/demangle"
+	"golang.org/x/arch/arm64/arm64asm"
+	"golang.org/x/arch/x86/x86asm"
+	"pproflame/internal/plugin"
+)
+
+// decoder decodes the instruction at the start of code, located at pc,
+// and returns its text and size. Undecodable bytes are returned as
+// "(bad)", as objdump does.
+type decoder func(code []byte, pc uint64, symname func(uint64) (string, uint64)) (string, int)
+
+func decodeAMD64(code []byte, pc uint64, symname func(uint64) (string, uint64)) (string, int) {
+	inst, err := x86asm.Decode(code, 64)
+	if err != nil || inst.Len == 0 {
+		return "(bad)", 1
+	}
+	return x86asm.GNUSyntax(inst, pc, symname), inst.Len
+}
+
+func decodeARM64(code []byte, pc uint64, symname func(uint64) (string, uint64)) (string, int) {
+	if len(code) < 4 {
+		return "(bad)", len(code)
+	}
+	inst, err := arm64asm.Decode(code)
+	if err != nil {
+		return "(bad)", 4
+	}
+	// GNUSyntax pads instructions without operands with a space.
+	return strings.TrimSpace(arm64asm.GNUSyntax(inst)), 4
+}
+
+// disassembleNative disassembles the address range [start, end] of a
+// binary in-process, so neither objdump nor a disassembler for the
+// architecture of the binary is needed. Like objdump -l, it annotates
+// the instructions with their innermost function, file and line, read
+// from the debug information.
+func disassembleNative(name string, start, end uint64) ([]plugin.Inst, error) {
+	code, addr, dec, err := readCode(name, start, end)
+	if err != nil {
+		return nil, err
+	}
+	s, err := newNativeSymbolizer(name, 0)
+	if err != nil {
+		return nil, err
+	}
+	symname := func(a uint64) (string, uint64) {
+		if sym := s.findSym(a); sym != nil {
+			return sym.name, sym.addr
+		}
+		return "", 0
+	}
+
+	var insts []plugin.Inst
+	for len(code) > 0 {
+		text, size := dec(code, addr, symname)
+		in := plugin.Inst{Addr: addr, Text: text}
+		if frames, err := s.addrInfo(addr); err == nil && len(frames) > 0 {
+			in.Function = demangle.Filter(frames[0].Func)
+			in.File, in.Line = frames[0].File, frames[0].Line
+		}
+		insts = append(insts, in)
+		code, addr = code[size:], addr+uint64(size)
+	}
+	return insts, nil
+}
+
+// readCode returns the bytes of the address range [start, end] of a
+// binary, truncated to the section holding start, with the address they
+// start at and the decoder for the architecture of the binary.
+func readCode(name string, start, end uint64) ([]byte, uint64, decoder, error) {
+	if end < start {
+		return nil, 0, nil, fmt.Errorf("invalid address range %#x-%#x", start, end)
+	}
+	if ef, err := elf.Open(name); err == nil {
+		defer ef.Close()
+		var dec decoder
+		switch ef.Machine {
+		case elf.EM_X86_64:
+			dec = decodeAMD64
+		case elf.EM_AARCH64:
+			dec = decodeARM64
+		default:
+			return nil, 0, nil, fmt.Errorf("%s: cannot disassemble %v binaries", name, ef.Machine)
+		}
+		for _, sec := range ef.Sections {
+			if sec.Type != elf.SHT_PROGBITS || sec.Flags&elf.SHF_EXECINSTR == 0 || start < sec.Addr || start-sec.Addr >= sec.Size {
+				continue
+			}
+			code, err := readRange(sec.ReadAt, sec.Addr, sec.Size, start, end)
+			return code, start, dec, err
+		}
+	} else if mf, err := macho.Open(name); err == nil {
+		defer mf.Close()
+		var dec decoder
+		switch mf.Cpu {
+		case macho.CpuAmd64:
+			dec = decodeAMD64
+		case macho.CpuArm64:
+			dec = decodeARM64
+		default:
+			return nil, 0, nil, fmt.Errorf("%s: cannot disassemble %v binaries", name, mf.Cpu)
+		}
+		for _, sec := range mf.Sections {
+			if start < sec.Addr || start-sec.Addr >= sec.Size {
+				continue
+			}
+			code, err := readRange(sec.ReadAt, sec.Addr, sec.Size, start, end)
+			return code, start, dec, err
+		}
+	} else {
+		return nil, 0, nil, fmt.Errorf("unrecognized binary: %s", name)
+	}
+	return nil, 0, nil, fmt.Errorf("%s: no code at %#x", name, start)
+}
+
+// readRange reads [start, end] from a section of size bytes at addr.
+func readRange(readAt func([]byte, int64) (int, error), addr, size, start, end uint64) ([]byte, error) {
+	n := size - (start - addr)
+	if end-start < n {
+		n = end - start + 1
+	}
+	code := make([]byte, n)
+	if _, err := readAt(code, int64(start-addr)); err != nil {
+		return nil, err
+	}
+	return code, nil
+}
@@ -16,7 +16,9 @@ package binutils
 
 import (
 	"fmt"
+	"path/filepath"
 	"regexp"
+	"strings"
 	"testing"
 
 	"pproflame/internal/plugin"
@@ -150,3 +152,49 @@ func TestFunctionAssembly(t *testing.T) {
 		}
 	}
 }
+
+func TestDisassembleNative(t *testing.T) {
+	bu := &Binutils{}
+	bu.SetNativeSymbolization(true)
+	// main of exe_linux_64: push, mov, mov, callq, pop, retq.
+	insts, err := bu.Disasm(filepath.Join("testdata", "exe_linux_64"), 0x40052d, 0x40053c)
+	if err != nil {
+		t.Fatalf("Disasm: unexpected error %v", err)
+	}
+	if len(insts) != 6 {
+		t.Fatalf("Disasm: got %d instructions, want 6: %v", len(insts), insts)
+	}
+	wantAddrs := []uint64{0x40052d, 0x40052e, 0x400531, 0x400536, 0x40053b, 0x40053c}
+	for i, in := range insts {
+		if in.Addr != wantAddrs[i] {
+			t.Errorf("instruction %d: got address %#x, want %#x", i, in.Addr, wantAddrs[i])
+		}
+		if in.Function != "main" || in.File != "/tmp/hello.c" || in.Line == 0 {
+			t.Errorf("instruction %d: got %s %s:%d, want main /tmp/hello.c", i, in.Function, in.File, in.Line)
+		}
+	}
+	if got := insts[0].Text; got != "push %rbp" {
+		t.Errorf("first instruction: got %q, want %q", got, "push %rbp")
+	}
+	if got := insts[5].Text; !strings.HasPrefix(got, "ret") {
+		t.Errorf("last instruction: got %q, want ret", got)
+	}
+}
+
+func TestDecodeARM64(t *testing.T) {
+	code := []byte{
+		0x1f, 0x20, 0x03, 0xd5, // nop
+		0x20, 0x00, 0x02, 0x8b, // add x0, x1, x2
+		0xc0, 0x03, 0x5f, 0xd6, // ret
+		0xff, 0xff,
+	}
+	var got []string
+	for len(code) > 0 {
+		text, size := decodeARM64(code, 0, nil)
+		got = append(got, text)
+		code = code[size:]
+	}
+	if want := []string{"nop", "add x0, x1, x2", "ret", "(bad)"}; strings.Join(got, ";") != strings.Join(want, ";") {
+		t.Errorf("decodeARM64: got %q, want %q", got, want)
+	}
+}
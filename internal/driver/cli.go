@@ -17,11 +17,13 @@ package driver
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"strings"
 
 	"pproflame/internal/binutils"
 	"pproflame/internal/plugin"
+	"pproflame/profile"
 )
 
 type source struct {
@@ -37,6 +39,8 @@ type source struct {
 	Symbolize    string
 	HTTPHostport string
 	Comment      string
+	Rules        []profile.Rule
+	Service      string // Service the profile is sampled from, named in its saved files
 }
 
 // smmParseFlags 通过 http param 的方式来获取参数, 并改变默认值
@@ -50,6 +54,7 @@ func smmParseFlags(o *plugin.Options) (*source, []string, error) {
 	flagBuildID := flag.String("buildid", "", "Override build id for first mapping")
 	flagTimeout := flag.Int("timeout", -1, "Timeout in seconds for fetching a profile")
 	flagAddComment := flag.String("add_comment", "", "Annotation string to record in the profile")
+	flagRules := flag.String("rules", "", "File with rules to rewrite the profile stacks and labels")
 	// CPU profile options
 	flagSeconds := flag.Int("seconds", 30, "Length of time for dynamic profiles")
 	// Heap profile options
@@ -151,6 +156,10 @@ func smmParseFlags(o *plugin.Options) (*source, []string, error) {
 	}
 	source.Normalize = normalize
 
+	if source.Rules, err = readRules(*flagRules); err != nil {
+		return nil, nil, err
+	}
+
 	if bu, ok := o.Obj.(*binutils.Binutils); ok {
 		bu.SetTools(*flagTools)
 	}
@@ -170,6 +179,7 @@ func parseFlags(o *plugin.Options) (*source, []string, error) {
 	flagBuildID := flag.String("buildid", "", "Override build id for first mapping")
 	flagTimeout := flag.Int("timeout", -1, "Timeout in seconds for fetching a profile")
 	flagAddComment := flag.String("add_comment", "", "Annotation string to record in the profile")
+	flagRules := flag.String("rules", "", "File with rules to rewrite the profile stacks and labels")
 	// CPU profile options
 	flagSeconds := flag.Int("seconds", -1, "Length of time for dynamic profiles")
 	// Heap profile options
@@ -271,12 +281,32 @@ func parseFlags(o *plugin.Options) (*source, []string, error) {
 	}
 	source.Normalize = normalize
 
+	if source.Rules, err = readRules(*flagRules); err != nil {
+		return nil, nil, err
+	}
+
 	if bu, ok := o.Obj.(*binutils.Binutils); ok {
 		bu.SetTools(*flagTools)
 	}
 	return source, cmd, nil
 }
 
+// readRules reads the rules in the named file, if any.
+func readRules(file string) ([]profile.Rule, error) {
+	if file == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := profile.ParseRules(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return rules, nil
+}
+
 // addBaseProfiles adds the list of base profiles or diff base profiles to
 // the source. This function will return an error if both base and diff base
 // profiles are specified.
@@ -431,6 +461,8 @@ var usageMsgSrc = "\n\n" +
 	"    -add_comment          Free-form annotation to add to the profile\n" +
 	"                          Displayed on some reports or with pprof -comments\n" +
 	"    -base source          Source of profile to use as baseline\n" +
+	"    -rules file           JSON rules to rename, collapse or drop frames\n" +
+	"                          and rewrite labels after fetching the profile\n" +
 	"    profile.pb.gz         Profile in compressed protobuf format\n" +
 	"    legacy_profile        Profile in legacy pprof format\n" +
 	"    http://host/profile   URL for profile handler to retrieve\n" +
@@ -438,8 +470,11 @@ var usageMsgSrc = "\n\n" +
 	"      none                  Do not attempt symbolization\n" +
 	"      local                 Examine only local binaries\n" +
 	"      fastlocal             Only get function names from local binaries\n" +
+	"      native                Read local binaries in-process, without binutils\n" +
 	"      remote                Do not examine local binaries\n" +
 	"      force                 Force re-symbolization\n" +
+	"      batch=n               Send at most n addresses per symbolization request\n" +
+	"      retries=n             Retry timed out or failed (5xx) requests n times\n" +
 	"    Binary                  Local path or build id of binary for symbolization\n"
 
 var usageMsgVars = "\n\n" +
@@ -464,4 +499,12 @@ var usageMsgVars = "\n\n" +
 	"   PPROF_BINARY_PATH  Search path for local binary files\n" +
 	"                      default: $HOME/pprof/binaries\n" +
 	"                      searches $name, $path, $buildid/$name, $path/$buildid\n" +
+	"   PPROF_SYMBOL_STORE Directory of binaries indexed by build id\n" +
+	"                      default: $HOME/pprof/symbols\n" +
+	"                      searches buildid/$buildid/executable, buildid/$buildid/debuginfo\n" +
+	"   DEBUGINFOD_URLS    Space-separated debuginfod servers to fetch missing binaries from\n" +
+	"   PPROF_SYMBOL_CACHE Directory caching symbolization results by build id\n" +
+	"                      default: $HOME/pprof/symbolcache, none to disable\n" +
+	"   PPROF_KALLSYMS     Kallsyms file resolving kernel addresses\n" +
+	"                      default: buildid/$buildid/kallsyms in the symbol store\n" +
 	"   * On Windows, %USERPROFILE% is used instead of $HOME"
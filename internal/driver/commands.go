@@ -86,9 +86,11 @@ var interactiveMode = false
 var PProfCommands = commands{
 	// Commands that require no post-processing.
 	"comments": {report.Comments, nil, nil, false, "Output all profile comments", ""},
+	"csv":      {report.CSV, nil, nil, false, "Outputs top entries in CSV format", reportHelp("csv", true, true)},
 	"disasm":   {report.Dis, nil, nil, true, "Output assembly listings annotated with samples", listHelp("disasm", true)},
 	"dot":      {report.Dot, nil, nil, false, "Outputs a graph in DOT format", reportHelp("dot", false, true)},
 	"list":     {report.List, nil, nil, true, "Output annotated source for functions matching regexp", listHelp("list", false)},
+	"paths":    {report.Paths, nil, nil, false, "Outputs the heaviest call stacks in text form", reportHelp("paths", false, true)},
 	"peek":     {report.Tree, nil, nil, true, "Output callers/callees of functions matching regexp", "peek func_regex\nDisplay callers and callees of functions matching func_regex."},
 	"raw":      {report.Raw, nil, nil, false, "Outputs a text representation of the raw profile", ""},
 	"tags":     {report.Tags, nil, nil, false, "Outputs all tags in the profile", "tags [tag_regex]* [-ignore_regex]* [>file]\nList tags with key:value matching tag_regex and exclude ignore_regex."},
@@ -96,11 +98,17 @@ var PProfCommands = commands{
 	"top":      {report.Text, nil, nil, false, "Outputs top entries in text form", reportHelp("top", true, true)},
 	"traces":   {report.Traces, nil, nil, false, "Outputs all profile samples in text form", ""},
 	"tree":     {report.Tree, nil, nil, false, "Outputs a text rendering of call graph", reportHelp("tree", true, true)},
+	"tsv":      {report.TSV, nil, nil, false, "Outputs top entries in TSV format", reportHelp("tsv", true, true)},
+	"validate": {report.Validate, nil, nil, false, "Outputs all structural problems in the profile", "validate [>file]\nList dangling IDs, mismatched value counts, overlapping mappings and other problems.\nMalformed profiles are repaired when loaded, and the problems repaired are listed too."},
+
+	// Compare with earlier snapshots of the profile.
+	"regressions": {report.Regressions, nil, nil, false, "Outputs the entries whose share grew over earlier snapshots", reportHelp("regressions", false, true)},
 
 	// Save binary formats to a file
 	"callgrind": {report.Callgrind, nil, awayFromTTY("callgraph.out"), false, "Outputs a graph in callgrind format", reportHelp("callgrind", false, true)},
 	"proto":     {report.Proto, nil, awayFromTTY("pb.gz"), false, "Outputs the profile in compressed protobuf format", ""},
 	"topproto":  {report.TopProto, nil, awayFromTTY("pb.gz"), false, "Outputs top entries in compressed protobuf format", ""},
+	"redact":    {report.Redact, nil, awayFromTTY("pb.gz"), false, "Outputs a redacted profile in compressed protobuf format", "redact [>file]\nHash the parts of names and tag values selected by the redact_* options.\nBuild IDs and comments are removed. Stacks and values are kept intact."},
 
 	// Generate report in DOT format and postprocess with dot
 	"gif": {report.Dot, invokeDot("gif"), awayFromTTY("gif"), false, "Outputs a graph image in GIF format", reportHelp("gif", false, true)},
@@ -109,7 +117,8 @@ var PProfCommands = commands{
 	"ps":  {report.Dot, invokeDot("ps"), awayFromTTY("ps"), false, "Outputs a graph in PS format", reportHelp("ps", false, true)},
 
 	// Save SVG output into a file
-	"svg": {report.Dot, massageDotSVG(), awayFromTTY("svg"), false, "Outputs a graph in SVG format", reportHelp("svg", false, true)},
+	"svg":        {report.Dot, massageDotSVG(), awayFromTTY("svg"), false, "Outputs a graph in SVG format", reportHelp("svg", false, true)},
+	"flamegraph": {report.FlameGraph, nil, awayFromTTY("svg"), false, "Outputs a flame graph in SVG format", reportHelp("flamegraph", false, true)},
 
 	// Visualize postprocessed dot output
 	"eog":    {report.Dot, invokeDot("svg"), invokeVisualizer("svg", []string{"eog"}), false, "Visualize graph through eog", reportHelp("eog", false, false)},
@@ -154,6 +163,14 @@ var PProfVariables = variables{
 	"compact_labels": &variable{boolKind, "f", "", "Show minimal headers"},
 	"source_path":    &variable{stringKind, "", "", "Search path for source files"},
 	"trim_path":      &variable{stringKind, "", "", "Path to trim from source paths before search"},
+	"git_mirror": &variable{stringKind, "", "", helpText(
+		"Git repository to read missing source files from",
+		"Files are read at the revision recorded in the profile comments,",
+		"as \"revision: <commit>\".")},
+	"highlight": &variable{stringKind, "", "", helpText(
+		"Regexp of frames to highlight in flame graphs",
+		"Frames whose name matches are drawn in a distinct color, and",
+		"the flame graph header tells the total value of their samples.")},
 
 	// Filtering options
 	"nodecount": &variable{intKind, "-1", "", helpText(
@@ -206,6 +223,54 @@ var PProfVariables = variables{
 	"taghide": &variable{stringKind, "", "", helpText(
 		"Skip tags matching this regexp",
 		"Discard tags that match this regexp")},
+	"time_range": &variable{stringKind, "", "", helpText(
+		"Restricts to samples collected within a time window",
+		"Use from:to, with durations relative to the start of the profile.",
+		"Either side may be omitted. Examples: 5s:10s, 1m:, :30s",
+		"Samples without a timestamp label are discarded.")},
+	"groupby": &variable{stringKind, "", "", helpText(
+		"Groups samples by the values of these labels",
+		"Use a comma-separated list of label keys. Each sample gets a",
+		"synthetic root frame key=value per key, first key outermost.",
+		"Samples without the label are grouped under key=(none).",
+		"Sort by cum (top -cum) for a table of totals per label value.")},
+	// Redaction options
+	"redact_functions": &variable{stringKind, "", "", helpText(
+		"Parts of function names to redact",
+		"Used by the redact command. Each match of this regexp is replaced",
+		"by a hash of its text, so distinct names remain distinct.")},
+	"redact_files": &variable{stringKind, "^.*/", "", helpText(
+		"Parts of source file names to redact",
+		"Used by the redact command. By default, hashes the directories.")},
+	"redact_mappings": &variable{stringKind, "^.*/", "", helpText(
+		"Parts of mapping file names to redact",
+		"Used by the redact command. By default, hashes the directories.")},
+	"redact_labels": &variable{stringKind, ".*", "", helpText(
+		"Tags whose values are redacted",
+		"Used by the redact command. The values of the string tags with",
+		"a key matching this regexp are hashed.")},
+	"redact_numeric_labels": &variable{stringKind, "", "", helpText(
+		"Numeric tags whose keys and units are redacted",
+		"Used by the redact command. The keys and units of the numeric",
+		"tags matching this regexp are hashed, and their values kept.",
+		"By default, none are, so that time_range and tag units still work.")},
+	"redact_salt": &variable{stringKind, "", "", helpText(
+		"Secret mixed into redaction hashes",
+		"Prevents recovering redacted names by hashing guesses.",
+		"Use the same salt to compare profiles redacted separately.")},
+
+	// Regression detection options
+	"history": &variable{stringKind, "", "", helpText(
+		"Snapshots compared by the regressions report",
+		"A glob of profile files. The profile is compared with the ones before it.",
+		"By default, the profiles saved in $PPROF_TMPDIR for the same binary",
+		"and sample types as the profile.")},
+	"history_size": &variable{intKind, "10", "", helpText(
+		"Number of earlier snapshots compared by the regressions report")},
+	"regression_score": &variable{floatKind, "3", "", helpText(
+		"Smallest increase shown by the regressions report",
+		"In standard deviations of the share of an entry in the earlier snapshots.")},
+
 	// Heap profile options
 	"divide_by": &variable{floatKind, "1", "", helpText(
 		"Ratio to divide all samples before visualization",
@@ -218,12 +283,22 @@ var PProfVariables = variables{
 		"Sample value to report (0-based index or name)",
 		"Profiles contain multiple values per sample.",
 		"Use sample_index=i to select the ith value (starting at 0).")},
+	"columns": &variable{stringKind, "", "", helpText(
+		"Other sample values to show in text reports",
+		"Comma-separated list of sample values, as for sample_index,",
+		"shown in columns of their own. Prefix a value with mean_ for",
+		"its average over the first value, e.g. mean_alloc_space.")},
 	"normalize": &variable{boolKind, "f", "", helpText(
 		"Scales profile based on the base profile.")},
 
 	// Data sorting criteria
 	"flat": &variable{boolKind, "t", "cumulative", helpText("Sort entries based on own weight")},
 	"cum":  &variable{boolKind, "f", "cumulative", helpText("Sort entries based on cumulative weight")},
+	"sort_column": &variable{stringKind, "", "", helpText(
+		"Column to sort text reports by",
+		"One of flat, cum and name, or <value> and <value>.cum for the",
+		"flat and cumulative values of a sample value of the columns option.",
+		"If unset, entries are sorted based on flat or cum.")},
 
 	// Output granularity
 	"functions": &variable{boolKind, "t", "granularity", helpText(
@@ -231,6 +306,15 @@ var PProfVariables = variables{
 		"Takes into account the filename/lineno where the function was defined.")},
 	"files": &variable{boolKind, "f", "granularity", "Aggregate at the file level."},
 	"lines": &variable{boolKind, "f", "granularity", "Aggregate at the source code line level."},
+	"packages": &variable{boolKind, "f", "granularity", helpText(
+		"Aggregate at the Go package level.",
+		"Symbols that are not Go symbols are aggregated at the function level.")},
+	"modules": &variable{boolKind, "f", "granularity", helpText(
+		"Aggregate at the Go module level.",
+		"Modules are guessed from the import paths of the packages.")},
+	"mappings": &variable{boolKind, "f", "granularity", helpText(
+		"Aggregate at the mapping level.",
+		"Merges the frames of each binary or shared object.")},
 	"addresses": &variable{boolKind, "f", "granularity", helpText(
 		"Aggregate at the function level.",
 		"Includes functions' addresses in the output.")},
@@ -385,6 +469,24 @@ func invokeDot(format string) PostProcessor {
 	}
 }
 
+// dotInstalled reports whether the dot tool of Graphviz can be run.
+// Without it, svg graphs are laid out in-process.
+func dotInstalled() bool {
+	_, err := exec.LookPath("dot")
+	return err == nil
+}
+
+// massageSVGOnly alters an SVG image laid out in-process to have
+// panning capabilities when viewed in a browser, as massageDotSVG does.
+func massageSVGOnly(input io.Reader, output io.Writer, ui plugin.UI) error {
+	baseSVG := new(bytes.Buffer)
+	if _, err := baseSVG.ReadFrom(input); err != nil {
+		return err
+	}
+	_, err := output.Write([]byte(massageSVG(baseSVG.String())))
+	return err
+}
+
 // massageDotSVG invokes the dot tool to generate an SVG image and alters
 // the image to have panning capabilities when viewed in a browser.
 func massageDotSVG() PostProcessor {
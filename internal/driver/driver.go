@@ -23,6 +23,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"pproflame/internal/graph"
 	"pproflame/internal/plugin"
 	"pproflame/internal/report"
 	"pproflame/profile"
@@ -30,8 +31,11 @@ import (
 	"strings"
 )
 
-// SMMPProf 通过配置的参数项, 采集
-func SMMPProf(eo *plugin.Options, fetchSource string, seconds int) (*WebInterface, error) {
+// SMMPProf 通过配置的参数项, 采集, 并按 rules 改写调用栈和标签.
+// defaults 是该服务报告变量的默认值, 如 source_path, 可被 URL 参数覆盖
+// diffBase 不为空时与它指向的 profile 对比, 同 -diff_base
+// 保存的 profile 文件名带上服务名称 service, 没有配置 history 时 regressions 报告只对比该服务的快照
+func SMMPProf(eo *plugin.Options, service, fetchSource string, seconds int, rules []profile.Rule, defaults map[string]string, diffBase string) (*WebInterface, error) {
 	// Remove any temporary files created during pprof processing.
 	// defer cleanupTempFiles() // FIXME: 删除临时文件?
 
@@ -47,6 +51,12 @@ func SMMPProf(eo *plugin.Options, fetchSource string, seconds int) (*WebInterfac
 		Symbolize:    "flagSymbolize",
 		HTTPHostport: "2333",
 		Comment:      "自定义的 source 结构体",
+		Rules:        rules,
+		Service:      service,
+	}
+	// 设置了对比基准时, 基准样本带上 pprof::base 标签, 火焰图为差分火焰图
+	if diffBase != "" {
+		src.Base, src.DiffBase = []string{diffBase}, true
 	}
 
 	p, err := fetchProfiles(src, o)
@@ -58,6 +68,19 @@ func SMMPProf(eo *plugin.Options, fetchSource string, seconds int) (*WebInterfac
 	log.Printf("解析后的 src: %+v\n cmd: %+v\n", src, "无命令行了 by MingH")
 
 	ui := MakeWebInterface(p, o)
+	if service != "" {
+		dir, err := setTmpDir(o.UI)
+		if err != nil {
+			return nil, err
+		}
+		ui.defaults["history"] = savedProfiles(dir, service, p)
+	}
+	for n, v := range defaults {
+		if PProfVariables[n] == nil {
+			return nil, fmt.Errorf("unknown variable %q", n)
+		}
+		ui.defaults[n] = v
+	}
 	for n, c := range PProfCommands {
 		ui.help[n] = c.description
 	}
@@ -66,6 +89,11 @@ func SMMPProf(eo *plugin.Options, fetchSource string, seconds int) (*WebInterfac
 	}
 	ui.help["details"] = "Show information about the profile and this view"
 	ui.help["graph"] = "Display profile as a directed graph"
+	ui.help["interactivegraph"] = "Display profile as a directed graph to explore in the browser"
+	ui.help["sandwich"] = "Display the callers and callees of the selected functions as flame graphs"
+	ui.help["paths"] = "Display the heaviest complete call stacks"
+	ui.help["regressions"] = "Display the entries whose share grew significantly over earlier snapshots"
+	ui.help["drilldown"] = "Show the functions of the selected packages, modules or mappings"
 	ui.help["reset"] = "Show the entire profile"
 
 	return ui, nil
@@ -103,6 +131,16 @@ func PProf(eo *plugin.Options, source, timeout string, httpHostPort string) erro
 func generateRawReport(p *profile.Profile, cmd []string, vars variables, o *plugin.Options) (*command, *report.Report, error) {
 	p = p.Copy() // Prevent modification to the incoming profile.
 
+	// The regressions report compares the profile with its earlier
+	// snapshots.
+	var history []*profile.Profile
+	if cmd[0] == "regressions" {
+		var err error
+		if history, err = historyProfiles(p, vars, o.UI); err != nil {
+			return nil, nil, err
+		}
+	}
+
 	// Identify units of numeric tags in profile.
 	numLabelUnits := identifyNumLabelUnits(p, o.UI)
 
@@ -114,18 +152,16 @@ func generateRawReport(p *profile.Profile, cmd []string, vars variables, o *plug
 
 	vars = applyCommandOverrides(cmd[0], c.format, vars)
 
-	// Delay focus after configuring report to get percentages on all samples.
-	relative := vars["relative_percentages"].boolValue()
-	if relative {
-		if err := applyFocus(p, numLabelUnits, vars, o.UI); err != nil {
-			return nil, nil, err
-		}
-	}
 	ropt, err := reportOptions(p, numLabelUnits, vars)
 	if err != nil {
 		return nil, nil, err
 	}
 	ropt.OutputFormat = c.format
+	if c.format == report.Redact {
+		if ropt.Redact, err = redactOptions(vars); err != nil {
+			return nil, nil, err
+		}
+	}
 	if len(cmd) == 2 {
 		s, err := regexp.Compile(cmd[1])
 		if err != nil {
@@ -134,17 +170,48 @@ func generateRawReport(p *profile.Profile, cmd []string, vars variables, o *plug
 		ropt.Symbol = s
 	}
 
+	rpt, err := newReport(p, ropt, numLabelUnits, vars, o)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The earlier snapshots are filtered and aggregated like the newest.
+	for _, h := range history {
+		hopt := *ropt
+		hrpt, err := newReport(h, &hopt, numLabelUnits, vars, o)
+		if err != nil {
+			return nil, nil, err
+		}
+		ropt.History = append(ropt.History, hrpt)
+	}
+	ropt.RegressionScore = vars["regression_score"].floatValue()
+
+	return c, rpt, nil
+}
+
+// newReport builds a report of p with the options ropt, after applying
+// the filters and the granularity of vars to p.
+func newReport(p *profile.Profile, ropt *report.Options, numLabelUnits map[string]string, vars variables, o *plugin.Options) (*report.Report, error) {
+	// Delay focus after configuring report to get percentages on all samples.
+	relative := vars["relative_percentages"].boolValue()
+	if relative {
+		if err := applyFocus(p, numLabelUnits, vars, o.UI); err != nil {
+			return nil, err
+		}
+	}
 	rpt := report.New(p, ropt)
 	if !relative {
 		if err := applyFocus(p, numLabelUnits, vars, o.UI); err != nil {
-			return nil, nil, err
+			return nil, err
 		}
 	}
 	if err := aggregate(p, vars); err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-
-	return c, rpt, nil
+	// Group-by frames are added last, as aggregating by package, module
+	// or mapping would rename them.
+	addGroupByNodes(p, groupByKeys(vars["groupby"].value), numLabelUnits, vars["unit"].value)
+	return rpt, nil
 }
 
 func generateReport(p *profile.Profile, cmd []string, vars variables, o *plugin.Options) error {
@@ -153,17 +220,27 @@ func generateReport(p *profile.Profile, cmd []string, vars variables, o *plugin.
 		return err
 	}
 
-	// Generate the report.
+	// Generate the report. Without Graphviz, svg graphs are laid out
+	// in-process, and other images cannot be drawn.
 	dst := new(bytes.Buffer)
-	if err := report.Generate(dst, rpt, o.Obj); err != nil {
+	postProcess := c.postProcess
+	svg := cmd[0] == "svg" || cmd[0] == "web"
+	if c.format == report.Dot && postProcess != nil && !svg && !dotInstalled() {
+		return fmt.Errorf("%s needs the dot tool of Graphviz, which is not installed; svg and web graphs are drawn without it", cmd[0])
+	}
+	if c.format == report.Dot && svg && !dotInstalled() {
+		g, config := report.GetDOT(rpt)
+		graph.ComposeSVG(dst, g, &graph.DotAttributes{}, config)
+		postProcess = massageSVGOnly
+	} else if err := report.Generate(dst, rpt, o.Obj); err != nil {
 		return err
 	}
 	src := dst
 
 	// If necessary, perform any data post-processing.
-	if c.postProcess != nil {
+	if postProcess != nil {
 		dst = new(bytes.Buffer)
-		if err := c.postProcess(src, dst, o.UI); err != nil {
+		if err := postProcess(src, dst, o.UI); err != nil {
 			return err
 		}
 		src = dst
@@ -204,10 +281,12 @@ func applyCommandOverrides(cmd string, outputFormat int, v variables) variables
 		v.set("addressnoinlines", "t")
 	case "peek":
 		trim, tagfilter, filter = false, false, false
+	case "flamegraph":
+		trim = false
 	case "list":
 		v.set("nodecount", "0")
 		v.set("lines", "t")
-	case "text", "top", "topproto":
+	case "text", "top", "topproto", "csv", "tsv":
 		if v["nodecount"].intValue() == -1 {
 			v.set("nodecount", "0")
 		}
@@ -217,9 +296,11 @@ func applyCommandOverrides(cmd string, outputFormat int, v variables) variables
 		}
 	}
 
-	if outputFormat == report.Proto || outputFormat == report.Raw {
+	if outputFormat == report.Proto || outputFormat == report.Redact || outputFormat == report.Raw || outputFormat == report.Validate {
+		// Profiles written out keep their stacks, without group-by frames.
 		trim, tagfilter, filter = false, false, false
 		v.set("addresses", "t")
+		v.set("groupby", "")
 	}
 
 	if !trim {
@@ -230,6 +311,7 @@ func applyCommandOverrides(cmd string, outputFormat int, v variables) variables
 	if !tagfilter {
 		v.set("tagfocus", "")
 		v.set("tagignore", "")
+		v.set("time_range", "")
 	}
 	if !filter {
 		v.set("focus", "")
@@ -254,6 +336,12 @@ func aggregate(prof *profile.Profile, v variables) error {
 	case v["files"].boolValue():
 		inlines = true
 		filename = true
+	case v["packages"].boolValue():
+		return prof.AggregatePackages()
+	case v["modules"].boolValue():
+		return prof.AggregateModules()
+	case v["mappings"].boolValue():
+		return prof.AggregateMappings()
 	case v["functions"].boolValue():
 		inlines = true
 		function = true
@@ -286,8 +374,18 @@ func reportOptions(p *profile.Profile, numLabelUnits map[string]string, vars var
 		return nil, fmt.Errorf("zero divisor specified")
 	}
 
+	highlight, err := compileRegexOption("highlight", vars["highlight"].value, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	columns, err := reportColumns(p, vars)
+	if err != nil {
+		return nil, err
+	}
+
 	var filters []string
-	for _, k := range []string{"focus", "ignore", "hide", "show", "show_from", "tagfocus", "tagignore", "tagshow", "taghide"} {
+	for _, k := range []string{"focus", "ignore", "hide", "show", "show_from", "tagfocus", "tagignore", "tagshow", "taghide", "time_range"} {
 		v := vars[k].value
 		if v != "" {
 			filters = append(filters, k+"="+v)
@@ -316,8 +414,13 @@ func reportOptions(p *profile.Profile, numLabelUnits map[string]string, vars var
 
 		OutputUnit: vars["unit"].value,
 
+		Columns:    columns,
+		SortColumn: vars["sort_column"].value,
+
+		Highlight:  highlight,
 		SourcePath: vars["source_path"].stringValue(),
 		TrimPath:   vars["trim_path"].stringValue(),
+		GitMirror:  vars["git_mirror"].stringValue(),
 	}
 
 	if len(p.Mapping) > 0 && p.Mapping[0].File != "" {
@@ -327,6 +430,60 @@ func reportOptions(p *profile.Profile, numLabelUnits map[string]string, vars var
 	return ropt, nil
 }
 
+// reportColumns returns the sample values of the columns option, after
+// checking that the sort_column option is one of the columns.
+func reportColumns(p *profile.Profile, vars variables) ([]report.Column, error) {
+	sortColumns := map[string]bool{"": true, "flat": true, "cum": true, "name": true}
+	var columns []report.Column
+	for _, name := range strings.Split(vars["columns"].value, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		index, mean := name, false
+		if strings.HasPrefix(name, "mean_") {
+			index, mean = strings.TrimPrefix(name, "mean_"), true
+		}
+		value, meanDiv, sample, err := sampleFormat(p, index, mean)
+		if err != nil {
+			return nil, fmt.Errorf("columns: %v", err)
+		}
+		columns = append(columns, report.Column{
+			Name:              name,
+			SampleValue:       value,
+			SampleMeanDivisor: meanDiv,
+			SampleUnit:        sample.Unit,
+		})
+		sortColumns[name] = true
+		sortColumns[name+".cum"] = true
+	}
+	if sc := vars["sort_column"].value; !sortColumns[sc] {
+		return nil, fmt.Errorf("sort_column %q is not a column of the report", sc)
+	}
+	return columns, nil
+}
+
+// redactOptions returns the options for the redact command.
+func redactOptions(vars variables) (*profile.RedactOptions, error) {
+	functions, err := compileRegexOption("redact_functions", vars["redact_functions"].value, nil)
+	files, err := compileRegexOption("redact_files", vars["redact_files"].value, err)
+	mappings, err := compileRegexOption("redact_mappings", vars["redact_mappings"].value, err)
+	labels, err := compileRegexOption("redact_labels", vars["redact_labels"].value, err)
+	numLabels, err := compileRegexOption("redact_numeric_labels", vars["redact_numeric_labels"].value, err)
+	if err != nil {
+		return nil, err
+	}
+	return &profile.RedactOptions{
+		Functions: functions,
+		Files:     files,
+		Mappings:  mappings,
+		Labels:    labels,
+		NumLabels: numLabels,
+		Salt:      vars["redact_salt"].value,
+		BuildIDs:  true,
+		Comments:  true,
+	}, nil
+}
+
 // identifyNumLabelUnits returns a map of numeric label keys to the units
 // associated with those keys.
 func identifyNumLabelUnits(p *profile.Profile, ui plugin.UI) map[string]string {
@@ -19,6 +19,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"pproflame/internal/measurement"
 	"pproflame/internal/plugin"
@@ -37,6 +38,7 @@ func applyFocus(prof *profile.Profile, numLabelUnits map[string]string, v variab
 	tagfocus, err := compileTagFilter("tagfocus", v["tagfocus"].value, numLabelUnits, ui, err)
 	tagignore, err := compileTagFilter("tagignore", v["tagignore"].value, numLabelUnits, ui, err)
 	prunefrom, err := compileRegexOption("prune_from", v["prune_from"].value, err)
+	from, to, err := compileTimeRange("time_range", v["time_range"].value, prof, err)
 	if err != nil {
 		return err
 	}
@@ -54,6 +56,11 @@ func applyFocus(prof *profile.Profile, numLabelUnits map[string]string, v variab
 	warnNoMatches(tagfocus == nil || tfm, "TagFocus", ui)
 	warnNoMatches(tagignore == nil || tim, "TagIgnore", ui)
 
+	if v["time_range"].value != "" {
+		trm := prof.SliceTime(from, to)
+		warnNoMatches(trm, "TimeRange", ui)
+	}
+
 	tagshow, err := compileRegexOption("tagshow", v["tagshow"].value, err)
 	taghide, err := compileRegexOption("taghide", v["taghide"].value, err)
 	tns, tnh := prof.FilterTagsByName(tagshow, taghide)
@@ -77,6 +84,43 @@ func compileRegexOption(name, value string, err error) (*regexp.Regexp, error) {
 	return rx, nil
 }
 
+// compileTimeRange parses a time_range option of the form from:to, where
+// from and to are durations relative to the start of the profile, and
+// returns the corresponding absolute times. Omitted bounds are returned
+// as zero times.
+func compileTimeRange(name, value string, prof *profile.Profile, err error) (from, to time.Time, _ error) {
+	if value == "" || err != nil {
+		return from, to, err
+	}
+	bounds := strings.SplitN(value, ":", 2)
+	if len(bounds) != 2 {
+		return from, to, fmt.Errorf("parsing %s: want from:to, got %q", name, value)
+	}
+	start := prof.TimeNanos
+	if first, _, ok := prof.TimeRange(); ok && (start == 0 || first < start) {
+		start = first
+	}
+	base := time.Unix(0, start)
+	for i, b := range bounds {
+		if b == "" {
+			continue
+		}
+		d, err := time.ParseDuration(b)
+		if err != nil {
+			return from, to, fmt.Errorf("parsing %s: %v", name, err)
+		}
+		if i == 0 {
+			from = base.Add(d)
+		} else {
+			to = base.Add(d)
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return from, to, fmt.Errorf("parsing %s: empty interval %q", name, value)
+	}
+	return from, to, nil
+}
+
 func compileTagFilter(name, value string, numLabelUnits map[string]string, ui plugin.UI, err error) (func(*profile.Sample) bool, error) {
 	if value == "" || err != nil {
 		return nil, err
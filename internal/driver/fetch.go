@@ -102,15 +102,7 @@ func fetchProfiles(s *source, o *plugin.Options) (*profile.Profile, error) {
 			return nil, err
 		}
 
-		prefix := "pprof."
-		if len(p.Mapping) > 0 && p.Mapping[0].File != "" {
-			prefix += filepath.Base(p.Mapping[0].File) + "."
-		}
-		for _, s := range p.SampleType {
-			prefix += s.Type + "."
-		}
-
-		tempFile, err := newTempFile(dir, prefix, ".pb.gz")
+		tempFile, err := newTempFile(dir, savedProfilePrefix(s.Service, p), ".pb.gz")
 		if err == nil {
 			if err = p.Write(tempFile); err == nil {
 				o.UI.PrintErr("Saved profile in ", tempFile.Name())
@@ -121,6 +113,12 @@ func fetchProfiles(s *source, o *plugin.Options) (*profile.Profile, error) {
 		}
 	}
 
+	// Rewrite stacks and labels after saving, so the saved profile keeps
+	// the original data.
+	if err := p.ApplyRules(s.Rules); err != nil {
+		return nil, err
+	}
+
 	if err := p.CheckValid(); err != nil {
 		log.Println("CheckValid failed: ", err.Error())
 		return nil, err
@@ -129,6 +127,29 @@ func fetchProfiles(s *source, o *plugin.Options) (*profile.Profile, error) {
 	return p, nil
 }
 
+// savedProfilePrefix returns the prefix of the names of the files the
+// profiles like p are saved in, made of the name of the service if any,
+// of the name of the main binary and of the sample types.
+func savedProfilePrefix(service string, p *profile.Profile) string {
+	prefix := "pprof."
+	if service != "" {
+		prefix += service + "."
+	}
+	if len(p.Mapping) > 0 && p.Mapping[0].File != "" {
+		prefix += filepath.Base(p.Mapping[0].File) + "."
+	}
+	for _, s := range p.SampleType {
+		prefix += s.Type + "."
+	}
+	return prefix
+}
+
+// savedProfiles returns the glob of the files in dir the profiles like
+// p of the service are saved in.
+func savedProfiles(dir, service string, p *profile.Profile) string {
+	return filepath.Join(dir, savedProfilePrefix(service, p)+"[0-9][0-9][0-9]*.pb.gz")
+}
+
 func grabSourcesAndBases(sources, bases []profileSource, fetch plugin.Fetcher, obj plugin.ObjTool, ui plugin.UI) (*profile.Profile, *profile.Profile, plugin.MappingSources, plugin.MappingSources, bool, error) {
 	wg := sync.WaitGroup{}
 	wg.Add(2)
@@ -449,6 +470,17 @@ mapping:
 				}
 			}
 		}
+		// Fall back to the symbol store, which the object tool consults
+		// when asked to open a build ID.
+		if m.BuildID != "" {
+			if f, err := obj.Open(m.BuildID, m.Start, m.Limit, m.Offset); err == nil {
+				// The store keeps build IDs in lower case.
+				if fileBuildID := f.BuildID(); fileBuildID == "" || strings.EqualFold(fileBuildID, m.BuildID) {
+					m.File = f.Name()
+				}
+				f.Close()
+			}
+		}
 	}
 	if len(p.Mapping) == 0 {
 		// If there are no mappings, add a fake mapping to attempt symbolization.
@@ -497,12 +529,39 @@ func fetch(source string, duration, timeout time.Duration, ui plugin.UI) (p *pro
 	}
 	if err == nil {
 		defer f.Close()
-		p, err = profile.Parse(f)
+		p, err = parseProfile(f, source, ui)
 		log.Println(" profile.Parse: ", err)
 	}
 	return
 }
 
+// parseProfile parses a profile read from r. If the profile is
+// malformed, it salvages and repairs what it can, reporting each
+// change through the ui, and only fails if the profile is beyond repair.
+func parseProfile(r io.Reader, source string, ui plugin.UI) (*profile.Profile, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	p, err := profile.ParseData(data)
+	if err == nil {
+		return p, nil
+	}
+	lp, lerr := profile.ParseLenient(data)
+	if lerr != nil {
+		return nil, err
+	}
+	fixes, rerr := profile.Repair(lp)
+	if rerr != nil {
+		return nil, fmt.Errorf("%v; repair failed: %v", err, rerr)
+	}
+	ui.PrintErr(source, ": ", err)
+	for _, fix := range fixes {
+		ui.PrintErr("Repaired ", source, ": ", fix)
+	}
+	return lp, nil
+}
+
 // fetchURL fetches a profile from a URL using HTTP.
 func fetchURL(source string, timeout time.Duration) (io.ReadCloser, error) {
 	resp, err := httpGet(source, timeout)
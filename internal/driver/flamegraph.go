@@ -15,81 +15,79 @@
 package driver
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"html/template"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"pproflame/internal/graph"
 	"pproflame/internal/measurement"
 	"pproflame/internal/report"
+	"pproflame/profile"
 )
 
-type treeNode struct {
-	Name      string      `json:"n"`
-	FullName  string      `json:"f"`
-	Cum       int64       `json:"v"`
-	CumFormat string      `json:"l"`
-	Percent   string      `json:"p"`
-	Children  []*treeNode `json:"c"`
-}
-
-// Flamegraph generates a web page containing a flamegraph.
+// Flamegraph generates a web page containing a flamegraph. The r
+// parameter selects the reverse flame graph, rooted at the leaves, and
+// the o parameter its orientation, which is handled by the page. When
+// the profile was compared to a diff_base, the flame graph is a
+// differential one, sized by the current profile or, with the b
+// parameter, by the base profile.
 func (ui *WebInterface) Flamegraph(c *gin.Context) {
+	build := report.NewFlameGraph
+	if c.Query("r") != "" {
+		build = reverseFlameGraph
+	}
+
 	// Force the call tree so that the graph is a tree.
 	// Also do not trim the tree so that the flame graph contains all functions.
-	rpt, errList := ui.makeReport(c, []string{"svg"}, "call_tree", "true", "trim", "false")
-	if rpt == nil {
-		return // error already reported
+	vars := []string{"call_tree", "true", "trim", "false"}
+
+	var rpt *report.Report
+	var errList []string
+	var g *graph.Graph
+	var config *graph.DotConfig
+	var rootNode *report.FlameNode
+	base, current := splitDiffBase(ui.prof)
+	if base != nil {
+		if rpt, errList = ui.makeProfileReport(c, current, []string{"svg"}, vars...); rpt == nil {
+			return // error already reported
+		}
+		brpt, _ := ui.makeProfileReport(c, base, []string{"svg"}, vars...)
+		if brpt == nil {
+			return // error already reported
+		}
+		g, config = report.GetDOT(rpt)
+		bg, bconfig := report.GetDOT(brpt)
+		sizeByBase := c.Query("b") != ""
+		sizeConfig := config
+		if sizeByBase {
+			sizeConfig = bconfig
+		}
+		rootNode = diffFlameGraph(build(bg, bconfig), build(g, config), sizeByBase, sizeConfig)
+		g.Nodes = append(g.Nodes, bg.Nodes...)
+	} else {
+		if rpt, errList = ui.makeReport(c, []string{"svg"}, vars...); rpt == nil {
+			return // error already reported
+		}
+		g, config = report.GetDOT(rpt)
+		rootNode = build(g, config)
 	}
 
-	// Generate dot graph.
-	g, config := report.GetDOT(rpt)
-	var nodes []*treeNode
-	nroots := 0
-	rootValue := int64(0)
+	// Get all node names into an array.
 	nodeArr := []string{}
-	nodeMap := map[*graph.Node]*treeNode{}
-	// Make all nodes and the map, collect the roots.
+	seen := map[string]bool{}
 	for _, n := range g.Nodes {
-		v := n.CumValue()
-		fullName := n.Info.PrintableName()
-		node := &treeNode{
-			Name:      getNodeShortName(fullName),
-			FullName:  fullName,
-			Cum:       v,
-			CumFormat: config.FormatValue(v),
-			Percent:   strings.TrimSpace(measurement.Percentage(v, config.Total)),
-		}
-		nodes = append(nodes, node)
-		if len(n.In) == 0 {
-			nodes[nroots], nodes[len(nodes)-1] = nodes[len(nodes)-1], nodes[nroots]
-			nroots++
-			rootValue += v
-		}
-		nodeMap[n] = node
-		// Get all node names into an array.
-		nodeArr = append(nodeArr, n.Info.Name)
-	}
-	// Populate the child links.
-	for _, n := range g.Nodes {
-		node := nodeMap[n]
-		for child := range n.Out {
-			node.Children = append(node.Children, nodeMap[child])
+		if !seen[n.Info.Name] {
+			seen[n.Info.Name] = true
+			nodeArr = append(nodeArr, n.Info.Name)
 		}
 	}
 
-	rootNode := &treeNode{
-		Name:      "root",
-		FullName:  "root",
-		Cum:       rootValue,
-		CumFormat: config.FormatValue(rootValue),
-		Percent:   strings.TrimSpace(measurement.Percentage(rootValue, config.Total)),
-		Children:  nodes[0:nroots],
-	}
-
 	// JSON marshalling flame graph
 	b, err := json.Marshal(rootNode)
 	if err != nil {
@@ -98,24 +96,272 @@ func (ui *WebInterface) Flamegraph(c *gin.Context) {
 		return
 	}
 
+	// JSON marshalling the timeline histogram, if samples have timestamps.
+	var tl []byte
+	if buckets := makeTimeline(ui.prof, config.FormatValue, timelineBuckets); buckets != nil {
+		if tl, err = json.Marshal(buckets); err != nil {
+			c.String(http.StatusInternalServerError, "error serializing timeline")
+			ui.options.UI.PrintErr(err)
+			return
+		}
+	}
+
 	ui.render(c, "flamegraph", rpt, errList, config.Labels, webArgs{
 		FlameGraph: template.JS(b),
+		Timeline:   template.JS(tl),
+		Diff:       base != nil,
 		Nodes:      nodeArr,
 	})
 }
 
-// getNodeShortName builds a short node name from fullName.
-func getNodeShortName(name string) string {
-	chunks := strings.SplitN(name, "(", 2)
-	head := chunks[0]
-	pathSep := strings.LastIndexByte(head, '/')
-	if pathSep == -1 || pathSep+1 >= len(head) {
-		return name
+// FlamegraphSVG generates a standalone svg flame graph, which is drawn
+// without JavaScript and so can be embedded in other documents. The
+// frames matching the q parameter are highlighted.
+func (ui *WebInterface) FlamegraphSVG(c *gin.Context) {
+	rpt, _ := ui.makeReport(c, []string{"flamegraph"}, "highlight", c.Query("q"))
+	if rpt == nil {
+		return // error already reported
+	}
+
+	out := &bytes.Buffer{}
+	if err := report.Generate(out, rpt, ui.options.Obj); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		ui.options.UI.PrintErr(err)
+		return
+	}
+	c.Data(http.StatusOK, "image/svg+xml", out.Bytes())
+}
+
+// reverseFlameGraph returns the flame graph of a call tree turned
+// upside down: its roots are the functions the samples were taken in,
+// and the children of a node are its callers. Every node of the call
+// tree with a flat value stands for the samples of one stack, which is
+// merged into the flame graph from the leaf up.
+func reverseFlameGraph(g *graph.Graph, config *graph.DotConfig) *report.FlameNode {
+	rootNode := report.NewFlameNode("root", 0, config)
+	for _, n := range g.Nodes {
+		if v := n.FlatValue(); v != 0 {
+			addStack(rootNode, treeStack(n), v, config)
+		}
+	}
+	setTreeFormat(rootNode, config)
+	return rootNode
+}
+
+// treeStack returns the stack of a node of a call tree, from the node
+// up to its root.
+func treeStack(n *graph.Node) []*graph.Node {
+	var stack []*graph.Node
+	for ; n != nil; n = treeParent(n) {
+		stack = append(stack, n)
+	}
+	return stack
+}
+
+// treeParent returns the caller of a node of a call tree, or nil for
+// the roots.
+func treeParent(n *graph.Node) *graph.Node {
+	for parent := range n.In {
+		return parent
+	}
+	return nil
+}
+
+// addStack adds v to the path of a flame graph from root through the
+// frames of stack, creating the nodes missing from it. The formatted
+// values are left to setTreeFormat.
+func addStack(root *report.FlameNode, stack []*graph.Node, v int64, config *graph.DotConfig) {
+	root.Cum += v
+	parent := root
+	for _, frame := range stack {
+		name := frame.Info.PrintableName()
+		var node *report.FlameNode
+		for _, child := range parent.Children {
+			if child.FullName == name {
+				node = child
+				break
+			}
+		}
+		if node == nil {
+			node = report.NewFlameNode(name, 0, config)
+			parent.Children = append(parent.Children, node)
+		}
+		node.Cum += v
+		parent = node
+	}
+}
+
+// setTreeFormat sets the formatted value and percentage of the nodes
+// of a flame graph after their values have been accumulated.
+func setTreeFormat(n *report.FlameNode, config *graph.DotConfig) {
+	n.CumFormat = config.FormatValue(n.Cum)
+	n.Percent = strings.TrimSpace(measurement.Percentage(n.Cum, config.Total))
+	for _, child := range n.Children {
+		setTreeFormat(child, config)
+	}
+}
+
+// splitDiffBase separates the samples of a profile compared to a
+// diff_base into a base and a current profile, with the values of the
+// base samples negated back. It returns nil profiles if p has no base
+// samples, as for the profiles sampled by SMMPProf.
+func splitDiffBase(p *profile.Profile) (base, current *profile.Profile) {
+	hasBase := false
+	for _, s := range p.Sample {
+		if s.DiffBaseSample() {
+			hasBase = true
+			break
+		}
+	}
+	if !hasBase {
+		return nil, nil
+	}
+	base, current = p.Copy(), p.Copy()
+	baseSamples, currentSamples := base.Sample, current.Sample
+	base.Sample, current.Sample = nil, nil
+	for i, s := range p.Sample {
+		if s.DiffBaseSample() {
+			base.Sample = append(base.Sample, baseSamples[i])
+		} else {
+			current.Sample = append(current.Sample, currentSamples[i])
+		}
+	}
+	base.Scale(-1)
+	return base, current
+}
+
+// diffFlameGraph merges the flame graphs of a base and a current
+// profile. Frames are matched by their path from the root, and carry
+// their values in both profiles. The frames are sized by their value in
+// the current profile, or in the base one if sizeByBase is set. Frames
+// missing from that profile are sized by their value in the other one
+// instead, so that they are still shown, and their ancestors are grown
+// to make room for them.
+func diffFlameGraph(base, current *report.FlameNode, sizeByBase bool, config *graph.DotConfig) *report.FlameNode {
+	n := mergeTrees(base, current, config)
+	setDiffValue(n, sizeByBase, config)
+	return n
+}
+
+func mergeTrees(base, current *report.FlameNode, config *graph.DotConfig) *report.FlameNode {
+	name := "root"
+	if current != nil {
+		name = current.FullName
+	} else if base != nil {
+		name = base.FullName
+	}
+	n := report.NewFlameNode(name, 0, config)
+	var children []*report.FlameNode
+	baseChildren := map[string]*report.FlameNode{}
+	if base != nil {
+		n.Base = base.Cum
+		for _, child := range base.Children {
+			baseChildren[child.FullName] = child
+		}
+	}
+	if current != nil {
+		n.Current = current.Cum
+		for _, child := range current.Children {
+			children = append(children, mergeTrees(baseChildren[child.FullName], child, config))
+			delete(baseChildren, child.FullName)
+		}
+	}
+	if base != nil {
+		for _, child := range base.Children {
+			if baseChildren[child.FullName] != nil {
+				children = append(children, mergeTrees(child, nil, config))
+			}
+		}
+	}
+	n.Children = children
+	n.BaseFormat = config.FormatValue(n.Base)
+	n.CurrentFormat = config.FormatValue(n.Current)
+	delta := n.Current - n.Base
+	n.DeltaFormat = config.FormatValue(delta)
+	if delta > 0 {
+		n.DeltaFormat = "+" + n.DeltaFormat
+	}
+	switch {
+	case n.Base != 0:
+		n.DeltaPercent = fmt.Sprintf("%+.2f%%", 100*float64(delta)/float64(n.Base))
+	case n.Current != 0:
+		n.DeltaPercent = "new"
+	}
+	return n
+}
+
+func setDiffValue(n *report.FlameNode, sizeByBase bool, config *graph.DotConfig) {
+	v, other := n.Current, n.Base
+	if sizeByBase {
+		v, other = n.Base, n.Current
+	}
+	if v == 0 {
+		v = other
+	}
+	var sum int64
+	for _, child := range n.Children {
+		setDiffValue(child, sizeByBase, config)
+		sum += child.Cum
+	}
+	if sum > v {
+		v = sum
+	}
+	n.Cum = v
+	n.CumFormat = config.FormatValue(v)
+	n.Percent = strings.TrimSpace(measurement.Percentage(v, config.Total))
+}
+
+// timelineBuckets is the number of bars in the flame graph timeline.
+const timelineBuckets = 60
+
+type timeBucket struct {
+	Start       int64  `json:"s"` // Offset from profile start, in nanoseconds
+	End         int64  `json:"e"` // Offset from profile start, in nanoseconds
+	Value       int64  `json:"v"`
+	ValueFormat string `json:"l"`
+}
+
+// makeTimeline distributes the default sample values of p over n buckets
+// of equal duration, according to the sample timestamps. Offsets are
+// relative to the start of the profile, which is the reference used by
+// the time_range option. It returns nil if no sample has a timestamp.
+func makeTimeline(p *profile.Profile, format func(int64) string, n int) []*timeBucket {
+	first, last, ok := p.TimeRange()
+	if !ok {
+		return nil
+	}
+	value, _, _, err := sampleFormat(p, "", false)
+	if err != nil {
+		return nil
+	}
+	start := p.TimeNanos
+	if start == 0 || first < start {
+		start = first
+	}
+	width := (last - start + 1 + int64(n) - 1) / int64(n)
+	if width < int64(time.Millisecond) {
+		width = int64(time.Millisecond)
+	}
+	buckets := make([]*timeBucket, n)
+	for i := range buckets {
+		buckets[i] = &timeBucket{
+			Start: int64(i) * width,
+			End:   int64(i+1) * width,
+		}
+	}
+	for _, s := range p.Sample {
+		t, ok := s.Timestamp()
+		if !ok {
+			continue
+		}
+		i := int((t - start) / width)
+		if i >= n {
+			i = n - 1
+		}
+		buckets[i].Value += value(s.Value)
 	}
-	// Check if name is a stdlib package, i.e. doesn't have "." before "/"
-	if dot := strings.IndexByte(head, '.'); dot == -1 || dot > pathSep {
-		return name
+	for _, b := range buckets {
+		b.ValueFormat = format(b.Value)
 	}
-	// Trim package path prefix from node name
-	return name[pathSep+1:]
+	return buckets
 }
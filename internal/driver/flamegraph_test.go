@@ -1,46 +1,211 @@
 package driver
 
-import "testing"
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
 
-func TestGetNodeShortName(t *testing.T) {
-	type testCase struct {
-		name string
-		want string
+	"pproflame/internal/graph"
+	"pproflame/internal/plugin"
+	"pproflame/internal/proftest"
+	"pproflame/internal/report"
+	"pproflame/profile"
+)
+
+func TestReverseFlameGraph(t *testing.T) {
+	// A call tree with the stacks main>foo>malloc (30), main>bar>malloc (20)
+	// and main>bar (10).
+	newNode := func(name string, flat, cum int64) *graph.Node {
+		return &graph.Node{Info: graph.NodeInfo{Name: name}, Flat: flat, Cum: cum, In: graph.EdgeMap{}, Out: graph.EdgeMap{}}
 	}
-	testcases := []testCase{
-		{
-			"root",
-			"root",
-		},
-		{
-			"syscall.Syscall",
-			"syscall.Syscall",
-		},
-		{
-			"net/http.(*conn).serve",
-			"net/http.(*conn).serve",
-		},
-		{
-			"github.com/blah/foo.Foo",
-			"foo.Foo",
-		},
+	main := newNode("main", 0, 60)
+	foo := newNode("foo", 0, 30)
+	bar := newNode("bar", 10, 30)
+	malloc1 := newNode("malloc", 30, 30)
+	malloc2 := newNode("malloc", 20, 20)
+	main.AddToEdge(foo, 30, false, false)
+	main.AddToEdge(bar, 30, false, false)
+	foo.AddToEdge(malloc1, 30, false, false)
+	bar.AddToEdge(malloc2, 20, false, false)
+	g := &graph.Graph{Nodes: graph.Nodes{main, foo, bar, malloc1, malloc2}}
+	config := &graph.DotConfig{
+		Total:       60,
+		FormatValue: func(v int64) string { return fmt.Sprint(v) },
+	}
+
+	got := printTree(reverseFlameGraph(g, config))
+	want := `root 60 100%
+ bar 10 16.67%
+  main 10 16.67%
+ malloc 50 83.33%
+  bar 20 33.33%
+   main 20 33.33%
+  foo 30 50.00%
+   main 30 50.00%
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	got = printTree(report.NewFlameGraph(g, config))
+	if !strings.HasPrefix(got, "root 60 100%\n main 60 100%\n") {
+		t.Errorf("top down flame graph:\n%s", got)
+	}
+}
+
+func printTree(n *report.FlameNode) string {
+	var b strings.Builder
+	var print func(n *report.FlameNode, indent string)
+	print = func(n *report.FlameNode, indent string) {
+		fmt.Fprintf(&b, "%s%s %s %s\n", indent, n.Name, n.CumFormat, n.Percent)
+		children := append([]*report.FlameNode(nil), n.Children...)
+		sort.Slice(children, func(i, j int) bool { return children[i].Name < children[j].Name })
+		for _, c := range children {
+			print(c, indent+" ")
+		}
+	}
+	print(n, "")
+	return b.String()
+}
+
+func TestDiffFlameGraph(t *testing.T) {
+	config := &graph.DotConfig{
+		Total:       100,
+		FormatValue: func(v int64) string { return fmt.Sprint(v) },
+	}
+	tree := func(name string, v int64, children ...*report.FlameNode) *report.FlameNode {
+		return &report.FlameNode{Name: name, FullName: name, Cum: v, Children: children}
+	}
+	base := tree("root", 60,
+		tree("main", 60,
+			tree("foo", 40),
+			tree("old", 20)))
+	current := tree("root", 100,
+		tree("main", 100,
+			tree("foo", 70),
+			tree("new", 30)))
+
+	for _, tc := range []struct {
+		sizeByBase bool
+		want       string
+	}{
 		{
-			"github.com/blah/foo_bar.(*FooBar).Foo",
-			"foo_bar.(*FooBar).Foo",
+			// Frames only in the base profile keep their size, and
+			// their ancestors grow to make room for them.
+			want: `root 120 (60 -> 100, +40, +66.67%)
+ main 120 (60 -> 100, +40, +66.67%)
+  foo 70 (40 -> 70, +30, +75.00%)
+  new 30 (0 -> 30, +30, new)
+  old 20 (20 -> 0, -20, -100.00%)
+`,
 		},
 		{
-			"encoding/json.(*structEncoder).(encoding/json.encode)-fm",
-			"encoding/json.(*structEncoder).(encoding/json.encode)-fm",
+			sizeByBase: true,
+			want: `root 90 (60 -> 100, +40, +66.67%)
+ main 90 (60 -> 100, +40, +66.67%)
+  foo 40 (40 -> 70, +30, +75.00%)
+  new 30 (0 -> 30, +30, new)
+  old 20 (20 -> 0, -20, -100.00%)
+`,
 		},
-		{
-			"github.com/blah/blah/vendor/gopkg.in/redis.v3.(*baseClient).(github.com/blah/blah/vendor/gopkg.in/redis.v3.process)-fm",
-			"redis.v3.(*baseClient).(github.com/blah/blah/vendor/gopkg.in/redis.v3.process)-fm",
+	} {
+		n := diffFlameGraph(base, current, tc.sizeByBase, config)
+		var b strings.Builder
+		var print func(n *report.FlameNode, indent string)
+		print = func(n *report.FlameNode, indent string) {
+			fmt.Fprintf(&b, "%s%s %s (%s -> %s, %s, %s)\n", indent, n.Name, n.CumFormat, n.BaseFormat, n.CurrentFormat, n.DeltaFormat, n.DeltaPercent)
+			for _, c := range n.Children {
+				print(c, indent+" ")
+			}
+		}
+		print(n, "")
+		if got := b.String(); got != tc.want {
+			t.Errorf("sizeByBase=%v: got:\n%s\nwant:\n%s", tc.sizeByBase, got, tc.want)
+		}
+	}
+}
+
+func TestSplitDiffBase(t *testing.T) {
+	fn := &profile.Function{ID: 1, Name: "main"}
+	loc := &profile.Location{ID: 1, Line: []profile.Line{{Function: fn}}}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+		Function:   []*profile.Function{fn},
+		Location:   []*profile.Location{loc},
+		Sample: []*profile.Sample{
+			{Location: []*profile.Location{loc}, Value: []int64{5}},
+			{Location: []*profile.Location{loc}, Value: []int64{-3}, Label: map[string][]string{"pprof::base": {"true"}}},
 		},
 	}
-	for _, tc := range testcases {
-		name := getNodeShortName(tc.name)
-		if got, want := name, tc.want; got != want {
-			t.Errorf("for %s, got %q, want %q", tc.name, got, want)
+	base, current := splitDiffBase(p)
+	if base == nil || current == nil {
+		t.Fatal("no base or current profile")
+	}
+	if len(base.Sample) != 1 || base.Sample[0].Value[0] != 3 {
+		t.Errorf("base samples: got %v", base.Sample)
+	}
+	if len(current.Sample) != 1 || current.Sample[0].Value[0] != 5 {
+		t.Errorf("current samples: got %v", current.Sample)
+	}
+	if len(p.Sample) != 2 || p.Sample[1].Value[0] != -3 {
+		t.Errorf("profile modified: %v", p.Sample)
+	}
+
+	p.Sample = p.Sample[:1]
+	if base, _ := splitDiffBase(p); base != nil {
+		t.Errorf("got a base profile from a profile without base samples")
+	}
+}
+
+func TestSMMPProfDiffBase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diffbase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name string, value int64) string {
+		fn := &profile.Function{ID: 1, Name: "main"}
+		loc := &profile.Location{ID: 1, Line: []profile.Line{{Function: fn}}}
+		p := &profile.Profile{
+			SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+			Function:   []*profile.Function{fn},
+			Location:   []*profile.Location{loc},
+			Sample:     []*profile.Sample{{Location: []*profile.Location{loc}, Value: []int64{value}}},
+		}
+		name = filepath.Join(dir, name)
+		f, err := os.Create(name)
+		if err != nil {
+			t.Fatal(err)
 		}
+		defer f.Close()
+		if err := p.Write(f); err != nil {
+			t.Fatal(err)
+		}
+		return name
+	}
+	current, base := write("current.pb.gz", 5), write("base.pb.gz", 3)
+
+	o := &plugin.Options{UI: &proftest.TestUI{T: t, AllowRx: ".*"}}
+	ui, err := SMMPProf(o, "", current, 0, nil, nil, base)
+	if err != nil {
+		t.Fatalf("SMMPProf: %v", err)
+	}
+	b, c := splitDiffBase(ui.prof)
+	if b == nil || len(b.Sample) != 1 || b.Sample[0].Value[0] != 3 {
+		t.Errorf("base samples: got %v", b)
+	}
+	if c == nil || len(c.Sample) != 1 || c.Sample[0].Value[0] != 5 {
+		t.Errorf("current samples: got %v", c)
+	}
+
+	if ui, err = SMMPProf(o, "", current, 0, nil, nil, ""); err != nil {
+		t.Fatalf("SMMPProf without a base: %v", err)
+	}
+	if b, _ := splitDiffBase(ui.prof); b != nil {
+		t.Errorf("got a base profile without a diff base")
 	}
 }
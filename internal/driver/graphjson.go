@@ -0,0 +1,135 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"pproflame/internal/graph"
+	"pproflame/internal/measurement"
+	"pproflame/internal/report"
+
+	"github.com/gin-gonic/gin"
+)
+
+// jsonGraph is the call graph of the graph view, for clients that lay
+// it out themselves.
+type jsonGraph struct {
+	Nodes []*jsonGraphNode `json:"nodes"`
+	Edges []*jsonGraphEdge `json:"edges"`
+	Total int64            `json:"total"`
+}
+
+type jsonGraphNode struct {
+	ID          int    `json:"id"`    // 1-based, as the node ids of the svg graph
+	Name        string `json:"name"`  // matched by the focus and hide regexps
+	Label       string `json:"label"` // printable name, with file and line if any
+	Flat        int64  `json:"flat"`
+	Cum         int64  `json:"cum"`
+	FlatFormat  string `json:"flatLabel"`
+	CumFormat   string `json:"cumLabel"`
+	FlatPercent string `json:"flatPercent"`
+	CumPercent  string `json:"cumPercent"`
+}
+
+type jsonGraphEdge struct {
+	Src          int    `json:"src"`
+	Dest         int    `json:"dest"`
+	Weight       int64  `json:"weight"`
+	WeightFormat string `json:"weightLabel"`
+	Residual     bool   `json:"residual"` // stands for a path through removed nodes
+	Inline       bool   `json:"inline"`
+}
+
+// makeJSONGraph converts a graph, as configured for dot, to JSON.
+func makeJSONGraph(g *graph.Graph, config *graph.DotConfig) *jsonGraph {
+	jg := &jsonGraph{Nodes: []*jsonGraphNode{}, Edges: []*jsonGraphEdge{}, Total: config.Total}
+	ids := make(map[*graph.Node]int)
+	for i, n := range g.Nodes {
+		ids[n] = i + 1
+		flat, cum := n.FlatValue(), n.CumValue()
+		jg.Nodes = append(jg.Nodes, &jsonGraphNode{
+			ID:          i + 1,
+			Name:        n.Info.Name,
+			Label:       n.Info.PrintableName(),
+			Flat:        flat,
+			Cum:         cum,
+			FlatFormat:  config.FormatValue(flat),
+			CumFormat:   config.FormatValue(cum),
+			FlatPercent: strings.TrimSpace(measurement.Percentage(flat, config.Total)),
+			CumPercent:  strings.TrimSpace(measurement.Percentage(cum, config.Total)),
+		})
+	}
+	// Collect all edges. Use a fake node to support multiple incoming edges.
+	edges := graph.EdgeMap{}
+	for _, n := range g.Nodes {
+		for _, e := range n.Out {
+			edges[&graph.Node{}] = e
+		}
+	}
+	for _, e := range edges.Sort() {
+		w := e.WeightValue()
+		jg.Edges = append(jg.Edges, &jsonGraphEdge{
+			Src:          ids[e.Src],
+			Dest:         ids[e.Dest],
+			Weight:       w,
+			WeightFormat: config.FormatValue(w),
+			Residual:     e.Residual,
+			Inline:       e.Inline,
+		})
+	}
+	return jg
+}
+
+// GraphJSON serves the call graph of the graph view as JSON.
+func (ui *WebInterface) GraphJSON(c *gin.Context) {
+	rpt, _ := ui.makeReport(c, []string{"svg"})
+	if rpt == nil {
+		return // error already reported
+	}
+	g, config := report.GetDOT(rpt)
+	c.JSON(http.StatusOK, makeJSONGraph(g, config))
+}
+
+// InteractiveGraph generates a web page drawing the call graph in the
+// browser, where nodes can be focused on, expanded and hidden without
+// a round trip to the server.
+func (ui *WebInterface) InteractiveGraph(c *gin.Context) {
+	rpt, errList := ui.makeReport(c, []string{"svg"})
+	if rpt == nil {
+		return // error already reported
+	}
+	g, config := report.GetDOT(rpt)
+	b, err := json.Marshal(makeJSONGraph(g, config))
+	if err != nil {
+		c.String(http.StatusInternalServerError, "error serializing graph")
+		ui.options.UI.PrintErr(err)
+		return
+	}
+
+	// Get all node names into an array.
+	nodes := []string{""} // node ids start at 1
+	for _, n := range g.Nodes {
+		nodes = append(nodes, n.Info.Name)
+	}
+
+	ui.render(c, "interactivegraph", rpt, errList, config.Labels, webArgs{
+		Graph: template.JS(b),
+		Nodes: nodes,
+	})
+}
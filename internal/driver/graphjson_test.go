@@ -0,0 +1,55 @@
+package driver
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"pproflame/internal/graph"
+)
+
+func TestMakeJSONGraph(t *testing.T) {
+	main := &graph.Node{Info: graph.NodeInfo{Name: "main"}, Flat: 0, Cum: 100, In: graph.EdgeMap{}, Out: graph.EdgeMap{}}
+	foo := &graph.Node{Info: graph.NodeInfo{Name: "foo"}, Flat: 60, Cum: 60, In: graph.EdgeMap{}, Out: graph.EdgeMap{}}
+	bar := &graph.Node{Info: graph.NodeInfo{Name: "bar"}, Flat: 40, Cum: 40, In: graph.EdgeMap{}, Out: graph.EdgeMap{}}
+	main.AddToEdge(foo, 60, false, false)
+	main.AddToEdge(bar, 40, true, true)
+	g := &graph.Graph{Nodes: graph.Nodes{main, foo, bar}}
+	config := &graph.DotConfig{
+		Total:       100,
+		FormatValue: func(v int64) string { return fmt.Sprintf("%dms", v) },
+	}
+
+	jg := makeJSONGraph(g, config)
+
+	if got, want := jg.Total, int64(100); got != want {
+		t.Errorf("total: got %d, want %d", got, want)
+	}
+	wantNodes := []jsonGraphNode{
+		{ID: 1, Name: "main", Label: "main", Flat: 0, Cum: 100, FlatFormat: "0ms", CumFormat: "100ms", FlatPercent: "0%", CumPercent: "100%"},
+		{ID: 2, Name: "foo", Label: "foo", Flat: 60, Cum: 60, FlatFormat: "60ms", CumFormat: "60ms", FlatPercent: "60.00%", CumPercent: "60.00%"},
+		{ID: 3, Name: "bar", Label: "bar", Flat: 40, Cum: 40, FlatFormat: "40ms", CumFormat: "40ms", FlatPercent: "40.00%", CumPercent: "40.00%"},
+	}
+	if len(jg.Nodes) != len(wantNodes) {
+		t.Fatalf("got %d nodes, want %d", len(jg.Nodes), len(wantNodes))
+	}
+	for i, n := range jg.Nodes {
+		if !reflect.DeepEqual(*n, wantNodes[i]) {
+			t.Errorf("node %d: got %+v, want %+v", i, *n, wantNodes[i])
+		}
+	}
+
+	// Edges are sorted by weight, heaviest first.
+	wantEdges := []jsonGraphEdge{
+		{Src: 1, Dest: 2, Weight: 60, WeightFormat: "60ms"},
+		{Src: 1, Dest: 3, Weight: 40, WeightFormat: "40ms", Residual: true, Inline: true},
+	}
+	if len(jg.Edges) != len(wantEdges) {
+		t.Fatalf("got %d edges, want %d", len(jg.Edges), len(wantEdges))
+	}
+	for i, e := range jg.Edges {
+		if !reflect.DeepEqual(*e, wantEdges[i]) {
+			t.Errorf("edge %d: got %+v, want %+v", i, *e, wantEdges[i])
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"pproflame/internal/plugin"
+	"pproflame/internal/proftest"
+	"pproflame/profile"
+)
+
+func TestGenerateWithoutGraphviz(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nodot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	savePath := os.Getenv("PATH")
+	os.Setenv("PATH", dir)
+	defer os.Setenv("PATH", savePath)
+
+	f := &profile.Function{ID: 1, Name: "work"}
+	l := &profile.Location{ID: 1, Line: []profile.Line{{Function: f}}}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "cpu", Unit: "nanoseconds"}},
+		Sample:     []*profile.Sample{{Location: []*profile.Location{l}, Value: []int64{1}}},
+		Location:   []*profile.Location{l},
+		Function:   []*profile.Function{f},
+	}
+	o := setDefaults(&plugin.Options{UI: &proftest.TestUI{T: t, AllowRx: "Generating report in "}})
+
+	// svg graphs are laid out in-process.
+	out := filepath.Join(dir, "graph.svg")
+	vars := PProfVariables.makeCopy()
+	vars.set("output", out)
+	if err := generateReport(p.Copy(), []string{"svg"}, vars, o); err != nil {
+		t.Fatalf("svg: %v", err)
+	}
+	if got, err := ioutil.ReadFile(out); err != nil || !strings.Contains(string(got), "<svg") {
+		t.Errorf("svg: got %.40q, %v; want an svg image", got, err)
+	}
+
+	// Other images need Graphviz.
+	vars.set("output", filepath.Join(dir, "graph.png"))
+	if err := generateReport(p.Copy(), []string{"png"}, vars, o); err == nil || !strings.Contains(err.Error(), "Graphviz") {
+		t.Errorf("png: got error %v, want one naming Graphviz", err)
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"sort"
+	"strings"
+
+	"pproflame/internal/measurement"
+	"pproflame/profile"
+)
+
+// groupByKeys parses the value of the groupby option into a list of
+// label keys.
+func groupByKeys(value string) []string {
+	var keys []string
+	for _, k := range strings.Split(value, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// addGroupByNodes pivots the profile on the values of the given label
+// keys. It adds a synthetic frame named key=value at the root of every
+// sample for each key, with the first key outermost, so every report
+// shows the samples of each label value under their own root. Samples
+// without the label get a key=(none) frame. Numeric label values are
+// scaled to outputUnit using the units in numLabelUnits.
+func addGroupByNodes(p *profile.Profile, keys []string, numLabelUnits map[string]string, outputUnit string) {
+	if len(keys) == 0 {
+		return
+	}
+
+	var maxLocID, maxFuncID uint64
+	for _, l := range p.Location {
+		if l.ID > maxLocID {
+			maxLocID = l.ID
+		}
+	}
+	for _, f := range p.Function {
+		if f.ID > maxFuncID {
+			maxFuncID = f.ID
+		}
+	}
+
+	locations := make(map[string]*profile.Location)
+	location := func(name string) *profile.Location {
+		if l := locations[name]; l != nil {
+			return l
+		}
+		maxFuncID++
+		f := &profile.Function{ID: maxFuncID, Name: name, SystemName: name}
+		p.Function = append(p.Function, f)
+		maxLocID++
+		l := &profile.Location{ID: maxLocID, Line: []profile.Line{{Function: f}}}
+		p.Location = append(p.Location, l)
+		locations[name] = l
+		return l
+	}
+
+	for _, s := range p.Sample {
+		// Sample locations are ordered leaf first, so the outermost
+		// frame goes last.
+		for i := len(keys) - 1; i >= 0; i-- {
+			name := keys[i] + "=" + groupByValue(s, keys[i], numLabelUnits, outputUnit)
+			s.Location = append(s.Location, location(name))
+		}
+	}
+}
+
+// groupByValue returns the value of the label key of a sample, as shown
+// in its group-by frame.
+func groupByValue(s *profile.Sample, key string, numLabelUnits map[string]string, outputUnit string) string {
+	var values []string
+	if vs, ok := s.Label[key]; ok {
+		values = append(values, vs...)
+	}
+	if vs, ok := s.NumLabel[key]; ok {
+		unit := numLabelUnits[key]
+		if units := s.NumUnit[key]; len(units) == len(vs) && units[0] != "" {
+			unit = units[0]
+		}
+		for _, v := range vs {
+			values = append(values, measurement.ScaledLabel(v, unit, outputUnit))
+		}
+	}
+	if len(values) == 0 {
+		return "(none)"
+	}
+	sort.Strings(values)
+	return strings.Join(values, ",")
+}
+
+// labelKeys returns the sorted keys of the string and numeric labels
+// found in the profile samples, except the timestamp label, which is
+// not useful to group by.
+func labelKeys(p *profile.Profile) []string {
+	seen := make(map[string]bool)
+	for _, s := range p.Sample {
+		for k := range s.Label {
+			seen[k] = true
+		}
+		for k := range s.NumLabel {
+			seen[k] = true
+		}
+	}
+	delete(seen, profile.TimestampLabel)
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,84 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"reflect"
+	"testing"
+
+	"pproflame/profile"
+)
+
+func TestAddGroupByNodes(t *testing.T) {
+	f := &profile.Function{ID: 1, Name: "work"}
+	l := &profile.Location{ID: 1, Line: []profile.Line{{Function: f}}}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "cpu", Unit: "nanoseconds"}},
+		Sample: []*profile.Sample{
+			{Location: []*profile.Location{l}, Value: []int64{1}, Label: map[string][]string{"handler": {"a"}, "tenant": {"x"}}},
+			{Location: []*profile.Location{l}, Value: []int64{2}, Label: map[string][]string{"handler": {"b"}}},
+			{Location: []*profile.Location{l}, Value: []int64{4}, NumLabel: map[string][]int64{"handler": {2048}}},
+			{Location: []*profile.Location{l}, Value: []int64{8}, Label: map[string][]string{"handler": {"a"}}},
+		},
+		Location: []*profile.Location{l},
+		Function: []*profile.Function{f},
+	}
+
+	addGroupByNodes(p, groupByKeys("handler, tenant"), map[string]string{"handler": "bytes"}, "auto")
+
+	var got [][]string
+	for _, s := range p.Sample {
+		var stack []string
+		for _, loc := range s.Location {
+			stack = append(stack, loc.Line[0].Function.Name)
+		}
+		got = append(got, stack)
+	}
+	want := [][]string{
+		{"work", "tenant=x", "handler=a"},
+		{"work", "tenant=(none)", "handler=b"},
+		{"work", "tenant=(none)", "handler=2kB"},
+		{"work", "tenant=(none)", "handler=a"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("addGroupByNodes: got %v, want %v", got, want)
+	}
+	if p.Sample[0].Location[2] != p.Sample[3].Location[2] {
+		t.Errorf("addGroupByNodes: samples with the same label value got different frames")
+	}
+	if err := p.CheckValid(); err != nil {
+		t.Errorf("addGroupByNodes produced an invalid profile: %v", err)
+	}
+	if got, want := labelKeys(p), []string{"handler", "tenant"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("labelKeys: got %v, want %v", got, want)
+	}
+}
+
+func TestGroupByCommandOverrides(t *testing.T) {
+	for cmd, want := range map[string]string{
+		"top":      "handler",
+		"proto":    "",
+		"raw":      "",
+		"validate": "",
+		"redact":   "",
+	} {
+		vars := PProfVariables.makeCopy()
+		vars.set("groupby", "handler")
+		vars = applyCommandOverrides(cmd, PProfCommands[cmd].format, vars)
+		if got := vars["groupby"].value; got != want {
+			t.Errorf("%s: got groupby %q, want %q", cmd, got, want)
+		}
+	}
+}
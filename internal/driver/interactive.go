@@ -316,7 +316,7 @@ func parseCommandLine(input []string) ([]string, variables, error) {
 		updateFocusIgnore(vcopy, "", focus, ignore)
 	}
 
-	if vcopy["nodecount"].intValue() == -1 && (name == "text" || name == "top") {
+	if vcopy["nodecount"].intValue() == -1 && (name == "text" || name == "top" || name == "paths") {
 		vcopy.set("nodecount", "10")
 	}
 
@@ -25,6 +25,7 @@ import (
 	"pproflame/internal/binutils"
 	"pproflame/internal/plugin"
 	"pproflame/internal/symbolizer"
+	"pproflame/internal/symbolstore"
 )
 
 // setDefaults returns a new plugin.Options with zero fields sets to
@@ -47,7 +48,13 @@ func setDefaults(o *plugin.Options) *plugin.Options {
 		d.UI = &stdUI{r: bufio.NewReader(os.Stdin)}
 	}
 	if d.Sym == nil {
-		d.Sym = &symbolizer.Symbolizer{Obj: d.Obj, UI: d.UI}
+		d.Sym = &symbolizer.Symbolizer{
+			Obj:      d.Obj,
+			UI:       d.UI,
+			Cache:    symbolizer.CacheFromEnv(),
+			Kallsyms: os.Getenv("PPROF_KALLSYMS"),
+			Store:    symbolstore.FromEnv(),
+		}
 	}
 	return d
 }
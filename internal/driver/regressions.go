@@ -0,0 +1,153 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+
+	"pproflame/internal/measurement"
+	"pproflame/internal/plugin"
+	"pproflame/internal/report"
+	"pproflame/profile"
+)
+
+// historyProfiles returns the snapshots of the history variable taken
+// before p, oldest first and up to the history_size variable, and fails
+// if there are fewer than two of them. By default, the snapshots are
+// the profiles saved for the binary and the sample types of p, which
+// include p itself once saved. The snapshots are rewritten with the
+// rules applied to p, and scaled with p to common units.
+func historyProfiles(p *profile.Profile, vars variables, ui plugin.UI) ([]*profile.Profile, error) {
+	pattern := vars["history"].value
+	if pattern == "" {
+		dir, err := setTmpDir(ui)
+		if err != nil {
+			return nil, err
+		}
+		pattern = savedProfiles(dir, "", p)
+	}
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("history %s: %v", pattern, err)
+	}
+
+	type snapshot struct {
+		file    string
+		modTime int64
+	}
+	var snapshots []snapshot
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil || fi.IsDir() {
+			continue
+		}
+		snapshots = append(snapshots, snapshot{f, fi.ModTime().UnixNano()})
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		if snapshots[i].modTime != snapshots[j].modTime {
+			return snapshots[i].modTime < snapshots[j].modTime
+		}
+		return snapshots[i].file < snapshots[j].file
+	})
+
+	// Read the snapshots from the newest, skipping p itself and the
+	// ones taken after it. Without a time, p is only recognized by its
+	// data, once its snapshot is rewritten like it.
+	size := vars["history_size"].intValue()
+	var history []*profile.Profile
+	var data string
+	for i := len(snapshots) - 1; i >= 0 && (size < 0 || len(history) < size); i-- {
+		s := snapshots[i]
+		f, err := os.Open(s.file)
+		if err != nil {
+			return nil, err
+		}
+		hp, err := profile.Parse(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %v", s.file, err)
+		}
+		if p.TimeNanos != 0 && hp.TimeNanos >= p.TimeNanos {
+			continue
+		}
+		if err := hp.ApplyRules(p.AppliedRules()); err != nil {
+			return nil, fmt.Errorf("rewriting %s: %v", s.file, err)
+		}
+		if p.TimeNanos == 0 {
+			if data == "" {
+				data = p.String()
+			}
+			if hp.String() == data {
+				continue
+			}
+		}
+		history = append(history, hp)
+	}
+	if len(history) < 2 {
+		return nil, fmt.Errorf("history %s: found %d snapshots before the profile, need at least 2", pattern, len(history))
+	}
+	for i, j := 0, len(history)-1; i < j; i, j = i+1, j-1 {
+		history[i], history[j] = history[j], history[i]
+	}
+	if err := measurement.ScaleProfiles(append([]*profile.Profile{p}, history...)); err != nil {
+		return nil, fmt.Errorf("history %s: %v", pattern, err)
+	}
+	return history, nil
+}
+
+// jsonRegressions is the regressions report, for clients that check
+// snapshots for regressions.
+type jsonRegressions struct {
+	Labels      []string            `json:"labels"`
+	Regressions []report.Regression `json:"regressions"`
+}
+
+// Regressions generates a web page with the entries whose share grew
+// significantly over earlier snapshots of the profile.
+func (ui *WebInterface) Regressions(c *gin.Context) {
+	rpt, errList := ui.makeReport(c, []string{"regressions"})
+	if rpt == nil {
+		return // error already reported
+	}
+	regressions, legend := report.DetectRegressions(rpt)
+	var nodes []string
+	for _, r := range regressions {
+		nodes = append(nodes, r.Name)
+	}
+
+	ui.render(c, "regressions", rpt, errList, legend, webArgs{
+		Regressions: regressions,
+		Nodes:       nodes,
+	})
+}
+
+// RegressionsJSON returns the regressions report in JSON.
+func (ui *WebInterface) RegressionsJSON(c *gin.Context) {
+	rpt, _ := ui.makeReport(c, []string{"regressions"})
+	if rpt == nil {
+		return // error already reported
+	}
+	regressions, legend := report.DetectRegressions(rpt)
+	if regressions == nil {
+		regressions = []report.Regression{}
+	}
+	c.JSON(http.StatusOK, jsonRegressions{Labels: legend, Regressions: regressions})
+}
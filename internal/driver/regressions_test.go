@@ -0,0 +1,163 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"pproflame/internal/proftest"
+	"pproflame/profile"
+)
+
+func TestHistoryProfiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	snapshot := func(time int64, unit string, value int64) *profile.Profile {
+		f := &profile.Function{ID: 1, Name: "work"}
+		l := &profile.Location{ID: 1, Line: []profile.Line{{Function: f}}}
+		return &profile.Profile{
+			SampleType: []*profile.ValueType{{Type: "cpu", Unit: unit}},
+			Sample:     []*profile.Sample{{Location: []*profile.Location{l}, Value: []int64{value}}},
+			Location:   []*profile.Location{l},
+			Function:   []*profile.Function{f},
+			TimeNanos:  time,
+		}
+	}
+
+	// Five snapshots, the fourth one in milliseconds instead of
+	// nanoseconds, written in a different order than their times.
+	for i, unit := range []string{"nanoseconds", "nanoseconds", "nanoseconds", "milliseconds", "nanoseconds"} {
+		p := snapshot(int64(i+1), unit, int64(i+1))
+		file := filepath.Join(dir, fmt.Sprintf("pprof.cpu.%03d.pb.gz", 5-i))
+		out, err := os.Create(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Write(out); err != nil {
+			t.Fatal(err)
+		}
+		out.Close()
+		mtime := time.Unix(int64(i)*60, 0)
+		if err := os.Chtimes(file, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The profile in memory is the one of time 4, rewritten by a rule:
+	// the snapshots saved for it and after it are left out, and the
+	// earlier ones are rewritten the same way.
+	p := snapshot(4, "milliseconds", 4)
+	if err := p.ApplyRules([]profile.Rule{{Action: profile.RuleRename, Match: "^work$", Replace: "job"}}); err != nil {
+		t.Fatal(err)
+	}
+	vars := PProfVariables.makeCopy()
+	vars.set("history", filepath.Join(dir, "pprof.cpu.*.pb.gz"))
+	vars.set("history_size", "2")
+	history, err := historyProfiles(p, vars, &proftest.TestUI{T: t})
+	if err != nil {
+		t.Fatalf("historyProfiles: %v", err)
+	}
+	var times []int64
+	for _, h := range history {
+		times = append(times, h.TimeNanos)
+		if name := h.Function[0].Name; name != "job" {
+			t.Errorf("snapshot of time %d: got function %q, want it renamed to job", h.TimeNanos, name)
+		}
+	}
+	if fmt.Sprint(times) != "[2 3]" {
+		t.Errorf("got earlier snapshots of times %v, want [2 3]", times)
+	}
+	if got := p.SampleType[0].Unit; got != "nanoseconds" {
+		t.Errorf("got profile in %s, want it scaled to nanoseconds", got)
+	}
+	if got := p.Sample[0].Value[0]; got != 4e6 {
+		t.Errorf("got profile value %d, want 4e6", got)
+	}
+
+	vars.set("history", filepath.Join(dir, "pprof.cpu.00[12].pb.gz"))
+	if _, err := historyProfiles(p, vars, &proftest.TestUI{T: t}); err == nil {
+		t.Errorf("historyProfiles: got no error without earlier snapshots")
+	}
+	vars.set("history", filepath.Join(dir, "pprof.cpu.00[123].pb.gz"))
+	if _, err := historyProfiles(p, vars, &proftest.TestUI{T: t}); err == nil {
+		t.Errorf("historyProfiles: got no error with a single earlier snapshot")
+	}
+
+	// Without a time, the snapshot saved for the profile is recognized
+	// by its data.
+	for i := 1; i <= 3; i++ {
+		file := filepath.Join(dir, fmt.Sprintf("pprof.untimed.%03d.pb.gz", i))
+		out, err := os.Create(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := snapshot(0, "nanoseconds", int64(i)).Write(out); err != nil {
+			t.Fatal(err)
+		}
+		out.Close()
+		mtime := time.Unix(int64(i)*60, 0)
+		if err := os.Chtimes(file, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	in, err := os.Open(filepath.Join(dir, "pprof.untimed.003.pb.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err = profile.Parse(in)
+	in.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars.set("history", filepath.Join(dir, "pprof.untimed.*.pb.gz"))
+	vars.set("history_size", "10")
+	history, err = historyProfiles(p, vars, &proftest.TestUI{T: t})
+	if err != nil {
+		t.Fatalf("historyProfiles of an untimed profile: %v", err)
+	}
+	var values []int64
+	for _, h := range history {
+		values = append(values, h.Sample[0].Value[0])
+	}
+	if fmt.Sprint(values) != "[1 2]" {
+		t.Errorf("got earlier untimed snapshots of values %v, want [1 2]", values)
+	}
+}
+
+func TestSavedProfilePrefix(t *testing.T) {
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}, {Type: "cpu", Unit: "nanoseconds"}},
+		Mapping:    []*profile.Mapping{{ID: 1, File: "/usr/bin/server"}},
+	}
+	for _, tc := range []struct {
+		service, want string
+	}{
+		{"", "pprof.server.samples.cpu."},
+		{"tradecenter", "pprof.tradecenter.server.samples.cpu."},
+	} {
+		if got := savedProfilePrefix(tc.service, p); got != tc.want {
+			t.Errorf("savedProfilePrefix(%q): got %q, want %q", tc.service, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+
+	"pproflame/internal/graph"
+	"pproflame/internal/report"
+)
+
+type sandwichTrees struct {
+	Callers *report.FlameNode `json:"callers"`
+	Callees *report.FlameNode `json:"callees"`
+}
+
+// Sandwich generates a web page with the callers and the callees of the
+// functions selected by the f parameter: a flame graph of the paths into
+// them, drawn upwards, above a flame graph of what they call.
+func (ui *WebInterface) Sandwich(c *gin.Context) {
+	// Build the same call tree as the flame graph, whose stacks are split
+	// at the selected functions. The focus restricts it to the samples
+	// going through them.
+	rpt, errList := ui.makeReport(c, []string{"svg"}, "call_tree", "true", "trim", "false")
+	if rpt == nil {
+		return // error already reported
+	}
+	g, config := report.GetDOT(rpt)
+
+	var b []byte
+	if f := c.Query("f"); f != "" {
+		re, err := regexp.Compile(f)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			ui.options.UI.PrintErr(err)
+			return
+		}
+		callers, callees := sandwich(g, config, re)
+		if b, err = json.Marshal(&sandwichTrees{callers, callees}); err != nil {
+			c.String(http.StatusInternalServerError, "error serializing sandwich")
+			ui.options.UI.PrintErr(err)
+			return
+		}
+	}
+
+	// Get all node names into an array.
+	nodes := []string{}
+	for _, n := range g.Nodes {
+		nodes = append(nodes, n.Info.Name)
+	}
+
+	ui.render(c, "sandwich", rpt, errList, config.Labels, webArgs{
+		Sandwich: template.JS(b),
+		Nodes:    nodes,
+	})
+}
+
+// sandwich returns the flame graphs of the callers and of the callees
+// of the nodes of a call tree whose names match re. Every stack of the
+// tree is split at its outermost matching frame, so that recursive
+// calls are counted once: the frames from there up to the root are
+// merged into the callers, and the frames from there down to the leaf
+// into the callees. Both flame graphs have the matching functions as
+// the children of their root.
+func sandwich(g *graph.Graph, config *graph.DotConfig, re *regexp.Regexp) (callers, callees *report.FlameNode) {
+	callers = report.NewFlameNode("root", 0, config)
+	callees = report.NewFlameNode("root", 0, config)
+	for _, n := range g.Nodes {
+		v := n.FlatValue()
+		if v == 0 {
+			continue
+		}
+		stack := treeStack(n)
+		outer := -1
+		for i, frame := range stack {
+			if re.MatchString(frame.Info.Name) {
+				outer = i
+			}
+		}
+		if outer < 0 {
+			continue
+		}
+		addStack(callers, stack[outer:], v, config)
+		var down []*graph.Node
+		for i := outer; i >= 0; i-- {
+			down = append(down, stack[i])
+		}
+		addStack(callees, down, v, config)
+	}
+	setTreeFormat(callers, config)
+	setTreeFormat(callees, config)
+	return callers, callees
+}
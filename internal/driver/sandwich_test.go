@@ -0,0 +1,93 @@
+package driver
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+
+	"pproflame/internal/graph"
+)
+
+func TestSandwich(t *testing.T) {
+	// A call tree with the stacks main>foo>malloc (30), main>bar>malloc (20),
+	// main>bar (10) and the recursive main>rec>rec>leaf (5).
+	newNode := func(name string, flat int64) *graph.Node {
+		return &graph.Node{Info: graph.NodeInfo{Name: name}, Flat: flat, In: graph.EdgeMap{}, Out: graph.EdgeMap{}}
+	}
+	main := newNode("main", 0)
+	foo := newNode("foo", 0)
+	bar := newNode("bar", 10)
+	malloc1 := newNode("malloc", 30)
+	malloc2 := newNode("malloc", 20)
+	rec1 := newNode("rec", 0)
+	rec2 := newNode("rec", 0)
+	leaf := newNode("leaf", 5)
+	main.AddToEdge(foo, 30, false, false)
+	main.AddToEdge(bar, 30, false, false)
+	main.AddToEdge(rec1, 5, false, false)
+	foo.AddToEdge(malloc1, 30, false, false)
+	bar.AddToEdge(malloc2, 20, false, false)
+	rec1.AddToEdge(rec2, 5, false, false)
+	rec2.AddToEdge(leaf, 5, false, false)
+	g := &graph.Graph{Nodes: graph.Nodes{main, foo, bar, malloc1, malloc2, rec1, rec2, leaf}}
+	config := &graph.DotConfig{
+		Total:       65,
+		FormatValue: func(v int64) string { return fmt.Sprint(v) },
+	}
+
+	for _, tc := range []struct {
+		re               string
+		callers, callees string
+	}{
+		{
+			re: "malloc",
+			callers: `root 50 76.92%
+ malloc 50 76.92%
+  bar 20 30.77%
+   main 20 30.77%
+  foo 30 46.15%
+   main 30 46.15%
+`,
+			callees: `root 50 76.92%
+ malloc 50 76.92%
+`,
+		},
+		{
+			re: "bar",
+			callers: `root 30 46.15%
+ bar 30 46.15%
+  main 30 46.15%
+`,
+			callees: `root 30 46.15%
+ bar 30 46.15%
+  malloc 20 30.77%
+`,
+		},
+		{
+			// Recursive calls are split at the outermost call.
+			re: "rec",
+			callers: `root 5 7.69%
+ rec 5 7.69%
+  main 5 7.69%
+`,
+			callees: `root 5 7.69%
+ rec 5 7.69%
+  rec 5 7.69%
+   leaf 5 7.69%
+`,
+		},
+		{
+			re:      "nomatch",
+			callers: "root 0 0%\n",
+			callees: "root 0 0%\n",
+		},
+	} {
+		callers, callees := sandwich(g, config, regexp.MustCompile(tc.re))
+		if got := printTree(callers); got != tc.callers {
+			t.Errorf("%s: callers got:\n%s\nwant:\n%s", tc.re, got, tc.callers)
+		}
+		if got := printTree(callees); got != tc.callees {
+			t.Errorf("%s: callees got:\n%s\nwant:\n%s", tc.re, got, tc.callees)
+		}
+	}
+}
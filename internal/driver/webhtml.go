@@ -19,11 +19,15 @@ import "pproflame/third_party/d3"
 
 import "pproflame/third_party/d3flamegraph"
 
+import "pproflame/profile"
+
 // addTemplates adds a set of template definitions to templates.
 func addTemplates(templates *template.Template) {
 	template.Must(templates.Parse(`{{define "d3script"}}` + d3.JSSource + `{{end}}`))
 	template.Must(templates.Parse(`{{define "d3flamegraphscript"}}` + d3flamegraph.JSSource + `{{end}}`))
 	template.Must(templates.Parse(`{{define "d3flamegraphcss"}}` + d3flamegraph.CSSSource + `{{end}}`))
+	template.Must(templates.Parse(`{{define "stdmodule"}}` + profile.StdModule + `{{end}}`))
+	template.Must(templates.Parse(`{{define "stdmodulefocus"}}` + profile.StdModuleFocus() + `{{end}}`))
 	template.Must(templates.Parse(`
 {{define "css"}}
 <style type="text/css">
@@ -206,19 +210,19 @@ table tr td {
   padding: .3em .5em;
   text-align: right;
 }
-#top table tr th:nth-child(6),
-#top table tr th:nth-child(7),
-#top table tr td:nth-child(6),
-#top table tr td:nth-child(7) {
+#top table tr th:nth-last-child(2),
+#top table tr th:nth-last-child(1),
+#top table tr td:nth-last-child(2),
+#top table tr td:nth-last-child(1) {
   text-align: left;
 }
-#top table tr td:nth-child(6) {
+#top table tr td:nth-last-child(2) {
   width: 100%;
   text-overflow: ellipsis;
   overflow: hidden;
   white-space: nowrap;
 }
-#flathdr1, #flathdr2, #cumhdr1, #cumhdr2, #namehdr {
+#flathdr1, #flathdr2, #cumhdr1, #cumhdr2, #namehdr, .colhdr {
   cursor: ns-resize;
 }
 .hilite {
@@ -242,7 +246,11 @@ table tr td {
     <div class="submenu">
       <a title="{{.Help.top}}"  href="./top" id="topbtn">Top</a>
       <a title="{{.Help.graph}}" href="./" id="graphbtn">Graph</a>
+      <a title="{{.Help.interactivegraph}}" href="./graph" id="interactivegraph">Interactive Graph</a>
       <a title="{{.Help.flamegraph}}" href="./flamegraph" id="flamegraph">Flame Graph</a>
+      <a title="{{.Help.sandwich}}" href="./sandwich" id="sandwich">Sandwich</a>
+      <a title="{{.Help.paths}}" href="./paths" id="paths">Paths</a>
+      <a title="{{.Help.regressions}}" href="./regressions" id="regressions">Regressions</a>
       <a title="{{.Help.peek}}" href="./peek" id="peek">Peek</a>
       <a title="{{.Help.list}}" href="./source" id="list">Source</a>
       <a title="{{.Help.disasm}}" href="./disasm" id="disasm">Disassemble</a>
@@ -264,6 +272,37 @@ table tr td {
     </div>
   </div>
 
+  <div id="granularity" class="menu-item">
+    <div class="menu-name">
+      Granularity
+      <i class="downArrow"></i>
+    </div>
+    <div class="submenu">
+      <a title="{{.Help.functions}}" href="?" class="granularity" data-key="functions">Functions</a>
+      <a title="{{.Help.packages}}" href="?" class="granularity" data-key="packages">Packages</a>
+      <a title="{{.Help.modules}}" href="?" class="granularity" data-key="modules">Modules</a>
+      <a title="{{.Help.mappings}}" href="?" class="granularity" data-key="mappings">Mappings</a>
+      <a title="{{.Help.files}}" href="?" class="granularity" data-key="files">Files</a>
+      <a title="{{.Help.lines}}" href="?" class="granularity" data-key="lines">Lines</a>
+      <hr>
+      <a title="{{.Help.drilldown}}" href="?" id="drilldown">Drill down</a>
+    </div>
+  </div>
+
+  {{if .Labels}}
+  <div id="groupby" class="menu-item">
+    <div class="menu-name">
+      Group by
+      <i class="downArrow"></i>
+    </div>
+    <div class="submenu">
+      {{range .Labels}}<a title="{{$.Help.groupby}}" href="?" class="groupby" data-key="{{.}}">{{.}}</a>
+      {{end}}<hr>
+      <a title="Do not group samples by label" href="?" class="groupby" data-key="">None</a>
+    </div>
+  </div>
+  {{end}}
+
   <div>
     <input id="search" type="text" placeholder="Search regexp" autocomplete="off" autocapitalize="none" size=40>
   </div>
@@ -565,6 +604,18 @@ function initMenus() {
   }, { passive: true, capture: true });
 }
 
+// Open the sandwich view of the function called name, keeping the
+// other parameters of the current page.
+function openSandwich(name) {
+  if (name == '' || name == 'root') return;
+  const url = new URL('./sandwich', window.location.href);
+  for (const p of new URLSearchParams(window.location.search)) {
+    url.searchParams.set(p[0], p[1]);
+  }
+  url.searchParams.set('f', name.replace(/([\\\.?+*\[\](){}|^$])/g, '\\$1'));
+  window.location.href = url.toString();
+}
+
 function viewer(baseUrl, nodes) {
   'use strict';
 
@@ -773,18 +824,16 @@ function viewer(baseUrl, nodes) {
   }
 
   function handleTopClick(e) {
-    // Walk back until we find TR and then get the Name column (index 5)
+    // Walk back until we find TR and then get the name of its entry.
     let elem = e.target;
     while (elem != null && elem.nodeName != 'TR') {
       elem = elem.parentElement;
     }
-    if (elem == null || elem.children.length < 6) return;
+    if (elem == null || elem.dataset.name == null) return;
 
     e.preventDefault();
     const tr = elem;
-    const td = elem.children[5];
-    if (td.nodeName != 'TD') return;
-    const name = td.innerText;
+    const name = elem.dataset.name;
     const index = nodes.indexOf(name);
     if (index < 0) return;
 
@@ -799,6 +848,25 @@ function viewer(baseUrl, nodes) {
     updateButtons();
   }
 
+  // Open the sandwich view of a node on double click.
+  function handleSvgDoubleClick(e) {
+    let elem = e.target;
+    while (elem != null && elem.parentElement != graph0) {
+      elem = elem.parentElement;
+    }
+    if (elem == null) return;
+    const n = nodeId(elem);
+    if (n >= 0) openSandwich(nodes[n]);
+  }
+
+  function handleTopDoubleClick(e) {
+    let elem = e.target;
+    while (elem != null && elem.nodeName != 'TR') {
+      elem = elem.parentElement;
+    }
+    if (elem != null && elem.dataset.name != null) openSandwich(elem.dataset.name);
+  }
+
   function updateButtons() {
     const enable = (search.value != '' || selected.size != 0);
     if (buttonsEnabled == enable) return;
@@ -818,16 +886,78 @@ function viewer(baseUrl, nodes) {
   initMenus();
   if (svg != null) {
     initPanAndZoom(svg, toggleSvgSelect);
+    svg.addEventListener('dblclick', handleSvgDoubleClick);
   }
   if (toptable != null) {
     toptable.addEventListener('mousedown', handleTopClick);
     toptable.addEventListener('touchstart', handleTopClick);
+    toptable.addEventListener('dblclick', handleTopDoubleClick);
   }
 
-  const ids = ['topbtn', 'graphbtn', 'peek', 'list', 'disasm',
-               'focus', 'ignore', 'hide', 'show'];
+  const ids = ['topbtn', 'graphbtn', 'interactivegraph', 'sandwich', 'paths', 'regressions',
+               'peek', 'list', 'disasm', 'focus', 'ignore', 'hide', 'show'];
   ids.forEach(makeLinkDynamic);
 
+  // Group by links keep the current parameters and replace 'g'.
+  const groupBy = new URL(window.location.href).searchParams.get('g') || '';
+  for (const link of document.getElementsByClassName('groupby')) {
+    const key = link.dataset.key;
+    link.classList.toggle('active', key == groupBy);
+    const url = new URL(window.location.href);
+    url.hash = '';
+    if (key != '') {
+      url.searchParams.set('g', key);
+    } else {
+      url.searchParams.delete('g');
+    }
+    link.href = url.toString();
+  }
+
+  // Granularity links keep the current parameters and replace 'l'.
+  const granularity = new URL(window.location.href).searchParams.get('l') || 'functions';
+  for (const link of document.getElementsByClassName('granularity')) {
+    const key = link.dataset.key;
+    link.classList.toggle('active', key == granularity);
+    const url = new URL(window.location.href);
+    url.hash = '';
+    if (key != 'functions') {
+      url.searchParams.set('l', key);
+    } else {
+      url.searchParams.delete('l');
+    }
+    link.href = url.toString();
+  }
+
+  // The drill down link shows the functions of the selected packages,
+  // modules or mappings, focusing on the functions named after them or
+  // on the locations of the mapped files. The std module stands for
+  // every package of the standard library.
+  const drilldown = document.getElementById('drilldown');
+  if (drilldown != null) {
+    const drillRegexp = {
+      'packages': name => '^' + quotemeta(name) + '\\.',
+      'modules': name => name == '{{template "stdmodule"}}'
+          ? '{{template "stdmodulefocus"}}'
+          : '^' + quotemeta(name) + '[./]',
+      'mappings': name => '(^|/)' + quotemeta(name) + '$',
+    }[granularity];
+    drilldown.classList.toggle('disabled', drillRegexp == undefined);
+    const updater = () => {
+      const url = new URL(window.location.href);
+      url.hash = '';
+      url.searchParams.delete('l');
+      const re = regexpActive
+        ? search.value
+        : Array.from(selected.keys()).map(key => drillRegexp(nodes[key])).join('|');
+      if (re != '') {
+        url.searchParams.set('f', re);
+      }
+      drilldown.href = url.toString();
+    };
+    drilldown.addEventListener('mouseenter', updater);
+    drilldown.addEventListener('touchstart', updater);
+  }
+
   // Bind action to button with specified id.
   function addAction(id, action) {
     const btn = document.getElementById(id);
@@ -859,11 +989,27 @@ function viewer(baseUrl, nodes) {
   <title>{{.Title}}</title>
   {{template "css" .}}
   <style type="text/css">
+  .top-options {
+    margin: 0.5em 1em;
+  }
+  .top-options a {
+    margin-right: 0.5em;
+  }
+  .top-options a.active {
+    font-weight: bold;
+  }
+  .top-options span {
+    margin-right: 2em;
+  }
   </style>
 </head>
 <body>
   {{template "header" .}}
   <div id="top">
+    <div class="top-options">
+      <span>Columns: {{range .Samples}}<a href="?" class="top-column" data-column="{{.}}" title="Show or hide the {{.}} values">{{.}}</a>{{end}}</span>
+      <span>Export: <a id="topcsv" href="./top.csv">CSV</a><a id="toptsv" href="./top.tsv">TSV</a></span>
+    </div>
     <table id="toptable">
       <thead>
         <tr>
@@ -872,6 +1018,7 @@ function viewer(baseUrl, nodes) {
           <th>Sum%</th>
           <th id="cumhdr1">Cum</th>
           <th id="cumhdr2">Cum%</th>
+          {{range $i, $c := .Columns}}<th class="colhdr" data-key="c{{$i}}">{{$c}}</th><th class="colhdr" data-key="c{{$i}}.cum">{{$c}}.cum</th>{{end}}
           <th id="namehdr">Name</th>
           <th>Inlined?</th>
         </tr>
@@ -881,7 +1028,7 @@ function viewer(baseUrl, nodes) {
   </div>
   {{template "script" .}}
   <script>
-    function makeTopTable(total, entries) {
+    function makeTopTable(total, entries, columns) {
       const rows = document.getElementById('rows');
       if (rows == null) return;
 
@@ -906,8 +1053,8 @@ function viewer(baseUrl, nodes) {
 
         // Sort according to current criteria.
         function cmp(a, b) {
-          const av = a[currentColumn];
-          const bv = b[currentColumn];
+          const av = value(a, currentColumn);
+          const bv = value(b, currentColumn);
           if (av < bv) return -1;
           if (av > bv) return +1;
           return 0;
@@ -931,12 +1078,17 @@ function viewer(baseUrl, nodes) {
         for (const row of entries) {
           const tr = document.createElement('tr');
           tr.id = row.Id;
+          tr.dataset.name = row.Name;
           sum += row.Flat;
           addCell(tr, row.FlatFormat);
           addCell(tr, percent(row.Flat));
           addCell(tr, percent(sum));
           addCell(tr, row.CumFormat);
           addCell(tr, percent(row.Cum));
+          for (const v of row.Columns || []) {
+            addCell(tr, v.FlatFormat);
+            addCell(tr, v.CumFormat);
+          }
           addCell(tr, row.Name);
           addCell(tr, row.InlineLabel);
           fragment.appendChild(tr);
@@ -944,6 +1096,35 @@ function viewer(baseUrl, nodes) {
 
         rows.textContent = ''; // Remove old rows
         rows.appendChild(fragment);
+        updateExport();
+      }
+
+      // The value of an entry in a column: Flat, Cum, Name, or cN and
+      // cN.cum for the flat and cum values of the Nth extra column.
+      function value(entry, column) {
+        const m = /^c(\d+)(\.cum)?$/.exec(column);
+        if (m == null) return entry[column];
+        const v = entry.Columns[+m[1]];
+        return m[2] ? v.Cum : v.Flat;
+      }
+
+      // Export the entries in the order of the table, when it is one
+      // the server sorts by: by decreasing value, or increasing name.
+      function updateExport() {
+        let sort = {Flat: 'flat', Cum: 'cum', Name: 'name'}[currentColumn];
+        const m = /^c(\d+)(\.cum)?$/.exec(currentColumn);
+        if (m != null) {
+          sort = columns[+m[1]] + (m[2] || '');
+        }
+        for (const id of ['topcsv', 'toptsv']) {
+          const link = document.getElementById(id);
+          const url = new URL(id == 'topcsv' ? './top.csv' : './top.tsv', window.location.href);
+          url.search = window.location.search;
+          if (descending == (currentColumn != 'Name')) {
+            url.searchParams.set('sort', sort);
+          }
+          link.href = url.toString();
+        }
       }
 
       // Make different column headers trigger sorting.
@@ -959,10 +1140,217 @@ function viewer(baseUrl, nodes) {
       bindSort('cumhdr1', 'Cum');
       bindSort('cumhdr2', 'Cum');
       bindSort('namehdr', 'Name');
+      for (const hdr of document.getElementsByClassName('colhdr')) {
+        const fn = function() { sortBy(hdr.dataset.key) };
+        hdr.addEventListener('click', fn);
+        hdr.addEventListener('touch', fn);
+      }
+    }
+
+    // Toggle the sample values shown as extra columns, kept in the c
+    // parameter.
+    (function() {
+      const url = new URL(window.location.href);
+      const shown = (url.searchParams.get('c') || '').split(',').filter(c => c != '');
+      for (const link of document.getElementsByClassName('top-column')) {
+        const column = link.dataset.column;
+        const on = shown.includes(column);
+        link.classList.toggle('active', on);
+        const next = on ? shown.filter(c => c != column) : shown.concat([column]);
+        const linkUrl = new URL(window.location.href);
+        linkUrl.hash = '';
+        if (next.length > 0) {
+          linkUrl.searchParams.set('c', next.join(','));
+        } else {
+          linkUrl.searchParams.delete('c');
+        }
+        link.href = linkUrl.toString();
+      }
+    })();
+
+    viewer(new URL(window.location.href), {{.Nodes}});
+    makeTopTable({{.Total}}, {{.Top}}, {{.Columns}});
+  </script>
+</body>
+</html>
+{{end}}
+
+{{define "paths" -}}
+<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>{{.Title}}</title>
+  {{template "css" .}}
+  <style type="text/css">
+    #pathstable td {
+      vertical-align: top;
+    }
+    #pathstable th:last-child,
+    #pathstable td:last-child {
+      text-align: left;
+      width: 100%;
+    }
+    .pathframe {
+      display: block;
+      color: black;
+      text-decoration: none;
+    }
+    .pathframe:hover {
+      text-decoration: underline;
+    }
+    .pathcommon {
+      display: block;
+      color: gray;
+      cursor: pointer;
+    }
+    .pathcommon + .pathprefix {
+      display: none;
     }
+    .pathcommon.expanded + .pathprefix {
+      display: block;
+    }
+  </style>
+</head>
+<body>
+  {{template "header" .}}
+  <div id="top">
+    <table id="pathstable">
+      <thead>
+        <tr>
+          <th>Value</th>
+          <th>Value%</th>
+          <th>Sum%</th>
+          <th>Path</th>
+        </tr>
+      </thead>
+      <tbody>
+        {{range $p := .Paths}}
+        <tr>
+          <td>{{.ValueFormat}}</td>
+          <td>{{.Percent}}</td>
+          <td>{{.SumPercent}}</td>
+          <td>
+            {{if .Common}}
+            <span class="pathcommon" title="Show the frames shared with the path above">&hellip; {{.Common}} frame{{if gt .Common 1}}s{{end}} as above</span>
+            <div class="pathprefix">
+              {{range $i, $f := .Frames}}{{if lt $i $p.Common}}<a class="pathframe" href="?" data-name="{{index $p.Names $i}}">{{$f}}</a>{{end}}{{end}}
+            </div>
+            {{end}}
+            {{range $i, $f := .Frames}}{{if ge $i $p.Common}}<a class="pathframe" href="?" data-name="{{index $p.Names $i}}">{{$f}}</a>{{end}}{{end}}
+          </td>
+        </tr>
+        {{end}}
+      </tbody>
+    </table>
+  </div>
+  {{template "script" .}}
+  <script>
+    viewer(new URL(window.location.href), {{.Nodes}});
+
+    // Every frame links to the graph focused on its function, or on its
+    // file when it has none.
+    for (const link of document.getElementsByClassName('pathframe')) {
+      const url = new URL('./', window.location.href);
+      for (const p of new URLSearchParams(window.location.search)) {
+        url.searchParams.set(p[0], p[1]);
+      }
+      url.searchParams.set('f', link.dataset.name.replace(/([\\\.?+*\[\](){}|^$])/g, '\\$1'));
+      link.href = url.toString();
+    }
+    for (const common of document.getElementsByClassName('pathcommon')) {
+      common.addEventListener('click', () => common.classList.toggle('expanded'));
+    }
+  </script>
+</body>
+</html>
+{{end}}
 
+{{define "regressions" -}}
+<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>{{.Title}}</title>
+  {{template "css" .}}
+  <style type="text/css">
+    #regressionstable th:last-child,
+    #regressionstable td:last-child {
+      text-align: left;
+      width: 100%;
+    }
+    #regressionstable .insignificant {
+      color: gray;
+    }
+    .regressionname {
+      color: black;
+      text-decoration: none;
+    }
+    .regressionname:hover {
+      text-decoration: underline;
+    }
+    .regressions-empty {
+      padding: 2em 5%;
+    }
+  </style>
+</head>
+<body>
+  {{template "header" .}}
+  <div id="top">
+    {{if .Regressions}}
+    <table id="regressionstable">
+      <thead>
+        <tr>
+          <th>Flat</th>
+          <th>Flat%</th>
+          <th title="Mean share in the earlier snapshots">Base%</th>
+          <th title="Increase, in standard deviations of the earlier shares">Score</th>
+          <th>Cum</th>
+          <th>Cum%</th>
+          <th title="Mean share in the earlier snapshots">Base%</th>
+          <th title="Increase, in standard deviations of the earlier shares">Score</th>
+          <th>Name</th>
+        </tr>
+      </thead>
+      <tbody>
+        {{range .Regressions}}
+        <tr>
+          {{with .Flat}}
+          <td{{if not .Significant}} class="insignificant"{{end}}>{{.ValueFormat}}</td>
+          <td{{if not .Significant}} class="insignificant"{{end}}>{{.SharePercent}}</td>
+          <td{{if not .Significant}} class="insignificant"{{end}}>{{.BasePercent}}</td>
+          <td{{if not .Significant}} class="insignificant"{{end}}>{{printf "%.1f" .Score}}</td>
+          {{end}}
+          {{with .Cum}}
+          <td{{if not .Significant}} class="insignificant"{{end}}>{{.ValueFormat}}</td>
+          <td{{if not .Significant}} class="insignificant"{{end}}>{{.SharePercent}}</td>
+          <td{{if not .Significant}} class="insignificant"{{end}}>{{.BasePercent}}</td>
+          <td{{if not .Significant}} class="insignificant"{{end}}>{{printf "%.1f" .Score}}</td>
+          {{end}}
+          <td><a class="regressionname" href="?" data-name="{{.Name}}">{{.Name}}</a></td>
+        </tr>
+        {{end}}
+      </tbody>
+    </table>
+    {{else}}
+    <div class="regressions-empty">
+      No entry grew significantly over the earlier snapshots.
+    </div>
+    {{end}}
+  </div>
+  {{template "script" .}}
+  <script>
     viewer(new URL(window.location.href), {{.Nodes}});
-    makeTopTable({{.Total}}, {{.Top}});
+
+    // Every entry links to the graph focused on it.
+    for (const link of document.getElementsByClassName('regressionname')) {
+      const url = new URL('./', window.location.href);
+      for (const p of new URLSearchParams(window.location.search)) {
+        url.searchParams.set(p[0], p[1]);
+      }
+      url.searchParams.set('f', link.dataset.name.replace(/([\\\.?+*\[\](){}|^$])/g, '\\$1'));
+      link.href = url.toString();
+    }
   </script>
 </body>
 </html>
@@ -1031,11 +1419,57 @@ function viewer(baseUrl, nodes) {
       margin-left: 5%;
       padding: 15px 0 35px;
     }
+    .flamegraph-modes {
+      width: 90%;
+      margin: 10px 0 0 5%;
+    }
+    .flamegraph-modes a {
+      padding: .2em .5em;
+      text-decoration: none;
+      border: 1px solid #ccc;
+    }
+    .flamegraph-modes a.active {
+      color: white;
+      background-color: #6b82d6;
+    }
+    .flamegraph-modes span {
+      margin-right: 2em;
+    }
+    .flamegraph-timeline {
+      display: flex;
+      align-items: flex-end;
+      height: 60px;
+      width: 90%;
+      margin: 10px 0 0 5%;
+      cursor: crosshair;
+      user-select: none;
+      border-bottom: 1px solid #999;
+    }
+    .flamegraph-timeline div {
+      flex: 1;
+      background-color: #e8a060;
+      border-right: 1px solid #fff;
+    }
+    .flamegraph-timeline div.selected {
+      background-color: #c05010;
+    }
   </style>
 </head>
 <body>
   {{template "header" .}}
   <div id="bodycontainer">
+    <div class="flamegraph-modes">
+      <span>
+        <a href="?" class="flamegraph-mode" data-key="r" data-value="" title="Root the flame graph at the roots of the call stacks">Top down</a><a href="?" class="flamegraph-mode" data-key="r" data-value="1" title="Root the flame graph at the functions the samples were taken in, with their callers above them">Reverse</a>
+      </span>
+      <span>
+        <a href="?" class="flamegraph-mode" data-key="o" data-value="" title="Draw the roots at the top">Icicle</a><a href="?" class="flamegraph-mode" data-key="o" data-value="flame" title="Draw the roots at the bottom">Flame</a>
+      </span>
+      {{if .Diff}}<span>
+        <a href="?" class="flamegraph-mode" data-key="b" data-value="" title="Size the frames by their value in the current profile">Current</a><a href="?" class="flamegraph-mode" data-key="b" data-value="1" title="Size the frames by their value in the base profile">Base</a>
+      </span>{{end}}
+    </div>
+    {{if .Timeline}}<div id="timeline" class="flamegraph-timeline" title="Drag to select a time window"></div>{{end}}
     <div id="flamegraphdetails" class="flamegraph-details"></div>
     <div class="flamegraph-content">
       <div id="chart"></div>
@@ -1048,6 +1482,24 @@ function viewer(baseUrl, nodes) {
   <script>
     var data = {{.FlameGraph}};
 
+    // The r parameter selects the reverse flame graph, which the server
+    // builds, the o parameter the orientation of the graph and the b
+    // parameter sizes a differential flame graph by the base profile.
+    var params = new URL(window.location.href).searchParams;
+    for (const link of document.getElementsByClassName('flamegraph-mode')) {
+      const key = link.dataset.key;
+      const value = link.dataset.value;
+      link.classList.toggle('active', (params.get(key) || '') == value);
+      const url = new URL(window.location.href);
+      url.hash = '';
+      if (value != '') {
+        url.searchParams.set(key, value);
+      } else {
+        url.searchParams.delete(key);
+      }
+      link.href = url.toString();
+    }
+
     var width = document.getElementById('chart').clientWidth;
 
     var flameGraph = d3.flamegraph()
@@ -1056,7 +1508,7 @@ function viewer(baseUrl, nodes) {
       .minFrameSize(1)
       .transitionDuration(750)
       .transitionEase(d3.easeCubic)
-      .inverted(true)
+      .inverted(params.get('o') != 'flame')
       .title('')
       .tooltip(false)
       .details(document.getElementById('flamegraphdetails'));
@@ -1064,6 +1516,24 @@ function viewer(baseUrl, nodes) {
     // <full name> (percentage, value)
     flameGraph.label((d) => d.data.f + ' (' + d.data.p + ', ' + d.data.l + ')');
 
+    {{if .Diff}}
+    // Frames of a differential flame graph are red when they grew from
+    // the base profile to the current one and blue when they shrank, the
+    // deeper the larger the relative change.
+    flameGraph.label((d) => d.data.f + ' (base ' + d.data.bl + ', current ' + d.data.cl +
+        ', ' + d.data.dl + (d.data.dp ? ', ' + d.data.dp : '') + ')');
+    flameGraph.color((d) => {
+      if (d.highlight) return '#E600E6';
+      const base = d.data.b || 0;
+      const current = d.data.cv || 0;
+      let change = base == 0 ? (current == 0 ? 0 : 1) : (current - base) / base;
+      change = Math.max(-1, Math.min(1, change));
+      const shade = Math.round(230 - 180 * Math.abs(change));
+      if (change > 0) return 'rgb(255,' + shade + ',' + shade + ')';
+      if (change < 0) return 'rgb(' + shade + ',' + shade + ',255)';
+      return 'rgb(230,230,230)';
+    });
+    {{else}}
     (function(flameGraph) {
       var oldColorMapper = flameGraph.color();
       function colorMapper(d) {
@@ -1074,11 +1544,20 @@ function viewer(baseUrl, nodes) {
 
       flameGraph.color(colorMapper);
     }(flameGraph));
+    {{end}}
 
     d3.select('#chart')
       .datum(data)
       .call(flameGraph);
 
+    // Open the sandwich view of a frame on double click.
+    document.getElementById('chart').addEventListener('dblclick', (e) => {
+      const frame = e.target.closest('g');
+      if (frame == null) return;
+      const d = d3.select(frame).datum();
+      if (d && d.data) openSandwich(d.data.f);
+    });
+
     function clear() {
       flameGraph.clear();
     }
@@ -1119,7 +1598,607 @@ function viewer(baseUrl, nodes) {
     }
 
     search.addEventListener('input', handleSearch);
+
+    // The SVG image is rendered by the server, for the same profile
+    // selection and with the current search passed as the q parameter.
+    document.getElementById('flamegraphsvg').addEventListener('click', function() {
+      const url = new URL('./flamegraph.svg', window.location.href);
+      url.search = window.location.search;
+      if (search.value != '') {
+        url.searchParams.set('q', search.value);
+      }
+      this.href = url.toString();
+    });
+
+    // Draw the timeline histogram and let the user drag over it to
+    // select a time window, which is applied through the t parameter.
+    (function(buckets) {
+      const timeline = document.getElementById('timeline');
+      if (timeline == null || buckets == null) return;
+
+      // Parse a duration such as 1.5s or 200ms, as produced by fmtDuration.
+      function parseDuration(str) {
+        const m = /^([0-9.]+)(ns|us|ms|s|m|h)$/.exec(str);
+        if (m == null) return null;
+        const scale = {ns: 1, us: 1e3, ms: 1e6, s: 1e9, m: 60e9, h: 3600e9};
+        return parseFloat(m[1]) * scale[m[2]];
+      }
+
+      function fmtDuration(nanos) {
+        return (nanos / 1e9).toFixed(3) + 's';
+      }
+
+      // Highlight the window currently in effect, if any.
+      let from = 0;
+      let to = Infinity;
+      const current = new URL(window.location.href).searchParams.get('t');
+      if (current) {
+        const bounds = current.split(':');
+        from = parseDuration(bounds[0]) || 0;
+        to = parseDuration(bounds[1] || '') || Infinity;
+      }
+
+      const max = Math.max(1, ...buckets.map(b => b.v));
+      const bars = buckets.map((b) => {
+        const bar = document.createElement('div');
+        bar.style.height = (100 * b.v / max) + '%';
+        bar.title = fmtDuration(b.s) + ' - ' + fmtDuration(b.e) + ': ' + b.l;
+        bar.classList.toggle('selected', current && b.s >= from && b.e <= to);
+        timeline.appendChild(bar);
+        return bar;
+      });
+
+      let dragStart = -1;
+      let dragEnd = -1;
+
+      function showDrag() {
+        const lo = Math.min(dragStart, dragEnd);
+        const hi = Math.max(dragStart, dragEnd);
+        bars.forEach((bar, i) => bar.classList.toggle('selected', i >= lo && i <= hi));
+      }
+
+      bars.forEach((bar, i) => {
+        bar.addEventListener('mousedown', (e) => {
+          dragStart = dragEnd = i;
+          showDrag();
+          e.preventDefault();
+        });
+        bar.addEventListener('mouseenter', () => {
+          if (dragStart < 0) return;
+          dragEnd = i;
+          showDrag();
+        });
+      });
+
+      document.addEventListener('mouseup', () => {
+        if (dragStart < 0) return;
+        const lo = Math.min(dragStart, dragEnd);
+        const hi = Math.max(dragStart, dragEnd);
+        dragStart = dragEnd = -1;
+        const url = new URL(window.location.href);
+        url.searchParams.set('t', fmtDuration(buckets[lo].s) + ':' + fmtDuration(buckets[hi].e));
+        window.location.href = url.toString();
+      });
+    }({{.Timeline}}));
+  </script>
+</body>
+</html>
+{{end}}
+
+{{define "interactivegraph" -}}
+<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>{{.Title}}</title>
+  {{template "css" .}}
+  <style type="text/css">
+    #graphactions {
+      padding: .5em 1em;
+      border-bottom: 1px solid #ddd;
+    }
+    #graphactions button {
+      margin-right: .5em;
+    }
+    #graphactions span {
+      color: #666;
+    }
+    #graph .node {
+      cursor: pointer;
+    }
+    #graph .node.current polygon {
+      stroke-width: 3;
+    }
+  </style>
+</head>
+<body>
+  {{template "header" .}}
+  <div id="graphactions">
+    <button id="graphfocus" title="Show only the paths through the selected node" disabled>Focus</button>
+    <button id="graphcallers" title="Add the callers of the selected node" disabled>Expand callers</button>
+    <button id="graphcallees" title="Add the callees of the selected node" disabled>Expand callees</button>
+    <button id="graphhide" title="Remove the selected node" disabled>Hide</button>
+    <button id="graphreset" title="Show the whole graph again">Show all</button>
+    <span id="graphcurrent"></span>
+  </div>
+  <div id="graph"></div>
+  {{template "script" .}}
+  <script>viewer(new URL(window.location.href), {{.Nodes}});</script>
+  <script>
+// Draw the call graph in the browser. Nodes are laid out in layers,
+// callers above callees, and can be focused on, expanded and hidden
+// without asking the server for a new graph. Focus and hide are
+// mirrored into the f and h parameters of the URL, so that reloading
+// the page, or following a link from it, keeps them.
+(function(data) {
+  'use strict';
+
+  const svgNS = 'http://www.w3.org/2000/svg';
+  const container = document.getElementById('graph');
+  const current = document.getElementById('graphcurrent');
+  const buttons = ['graphfocus', 'graphcallers', 'graphcallees', 'graphhide'];
+
+  const byId = new Map();
+  for (const n of data.nodes) {
+    n.in = [];
+    n.out = [];
+    byId.set(n.id, n);
+  }
+  for (const e of data.edges) {
+    byId.get(e.src).out.push(e);
+    byId.get(e.dest).in.push(e);
+  }
+  let maxFlat = 1;
+  for (const n of data.nodes) {
+    maxFlat = Math.max(maxFlat, Math.abs(n.flat));
+  }
+  const total = Math.max(1, Math.abs(data.total));
+
+  let visible = new Set(byId.keys());
+  let hidden = new Set();
+  let selected = 0;
+
+  // convert a string to a regexp that matches that string.
+  function quotemeta(str) {
+    return str.replace(/([\\\.?+*\[\](){}|^$])/g, '\\$1');
+  }
+
+  // Mirror an action into the URL. Hide adds to the existing
+  // parameter, focus replaces it.
+  function updateUrl(param, name) {
+    const url = new URL(window.location.href);
+    let re = quotemeta(name);
+    const old = url.searchParams.get(param);
+    if (param == 'h' && old) {
+      re = old + '|' + re;
+    }
+    url.searchParams.set(param, re);
+    window.history.replaceState(null, '', url.toString());
+  }
+
+  // reachable returns the nodes not hidden reachable from id along dir
+  // ('in' or 'out').
+  function reachable(id, dir) {
+    const seen = new Set([id]);
+    const stack = [id];
+    while (stack.length > 0) {
+      for (const e of byId.get(stack.pop())[dir]) {
+        const next = dir == 'in' ? e.src : e.dest;
+        if (!seen.has(next) && !hidden.has(next)) {
+          seen.add(next);
+          stack.push(next);
+        }
+      }
+    }
+    return seen;
+  }
+
+  function focus() {
+    const n = byId.get(selected);
+    visible = new Set([...reachable(n.id, 'in'), ...reachable(n.id, 'out')]);
+    updateUrl('f', n.name);
+    render();
+  }
+
+  function expand(dir) {
+    for (const e of byId.get(selected)[dir]) {
+      const next = dir == 'in' ? e.src : e.dest;
+      if (!hidden.has(next)) visible.add(next);
+    }
+    render();
+  }
+
+  function hide() {
+    const n = byId.get(selected);
+    visible.delete(n.id);
+    hidden.add(n.id);
+    selected = 0;
+    updateUrl('h', n.name);
+    render();
+  }
+
+  // The server leaves out the nodes filtered by the f and h parameters
+  // the page was loaded with, so showing them needs a new page.
+  const loadedFiltered = new URL(window.location.href).searchParams.has('f') ||
+      new URL(window.location.href).searchParams.has('h');
+
+  function reset() {
+    const url = new URL(window.location.href);
+    url.searchParams.delete('f');
+    url.searchParams.delete('h');
+    if (loadedFiltered) {
+      window.location.href = url.toString();
+      return;
+    }
+    window.history.replaceState(null, '', url.toString());
+    visible = new Set(byId.keys());
+    hidden = new Set();
+    render();
+  }
+
+  // layout assigns every visible node a layer and a position in it.
+  // Layers follow the longest path from the roots, ignoring the edges
+  // that close cycles; positions in a layer are improved by a few
+  // sweeps ordering nodes by the mean position of their neighbours.
+  function layout(nodes) {
+    const layer = new Map();
+    const state = new Map();
+    const back = new Set();
+    function dfs(n) {
+      state.set(n.id, 1);
+      for (const e of n.out) {
+        if (!visible.has(e.dest)) continue;
+        const s = state.get(e.dest);
+        if (s == 1) {
+          back.add(e);
+        } else if (s == undefined) {
+          dfs(byId.get(e.dest));
+        }
+      }
+      state.set(n.id, 2);
+    }
+    for (const n of nodes) {
+      if (!state.has(n.id)) dfs(n);
+    }
+    function depth(n) {
+      if (layer.has(n.id)) return layer.get(n.id);
+      let d = 0;
+      layer.set(n.id, 0);
+      for (const e of n.in) {
+        if (visible.has(e.src) && !back.has(e)) {
+          d = Math.max(d, depth(byId.get(e.src)) + 1);
+        }
+      }
+      layer.set(n.id, d);
+      return d;
+    }
+    const layers = [];
+    for (const n of nodes) {
+      const d = depth(n);
+      while (layers.length <= d) layers.push([]);
+      layers[d].push(n);
+    }
+    const pos = new Map();
+    function number() {
+      for (const l of layers) {
+        l.forEach((n, i) => pos.set(n.id, i));
+      }
+    }
+    number();
+    for (let sweep = 0; sweep < 8; sweep++) {
+      const down = sweep % 2 == 0;
+      const order = down ? layers : layers.slice().reverse();
+      for (const l of order) {
+        const center = new Map();
+        for (const n of l) {
+          let sum = 0, count = 0;
+          for (const e of (down ? n.in : n.out)) {
+            const other = down ? e.src : e.dest;
+            if (visible.has(other)) {
+              sum += pos.get(other);
+              count++;
+            }
+          }
+          center.set(n.id, count > 0 ? sum / count : pos.get(n.id));
+        }
+        l.sort((a, b) => center.get(a.id) - center.get(b.id));
+        l.forEach((n, i) => pos.set(n.id, i));
+      }
+    }
+    return {layers: layers, back: back};
+  }
+
+  function fontSize(n) {
+    return Math.round(10 + 14 * Math.sqrt(Math.abs(n.flat) / maxFlat));
+  }
+
+  function labelLines(n) {
+    const lines = n.label.split('\n');
+    let flat = n.flatLabel + ' (' + n.flatPercent + ')';
+    if (n.flat == 0) flat = '0';
+    lines.push(flat);
+    if (n.cum != n.flat) {
+      lines.push('of ' + n.cumLabel + ' (' + n.cumPercent + ')');
+    }
+    return lines;
+  }
+
+  function fill(n) {
+    const frac = Math.min(1, Math.abs(n.cum) / total);
+    const hue = n.cum < 0 ? 120 : 0;
+    return 'hsl(' + hue + ',' + Math.round(20 + 60 * frac) + '%,' + Math.round(95 - 35 * frac) + '%)';
+  }
+
+  function el(name, attrs, parent) {
+    const e = document.createElementNS(svgNS, name);
+    for (const k in attrs) {
+      e.setAttribute(k, attrs[k]);
+    }
+    if (parent) parent.appendChild(e);
+    return e;
+  }
+
+  function render() {
+    const nodes = data.nodes.filter(n => visible.has(n.id));
+    if (!visible.has(selected)) selected = 0;
+    const edges = data.edges.filter(e => visible.has(e.src) && visible.has(e.dest));
+    const l = layout(nodes);
+
+    // Size the nodes, then place them layer by layer, centering each layer.
+    const box = new Map();
+    const layerSep = 80, nodeSep = 30, charWidth = 0.6;
+    let y = 10, width = 0;
+    const rows = [];
+    for (const layer of l.layers) {
+      let x = 0, height = 0;
+      for (const n of layer) {
+        const fs = fontSize(n);
+        const lines = labelLines(n);
+        const w = Math.max(...lines.map(s => s.length)) * fs * charWidth + 16;
+        const h = lines.length * fs * 1.2 + 10;
+        box.set(n.id, {x: x, y: y, w: w, h: h, fs: fs, lines: lines});
+        x += w + nodeSep;
+        height = Math.max(height, h);
+      }
+      rows.push({nodes: layer, width: x - nodeSep});
+      width = Math.max(width, x - nodeSep);
+      y += height + layerSep;
+    }
+    for (const row of rows) {
+      const shift = (width - row.width) / 2;
+      for (const n of row.nodes) box.get(n.id).x += shift + 10;
+    }
+    const height = y - layerSep + 10;
+
+    const svg = el('svg', {
+      width: width + 20,
+      height: height,
+      viewBox: '0 0 ' + (width + 20) + ' ' + height,
+    });
+    const g = el('g', {id: 'graph0', class: 'graph'}, svg);
+    el('title', {}, g).textContent = data.nodes.length + ' nodes';
+
+    // Edges first, so nodes are drawn over them.
+    let maxWeight = 1;
+    for (const e of edges) maxWeight = Math.max(maxWeight, Math.abs(e.weight));
+    edges.forEach((e, i) => {
+      const s = box.get(e.src), d = box.get(e.dest);
+      const eg = el('g', {id: 'edge' + (i + 1), class: 'edge'}, g);
+      el('title', {}, eg).textContent = byId.get(e.src).name + ' -> ' + byId.get(e.dest).name +
+          ' (' + e.weightLabel + (e.inline ? ', inline' : '') + ')';
+      const x1 = s.x + s.w / 2, x2 = d.x + d.w / 2;
+      let path;
+      if (l.back.has(e) || e.src == e.dest) {
+        // Route edges going up around the right of the nodes.
+        const x = Math.max(s.x + s.w, d.x + d.w) + 20;
+        path = 'M' + (s.x + s.w) + ',' + (s.y + s.h / 2) + ' C' + x + ',' + (s.y + s.h / 2) + ' ' +
+            x + ',' + (d.y + d.h / 2) + ' ' + (d.x + d.w) + ',' + (d.y + d.h / 2);
+      } else {
+        const y1 = s.y + s.h, y2 = d.y;
+        const my = (y1 + y2) / 2;
+        path = 'M' + x1 + ',' + y1 + ' C' + x1 + ',' + my + ' ' + x2 + ',' + my + ' ' + x2 + ',' + y2;
+      }
+      const attrs = {
+        d: path,
+        fill: 'none',
+        stroke: e.inline ? '#888' : '#333',
+        'stroke-width': (1 + 5 * Math.abs(e.weight) / maxWeight).toFixed(1),
+      };
+      if (e.residual) attrs['stroke-dasharray'] = '1,5';
+      el('path', attrs, eg);
+      if (!l.back.has(e) && e.src != e.dest) {
+        el('polygon', {
+          points: (x2 - 4) + ',' + (d.y - 8) + ' ' + (x2 + 4) + ',' + (d.y - 8) + ' ' + x2 + ',' + d.y,
+          fill: '#333', stroke: '#333',
+        }, eg);
+        const t = el('text', {
+          x: (x1 + x2) / 2 + 4, y: (s.y + s.h + d.y) / 2,
+          'font-family': 'Times,serif', 'font-size': 12,
+        }, eg);
+        t.textContent = ' ' + e.weightLabel + (e.inline ? ' (inline)' : '');
+      }
+    });
+
+    for (const n of nodes) {
+      const b = box.get(n.id);
+      const ng = el('g', {id: 'node' + n.id, class: 'node' + (n.id == selected ? ' current' : '')}, g);
+      el('title', {}, ng).textContent = n.name + ' (' + n.cumLabel + ')';
+      el('polygon', {
+        points: b.x + ',' + b.y + ' ' + (b.x + b.w) + ',' + b.y + ' ' + (b.x + b.w) + ',' + (b.y + b.h) + ' ' + b.x + ',' + (b.y + b.h),
+        fill: fill(n), stroke: '#b2b2b2',
+      }, ng);
+      b.lines.forEach((line, i) => {
+        const t = el('text', {
+          'text-anchor': 'middle',
+          x: b.x + b.w / 2,
+          y: b.y + 5 + (i + 1) * b.fs * 1.2 - b.fs * 0.2,
+          'font-family': 'Times,serif',
+          'font-size': b.fs,
+        }, ng);
+        t.textContent = line;
+      });
+    }
+
+    container.replaceChildren(svg);
+    initPanAndZoom(svg, select);
+
+    for (const id of buttons) {
+      document.getElementById(id).disabled = (selected == 0);
+    }
+    current.textContent = selected == 0 ? 'Click a node to select it.' : byId.get(selected).name;
+  }
+
+  function select(elem) {
+    // Walk up to immediate child of graph0
+    while (elem != null && (elem.parentElement == null || elem.parentElement.id != 'graph0')) {
+      elem = elem.parentElement;
+    }
+    if (elem == null || !elem.id.startsWith('node')) return;
+    const id = parseInt(elem.id.slice(4), 10);
+    selected = (selected == id ? 0 : id);
+    render();
+  }
+
+  document.getElementById('graphfocus').addEventListener('click', focus);
+  document.getElementById('graphcallers').addEventListener('click', () => expand('in'));
+  document.getElementById('graphcallees').addEventListener('click', () => expand('out'));
+  document.getElementById('graphhide').addEventListener('click', hide);
+  document.getElementById('graphreset').addEventListener('click', reset);
+  render();
+}({{.Graph}}));
+  </script>
+</body>
+</html>
+{{end}}
+
+{{define "sandwich" -}}
+<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>{{.Title}}</title>
+  {{template "css" .}}
+  <style type="text/css">{{template "d3flamegraphcss" .}}</style>
+  <style type="text/css">
+    .flamegraph-content {
+      width: 90%;
+      min-width: 80%;
+      margin-left: 5%;
+    }
+    .flamegraph-details {
+      height: 1.2em;
+      width: 90%;
+      min-width: 90%;
+      margin-left: 5%;
+      padding: 15px 0 35px;
+    }
+    .sandwich-empty {
+      padding: 2em 5%;
+    }
+  </style>
+</head>
+<body>
+  {{template "header" .}}
+  <div id="bodycontainer">
+    {{if .Sandwich}}
+    <div id="flamegraphdetails" class="flamegraph-details"></div>
+    <div class="flamegraph-content">
+      <div id="callers"></div>
+      <div id="callees"></div>
+    </div>
+    {{else}}
+    <div class="sandwich-empty">
+      Double click a function in the top table, the graph or the flame graph,
+      or search for one and press Enter, to see its callers and callees.
+    </div>
+    {{end}}
+  </div>
+  {{template "script" .}}
+  <script>viewer(new URL(window.location.href), {{.Nodes}});</script>
+  {{if .Sandwich}}
+  <script>{{template "d3script" .}}</script>
+  <script>{{template "d3flamegraphscript" .}}</script>
+  <script>
+    var data = {{.Sandwich}};
+    var details = document.getElementById('flamegraphdetails');
+
+    // The callers grow upwards from the selected functions, and the
+    // callees downwards, so that both meet in the middle of the page.
+    function makeFlameGraph(id, tree, inverted) {
+      var flameGraph = d3.flamegraph()
+        .width(document.getElementById(id).clientWidth)
+        .cellHeight(18)
+        .minFrameSize(1)
+        .transitionDuration(750)
+        .transitionEase(d3.easeCubic)
+        .inverted(inverted)
+        .title('')
+        .tooltip(false)
+        .details(details);
+
+      // <full name> (percentage, value)
+      flameGraph.label((d) => d.data.f + ' (' + d.data.p + ', ' + d.data.l + ')');
+
+      var oldColorMapper = flameGraph.color();
+      flameGraph.color((d) => {
+        // Hack to force default color mapper to use 'warm' color scheme by not passing libtype
+        const { data, highlight } = d;
+        return oldColorMapper({ data: { n: data.n }, highlight });
+      });
+
+      d3.select('#' + id)
+        .datum(tree)
+        .call(flameGraph);
+
+      // Open the sandwich view of a frame on double click.
+      document.getElementById(id).addEventListener('dblclick', (e) => {
+        const frame = e.target.closest('g');
+        if (frame == null) return;
+        const d = d3.select(frame).datum();
+        if (d && d.data) openSandwich(d.data.f);
+      });
+      return flameGraph;
+    }
+
+    var graphs = [
+      makeFlameGraph('callers', data.callers, false),
+      makeFlameGraph('callees', data.callees, true),
+    ];
+
+    window.addEventListener('resize', function() {
+      var width = document.getElementById('callers').clientWidth;
+      for (const g of document.getElementsByClassName('d3-flame-graph')) {
+        g.setAttribute('width', width);
+      }
+      for (const flameGraph of graphs) {
+        flameGraph.width(width);
+        flameGraph.resetZoom();
+      }
+    }, true);
+
+    var search = document.getElementById('search');
+    var searchAlarm = null;
+
+    function selectMatching() {
+      searchAlarm = null;
+      for (const flameGraph of graphs) {
+        if (search.value != '') {
+          flameGraph.search(search.value);
+        } else {
+          flameGraph.clear();
+        }
+      }
+    }
+
+    search.addEventListener('input', function() {
+      // Delay expensive processing so a flurry of key strokes is handled once.
+      if (searchAlarm != null) {
+        clearTimeout(searchAlarm);
+      }
+      searchAlarm = setTimeout(selectMatching, 300);
+    });
   </script>
+  {{end}}
 </body>
 </html>
 {{end}}
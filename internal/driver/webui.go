@@ -41,6 +41,7 @@ type WebInterface struct {
 	options   *plugin.Options
 	help      map[string]string
 	templates *template.Template
+	defaults  map[string]string // values of the variables not set by the URL
 }
 
 // MakeWebInterface 获取 Web UI 对象
@@ -53,6 +54,7 @@ func MakeWebInterface(p *profile.Profile, opt *plugin.Options) *WebInterface {
 		options:   opt,
 		help:      make(map[string]string),
 		templates: templates,
+		defaults:  make(map[string]string),
 	}
 }
 
@@ -72,16 +74,25 @@ func (ec *errorCatcher) PrintErr(args ...interface{}) {
 
 // webArgs contains arguments passed to templates in webhtml.go.
 type webArgs struct {
-	Title      string
-	Errors     []string
-	Total      int64
-	Legend     []string
-	Help       map[string]string
-	Nodes      []string
-	HTMLBody   template.HTML
-	TextBody   string
-	Top        []report.TextItem
-	FlameGraph template.JS
+	Title       string
+	Errors      []string
+	Total       int64
+	Legend      []string
+	Help        map[string]string
+	Nodes       []string
+	HTMLBody    template.HTML
+	TextBody    string
+	Top         []report.TextItem
+	Paths       []report.CallPath
+	Regressions []report.Regression
+	Columns     []string
+	Samples     []string
+	FlameGraph  template.JS
+	Timeline    template.JS
+	Graph       template.JS
+	Sandwich    template.JS
+	Diff        bool
+	Labels      []string
 }
 
 func serveWebInterface(hostport string, p *profile.Profile, o *plugin.Options) error {
@@ -99,6 +110,11 @@ func serveWebInterface(hostport string, p *profile.Profile, o *plugin.Options) e
 	}
 	ui.help["details"] = "Show information about the profile and this view"
 	ui.help["graph"] = "Display profile as a directed graph"
+	ui.help["interactivegraph"] = "Display profile as a directed graph to explore in the browser"
+	ui.help["sandwich"] = "Display the callers and callees of the selected functions as flame graphs"
+	ui.help["paths"] = "Display the heaviest complete call stacks"
+	ui.help["regressions"] = "Display the entries whose share grew significantly over earlier snapshots"
+	ui.help["drilldown"] = "Show the functions of the selected packages, modules or mappings"
 	ui.help["reset"] = "Show the entire profile"
 
 	server := o.HTTPServer
@@ -110,12 +126,21 @@ func serveWebInterface(hostport string, p *profile.Profile, o *plugin.Options) e
 		Host:     host,
 		Port:     port,
 		Handlers: map[string]http.Handler{
-		// "/":           http.HandlerFunc(ui.Dot),
-		// "/top":        http.HandlerFunc(ui.Top),
-		// "/disasm":     http.HandlerFunc(ui.Disasm),
-		// "/source":     http.HandlerFunc(ui.Source),
-		// "/peek":       http.HandlerFunc(ui.Peek),
-		// "/flamegraph": http.HandlerFunc(ui.Flamegraph),
+		// "/":                 http.HandlerFunc(ui.Dot),
+		// "/top":              http.HandlerFunc(ui.Top),
+		// "/top.csv":          http.HandlerFunc(ui.TopExport),
+		// "/top.tsv":          http.HandlerFunc(ui.TopExport),
+		// "/disasm":           http.HandlerFunc(ui.Disasm),
+		// "/source":           http.HandlerFunc(ui.Source),
+		// "/peek":             http.HandlerFunc(ui.Peek),
+		// "/paths":            http.HandlerFunc(ui.Paths),
+		// "/regressions":      http.HandlerFunc(ui.Regressions),
+		// "/regressions.json": http.HandlerFunc(ui.RegressionsJSON),
+		// "/flamegraph":       http.HandlerFunc(ui.Flamegraph),
+		// "/flamegraph.svg":   http.HandlerFunc(ui.FlamegraphSVG),
+		// "/graph":            http.HandlerFunc(ui.InteractiveGraph),
+		// "/graph.json":       http.HandlerFunc(ui.GraphJSON),
+		// "/sandwich":         http.HandlerFunc(ui.Sandwich),
 		},
 	}
 
@@ -235,20 +260,40 @@ func varsFromURL(u *gourl.URL) variables {
 	vars["show"].value = u.Query().Get("s")
 	vars["ignore"].value = u.Query().Get("i")
 	vars["hide"].value = u.Query().Get("h")
+	vars["time_range"].value = u.Query().Get("t")
+	vars["groupby"].value = u.Query().Get("g")
+	vars["columns"].value = u.Query().Get("c")
+	if l := u.Query().Get("l"); l != "" {
+		if v := vars[l]; v != nil && v.group == "granularity" {
+			vars.set(l, "t")
+		}
+	}
 	return vars
 }
 
 // makeReport generates a report for the specified command.
 func (ui *WebInterface) makeReport(c *gin.Context,
+	cmd []string, vars ...string) (*report.Report, []string) {
+	return ui.makeProfileReport(c, ui.prof, cmd, vars...)
+}
+
+// makeProfileReport is makeReport for a profile derived from the one of
+// the web interface.
+func (ui *WebInterface) makeProfileReport(c *gin.Context, p *profile.Profile,
 	cmd []string, vars ...string) (*report.Report, []string) {
 	v := varsFromURL(c.Request.URL)
+	for n, value := range ui.defaults {
+		if v[n].value == "" {
+			v[n].value = value
+		}
+	}
 	for i := 0; i+1 < len(vars); i += 2 {
 		v[vars[i]].value = vars[i+1]
 	}
 	catcher := &errorCatcher{UI: ui.options.UI}
 	options := *ui.options
 	options.UI = catcher
-	_, rpt, err := generateRawReport(ui.prof, cmd, v, &options)
+	_, rpt, err := generateRawReport(p, cmd, v, &options)
 	if err != nil {
 		c.String(http.StatusBadRequest, err.Error())
 		ui.options.UI.PrintErr(err)
@@ -267,6 +312,7 @@ func (ui *WebInterface) render(c *gin.Context, tmpl string,
 	data.Total = rpt.Total()
 	data.Legend = legend
 	data.Help = ui.help
+	data.Labels = labelKeys(ui.prof)
 	html := &bytes.Buffer{}
 	if err := ui.templates.ExecuteTemplate(html, tmpl, data); err != nil {
 		c.String(http.StatusInternalServerError, "internal template error")
@@ -291,12 +337,22 @@ func (ui *WebInterface) Dot(c *gin.Context) {
 	dot := &bytes.Buffer{}
 	graph.ComposeDot(dot, g, &graph.DotAttributes{}, config)
 
-	// Convert to svg.
-	svg, err := dotToSvg(dot.Bytes())
-	if err != nil {
-		c.String(http.StatusNotImplemented, "Could not execute dot; may need to install graphviz.")
-		ui.options.UI.PrintErr("Failed to execute dot. Is Graphviz installed?\n", err)
-		return
+	// Convert to svg, laying the graph out in-process without Graphviz.
+	var svg []byte
+	if dotInstalled() {
+		var err error
+		if svg, err = dotToSvg(dot.Bytes()); err != nil {
+			c.String(http.StatusNotImplemented, "Could not execute dot; may need to install graphviz.")
+			ui.options.UI.PrintErr("Failed to execute dot. Is Graphviz installed?\n", err)
+			return
+		}
+	} else {
+		out := &bytes.Buffer{}
+		graph.ComposeSVG(out, g, &graph.DotAttributes{}, config)
+		svg = out.Bytes()
+		if pos := bytes.Index(svg, []byte("<svg")); pos >= 0 {
+			svg = svg[pos:]
+		}
 	}
 
 	// Get all node names into an array.
@@ -340,9 +396,67 @@ func (ui *WebInterface) Top(c *gin.Context) {
 	for _, item := range top {
 		nodes = append(nodes, item.Name)
 	}
+	var columns []string
+	for _, col := range rpt.Columns() {
+		columns = append(columns, col.Name)
+	}
+	var samples []string
+	for _, st := range ui.prof.SampleType {
+		samples = append(samples, st.Type)
+	}
 
 	ui.render(c, "top", rpt, errList, legend, webArgs{
-		Top:   top,
+		Top:     top,
+		Columns: columns,
+		Samples: samples,
+		Nodes:   nodes,
+	})
+}
+
+// TopExport generates the entries of the top table as CSV or, if the
+// path ends in .tsv, as TSV. The sort parameter is the column they are
+// sorted by, as for the sort_column option.
+func (ui *WebInterface) TopExport(c *gin.Context) {
+	cmd, contentType := "csv", "text/csv"
+	if strings.HasSuffix(c.Request.URL.Path, ".tsv") {
+		cmd, contentType = "tsv", "text/tab-separated-values"
+	}
+	rpt, _ := ui.makeReport(c, []string{cmd}, "nodecount", "500", "sort_column", c.Query("sort"))
+	if rpt == nil {
+		return // error already reported
+	}
+
+	out := &bytes.Buffer{}
+	if err := report.Generate(out, rpt, ui.options.Obj); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		ui.options.UI.PrintErr(err)
+		return
+	}
+	c.Header("Content-Disposition", "attachment; filename=top."+cmd)
+	c.Data(http.StatusOK, contentType+"; charset=utf-8", out.Bytes())
+}
+
+// Paths generates a web page with the heaviest call stacks, whose
+// frames link to the graph focused on them.
+func (ui *WebInterface) Paths(c *gin.Context) {
+	rpt, errList := ui.makeReport(c, []string{"paths"}, "nodecount", "100")
+	if rpt == nil {
+		return // error already reported
+	}
+	paths, legend := report.CallPaths(rpt)
+	var nodes []string
+	seen := map[string]bool{}
+	for _, p := range paths {
+		for _, f := range p.Frames {
+			if !seen[f] {
+				seen[f] = true
+				nodes = append(nodes, f)
+			}
+		}
+	}
+
+	ui.render(c, "paths", rpt, errList, legend, webArgs{
+		Paths: paths,
 		Nodes: nodes,
 	})
 }
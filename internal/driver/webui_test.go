@@ -75,6 +75,7 @@ func TestWebInterface(t *testing.T) {
 	testcases := []testCase{
 		{"/", []string{"F1", "F2", "F3", "testbin", "cpu"}, true},
 		{"/top", []string{`"Name":"F2","InlineLabel":"","Flat":200,"Cum":300,"FlatFormat":"200ms","CumFormat":"300ms"}`}, false},
+		{"/top?l=mappings", []string{`"Name":"testbin","InlineLabel":"","Flat":300`}, false},
 		{"/source?f=" + url.QueryEscape("F[12]"),
 			[]string{"F1", "F2", "300ms +line1"}, false},
 		{"/peek?f=" + url.QueryEscape("F[12]"),
@@ -139,11 +139,50 @@ func (b *builder) addLegend() {
 
 // addNode generates a graph node in DOT format.
 func (b *builder) addNode(node *Node, nodeID int, maxFlat float64) {
+	attrs := b.attributes.Nodes[node]
+	label, cumValue := b.nodeLabel(node)
+	fontSize := nodeFontSize(node.FlatValue(), maxFlat)
+
+	// Determine node shape.
+	shape := "box"
+	if attrs != nil && attrs.Shape != "" {
+		shape = attrs.Shape
+	}
+
+	// Create DOT attribute for node.
+	attr := fmt.Sprintf(`label="%s" id="node%d" fontsize=%d shape=%s tooltip="%s (%s)" color="%s" fillcolor="%s"`,
+		label, nodeID, fontSize, shape, node.Info.PrintableName(), cumValue,
+		dotColor(float64(node.CumValue())/float64(abs64(b.config.Total)), false),
+		dotColor(float64(node.CumValue())/float64(abs64(b.config.Total)), true))
+
+	// Add on extra attributes if provided.
+	if attrs != nil {
+		// Make bold if specified.
+		if attrs.Bold {
+			attr += ` style="bold,filled"`
+		}
+
+		// Add peripheries if specified.
+		if attrs.Peripheries != 0 {
+			attr += fmt.Sprintf(` peripheries=%d`, attrs.Peripheries)
+		}
+
+		// Add URL if specified. target="_blank" forces the link to open in a new tab.
+		if attrs.URL != "" {
+			attr += fmt.Sprintf(` URL="%s" target="_blank"`, attrs.URL)
+		}
+	}
+
+	fmt.Fprintf(b, "N%d [%s]\n", nodeID, attr)
+}
+
+// nodeLabel returns the label of a node, with its lines separated by
+// \n escapes, and its formatted cum value.
+func (b *builder) nodeLabel(node *Node) (label, cumValue string) {
 	flat, cum := node.FlatValue(), node.CumValue()
 	attrs := b.attributes.Nodes[node]
 
 	// Populate label for node.
-	var label string
 	if attrs != nil && attrs.Formatter != nil {
 		label = attrs.Formatter(&node.Info)
 	} else {
@@ -158,7 +197,7 @@ func (b *builder) addNode(node *Node, nodeID int, maxFlat float64) {
 	} else {
 		label = label + "0"
 	}
-	cumValue := flatValue
+	cumValue = flatValue
 	if cum != flat {
 		if flat != 0 {
 			label = label + `\n`
@@ -170,51 +209,49 @@ func (b *builder) addNode(node *Node, nodeID int, maxFlat float64) {
 			cumValue,
 			strings.TrimSpace(measurement.Percentage(cum, b.config.Total)))
 	}
+	return label, cumValue
+}
 
-	// Scale font sizes from 8 to 24 based on percentage of flat frequency.
-	// Use non linear growth to emphasize the size difference.
+// nodeFontSize scales font sizes from 8 to 24 based on percentage of
+// flat frequency. Use non linear growth to emphasize the size
+// difference.
+func nodeFontSize(flat int64, maxFlat float64) int {
 	baseFontSize, maxFontGrowth := 8, 16.0
 	fontSize := baseFontSize
 	if maxFlat != 0 && flat != 0 && float64(abs64(flat)) <= maxFlat {
 		fontSize += int(math.Ceil(maxFontGrowth * math.Sqrt(float64(abs64(flat))/maxFlat)))
 	}
+	return fontSize
+}
 
-	// Determine node shape.
-	shape := "box"
-	if attrs != nil && attrs.Shape != "" {
-		shape = attrs.Shape
-	}
-
-	// Create DOT attribute for node.
-	attr := fmt.Sprintf(`label="%s" id="node%d" fontsize=%d shape=%s tooltip="%s (%s)" color="%s" fillcolor="%s"`,
-		label, nodeID, fontSize, shape, node.Info.PrintableName(), cumValue,
-		dotColor(float64(node.CumValue())/float64(abs64(b.config.Total)), false),
-		dotColor(float64(node.CumValue())/float64(abs64(b.config.Total)), true))
-
-	// Add on extra attributes if provided.
-	if attrs != nil {
-		// Make bold if specified.
-		if attrs.Bold {
-			attr += ` style="bold,filled"`
-		}
-
-		// Add peripheries if specified.
-		if attrs.Peripheries != 0 {
-			attr += fmt.Sprintf(` peripheries=%d`, attrs.Peripheries)
-		}
+// nodelet is a box for a tag of a node. It hangs from the node, or
+// from the nodelet of the label a numeric tag belongs to, by an edge
+// labeled with the weight of the tag.
+type nodelet struct {
+	id, parent string // DOT names, such as N1_0 and N1
+	label      string
+	weight     string
+	dotted     bool // the weight includes the weight of the callees
+}
 
-		// Add URL if specified. target="_blank" forces the link to open in a new tab.
-		if attrs.URL != "" {
-			attr += fmt.Sprintf(` URL="%s" target="_blank"`, attrs.URL)
+// addNodelets generates the DOT boxes for the node tags if they exist.
+func (b *builder) addNodelets(node *Node, nodeID int) bool {
+	nodelets := b.nodelets(node, nodeID)
+	for _, n := range nodelets {
+		var attr string
+		if n.dotted {
+			attr = ` style="dotted"`
 		}
+		fmt.Fprintf(b, `%s [label = "%s" id="%s" fontsize=8 shape=box3d tooltip="%s"]`+"\n", n.id, n.label, n.id, n.weight)
+		fmt.Fprintf(b, `%s -> %s [label=" %s" weight=100 tooltip="%s" labeltooltip="%s"%s]`+"\n", n.parent, n.id, n.weight, n.weight, n.weight, attr)
 	}
-
-	fmt.Fprintf(b, "N%d [%s]\n", nodeID, attr)
+	return len(nodelets) > 0
 }
 
-// addNodelets generates the DOT boxes for the node tags if they exist.
-func (b *builder) addNodelets(node *Node, nodeID int) bool {
-	var nodelets string
+// nodelets returns the nodelets for the tags of a node, each after the
+// nodelet it hangs from.
+func (b *builder) nodelets(node *Node, nodeID int) []nodelet {
+	var nodelets []nodelet
 
 	// Populate two Tag slices, one for LabelTags and one for NumericTags.
 	var ts []*Tag
@@ -246,36 +283,37 @@ func (b *builder) addNodelets(node *Node, nodeID int) bool {
 		if w == 0 {
 			continue
 		}
-		weight := b.config.FormatValue(w)
-		nodelets += fmt.Sprintf(`N%d_%d [label = "%s" id="N%d_%d" fontsize=8 shape=box3d tooltip="%s"]`+"\n", nodeID, i, t.Name, nodeID, i, weight)
-		nodelets += fmt.Sprintf(`N%d -> N%d_%d [label=" %s" weight=100 tooltip="%s" labeltooltip="%s"]`+"\n", nodeID, nodeID, i, weight, weight, weight)
+		id := fmt.Sprintf(`N%d_%d`, nodeID, i)
+		nodelets = append(nodelets, nodelet{id: id, parent: fmt.Sprintf(`N%d`, nodeID), label: t.Name, weight: b.config.FormatValue(w)})
 		if nts := lnts[t.Name]; nts != nil {
-			nodelets += b.numericNodelets(nts, maxNodelets, flatTags, fmt.Sprintf(`N%d_%d`, nodeID, i))
+			nodelets = append(nodelets, b.numericNodelets(nts, maxNodelets, flatTags, id)...)
 		}
 	}
 
 	if nts := lnts[""]; nts != nil {
-		nodelets += b.numericNodelets(nts, maxNodelets, flatTags, fmt.Sprintf(`N%d`, nodeID))
+		nodelets = append(nodelets, b.numericNodelets(nts, maxNodelets, flatTags, fmt.Sprintf(`N%d`, nodeID))...)
 	}
-
-	fmt.Fprint(b, nodelets)
-	return nodelets != ""
+	return nodelets
 }
 
-func (b *builder) numericNodelets(nts []*Tag, maxNumNodelets int, flatTags bool, source string) string {
-	nodelets := ""
+func (b *builder) numericNodelets(nts []*Tag, maxNumNodelets int, flatTags bool, source string) []nodelet {
+	var nodelets []nodelet
 
 	// Collapse numeric labels into maxNumNodelets buckets, of the form:
 	// 1MB..2MB, 3MB..5MB, ...
 	for j, t := range b.collapsedTags(nts, maxNumNodelets, flatTags) {
-		w, attr := t.CumValue(), ` style="dotted"`
+		w, dotted := t.CumValue(), true
 		if flatTags || t.FlatValue() == t.CumValue() {
-			w, attr = t.FlatValue(), ""
+			w, dotted = t.FlatValue(), false
 		}
 		if w != 0 {
-			weight := b.config.FormatValue(w)
-			nodelets += fmt.Sprintf(`N%s_%d [label = "%s" id="N%s_%d" fontsize=8 shape=box3d tooltip="%s"]`+"\n", source, j, t.Name, source, j, weight)
-			nodelets += fmt.Sprintf(`%s -> N%s_%d [label=" %s" weight=100 tooltip="%s" labeltooltip="%s"%s]`+"\n", source, source, j, weight, weight, weight, attr)
+			nodelets = append(nodelets, nodelet{
+				id:     fmt.Sprintf(`N%s_%d`, source, j),
+				parent: source,
+				label:  t.Name,
+				weight: b.config.FormatValue(w),
+				dotted: dotted,
+			})
 		}
 	}
 	return nodelets
@@ -294,7 +332,7 @@ func (b *builder) addEdge(edge *Edge, from, to int, hasNodelets bool) {
 		if weight := 1 + int(min64(abs64(edge.WeightValue()*100/b.config.Total), 100)); weight > 1 {
 			attr = fmt.Sprintf(`%s weight=%d`, attr, weight)
 		}
-		if width := 1 + int(min64(abs64(edge.WeightValue()*5/b.config.Total), 5)); width > 1 {
+		if width := b.penWidth(edge); width > 1 {
 			attr = fmt.Sprintf(`%s penwidth=%d`, attr, width)
 		}
 		attr = fmt.Sprintf(`%s color="%s"`, attr,
@@ -320,6 +358,15 @@ func (b *builder) addEdge(edge *Edge, from, to int, hasNodelets bool) {
 	fmt.Fprintf(b, "N%d -> N%d [%s]\n", from, to, attr)
 }
 
+// penWidth returns the width of an edge, from 1 to 6 depending on its
+// share of the total.
+func (b *builder) penWidth(edge *Edge) int {
+	if b.config.Total == 0 {
+		return 1
+	}
+	return 1 + int(min64(abs64(edge.WeightValue()*5/b.config.Total), 5))
+}
+
 // dotColor returns a color for the given score (between -1.0 and
 // 1.0), with -1.0 colored red, 0.0 colored grey, and 1.0 colored
 // green. If isBackground is true, then a light (low-saturation)
@@ -0,0 +1,471 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"math"
+	"sort"
+)
+
+// layout places the vertices of a directed graph in ranks from top to
+// bottom, as dot does, so that most edges point down, edges are short
+// and few edges cross. It follows the method of Sugiyama et al.:
+// cycles are broken by reversing edges, vertices are ranked by longest
+// path, long edges are split by dummy vertices, the vertices of each
+// rank are ordered by the barycenter of their neighbors and finally
+// moved towards their neighbors.
+type layout struct {
+	vertices []*vertex
+	edges    []*layoutEdge
+	ranks    [][]*vertex
+
+	nodeSep, rankSep float64 // space between vertices and ranks
+	width, height    float64 // extent of the layout, from (0, 0)
+}
+
+// vertex is a box of the layout. Dummy vertices route the edges that
+// span several ranks.
+type vertex struct {
+	w, h  float64 // size
+	x, y  float64 // center
+	rank  int
+	order int // index in rank
+	dummy bool
+
+	out []*layoutEdge // edges to vertices of lower ranks
+	in  []*layoutEdge // edges from vertices of upper ranks
+}
+
+// layoutEdge is an edge of the layout. After layout, points holds its
+// route, from the source to the destination, whatever the direction
+// of the edge in the ranking.
+type layoutEdge struct {
+	src, dst *vertex
+	minLen   int     // minimum rank difference
+	weight   float64 // pull on the vertices when placing them
+	points   []point
+
+	reversed bool      // the edge points up
+	chain    []*vertex // dummy vertices, top to bottom
+}
+
+type point struct {
+	x, y float64
+}
+
+// segment is an edge between vertices of adjacent ranks.
+type segment struct {
+	up, down *vertex
+	weight   float64
+}
+
+func newLayout(nodeSep, rankSep float64) *layout {
+	return &layout{nodeSep: nodeSep, rankSep: rankSep}
+}
+
+// addVertex adds a vertex of the given size.
+func (l *layout) addVertex(w, h float64) *vertex {
+	v := &vertex{w: w, h: h}
+	l.vertices = append(l.vertices, v)
+	return v
+}
+
+// addEdge adds an edge from src to dst. The destination will be at
+// least minLen ranks below the source unless the edge closes a cycle.
+func (l *layout) addEdge(src, dst *vertex, minLen int, weight float64) *layoutEdge {
+	e := &layoutEdge{src: src, dst: dst, minLen: minLen, weight: weight}
+	l.edges = append(l.edges, e)
+	return e
+}
+
+// run computes the positions of the vertices and the routes of the
+// edges.
+func (l *layout) run() {
+	l.breakCycles()
+	l.rank()
+	l.split()
+	l.order()
+	l.position()
+	l.route()
+}
+
+// top and bottom return the upper and lower ends of an edge in the
+// ranking.
+func (e *layoutEdge) top() *vertex {
+	if e.reversed {
+		return e.dst
+	}
+	return e.src
+}
+
+func (e *layoutEdge) bottom() *vertex {
+	if e.reversed {
+		return e.src
+	}
+	return e.dst
+}
+
+// breakCycles reverses the edges closing a cycle in a depth first
+// search, visiting the vertices in the order they were added. Self
+// loops are left out of the ranking.
+func (l *layout) breakCycles() {
+	out := make(map[*vertex][]*layoutEdge)
+	for _, e := range l.edges {
+		if e.src != e.dst {
+			out[e.src] = append(out[e.src], e)
+		}
+	}
+	const (
+		unvisited = iota
+		active
+		done
+	)
+	state := make(map[*vertex]int)
+	var visit func(v *vertex)
+	visit = func(v *vertex) {
+		state[v] = active
+		for _, e := range out[v] {
+			switch state[e.dst] {
+			case active:
+				e.reversed = true
+			case unvisited:
+				visit(e.dst)
+			}
+		}
+		state[v] = done
+	}
+	for _, v := range l.vertices {
+		if state[v] == unvisited {
+			visit(v)
+		}
+	}
+	for _, e := range l.edges {
+		if e.src == e.dst {
+			continue
+		}
+		top, bottom := e.top(), e.bottom()
+		top.out = append(top.out, e)
+		bottom.in = append(bottom.in, e)
+	}
+}
+
+// rank assigns each vertex the length of the longest path to it, and
+// then moves sources down to their highest successor to shorten their
+// edges.
+func (l *layout) rank() {
+	indegree := make(map[*vertex]int)
+	for _, v := range l.vertices {
+		indegree[v] = len(v.in)
+	}
+	var queue, sorted []*vertex
+	for _, v := range l.vertices {
+		if indegree[v] == 0 {
+			queue = append(queue, v)
+		}
+	}
+	for len(queue) > 0 {
+		v := queue[0]
+		queue = queue[1:]
+		sorted = append(sorted, v)
+		for _, e := range v.out {
+			d := e.bottom()
+			if r := v.rank + e.minLen; r > d.rank {
+				d.rank = r
+			}
+			if indegree[d]--; indegree[d] == 0 {
+				queue = append(queue, d)
+			}
+		}
+	}
+	for i := len(sorted) - 1; i >= 0; i-- {
+		v := sorted[i]
+		if len(v.in) != 0 || len(v.out) == 0 {
+			continue
+		}
+		r := math.MaxInt32
+		for _, e := range v.out {
+			if d := e.bottom().rank - e.minLen; d < r {
+				r = d
+			}
+		}
+		v.rank = r
+	}
+}
+
+// split adds dummy vertices to the edges spanning several ranks, and
+// groups the vertices by rank.
+func (l *layout) split() {
+	maxRank := 0
+	for _, v := range l.vertices {
+		if v.rank > maxRank {
+			maxRank = v.rank
+		}
+	}
+	for _, e := range l.edges {
+		if e.src == e.dst {
+			continue
+		}
+		for r := e.top().rank + 1; r < e.bottom().rank; r++ {
+			d := l.addVertex(0, 0)
+			d.dummy, d.rank = true, r
+			e.chain = append(e.chain, d)
+		}
+	}
+	l.ranks = make([][]*vertex, maxRank+1)
+	for _, v := range l.vertices {
+		v.order = len(l.ranks[v.rank])
+		l.ranks[v.rank] = append(l.ranks[v.rank], v)
+	}
+}
+
+// segments returns, for each rank r, the segments between rank r and
+// rank r+1.
+func (l *layout) segments() [][]segment {
+	segs := make([][]segment, len(l.ranks))
+	for _, e := range l.edges {
+		if e.src == e.dst {
+			continue
+		}
+		path := append(append([]*vertex{e.top()}, e.chain...), e.bottom())
+		for i := 0; i+1 < len(path); i++ {
+			r := path[i].rank
+			segs[r] = append(segs[r], segment{path[i], path[i+1], e.weight})
+		}
+	}
+	return segs
+}
+
+// maxOrderVertices is the number of vertices, dummy ones included, above
+// which order keeps the ranks in their plain layered order: counting the
+// crossings is quadratic in the segments of each rank.
+const maxOrderVertices = 5000
+
+// order orders the vertices of each rank to reduce the number of edge
+// crossings, sweeping down and up the ranks and sorting each rank by
+// the mean position of the neighbors of its vertices in the previous
+// rank. The best order found is kept.
+func (l *layout) order() {
+	if len(l.vertices) > maxOrderVertices {
+		return
+	}
+	segs := l.segments()
+	best, bestCrossings := l.saveOrder(), l.crossings(segs)
+	for iter := 0; iter < 8 && bestCrossings > 0; iter++ {
+		if iter%2 == 0 {
+			for r := 1; r < len(l.ranks); r++ {
+				l.sortRank(r, segs[r-1], true)
+			}
+		} else {
+			for r := len(l.ranks) - 2; r >= 0; r-- {
+				l.sortRank(r, segs[r], false)
+			}
+		}
+		if c := l.crossings(segs); c < bestCrossings {
+			best, bestCrossings = l.saveOrder(), c
+		}
+	}
+	for r, vs := range best {
+		l.ranks[r] = vs
+		for i, v := range vs {
+			v.order = i
+		}
+	}
+}
+
+func (l *layout) saveOrder() [][]*vertex {
+	saved := make([][]*vertex, len(l.ranks))
+	for r, vs := range l.ranks {
+		saved[r] = append([]*vertex(nil), vs...)
+	}
+	return saved
+}
+
+// sortRank sorts rank r by the barycenter of the neighbors of its
+// vertices in the rank above, if down, or below. Vertices without
+// neighbors keep their position.
+func (l *layout) sortRank(r int, segs []segment, down bool) {
+	sum := make(map[*vertex]float64)
+	count := make(map[*vertex]int)
+	for _, s := range segs {
+		v, n := s.up, s.down
+		if down {
+			v, n = s.down, s.up
+		}
+		sum[v] += float64(n.order)
+		count[v]++
+	}
+	vs := l.ranks[r]
+	key := make(map[*vertex]float64, len(vs))
+	for _, v := range vs {
+		key[v] = float64(v.order)
+		if c := count[v]; c > 0 {
+			key[v] = sum[v] / float64(c)
+		}
+	}
+	sort.SliceStable(vs, func(i, j int) bool { return key[vs[i]] < key[vs[j]] })
+	for i, v := range vs {
+		v.order = i
+	}
+}
+
+// crossings counts the pairs of segments that cross.
+func (l *layout) crossings(segs [][]segment) int {
+	n := 0
+	for _, ss := range segs {
+		for i := range ss {
+			for j := i + 1; j < len(ss); j++ {
+				a, b := ss[i], ss[j]
+				if (a.up.order-b.up.order)*(a.down.order-b.down.order) < 0 {
+					n++
+				}
+			}
+		}
+	}
+	return n
+}
+
+// position places the ranks from the top down, and the vertices of
+// each rank from left to right, moving them towards the weighted mean
+// of the positions of their neighbors.
+func (l *layout) position() {
+	y := 0.0
+	for _, vs := range l.ranks {
+		h := 0.0
+		for _, v := range vs {
+			h = math.Max(h, v.h)
+		}
+		for _, v := range vs {
+			v.y = y + h/2
+		}
+		y += h + l.rankSep
+	}
+	l.height = math.Max(0, y-l.rankSep)
+
+	for _, vs := range l.ranks {
+		x := 0.0
+		for _, v := range vs {
+			v.x = x + v.w/2
+			x += v.w + l.nodeSep
+		}
+	}
+	segs := l.segments()
+	for iter := 0; iter < 16; iter++ {
+		if iter%2 == 0 {
+			for r := 1; r < len(l.ranks); r++ {
+				l.align(r, segs[r-1], true)
+			}
+		} else {
+			for r := len(l.ranks) - 2; r >= 0; r-- {
+				l.align(r, segs[r], false)
+			}
+		}
+	}
+
+	left, right := math.Inf(1), math.Inf(-1)
+	for _, v := range l.vertices {
+		left, right = math.Min(left, v.x-v.w/2), math.Max(right, v.x+v.w/2)
+	}
+	if len(l.vertices) == 0 {
+		left, right = 0, 0
+	}
+	for _, v := range l.vertices {
+		v.x -= left
+	}
+	l.width = right - left
+}
+
+// align moves the vertices of rank r towards their neighbors in the
+// rank above, if down, or below, keeping their order and separation.
+func (l *layout) align(r int, segs []segment, down bool) {
+	sum := make(map[*vertex]float64)
+	weight := make(map[*vertex]float64)
+	for _, s := range segs {
+		v, n := s.up, s.down
+		if down {
+			v, n = s.down, s.up
+		}
+		w := s.weight
+		if v.dummy && n.dummy {
+			// Keep long edges straight.
+			w *= 8
+		}
+		sum[v] += n.x * w
+		weight[v] += w
+	}
+	vs := l.ranks[r]
+	want := make([]float64, len(vs))
+	for i, v := range vs {
+		want[i] = v.x
+		if w := weight[v]; w > 0 {
+			want[i] = sum[v] / w
+		}
+	}
+	sep := func(i int) float64 {
+		return (vs[i-1].w+vs[i].w)/2 + l.nodeSep
+	}
+	// Resolve overlaps from both sides and meet in the middle.
+	fromLeft := make([]float64, len(vs))
+	fromRight := make([]float64, len(vs))
+	for i := range vs {
+		fromLeft[i] = want[i]
+		if i > 0 {
+			fromLeft[i] = math.Max(want[i], fromLeft[i-1]+sep(i))
+		}
+	}
+	for i := len(vs) - 1; i >= 0; i-- {
+		fromRight[i] = want[i]
+		if i < len(vs)-1 {
+			fromRight[i] = math.Min(want[i], fromRight[i+1]-sep(i+1))
+		}
+	}
+	for i, v := range vs {
+		v.x = (fromLeft[i] + fromRight[i]) / 2
+		if i > 0 {
+			v.x = math.Max(v.x, vs[i-1].x+sep(i))
+		}
+	}
+}
+
+// route computes the route of each edge, from the border of its source
+// through its dummy vertices to the border of its destination. Self
+// loops go around the right side of their vertex.
+func (l *layout) route() {
+	for _, e := range l.edges {
+		v := e.src
+		if e.src == e.dst {
+			r := v.x + v.w/2
+			e.points = []point{
+				{r, v.y - v.h/4},
+				{r + 24, v.y - v.h/4},
+				{r + 24, v.y + v.h/4},
+				{r, v.y + v.h/4},
+			}
+			l.width = math.Max(l.width, r+24)
+			continue
+		}
+		top, bottom := e.top(), e.bottom()
+		points := []point{{top.x, top.y + top.h/2}}
+		for _, d := range e.chain {
+			points = append(points, point{d.x, d.y})
+		}
+		points = append(points, point{bottom.x, bottom.y - bottom.h/2})
+		if e.reversed {
+			for i, j := 0, len(points)-1; i < j; i, j = i+1, j-1 {
+				points[i], points[j] = points[j], points[i]
+			}
+		}
+		e.points = points
+	}
+}
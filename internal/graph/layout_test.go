@@ -0,0 +1,120 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"testing"
+)
+
+func TestLayout(t *testing.T) {
+	l := newLayout(10, 20)
+	// main calls a and b, both call c, which calls back into a.
+	main, a, b, c := l.addVertex(50, 20), l.addVertex(30, 20), l.addVertex(30, 20), l.addVertex(40, 30)
+	l.addEdge(main, a, 1, 1)
+	l.addEdge(main, b, 1, 1)
+	l.addEdge(a, c, 1, 1)
+	l.addEdge(b, c, 2, 1)
+	long := l.addEdge(main, c, 1, 1)
+	back := l.addEdge(c, a, 1, 1)
+	self := l.addEdge(c, c, 1, 1)
+	l.run()
+
+	for _, tc := range []struct {
+		v    *vertex
+		rank int
+	}{
+		{main, 0}, {a, 1}, {b, 1}, {c, 3},
+	} {
+		if tc.v.rank != tc.rank {
+			t.Errorf("got rank %d, want %d", tc.v.rank, tc.rank)
+		}
+	}
+	if !back.reversed {
+		t.Errorf("edge closing the cycle is not reversed")
+	}
+	if len(long.chain) != 2 {
+		t.Errorf("edge across 3 ranks: got %d dummy vertices, want 2", len(long.chain))
+	}
+
+	// Vertices of a rank do not overlap, and ranks are stacked.
+	for r, vs := range l.ranks {
+		for i := 1; i < len(vs); i++ {
+			if vs[i-1].x+vs[i-1].w/2+l.nodeSep > vs[i].x-vs[i].w/2+1e-9 {
+				t.Errorf("rank %d: vertices %d and %d overlap", r, i-1, i)
+			}
+		}
+	}
+	if !(main.y < a.y && a.y < c.y) {
+		t.Errorf("ranks not stacked: y %v, %v, %v", main.y, a.y, c.y)
+	}
+	for _, v := range l.vertices {
+		if v.x-v.w/2 < -1e-9 || v.x+v.w/2 > l.width+1e-9 || v.y+v.h/2 > l.height+1e-9 {
+			t.Errorf("vertex at %v,%v outside of the layout %vx%v", v.x, v.y, l.width, l.height)
+		}
+	}
+
+	// Routes go from the source to the destination.
+	for _, e := range l.edges {
+		if len(e.points) < 2 {
+			t.Fatalf("edge without route")
+		}
+	}
+	if p := back.points; p[0].y <= p[len(p)-1].y {
+		t.Errorf("back edge: route goes down from %v to %v", p[0], p[len(p)-1])
+	}
+	if got := len(long.points); got != 4 {
+		t.Errorf("edge across 3 ranks: got %d points, want 4", got)
+	}
+	if p := self.points; p[0].x < c.x+c.w/2 {
+		t.Errorf("self loop: route starts inside the vertex at %v", p[0])
+	}
+}
+
+func TestLayoutOrder(t *testing.T) {
+	// Two parallel chains given crossed: the order must uncross them.
+	l := newLayout(10, 20)
+	a, b := l.addVertex(10, 10), l.addVertex(10, 10)
+	c, d := l.addVertex(10, 10), l.addVertex(10, 10)
+	l.addEdge(a, d, 1, 1)
+	l.addEdge(b, c, 1, 1)
+	l.run()
+
+	if n := l.crossings(l.segments()); n != 0 {
+		t.Errorf("got %d crossings, want 0", n)
+	}
+}
+
+func TestLayoutLarge(t *testing.T) {
+	// A long chain with edges skipping down to its end needs more dummy
+	// vertices than are ordered: the ranks keep their layered order.
+	l := newLayout(10, 20)
+	var vs []*vertex
+	for i := 0; i < 200; i++ {
+		vs = append(vs, l.addVertex(10, 10))
+		if i > 0 {
+			l.addEdge(vs[i-1], vs[i], 1, 1)
+			l.addEdge(vs[i/2], vs[i], 1, 1)
+		}
+	}
+	l.run()
+	if len(l.vertices) <= maxOrderVertices {
+		t.Fatalf("got %d vertices, want more than %d", len(l.vertices), maxOrderVertices)
+	}
+	for r, rank := range l.ranks {
+		if rank[0] != vs[r] {
+			t.Fatalf("rank %d does not start with its chain vertex", r)
+		}
+	}
+}
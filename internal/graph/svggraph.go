@@ -0,0 +1,383 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// Sizes of the layout, in points, as the defaults of dot.
+const (
+	svgNodeSep   = 18
+	svgRankSep   = 36
+	svgMinWidth  = 54
+	svgMinHeight = 36
+	svgMargin    = 4
+	svgArrowLen  = 10
+	svgEdgeFont  = 14
+)
+
+// svgBox is a node, nodelet or legend of an SVG graph.
+type svgBox struct {
+	v           *vertex
+	id, title   string
+	lines       []string
+	fontSize    float64
+	leftAligned bool
+	shape       string
+	color, fill string
+	tooltip     string
+	url         string
+	bold        bool
+	peripheries int
+}
+
+// svgEdge is an edge of an SVG graph.
+type svgEdge struct {
+	e       *layoutEdge
+	title   string
+	tooltip string
+	label   []string
+	color   string
+	width   int
+	dotted  bool
+}
+
+// ComposeSVG writes the graph as an SVG image, laid out in-process
+// instead of by Graphviz. Nodes, nodelets, edges and the legend keep
+// the labels, sizes, colors, styles and ids of the ComposeDot output,
+// so the image reads, and is selected in the web interface, as the one
+// drawn by dot.
+func ComposeSVG(w io.Writer, g *Graph, a *DotAttributes, c *DotConfig) {
+	b := &builder{w, a, c}
+	l := newLayout(svgNodeSep, svgRankSep)
+	var boxes []*svgBox
+	var edges []*svgEdge
+
+	// Place the legend first, so it comes at the top left.
+	if len(c.Labels) > 0 {
+		box := &svgBox{
+			id:          "legend",
+			title:       c.Labels[0],
+			lines:       c.Labels,
+			fontSize:    16,
+			leftAligned: true,
+			shape:       "box",
+			color:       "black",
+			fill:        "#f8f8f8",
+			tooltip:     c.Title,
+			url:         c.LegendURL,
+		}
+		box.v = l.addVertex(box.size())
+		boxes = append(boxes, box)
+	}
+
+	maxFlat := 0.0
+	for _, n := range g.Nodes {
+		maxFlat = math.Max(maxFlat, float64(abs64(n.FlatValue())))
+	}
+	nodeIDMap := make(map[*Node]int)
+	vertices := make(map[*Node]*vertex)
+	hasNodelets := make(map[*Node]bool)
+	for i, n := range g.Nodes {
+		box := b.svgNode(n, i+1, maxFlat)
+		box.v = l.addVertex(box.size())
+		boxes = append(boxes, box)
+		nodeIDMap[n] = i + 1
+		vertices[n] = box.v
+
+		byID := map[string]*vertex{fmt.Sprintf("N%d", i+1): box.v}
+		for _, nl := range b.nodelets(n, i+1) {
+			nbox := &svgBox{
+				id:       nl.id,
+				title:    nl.id,
+				lines:    []string{nl.label},
+				fontSize: 8,
+				shape:    "box3d",
+				color:    "black",
+				fill:     "#f8f8f8",
+				tooltip:  nl.weight,
+			}
+			nbox.v = l.addVertex(nbox.size())
+			boxes = append(boxes, nbox)
+			byID[nl.id] = nbox.v
+			edges = append(edges, &svgEdge{
+				e:       l.addEdge(byID[nl.parent], nbox.v, 1, 100),
+				title:   nl.parent + "->" + nl.id,
+				tooltip: nl.weight,
+				label:   []string{nl.weight},
+				color:   "black",
+				width:   1,
+				dotted:  nl.dotted,
+			})
+			hasNodelets[n] = true
+		}
+	}
+
+	all := EdgeMap{}
+	for _, n := range g.Nodes {
+		for _, e := range n.Out {
+			all[&Node{}] = e
+		}
+	}
+	for _, e := range all.Sort() {
+		minLen := 1
+		if hasNodelets[e.Src] {
+			minLen = 2
+		}
+		le := l.addEdge(vertices[e.Src], vertices[e.Dest], minLen, b.edgeWeight(e))
+		edges = append(edges, b.svgEdge(e, le, nodeIDMap[e.Src], nodeIDMap[e.Dest]))
+	}
+
+	l.run()
+
+	width, height := l.width+2*svgMargin, l.height+2*svgMargin
+	title := "unnamed"
+	if c.Title != "" {
+		title = c.Title
+	}
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8" standalone="no"?>`+"\n")
+	fmt.Fprintf(w, `<svg width="%.0fpt" height="%.0fpt" viewBox="0.00 0.00 %.2f %.2f" xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink">`+"\n",
+		width, height, width, height)
+	fmt.Fprintf(w, `<g id="graph0" class="graph" transform="translate(%d %d)">`+"\n", svgMargin, svgMargin)
+	fmt.Fprintf(w, "<title>%s</title>\n", svgEscape(title))
+	fmt.Fprintf(w, `<polygon fill="white" stroke="transparent" points="%s"/>`+"\n",
+		svgPoints(-svgMargin, -svgMargin, l.width+svgMargin, l.height+svgMargin))
+	for i, e := range edges {
+		e.write(w, i+1)
+	}
+	for _, box := range boxes {
+		box.write(w)
+	}
+	fmt.Fprintln(w, "</g>")
+	fmt.Fprintln(w, "</svg>")
+}
+
+// svgNode returns the box of a node, as addNode describes it to dot.
+func (b *builder) svgNode(node *Node, nodeID int, maxFlat float64) *svgBox {
+	label, cumValue := b.nodeLabel(node)
+	score := float64(node.CumValue()) / float64(abs64(b.config.Total))
+	box := &svgBox{
+		id:       fmt.Sprintf("node%d", nodeID),
+		title:    fmt.Sprintf("N%d", nodeID),
+		lines:    strings.Split(strings.TrimSuffix(label, `\n`), `\n`),
+		fontSize: float64(nodeFontSize(node.FlatValue(), maxFlat)),
+		shape:    "box",
+		color:    dotColor(score, false),
+		fill:     dotColor(score, true),
+		tooltip:  fmt.Sprintf("%s (%s)", node.Info.PrintableName(), cumValue),
+	}
+	if attrs := b.attributes.Nodes[node]; attrs != nil {
+		if attrs.Shape != "" {
+			box.shape = attrs.Shape
+		}
+		box.bold = attrs.Bold
+		box.peripheries = attrs.Peripheries
+		box.url = attrs.URL
+	}
+	return box
+}
+
+// svgEdge returns the drawing of an edge, as addEdge describes it to
+// dot.
+func (b *builder) svgEdge(edge *Edge, e *layoutEdge, from, to int) *svgEdge {
+	w := b.config.FormatValue(edge.WeightValue())
+	arrow := "->"
+	if edge.Residual {
+		arrow = "..."
+	}
+	se := &svgEdge{
+		e:       e,
+		title:   fmt.Sprintf("N%d->N%d", from, to),
+		tooltip: fmt.Sprintf("%s %s %s (%s)", edge.Src.Info.PrintableName(), arrow, edge.Dest.Info.PrintableName(), w),
+		label:   []string{w},
+		color:   "black",
+		width:   b.penWidth(edge),
+		dotted:  edge.Residual,
+	}
+	if edge.Inline {
+		se.label = append(se.label, "(inline)")
+	}
+	if b.config.Total != 0 {
+		se.color = dotColor(float64(edge.WeightValue())/float64(abs64(b.config.Total)), false)
+	}
+	return se
+}
+
+// edgeWeight returns the pull of an edge on the placement of its
+// nodes, as the weight given to dot.
+func (b *builder) edgeWeight(edge *Edge) float64 {
+	if b.config.Total == 0 {
+		return 1
+	}
+	return float64(1 + min64(abs64(edge.WeightValue()*100/b.config.Total), 100))
+}
+
+// size returns the size of the box around the lines of a label.
+func (box *svgBox) size() (w, h float64) {
+	for _, l := range box.lines {
+		w = math.Max(w, textWidth(l, box.fontSize))
+	}
+	w += 16
+	h = float64(len(box.lines))*box.fontSize*1.2 + 8
+	if box.shape != "box3d" {
+		w, h = math.Max(w, svgMinWidth), math.Max(h, svgMinHeight)
+	}
+	if box.peripheries > 1 {
+		w += 8 * float64(box.peripheries-1)
+		h += 8 * float64(box.peripheries-1)
+	}
+	return w, h
+}
+
+// textWidth estimates the width of a line in the Times font dot uses.
+func textWidth(s string, fontSize float64) float64 {
+	return float64(utf8.RuneCountInString(s)) * fontSize * 0.55
+}
+
+func (box *svgBox) write(w io.Writer) {
+	v := box.v
+	class := "node"
+	if box.id == "legend" {
+		class = "cluster"
+	}
+	fmt.Fprintf(w, `<g id="%s" class="%s">`+"\n", box.id, class)
+	fmt.Fprintf(w, "<title>%s</title>\n", svgEscape(box.title))
+	fmt.Fprintf(w, `<g id="a_%s"><a`, box.id)
+	if box.url != "" {
+		fmt.Fprintf(w, ` xlink:href="%s" target="_blank"`, svgEscape(box.url))
+	}
+	fmt.Fprintf(w, ` xlink:title="%s">`+"\n", svgEscape(box.tooltip))
+
+	strokeWidth := 1
+	if box.bold {
+		strokeWidth = 2
+	}
+	x0, y0, x1, y1 := v.x-v.w/2, v.y-v.h/2, v.x+v.w/2, v.y+v.h/2
+	switch box.shape {
+	case "ellipse", "oval", "circle":
+		fmt.Fprintf(w, `<ellipse fill="%s" stroke="%s" stroke-width="%d" cx="%.2f" cy="%.2f" rx="%.2f" ry="%.2f"/>`+"\n",
+			box.fill, box.color, strokeWidth, v.x, v.y, v.w/2, v.h/2)
+	case "box3d":
+		fmt.Fprintf(w, `<polygon fill="%s" stroke="%s" points="%s"/>`+"\n",
+			box.fill, box.color, svgPoints(x0, y0+4, x1-4, y1))
+		fmt.Fprintf(w, `<polyline fill="none" stroke="%s" points="%.2f,%.2f %.2f,%.2f %.2f,%.2f %.2f,%.2f %.2f,%.2f"/>`+"\n",
+			box.color, x0, y0+4, x0+4, y0, x1, y0, x1, y1-4, x1-4, y1)
+		fmt.Fprintf(w, `<polyline fill="none" stroke="%s" points="%.2f,%.2f %.2f,%.2f"/>`+"\n",
+			box.color, x1-4, y0+4, x1, y0)
+	default:
+		fmt.Fprintf(w, `<polygon fill="%s" stroke="%s" stroke-width="%d" points="%s"/>`+"\n",
+			box.fill, box.color, strokeWidth, svgPoints(x0, y0, x1, y1))
+		for p := 1; p < box.peripheries; p++ {
+			d := 4 * float64(p)
+			fmt.Fprintf(w, `<polygon fill="none" stroke="%s" stroke-width="%d" points="%s"/>`+"\n",
+				box.color, strokeWidth, svgPoints(x0+d, y0+d, x1-d, y1-d))
+		}
+	}
+
+	anchor, x := "middle", v.x
+	if box.leftAligned {
+		anchor, x = "start", x0+8
+	}
+	writeText(w, box.lines, anchor, x, v.y, box.fontSize)
+	fmt.Fprintln(w, "</a>\n</g>\n</g>")
+}
+
+func (e *svgEdge) write(w io.Writer, n int) {
+	points := e.e.points
+	if len(points) < 2 {
+		return
+	}
+	fmt.Fprintf(w, `<g id="edge%d" class="edge">`+"\n", n)
+	fmt.Fprintf(w, "<title>%s</title>\n", svgEscape(e.title))
+	fmt.Fprintf(w, `<g id="a_edge%d"><a xlink:title="%s">`+"\n", n, svgEscape(e.tooltip))
+
+	// Draw the curve through the points, leaving room for the arrow.
+	var d strings.Builder
+	fmt.Fprintf(&d, "M%.2f,%.2f", points[0].x, points[0].y)
+	var ctrl point
+	if e.e.src == e.e.dst {
+		ctrl = points[2]
+		fmt.Fprintf(&d, "C%.2f,%.2f %.2f,%.2f", points[1].x, points[1].y, ctrl.x, ctrl.y)
+	} else {
+		for i := 1; i < len(points); i++ {
+			a, b := points[i-1], points[i]
+			dy := (b.y - a.y) / 2
+			ctrl = point{b.x, b.y - dy}
+			fmt.Fprintf(&d, "C%.2f,%.2f %.2f,%.2f", a.x, a.y+dy, ctrl.x, ctrl.y)
+			if i < len(points)-1 {
+				fmt.Fprintf(&d, " %.2f,%.2f", b.x, b.y)
+			}
+		}
+	}
+	tip := points[len(points)-1]
+	dx, dy := tip.x-ctrl.x, tip.y-ctrl.y
+	if l := math.Hypot(dx, dy); l > 0 {
+		dx, dy = dx/l, dy/l
+	} else {
+		dx, dy = 0, 1
+	}
+	arrowLen := svgArrowLen + float64(e.width)
+	base := point{tip.x - dx*arrowLen, tip.y - dy*arrowLen}
+	fmt.Fprintf(&d, " %.2f,%.2f", base.x, base.y)
+
+	dash := ""
+	if e.dotted {
+		dash = ` stroke-dasharray="1,5"`
+	}
+	fmt.Fprintf(w, `<path fill="none" stroke="%s" stroke-width="%d"%s d="%s"/>`+"\n", e.color, e.width, dash, d.String())
+	half := 3.5 + float64(e.width)/2
+	fmt.Fprintf(w, `<polygon fill="%s" stroke="%s" stroke-width="%d" points="%.2f,%.2f %.2f,%.2f %.2f,%.2f %.2f,%.2f"/>`+"\n",
+		e.color, e.color, e.width,
+		base.x-dy*half, base.y+dx*half, tip.x, tip.y, base.x+dy*half, base.y-dx*half, base.x-dy*half, base.y+dx*half)
+	fmt.Fprintln(w, "</a>\n</g>")
+
+	// Label the edge at the middle of its route.
+	mid := points[len(points)/2]
+	if len(points)%2 == 0 {
+		a := points[len(points)/2-1]
+		mid = point{(a.x + mid.x) / 2, (a.y + mid.y) / 2}
+	}
+	fmt.Fprintf(w, `<g id="a_edge%d-label"><a xlink:title="%s">`+"\n", n, svgEscape(e.tooltip))
+	writeText(w, e.label, "start", mid.x+4, mid.y, svgEdgeFont)
+	fmt.Fprintln(w, "</a>\n</g>\n</g>")
+}
+
+// writeText writes lines of text centered vertically on y.
+func writeText(w io.Writer, lines []string, anchor string, x, y, fontSize float64) {
+	lineHeight := fontSize * 1.2
+	top := y - lineHeight*float64(len(lines))/2
+	for i, l := range lines {
+		fmt.Fprintf(w, `<text text-anchor="%s" x="%.2f" y="%.2f" font-family="Times,serif" font-size="%.2f">%s</text>`+"\n",
+			anchor, x, top+lineHeight*float64(i)+fontSize, fontSize, svgEscape(l))
+	}
+}
+
+// svgPoints returns the corners of a rectangle as the points of a
+// closed polygon.
+func svgPoints(x0, y0, x1, y1 float64) string {
+	return fmt.Sprintf("%.2f,%.2f %.2f,%.2f %.2f,%.2f %.2f,%.2f %.2f,%.2f", x0, y0, x1, y0, x1, y1, x0, y1, x0, y0)
+}
+
+func svgEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
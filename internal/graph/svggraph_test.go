@@ -0,0 +1,76 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestComposeSVG(t *testing.T) {
+	g := baseGraph()
+	a, c := baseAttrsAndConfig()
+	a.Nodes[g.Nodes[0]] = &DotNodeAttributes{Bold: true, Peripheries: 2, URL: "www.google.com"}
+	g.Nodes[0].LabelTags["a"] = &Tag{Name: "tag1", Cum: 10, Flat: 10}
+	g.Nodes[0].Out[g.Nodes[1]].Residual = true
+
+	var buf bytes.Buffer
+	ComposeSVG(&buf, g, a, c)
+	svg := buf.String()
+
+	// The image must be well formed.
+	d := xml.NewDecoder(strings.NewReader(svg))
+	for {
+		if _, err := d.Token(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("ComposeSVG: malformed SVG: %v\n%s", err, svg)
+		}
+	}
+
+	for _, want := range []string{
+		`<g id="graph0" class="graph"`,
+		`<g id="node1" class="node">`,
+		`<g id="node2" class="node">`,
+		`<g id="N1_0" class="node">`,
+		`<g id="legend" class="cluster">`,
+		`<title>N1-&gt;N2</title>`,
+		`stroke-dasharray="1,5"`,
+		`xlink:href="www.google.com"`,
+		`stroke-width="2"`,
+		`>tag1<`,
+	} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("ComposeSVG: output does not contain %q:\n%s", want, svg)
+		}
+	}
+	if n := strings.Count(svg, `class="edge"`); n != 2 {
+		t.Errorf("ComposeSVG: got %d edges, want 2 (1 edge and 1 nodelet edge)", n)
+	}
+}
+
+func TestComposeSVGWithEmptyGraph(t *testing.T) {
+	g := &Graph{}
+	a, c := baseAttrsAndConfig()
+
+	var buf bytes.Buffer
+	ComposeSVG(&buf, g, a, c)
+	if !strings.Contains(buf.String(), `<g id="legend" class="cluster">`) {
+		t.Errorf("ComposeSVG: empty graph has no legend:\n%s", buf.String())
+	}
+}
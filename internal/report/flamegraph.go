@@ -0,0 +1,261 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"pproflame/internal/graph"
+	"pproflame/internal/measurement"
+)
+
+// FlameNode is a frame of a flame graph, as drawn by the flame graph
+// views of the web interface and by the flamegraph report.
+type FlameNode struct {
+	Name      string       `json:"n"`
+	FullName  string       `json:"f"`
+	Cum       int64        `json:"v"`
+	CumFormat string       `json:"l"`
+	Percent   string       `json:"p"`
+	Children  []*FlameNode `json:"c"`
+
+	// The values of the frame in the base and the current profiles of
+	// a differential flame graph.
+	Base          int64  `json:"b,omitempty"`
+	BaseFormat    string `json:"bl,omitempty"`
+	Current       int64  `json:"cv,omitempty"`
+	CurrentFormat string `json:"cl,omitempty"`
+	DeltaFormat   string `json:"dl,omitempty"`
+	DeltaPercent  string `json:"dp,omitempty"` // Relative to the base value
+}
+
+// NewFlameNode returns a frame of a flame graph for the node called
+// fullName, with value v.
+func NewFlameNode(fullName string, v int64, config *graph.DotConfig) *FlameNode {
+	return &FlameNode{
+		Name:      getNodeShortName(fullName),
+		FullName:  fullName,
+		Cum:       v,
+		CumFormat: config.FormatValue(v),
+		Percent:   strings.TrimSpace(measurement.Percentage(v, config.Total)),
+	}
+}
+
+// NewFlameGraph returns the flame graph of a call tree, rooted at the
+// roots of the tree.
+func NewFlameGraph(g *graph.Graph, config *graph.DotConfig) *FlameNode {
+	var nodes []*FlameNode
+	nroots := 0
+	rootValue := int64(0)
+	nodeMap := map[*graph.Node]*FlameNode{}
+	// Make all nodes and the map, collect the roots.
+	for _, n := range g.Nodes {
+		v := n.CumValue()
+		node := NewFlameNode(n.Info.PrintableName(), v, config)
+		nodes = append(nodes, node)
+		if len(n.In) == 0 {
+			nodes[nroots], nodes[len(nodes)-1] = nodes[len(nodes)-1], nodes[nroots]
+			nroots++
+			rootValue += v
+		}
+		nodeMap[n] = node
+	}
+	// Populate the child links.
+	for _, n := range g.Nodes {
+		node := nodeMap[n]
+		for child := range n.Out {
+			node.Children = append(node.Children, nodeMap[child])
+		}
+	}
+
+	rootNode := NewFlameNode("root", rootValue, config)
+	rootNode.Children = nodes[0:nroots]
+	return rootNode
+}
+
+// getNodeShortName builds a short node name from fullName.
+func getNodeShortName(name string) string {
+	chunks := strings.SplitN(name, "(", 2)
+	head := chunks[0]
+	pathSep := strings.LastIndexByte(head, '/')
+	if pathSep == -1 || pathSep+1 >= len(head) {
+		return name
+	}
+	// Check if name is a stdlib package, i.e. doesn't have "." before "/"
+	if dot := strings.IndexByte(head, '.'); dot == -1 || dot > pathSep {
+		return name
+	}
+	// Trim package path prefix from node name
+	return name[pathSep+1:]
+}
+
+// Dimensions of the flamegraph report, in pixels.
+const (
+	flameWidth       = 1200
+	flameFrameHeight = 16
+	flameFontSize    = 12
+	flameCharWidth   = 7 // Approximate width of a character of the font
+	flameMargin      = 10
+)
+
+// printFlameGraph prints the call tree of the profile as a standalone
+// SVG flame graph, with the roots at the bottom. Every frame has a
+// tooltip with its full name and value. Frames whose name matches the
+// highlight option are drawn in a distinct color, and the header tells
+// the total value of the matching stacks.
+func printFlameGraph(w io.Writer, rpt *Report) error {
+	o := rpt.options
+	g := rpt.newGraph(nil)
+	rpt.selectOutputUnit(g)
+	config := &graph.DotConfig{FormatValue: rpt.formatValue, Total: rpt.total}
+	root := NewFlameGraph(g, config)
+	sortFlameGraph(root)
+
+	header := ProfileLabels(rpt)
+	if o.Highlight != nil {
+		matched := highlightedValue(root, o.Highlight)
+		header = append(header, fmt.Sprintf("Highlighted %s: %s (%s)", o.Highlight,
+			rpt.formatValue(matched), strings.TrimSpace(measurement.Percentage(matched, rpt.total))))
+	}
+
+	depth := flameDepth(root)
+	top := flameMargin + len(header)*(flameFontSize+4) + flameMargin
+	height := top + depth*flameFrameHeight + flameMargin
+
+	fmt.Fprintf(w, `<?xml version="1.0" standalone="no"?>
+<svg version="1.1" width="%d" height="%d" viewBox="0 0 %d %d" xmlns="http://www.w3.org/2000/svg">
+`, flameWidth+2*flameMargin, height, flameWidth+2*flameMargin, height)
+	if o.Title != "" {
+		fmt.Fprintf(w, "<title>%s</title>\n", template.HTMLEscapeString(o.Title))
+	}
+	fmt.Fprintf(w, `<style type="text/css">
+text { font-family: Verdana, sans-serif; font-size: %dpx; fill: #000; }
+.frame text { pointer-events: none; }
+.frame rect { stroke: #fff; stroke-width: 0.5; }
+.frame:hover rect { stroke: #000; }
+</style>
+<rect x="0" y="0" width="100%%" height="100%%" fill="#fff"/>
+`, flameFontSize)
+	for i, line := range header {
+		fmt.Fprintf(w, "<text x=\"%d\" y=\"%d\">%s</text>\n", flameMargin, flameMargin+(i+1)*(flameFontSize+4), template.HTMLEscapeString(line))
+	}
+	if root.Cum > 0 {
+		scale := float64(flameWidth) / float64(root.Cum)
+		printFlameFrame(w, root, flameMargin, top+(depth-1)*flameFrameHeight, scale, o.Highlight)
+	}
+	fmt.Fprintln(w, "</svg>")
+	return nil
+}
+
+// printFlameFrame prints the frame n at x, y and its children above it.
+func printFlameFrame(w io.Writer, n *FlameNode, x float64, y int, scale float64, highlight *regexp.Regexp) {
+	width := float64(n.Cum) * scale
+	if n.Cum <= 0 || width < 0.1 {
+		return
+	}
+	fill := flameColor(n.Name)
+	if highlight != nil && highlight.MatchString(n.FullName) {
+		fill = "#e600e6"
+	}
+	title := fmt.Sprintf("%s (%s, %s)", n.FullName, n.CumFormat, n.Percent)
+	fmt.Fprintf(w, "<g class=\"frame\">\n<title>%s</title>\n", template.HTMLEscapeString(title))
+	fmt.Fprintf(w, "<rect x=\"%.2f\" y=\"%d\" width=\"%.2f\" height=\"%d\" fill=\"%s\" rx=\"2\"/>\n", x, y, width, flameFrameHeight, fill)
+	if chars := int((width - 6) / flameCharWidth); chars >= 3 {
+		fmt.Fprintf(w, "<text x=\"%.2f\" y=\"%d\">%s</text>\n", x+3, y+flameFrameHeight-4, template.HTMLEscapeString(flameLabel(n.Name, chars)))
+	}
+	fmt.Fprintln(w, "</g>")
+	for _, child := range n.Children {
+		printFlameFrame(w, child, x, y-flameFrameHeight, scale, highlight)
+		if child.Cum > 0 {
+			x += float64(child.Cum) * scale
+		}
+	}
+}
+
+// flameLabel returns name cut to fit in chars characters, ending in
+// ".." when cut. It cuts by runes, so that names are not split inside
+// a multibyte character.
+func flameLabel(name string, chars int) string {
+	r := []rune(name)
+	if len(r) <= chars {
+		return name
+	}
+	return string(r[:chars-2]) + ".."
+}
+
+// flameColor returns the color of the frames of a function, a hash of
+// its name into the warm palette of the flame graph of the web
+// interface, favoring the first characters of the name.
+func flameColor(name string) string {
+	const maxChar = 6
+	var hash, maxHash float64
+	weight := 1.0
+	name = strings.SplitN(name, "(", 2)[0]
+	for i := 0; i < len(name) && i <= maxChar; i++ {
+		hash += weight * float64(name[i]%10)
+		maxHash += weight * 9
+		weight *= 0.7
+	}
+	if maxHash > 0 {
+		hash /= maxHash
+	}
+	r := 200 + int(math.Round(55*hash))
+	g := int(math.Round(230 * (1 - hash)))
+	b := int(math.Round(55 * (1 - hash)))
+	return fmt.Sprintf("rgb(%d,%d,%d)", r, g, b)
+}
+
+// sortFlameGraph sorts the children of every frame by name, so that the
+// report is stable.
+func sortFlameGraph(n *FlameNode) {
+	sort.Slice(n.Children, func(i, j int) bool {
+		return n.Children[i].Name < n.Children[j].Name
+	})
+	for _, child := range n.Children {
+		sortFlameGraph(child)
+	}
+}
+
+// flameDepth returns the number of levels of frames drawn for n.
+func flameDepth(n *FlameNode) int {
+	depth := 0
+	for _, child := range n.Children {
+		if child.Cum > 0 {
+			if d := flameDepth(child); d > depth {
+				depth = d
+			}
+		}
+	}
+	return depth + 1
+}
+
+// highlightedValue returns the value of the outermost frames under n
+// whose name matches re, so that recursive calls are counted once.
+func highlightedValue(n *FlameNode, re *regexp.Regexp) int64 {
+	if re.MatchString(n.FullName) {
+		return n.Cum
+	}
+	var v int64
+	for _, child := range n.Children {
+		v += highlightedValue(child, re)
+	}
+	return v
+}
@@ -0,0 +1,112 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestGetNodeShortName(t *testing.T) {
+	type testCase struct {
+		name string
+		want string
+	}
+	testcases := []testCase{
+		{
+			"root",
+			"root",
+		},
+		{
+			"syscall.Syscall",
+			"syscall.Syscall",
+		},
+		{
+			"net/http.(*conn).serve",
+			"net/http.(*conn).serve",
+		},
+		{
+			"github.com/blah/foo.Foo",
+			"foo.Foo",
+		},
+		{
+			"github.com/blah/foo_bar.(*FooBar).Foo",
+			"foo_bar.(*FooBar).Foo",
+		},
+		{
+			"encoding/json.(*structEncoder).(encoding/json.encode)-fm",
+			"encoding/json.(*structEncoder).(encoding/json.encode)-fm",
+		},
+		{
+			"github.com/blah/blah/vendor/gopkg.in/redis.v3.(*baseClient).(github.com/blah/blah/vendor/gopkg.in/redis.v3.process)-fm",
+			"redis.v3.(*baseClient).(github.com/blah/blah/vendor/gopkg.in/redis.v3.process)-fm",
+		},
+	}
+	for _, tc := range testcases {
+		name := getNodeShortName(tc.name)
+		if got, want := name, tc.want; got != want {
+			t.Errorf("for %s, got %q, want %q", tc.name, got, want)
+		}
+	}
+}
+
+func TestFlameLabel(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		chars int
+		want  string
+	}{
+		{"main", 4, "main"},
+		{"main.run", 6, "main.."},
+		{"处理请求", 4, "处理请求"},
+		{"处理请求.run", 5, "处理请.."},
+	} {
+		if got := flameLabel(tc.name, tc.chars); got != tc.want {
+			t.Errorf("flameLabel(%q, %d): got %q, want %q", tc.name, tc.chars, got, tc.want)
+		}
+	}
+}
+
+func TestFlameGraphSVG(t *testing.T) {
+	rpt := New(testProfile.Copy(), &Options{
+		OutputFormat: FlameGraph,
+		Highlight:    regexp.MustCompile(`tee`),
+
+		SampleValue: func(v []int64) int64 { return v[1] },
+		SampleUnit:  testProfile.SampleType[1].Unit,
+	})
+	var b bytes.Buffer
+	if err := Generate(&b, rpt, nil); err != nil {
+		t.Fatal(err)
+	}
+	got := b.String()
+	for _, want := range []string{
+		"<svg ",
+		"<title>root (11111, 100%)</title>",
+		"<title>main testdata/source1:2 (11111, 100%)</title>",
+		// The samples in tee are counted once, at its outermost calls.
+		"Highlighted tee: 11100 (99.90%)",
+		"</svg>",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("flame graph does not contain %q:\n%s", want, got)
+		}
+	}
+	if n := strings.Count(got, `fill="#e600e6"`); n != 3 {
+		t.Errorf("got %d highlighted frames, want 3:\n%s", n, got)
+	}
+}
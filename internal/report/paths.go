@@ -0,0 +1,156 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"pproflame/internal/graph"
+	"pproflame/internal/measurement"
+)
+
+// CallPath is a distinct call stack of a profile, with the total value
+// of its samples.
+type CallPath struct {
+	Frames      []string // From the outermost caller down to the leaf
+	Names       []string // Function, or file if none, of each frame, for focusing on it
+	Common      int      // Leading frames shared with the path before it
+	Value       int64
+	ValueFormat string
+	Percent     string // Percentage of the total of the report
+	SumPercent  string // Same, for this path and the ones before it
+}
+
+// CallPaths returns the call stacks of the samples of the report, with
+// identical stacks merged, by decreasing value and up to the NodeCount
+// option, and a list of labels that describe the report. The stacks are
+// made of the nodes of the granularity of the report, so stacks only
+// differing by finer details are merged too.
+func CallPaths(rpt *Report) ([]CallPath, []string) {
+	o := rpt.options
+	prof := rpt.prof
+	rpt.selectOutputUnit(rpt.newGraph(nil))
+
+	type path struct {
+		frames, names []string
+		value, div    int64
+	}
+	var paths []*path
+	pathMap := make(map[string]*path)
+	_, locations := graph.CreateNodes(prof, &graph.Options{})
+	for _, sample := range prof.Sample {
+		// Locations and their inlined frames are listed from the leaf up.
+		var frames, names []string
+		for i := len(sample.Location) - 1; i >= 0; i-- {
+			nodes := locations[sample.Location[i].ID]
+			for j := len(nodes) - 1; j >= 0; j-- {
+				info := &nodes[j].Info
+				frames = append(frames, info.PrintableName())
+				name := info.Name
+				if name == "" {
+					name = info.File
+				}
+				names = append(names, name)
+			}
+		}
+		if len(frames) == 0 {
+			continue
+		}
+
+		key := strings.Join(frames, "\n")
+		p := pathMap[key]
+		if p == nil {
+			p = &path{frames: frames, names: names}
+			pathMap[key] = p
+			paths = append(paths, p)
+		}
+		p.value += o.SampleValue(sample.Value)
+		if o.SampleMeanDivisor != nil {
+			p.div += o.SampleMeanDivisor(sample.Value)
+		}
+	}
+	for _, p := range paths {
+		if p.div != 0 {
+			p.value /= p.div
+		}
+	}
+
+	sort.SliceStable(paths, func(i, j int) bool {
+		if iv, jv := abs64(paths[i].value), abs64(paths[j].value); iv != jv {
+			return iv > jv
+		}
+		return strings.Join(paths[i].frames, "\n") < strings.Join(paths[j].frames, "\n")
+	})
+	count := len(paths)
+	if o.NodeCount > 0 && o.NodeCount < count {
+		count = o.NodeCount
+	}
+
+	var items []CallPath
+	var sum int64
+	var prev []string
+	for _, p := range paths[:count] {
+		common := 0
+		for common < len(prev) && common < len(p.frames)-1 && prev[common] == p.frames[common] {
+			common++
+		}
+		sum += p.value
+		items = append(items, CallPath{
+			Frames:      p.frames,
+			Names:       p.names,
+			Common:      common,
+			Value:       p.value,
+			ValueFormat: rpt.formatValue(p.value),
+			Percent:     strings.TrimSpace(measurement.Percentage(p.value, rpt.total)),
+			SumPercent:  strings.TrimSpace(measurement.Percentage(sum, rpt.total)),
+		})
+		prev = p.frames
+	}
+
+	labels := ProfileLabels(rpt)
+	labels = append(labels, fmt.Sprintf("Showing top %d paths out of %d, accounting for %s, %s of %s total",
+		count, len(paths), rpt.formatValue(sum), strings.TrimSpace(measurement.Percentage(sum, rpt.total)), rpt.formatValue(rpt.total)))
+	return items, labels
+}
+
+// printPaths prints the heaviest call stacks of a profile, from their
+// outermost caller down. The frames a stack shares with the one above
+// it are collapsed into a single line.
+func printPaths(w io.Writer, rpt *Report) error {
+	paths, labels := CallPaths(rpt)
+	fmt.Fprintln(w, strings.Join(labels, "\n"))
+	fmt.Fprintf(w, "%10s %6s %6s  %s\n", "value", "value%", "sum%", "path")
+	for _, p := range paths {
+		values := fmt.Sprintf("%10s %6s %6s", p.ValueFormat, p.Percent, p.SumPercent)
+		frames := p.Frames
+		if p.Common > 0 {
+			n := "frame"
+			if p.Common > 1 {
+				n += "s"
+			}
+			fmt.Fprintf(w, "%s  ... (%d %s as above)\n", values, p.Common, n)
+			values = ""
+			frames = frames[p.Common:]
+		}
+		for _, f := range frames {
+			fmt.Fprintf(w, "%24s  %s\n", values, f)
+			values = ""
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"pproflame/profile"
+)
+
+func TestCallPaths(t *testing.T) {
+	p := testProfile.Copy()
+	// A second sample of the heaviest stack is merged into it.
+	p.Sample = append(p.Sample, &profile.Sample{
+		Location: []*profile.Location{p.Location[4], p.Location[3], p.Location[0]},
+		Value:    []int64{1, 5000},
+	})
+	rpt := New(p, &Options{
+		OutputFormat: Paths,
+		OutputUnit:   "minimum",
+		NodeCount:    4,
+
+		SampleValue: func(v []int64) int64 { return v[1] },
+		SampleUnit:  testProfile.SampleType[1].Unit,
+	})
+
+	paths, labels := CallPaths(rpt)
+	want := []CallPath{
+		{
+			Frames:      []string{"main testdata/source1:2", "tee /some/path/testdata/source2:2", "tee /some/path/testdata/source2:8"},
+			Names:       []string{"main", "tee", "tee"},
+			Value:       15000,
+			ValueFormat: "15000",
+			Percent:     "93.10%",
+			SumPercent:  "93.10%",
+		},
+		{
+			Frames:      []string{"main testdata/source1:2", "tee /some/path/testdata/source2:2"},
+			Names:       []string{"main", "tee"},
+			Common:      1, // The leaf is always shown
+			Value:       1000,
+			ValueFormat: "1000",
+			Percent:     "6.21%",
+			SumPercent:  "99.31%",
+		},
+		{
+			Frames:      []string{"main testdata/source1:2", "bar testdata/source1:10", "tee /some/path/testdata/source2:8"},
+			Names:       []string{"main", "bar", "tee"},
+			Common:      1,
+			Value:       100,
+			ValueFormat: "100",
+			Percent:     "0.62%",
+			SumPercent:  "99.93%",
+		},
+		{
+			Frames:      []string{"main testdata/source1:2", "foo testdata/source1:4", "bar testdata/source1:10"},
+			Names:       []string{"main", "foo", "bar"},
+			Common:      1,
+			Value:       10,
+			ValueFormat: "10",
+			Percent:     "0.062%",
+			SumPercent:  "100%",
+		},
+	}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("got paths:\n%+v\nwant:\n%+v", paths, want)
+	}
+	if got, want := labels[len(labels)-1], "Showing top 4 paths out of 5, accounting for 16110, 100% of 16111 total"; got != want {
+		t.Errorf("got label %q, want %q", got, want)
+	}
+
+	var b bytes.Buffer
+	if err := Generate(&b, rpt, nil); err != nil {
+		t.Fatal(err)
+	}
+	got := b.String()
+	got = got[strings.Index(got, "     value"):]
+	wantText := `     value value%   sum%  path
+     15000 93.10% 93.10%  main testdata/source1:2
+                          tee /some/path/testdata/source2:2
+                          tee /some/path/testdata/source2:8
+      1000  6.21% 99.31%  ... (1 frame as above)
+                          tee /some/path/testdata/source2:2
+       100  0.62% 99.93%  ... (1 frame as above)
+                          bar testdata/source1:10
+                          tee /some/path/testdata/source2:8
+        10 0.062%   100%  ... (1 frame as above)
+                          foo testdata/source1:4
+                          bar testdata/source1:10
+`
+	if got != wantText {
+		t.Errorf("got:\n%s\nwant:\n%s", got, wantText)
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"pproflame/internal/measurement"
+)
+
+// minRegressionStddev is the smallest standard deviation of the share
+// of an entry in the earlier profiles, so that entries with a steady
+// share are not flagged for insignificant increases.
+const minRegressionStddev = 0.001
+
+// Regression is an entry of a report whose share of the total of the
+// profile grew significantly over its shares in earlier profiles.
+type Regression struct {
+	Name  string          `json:"name"`
+	Flat  RegressionValue `json:"flat"`
+	Cum   RegressionValue `json:"cum"`
+	Score float64         `json:"score"` // The larger score of Flat and Cum
+}
+
+// RegressionValue compares the flat or cum value of an entry with its
+// values in the earlier profiles.
+type RegressionValue struct {
+	Value       int64   `json:"value"`
+	ValueFormat string  `json:"valueLabel"`
+	Share       float64 `json:"share"`      // Fraction of the total of the report
+	BaseShare   float64 `json:"baseShare"`  // Mean share in the earlier profiles
+	BaseStddev  float64 `json:"baseStddev"` // Standard deviation of the same
+	Score       float64 `json:"score"`      // Increase, in standard deviations
+	Significant bool    `json:"significant"`
+}
+
+// SharePercent returns the share of the value, as a percentage.
+func (v RegressionValue) SharePercent() string {
+	return sharePercent(v.Share)
+}
+
+// BasePercent returns the mean share of the value in the earlier
+// profiles, as a percentage.
+func (v RegressionValue) BasePercent() string {
+	return sharePercent(v.BaseShare)
+}
+
+// sharePercent formats a fraction of a total as a percentage.
+func sharePercent(share float64) string {
+	return strings.TrimSpace(measurement.Percentage(int64(math.Round(share*1e6)), 1e6))
+}
+
+// DetectRegressions compares the share of the total of every entry of the
+// report with its shares in the reports of the History option, and
+// returns the entries with a significant increase, by decreasing score
+// and up to the NodeCount option, and a list of labels that describe the
+// report. An increase is significant if it is at least RegressionScore
+// standard deviations of the earlier shares. Earlier reports with a zero
+// total are left out, and no increase is significant with fewer than
+// two earlier reports left.
+func DetectRegressions(rpt *Report) ([]Regression, []string) {
+	o := rpt.options
+	g := rpt.newGraph(nil)
+	rpt.selectOutputUnit(g)
+
+	var history []*Report
+	for _, h := range o.History {
+		if h.total != 0 {
+			history = append(history, h)
+		}
+	}
+
+	// The shares of every entry in the earlier profiles.
+	type shares struct {
+		flat, cum []float64
+	}
+	base := make(map[string]*shares)
+	for i, h := range history {
+		for _, n := range h.newGraph(nil).Nodes {
+			name := n.Info.PrintableName()
+			s := base[name]
+			if s == nil {
+				s = &shares{make([]float64, len(history)), make([]float64, len(history))}
+				base[name] = s
+			}
+			s.flat[i] += float64(n.FlatValue()) / float64(h.total)
+			s.cum[i] += float64(n.CumValue()) / float64(h.total)
+		}
+	}
+
+	type values struct {
+		flat, cum int64
+	}
+	var names []string
+	current := make(map[string]*values)
+	for _, n := range g.Nodes {
+		name := n.Info.PrintableName()
+		v := current[name]
+		if v == nil {
+			v = &values{}
+			current[name] = v
+			names = append(names, name)
+		}
+		v.flat += n.FlatValue()
+		v.cum += n.CumValue()
+	}
+
+	none := &shares{make([]float64, len(history)), make([]float64, len(history))}
+	var regressions []Regression
+	for _, name := range names {
+		s := base[name]
+		if s == nil {
+			s = none
+		}
+		v := current[name]
+		r := Regression{
+			Name: name,
+			Flat: rpt.regressionValue(v.flat, s.flat),
+			Cum:  rpt.regressionValue(v.cum, s.cum),
+		}
+		if !r.Flat.Significant && !r.Cum.Significant {
+			continue
+		}
+		r.Score = math.Max(r.Flat.Score, r.Cum.Score)
+		regressions = append(regressions, r)
+	}
+	sort.Slice(regressions, func(i, j int) bool {
+		if regressions[i].Score != regressions[j].Score {
+			return regressions[i].Score > regressions[j].Score
+		}
+		return regressions[i].Name < regressions[j].Name
+	})
+	count := len(regressions)
+	if o.NodeCount > 0 && o.NodeCount < count {
+		regressions = regressions[:o.NodeCount]
+	}
+
+	labels := ProfileLabels(rpt)
+	baseline := fmt.Sprintf("Baseline: %d earlier profiles", len(history))
+	if first, last := historyTimes(history); first != 0 {
+		const layout = "Jan 2, 2006 at 3:04pm (MST)"
+		baseline += fmt.Sprintf(", from %s to %s", time.Unix(0, first).Format(layout), time.Unix(0, last).Format(layout))
+	}
+	labels = append(labels, baseline)
+	labels = append(labels, fmt.Sprintf("Showing %d of %d entries with an increase of at least %g standard deviations, out of %d",
+		len(regressions), count, o.RegressionScore, len(names)))
+	return regressions, labels
+}
+
+// regressionValue compares the value v of an entry of the report with
+// the shares base of the entry in the earlier profiles, at least two of
+// them for the increase to be significant.
+func (rpt *Report) regressionValue(v int64, base []float64) RegressionValue {
+	r := RegressionValue{
+		Value:       v,
+		ValueFormat: rpt.formatValue(v),
+	}
+	if rpt.total != 0 {
+		r.Share = float64(v) / float64(rpt.total)
+	}
+	for _, s := range base {
+		r.BaseShare += s
+	}
+	if len(base) > 0 {
+		r.BaseShare /= float64(len(base))
+	}
+	if len(base) > 1 {
+		for _, s := range base {
+			r.BaseStddev += (s - r.BaseShare) * (s - r.BaseShare)
+		}
+		r.BaseStddev = math.Sqrt(r.BaseStddev / float64(len(base)-1))
+	}
+	r.Score = (r.Share - r.BaseShare) / math.Max(r.BaseStddev, minRegressionStddev)
+	r.Significant = len(base) > 1 && r.Share > r.BaseShare && r.Score >= rpt.options.RegressionScore
+	return r
+}
+
+// historyTimes returns the times of the first and last of the reports
+// with a time, in nanoseconds since the epoch, or zeros if none has one.
+func historyTimes(history []*Report) (first, last int64) {
+	for _, h := range history {
+		t := h.prof.TimeNanos
+		if t == 0 {
+			continue
+		}
+		if first == 0 || t < first {
+			first = t
+		}
+		if t > last {
+			last = t
+		}
+	}
+	return first, last
+}
+
+// printRegressions prints the entries of the report whose share grew
+// significantly over the earlier profiles, with the most significant
+// increases first.
+func printRegressions(w io.Writer, rpt *Report) error {
+	regressions, labels := DetectRegressions(rpt)
+	fmt.Fprintln(w, strings.Join(labels, "\n"))
+	fmt.Fprintf(w, "%10s %6s %6s %6s %10s %6s %6s %6s\n",
+		"flat", "flat%", "base%", "score", "cum", "cum%", "base%", "score")
+	for _, r := range regressions {
+		fmt.Fprintf(w, "%10s %6s %6s %6.1f %10s %6s %6s %6.1f  %s\n",
+			r.Flat.ValueFormat, r.Flat.SharePercent(), r.Flat.BasePercent(), r.Flat.Score,
+			r.Cum.ValueFormat, r.Cum.SharePercent(), r.Cum.BasePercent(), r.Cum.Score,
+			r.Name)
+	}
+	return nil
+}
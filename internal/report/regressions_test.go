@@ -0,0 +1,115 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"pproflame/profile"
+)
+
+func TestRegressions(t *testing.T) {
+	options := func() *Options {
+		return &Options{
+			OutputFormat: Regressions,
+			OutputUnit:   "minimum",
+
+			SampleValue: func(v []int64) int64 { return v[1] },
+			SampleUnit:  testProfile.SampleType[1].Unit,
+
+			RegressionScore: 3,
+		}
+	}
+	// scaled returns a copy of testProfile with the value of its sample
+	// i multiplied by f.
+	scaled := func(i int, f int64) *profile.Profile {
+		p := testProfile.Copy()
+		p.Sample[i].Value[1] *= f
+		return p
+	}
+
+	// The stack main, foo, bar of sample 1 varies slightly over time. An
+	// empty snapshot, with a zero total, is left out of the baseline.
+	var history []*Report
+	for _, f := range []int64{1, 2, 1, 3} {
+		history = append(history, New(scaled(1, f), options()))
+	}
+	empty := testProfile.Copy()
+	for _, s := range empty.Sample {
+		s.Value[1] = 0
+	}
+	history = append(history, New(empty, options()))
+
+	for _, tc := range []struct {
+		name     string
+		current  *profile.Profile
+		history  []*Report
+		want     []string
+		baseline string
+	}{
+		{
+			name:     "steady",
+			current:  scaled(1, 2),
+			history:  history,
+			baseline: "Baseline: 4 earlier profiles",
+		},
+		{
+			name:     "regressed",
+			current:  scaled(1, 500),
+			history:  history,
+			want:     []string{"bar testdata/source1:10", "foo testdata/source1:4"},
+			baseline: "Baseline: 4 earlier profiles",
+		},
+		{
+			name:     "single snapshot",
+			current:  scaled(1, 500),
+			history:  history[3:],
+			baseline: "Baseline: 1 earlier profiles",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			o := options()
+			o.History = tc.history
+			rpt := New(tc.current, o)
+
+			regressions, labels := DetectRegressions(rpt)
+			var got []string
+			for _, r := range regressions {
+				got = append(got, r.Name)
+				if r.Score < 3 {
+					t.Errorf("%s: got score %f, want at least 3", r.Name, r.Score)
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Errorf("got regressions %q, want %q", got, tc.want)
+			}
+			if !strings.Contains(strings.Join(labels, "\n"), tc.baseline) {
+				t.Errorf("labels %q do not describe the baseline", labels)
+			}
+
+			var buf bytes.Buffer
+			if err := Generate(&buf, rpt, nil); err != nil {
+				t.Fatalf("generating regressions report: %v", err)
+			}
+			for _, name := range tc.want {
+				if !strings.Contains(buf.String(), name) {
+					t.Errorf("regressions report has no %q:\n%s", name, buf.String())
+				}
+			}
+		})
+	}
+}
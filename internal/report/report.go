@@ -17,8 +17,10 @@
 package report
 
 import (
+	"encoding/csv"
 	"fmt"
 	"io"
+	"math"
 	"path/filepath"
 	"regexp"
 	"sort"
@@ -37,16 +39,23 @@ import (
 const (
 	Callgrind = iota
 	Comments
+	CSV
 	Dis
 	Dot
+	FlameGraph
 	List
+	Paths
 	Proto
 	Raw
+	Redact
+	Regressions
 	Tags
 	Text
 	TopProto
 	Traces
 	Tree
+	TSV
+	Validate
 	WebList
 )
 
@@ -76,9 +85,19 @@ type Options struct {
 
 	OutputUnit string // Units for data formatting in report.
 
+	Columns    []Column // Other sample values shown by text reports.
+	SortColumn string   // Column to sort text reports by, if not flat or cum.
+
+	History         []*Report // Reports of earlier profiles, for the regressions report.
+	RegressionScore float64   // Smallest significant increase, in standard deviations.
+
+	Redact *profile.RedactOptions // Data removed from the profile by the redact report.
+
 	Symbol     *regexp.Regexp // Symbols to include on disassembly report.
+	Highlight  *regexp.Regexp // Frames to highlight on flame graph report.
 	SourcePath string         // Search path for source files.
 	TrimPath   string         // Paths to trim from source file paths.
+	GitMirror  string         // Git repository holding the sources at the profile revision.
 }
 
 // Generate generates a report as directed by the Report.
@@ -90,19 +109,34 @@ func Generate(w io.Writer, rpt *Report, obj plugin.ObjTool) error {
 		return printComments(w, rpt)
 	case Dot:
 		return printDOT(w, rpt)
+	case FlameGraph:
+		return printFlameGraph(w, rpt)
 	case Tree:
 		return printTree(w, rpt)
 	case Text:
 		return printText(w, rpt)
+	case CSV:
+		return printDelimited(w, rpt, ',')
+	case TSV:
+		return printDelimited(w, rpt, '\t')
 	case Traces:
 		return printTraces(w, rpt)
+	case Paths:
+		return printPaths(w, rpt)
 	case Raw:
 		fmt.Fprint(w, rpt.prof.String())
 		return nil
+	case Regressions:
+		return printRegressions(w, rpt)
 	case Tags:
 		return printTags(w, rpt)
 	case Proto:
 		return rpt.prof.Write(w)
+	case Redact:
+		if o.Redact != nil {
+			profile.Redact(rpt.prof, o.Redact)
+		}
+		return rpt.prof.Write(w)
 	case TopProto:
 		return printTopProto(w, rpt)
 	case Dis:
@@ -113,6 +147,8 @@ func Generate(w io.Writer, rpt *Report, obj plugin.ObjTool) error {
 		return printWebSource(w, rpt, obj)
 	case Callgrind:
 		return printCallgrind(w, rpt)
+	case Validate:
+		return printValidate(w, rpt)
 	}
 	return fmt.Errorf("unexpected output format")
 }
@@ -186,10 +222,17 @@ func (rpt *Report) selectOutputUnit(g *graph.Graph) {
 	o := rpt.options
 
 	// Select best unit for profile output.
-	// Find the appropriate units for the smallest non-zero sample
 	if o.OutputUnit != "minimum" || len(g.Nodes) == 0 {
 		return
 	}
+	o.OutputUnit = minimumUnit(g, rpt.total, o.SampleUnit, o.Ratio, o.OutputFormat == Callgrind)
+}
+
+// minimumUnit returns the unit to display the values of the nodes of
+// g, of the given sample unit, out of total. The values are scaled by
+// ratio, and must be displayed as integers if integral is set.
+func minimumUnit(g *graph.Graph, total int64, sampleUnit string, ratio float64, integral bool) string {
+	// Find the appropriate units for the smallest non-zero sample
 	var minValue int64
 
 	for _, n := range g.Nodes {
@@ -201,33 +244,32 @@ func (rpt *Report) selectOutputUnit(g *graph.Graph) {
 			minValue = nodeMin
 		}
 	}
-	maxValue := rpt.total
+	maxValue := total
 	if minValue == 0 {
 		minValue = maxValue
 	}
 
-	if r := o.Ratio; r > 0 && r != 1 {
+	if r := ratio; r > 0 && r != 1 {
 		minValue = int64(float64(minValue) * r)
 		maxValue = int64(float64(maxValue) * r)
 	}
 
-	_, minUnit := measurement.Scale(minValue, o.SampleUnit, "minimum")
-	_, maxUnit := measurement.Scale(maxValue, o.SampleUnit, "minimum")
+	_, minUnit := measurement.Scale(minValue, sampleUnit, "minimum")
+	_, maxUnit := measurement.Scale(maxValue, sampleUnit, "minimum")
 
 	unit := minUnit
-	if minUnit != maxUnit && minValue*100 < maxValue && o.OutputFormat != Callgrind {
+	if minUnit != maxUnit && minValue*100 < maxValue && !integral {
 		// Minimum and maximum values have different units. Scale
 		// minimum by 100 to use larger units, allowing minimum value to
 		// be scaled down to 0.01, except for callgrind reports since
 		// they can only represent integer values.
-		_, unit = measurement.Scale(100*minValue, o.SampleUnit, "minimum")
+		_, unit = measurement.Scale(100*minValue, sampleUnit, "minimum")
 	}
 
 	if unit != "" {
-		o.OutputUnit = unit
-	} else {
-		o.OutputUnit = o.SampleUnit
+		return unit
 	}
+	return sampleUnit
 }
 
 // newGraph creates a new graph for this report. If nodes is non-nil,
@@ -276,7 +318,7 @@ func (rpt *Report) newGraph(nodes graph.NodeSet) *graph.Graph {
 		SampleValue:       o.SampleValue,
 		SampleMeanDivisor: o.SampleMeanDivisor,
 		FormatTag:         formatTag,
-		CallTree:          o.CallTree && (o.OutputFormat == Dot || o.OutputFormat == Callgrind),
+		CallTree:          o.CallTree && (o.OutputFormat == Dot || o.OutputFormat == Callgrind) || o.OutputFormat == FlameGraph,
 		DropNegative:      o.DropNegative,
 		KeptNodes:         nodes,
 	}
@@ -431,11 +473,17 @@ func PrintAssembly(w io.Writer, rpt *Report, obj plugin.ObjTool, maxFuncs int) e
 			measurement.Percentage(cumSum, rpt.total))
 
 		function, file, line := "", "", 0
+		var stack []plugin.Frame
 		for _, n := range ns {
 			locStr := ""
-			// Skip loc information if it hasn't changed from previous instruction.
-			if n.function != function || n.file != file || n.line != line {
-				function, file, line = n.function, n.file, n.line
+			// Skip loc information if neither the location nor the
+			// inline stack has changed from previous instruction.
+			frames := s.sourceLine(n.address)
+			if n.function != function || n.file != file || n.line != line || !sameFrames(frames, stack) {
+				function, file, line, stack = n.function, n.file, n.line, frames
+				// Print the functions the instruction is inlined into,
+				// outermost first.
+				printInlineCallers(w, frames)
 				if n.function != "" {
 					locStr = n.function + " "
 				}
@@ -476,6 +524,29 @@ func PrintAssembly(w io.Writer, rpt *Report, obj plugin.ObjTool, maxFuncs int) e
 	return nil
 }
 
+// printInlineCallers prints the frames an instruction is inlined into,
+// the outer frames of its inline stack, outermost first and indented by
+// their depth. The innermost frame is left to the instruction line.
+func printInlineCallers(w io.Writer, frames []plugin.Frame) {
+	for i := len(frames) - 1; i > 0; i-- {
+		f := frames[i]
+		fmt.Fprintf(w, "%74s;%s%s %s:%d\n", "", strings.Repeat("  ", len(frames)-1-i), f.Func, filepath.Base(f.File), f.Line)
+	}
+}
+
+// sameFrames reports whether a and b are the same inline stack.
+func sameFrames(a, b []plugin.Frame) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 // symbolsFromBinaries examines the binaries listed on the profile
 // that have associated samples, and identifies symbols matching rx.
 func symbolsFromBinaries(prof *profile.Profile, g *graph.Graph, rx *regexp.Regexp, address *uint64, obj plugin.ObjTool) []*objSymbol {
@@ -535,6 +606,30 @@ type objSymbol struct {
 	sym  *plugin.Sym
 	base uint64
 	file plugin.ObjFile
+	// frames caches the source lines of the instructions of the
+	// symbol, by address, nil for the addresses with none.
+	frames map[uint64][]plugin.Frame
+}
+
+// sourceLine returns the source frames of the instruction at addr, an
+// address of the symbol in its binary, innermost first. Each address is
+// looked up in the binary only once.
+func (o *objSymbol) sourceLine(addr uint64) []plugin.Frame {
+	if o.file == nil {
+		return nil
+	}
+	if frames, ok := o.frames[addr]; ok {
+		return frames
+	}
+	frames, err := o.file.SourceLine(addr + o.base)
+	if err != nil {
+		frames = nil
+	}
+	if o.frames == nil {
+		o.frames = make(map[uint64][]plugin.Frame)
+	}
+	o.frames[addr] = frames
+	return frames
 }
 
 // orderSyms is a wrapper type to sort []*objSymbol by a supplied comparator.
@@ -727,21 +822,95 @@ func printComments(w io.Writer, rpt *Report) error {
 	return nil
 }
 
+// printValidate prints every structural problem found in the profile,
+// including those repaired when it was loaded.
+func printValidate(w io.Writer, rpt *Report) error {
+	repaired, errs := rpt.prof.RepairedProblems(), rpt.prof.Validate()
+	if len(repaired)+len(errs) == 0 {
+		fmt.Fprintln(w, "No problems found")
+		return nil
+	}
+	fmt.Fprintf(w, "%d problems found:\n", len(repaired)+len(errs))
+	for _, err := range repaired {
+		fmt.Fprintf(w, "  %v (repaired when loaded)\n", err)
+	}
+	for _, err := range errs {
+		fmt.Fprintf(w, "  %v\n", err)
+	}
+	return nil
+}
+
 // TextItem holds a single text report entry.
 type TextItem struct {
 	Name                  string
-	InlineLabel           string // Not empty if inlined
+	InlineLabel           string        // Not empty if inlined
+	Flat, Cum             int64         // Raw values
+	FlatFormat, CumFormat string        // Formatted values
+	Columns               []ColumnValue // Values of the Columns option, in order
+}
+
+// Column is a sample value shown by text reports in columns of its own,
+// next to the one selected by SampleValue.
+type Column struct {
+	Name              string // Header of the column
+	SampleValue       func(s []int64) int64
+	SampleMeanDivisor func(s []int64) int64
+	SampleUnit        string
+}
+
+// ColumnValue holds the values of a text report entry for a Column.
+type ColumnValue struct {
 	Flat, Cum             int64  // Raw values
 	FlatFormat, CumFormat string // Formatted values
 }
 
+// textColumn is a Column of a text report, with the total and the
+// output unit of its values.
+type textColumn struct {
+	Column
+	total int64
+	unit  string
+}
+
 // TextItems returns a list of text items from the report and a list
 // of labels that describe the report.
 func TextItems(rpt *Report) ([]TextItem, []string) {
+	items, labels, _ := textItems(rpt)
+	return items, labels
+}
+
+func textItems(rpt *Report) ([]TextItem, []string, []*textColumn) {
 	g, origCount, droppedNodes, _ := rpt.newTrimmedGraph()
 	rpt.selectOutputUnit(g)
 	labels := reportLabels(rpt, g, origCount, droppedNodes, 0, false)
 
+	// Columns of the sample unit of the report share its output unit,
+	// others get the one that suits their values best.
+	var columns []*textColumn
+	var columnNodes []map[graph.NodeInfo]*graph.Node
+	if len(rpt.options.Columns) > 0 {
+		kept := make(graph.NodeSet, len(g.Nodes))
+		for _, n := range g.Nodes {
+			kept[n.Info] = true
+		}
+		for _, c := range rpt.options.Columns {
+			o := *rpt.options
+			o.SampleValue, o.SampleMeanDivisor = c.SampleValue, c.SampleMeanDivisor
+			crpt := &Report{rpt.prof, computeTotal(rpt.prof, c.SampleValue, c.SampleMeanDivisor), &o, nil}
+			cg := crpt.newGraph(kept)
+			col := &textColumn{Column: c, total: crpt.total, unit: rpt.options.OutputUnit}
+			if c.SampleUnit != o.SampleUnit {
+				col.unit = minimumUnit(cg, col.total, c.SampleUnit, o.Ratio, false)
+			}
+			nodes := make(map[graph.NodeInfo]*graph.Node, len(cg.Nodes))
+			for _, n := range cg.Nodes {
+				nodes[n.Info] = n
+			}
+			columns = append(columns, col)
+			columnNodes = append(columnNodes, nodes)
+		}
+	}
+
 	var items []TextItem
 	var flatSum int64
 	for _, n := range g.Nodes {
@@ -765,6 +934,17 @@ func TextItems(rpt *Report) ([]TextItem, []string) {
 			}
 		}
 
+		var values []ColumnValue
+		for i, col := range columns {
+			var v ColumnValue
+			if cn := columnNodes[i][n.Info]; cn != nil {
+				v.Flat, v.Cum = cn.FlatValue(), cn.CumValue()
+			}
+			v.FlatFormat = rpt.formatColumnValue(v.Flat, col)
+			v.CumFormat = rpt.formatColumnValue(v.Cum, col)
+			values = append(values, v)
+		}
+
 		flatSum += flat
 		items = append(items, TextItem{
 			Name:        name,
@@ -773,17 +953,96 @@ func TextItems(rpt *Report) ([]TextItem, []string) {
 			Cum:         cum,
 			FlatFormat:  rpt.formatValue(flat),
 			CumFormat:   rpt.formatValue(cum),
+			Columns:     values,
 		})
 	}
-	return items, labels
+	sortTextItems(items, rpt.options.SortColumn, rpt.options.Columns)
+	return items, labels, columns
+}
+
+// formatColumnValue formats a value of a column of a text report.
+func (rpt *Report) formatColumnValue(v int64, col *textColumn) string {
+	if r := rpt.options.Ratio; r > 0 && r != 1 {
+		v = int64(float64(v) * r)
+	}
+	return measurement.ScaledLabel(v, col.SampleUnit, col.unit)
+}
+
+// sortTextItems sorts text report entries by a column: "name", the
+// flat or cum value of the report, or "<column>" or "<column>.cum" for
+// the flat or cum value of one of the columns. Values are sorted by
+// decreasing magnitude, then by name. Entries are left in the order of
+// the report for any other column.
+func sortTextItems(items []TextItem, column string, columns []Column) {
+	var value func(item *TextItem) int64
+	switch column {
+	case "name":
+		sort.SliceStable(items, func(i, j int) bool {
+			return items[i].Name < items[j].Name
+		})
+		return
+	case "flat":
+		value = func(item *TextItem) int64 { return item.Flat }
+	case "cum":
+		value = func(item *TextItem) int64 { return item.Cum }
+	default:
+		for i, c := range columns {
+			i := i
+			switch column {
+			case c.Name:
+				value = func(item *TextItem) int64 { return item.Columns[i].Flat }
+			case c.Name + ".cum":
+				value = func(item *TextItem) int64 { return item.Columns[i].Cum }
+			}
+		}
+	}
+	if value == nil {
+		return
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if iv, jv := abs64(value(&items[i])), abs64(value(&items[j])); iv != jv {
+			return iv > jv
+		}
+		return items[i].Name < items[j].Name
+	})
 }
 
-// printText prints a flat text report for a profile.
+// sortedByFlat reports whether the entries of the text reports are
+// sorted by flat value, the only order their running sum is meaningful
+// in.
+func (rpt *Report) sortedByFlat() bool {
+	o := rpt.options
+	switch o.SortColumn {
+	case "flat":
+		return true
+	case "cum", "name":
+		return false
+	}
+	for _, c := range o.Columns {
+		if o.SortColumn == c.Name || o.SortColumn == c.Name+".cum" {
+			return false
+		}
+	}
+	return !o.CumSort
+}
+
+// printText prints a flat text report for a profile. The sum% column
+// is left empty unless the entries are sorted by flat value.
 func printText(w io.Writer, rpt *Report) error {
-	items, labels := TextItems(rpt)
+	items, labels, columns := textItems(rpt)
+	byFlat := rpt.sortedByFlat()
 	fmt.Fprintln(w, strings.Join(labels, "\n"))
-	fmt.Fprintf(w, "%10s %5s%% %5s%% %10s %5s%%\n",
+	fmt.Fprintf(w, "%10s %5s%% %5s%% %10s %5s%%",
 		"flat", "flat", "sum", "cum", "cum")
+	widths := make([]int, len(columns))
+	for i, col := range columns {
+		widths[i] = len(col.Name) + len(".cum")
+		if widths[i] < 10 {
+			widths[i] = 10
+		}
+		fmt.Fprintf(w, " %*s %*s", widths[i], col.Name, widths[i], col.Name+".cum")
+	}
+	fmt.Fprintln(w)
 	var flatSum int64
 	for _, item := range items {
 		inl := item.InlineLabel
@@ -791,15 +1050,98 @@ func printText(w io.Writer, rpt *Report) error {
 			inl = " " + inl
 		}
 		flatSum += item.Flat
-		fmt.Fprintf(w, "%10s %s %s %10s %s  %s%s\n",
+		sum := fmt.Sprintf("%6s", "")
+		if byFlat {
+			sum = measurement.Percentage(flatSum, rpt.total)
+		}
+		fmt.Fprintf(w, "%10s %s %s %10s %s",
 			item.FlatFormat, measurement.Percentage(item.Flat, rpt.total),
-			measurement.Percentage(flatSum, rpt.total),
-			item.CumFormat, measurement.Percentage(item.Cum, rpt.total),
-			item.Name, inl)
+			sum,
+			item.CumFormat, measurement.Percentage(item.Cum, rpt.total))
+		for i, v := range item.Columns {
+			fmt.Fprintf(w, " %*s %*s", widths[i], v.FlatFormat, widths[i], v.CumFormat)
+		}
+		fmt.Fprintf(w, "  %s%s\n", item.Name, inl)
 	}
 	return nil
 }
 
+// printDelimited prints the entries of the text report as records with
+// fields separated by comma, such as CSV or TSV. Values are printed as
+// numbers in the output unit, which is part of the field names. As in
+// text reports, the sum% field is left empty unless the entries are
+// sorted by flat value.
+func printDelimited(w io.Writer, rpt *Report, comma rune) error {
+	items, _, columns := textItems(rpt)
+	o := rpt.options
+	byFlat := rpt.sortedByFlat()
+
+	// All the values of a field are in the same unit, which is left to
+	// the sample unit when the output unit is chosen value by value.
+	fieldUnit := func(fromUnit, toUnit string) string {
+		if toUnit == "auto" || toUnit == "minimum" {
+			return fromUnit
+		}
+		return toUnit
+	}
+	withUnit := func(name, fromUnit, toUnit string) string {
+		if _, u := measurement.Scale(0, fromUnit, fieldUnit(fromUnit, toUnit)); u != "" {
+			return name + " (" + u + ")"
+		}
+		return name
+	}
+	scaled := func(v int64, fromUnit, toUnit string) string {
+		if r := o.Ratio; r > 0 && r != 1 {
+			v = int64(float64(v) * r)
+		}
+		f, _ := measurement.Scale(v, fromUnit, fieldUnit(fromUnit, toUnit))
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	percent := func(v, total int64) string {
+		var ratio float64
+		if total != 0 {
+			ratio = math.Abs(float64(v)/float64(total)) * 100
+		}
+		return strconv.FormatFloat(ratio, 'f', 2, 64)
+	}
+
+	out := csv.NewWriter(w)
+	out.Comma = comma
+	header := []string{"name", "inline",
+		withUnit("flat", o.SampleUnit, o.OutputUnit), "flat%", "sum%",
+		withUnit("cum", o.SampleUnit, o.OutputUnit), "cum%"}
+	for _, col := range columns {
+		header = append(header, withUnit(col.Name, col.SampleUnit, col.unit), col.Name+"%",
+			withUnit(col.Name+".cum", col.SampleUnit, col.unit), col.Name+".cum%")
+	}
+	if err := out.Write(header); err != nil {
+		return err
+	}
+	var flatSum int64
+	for _, item := range items {
+		flatSum += item.Flat
+		sum := ""
+		if byFlat {
+			sum = percent(flatSum, rpt.total)
+		}
+		record := []string{item.Name, strings.Trim(item.InlineLabel, "()"),
+			scaled(item.Flat, o.SampleUnit, o.OutputUnit), percent(item.Flat, rpt.total),
+			sum,
+			scaled(item.Cum, o.SampleUnit, o.OutputUnit), percent(item.Cum, rpt.total)}
+		for i, v := range item.Columns {
+			col := columns[i]
+			record = append(record,
+				scaled(v.Flat, col.SampleUnit, col.unit), percent(v.Flat, col.total),
+				scaled(v.Cum, col.SampleUnit, col.unit), percent(v.Cum, col.total))
+		}
+		if err := out.Write(record); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
 // printTraces prints all traces from a profile.
 func printTraces(w io.Writer, rpt *Report) error {
 	fmt.Fprintln(w, strings.Join(ProfileLabels(rpt), "\n"))
@@ -1259,6 +1601,9 @@ type Report struct {
 // Total returns the total number of samples in a report.
 func (rpt *Report) Total() int64 { return rpt.total }
 
+// Columns returns the other sample values shown by text reports.
+func (rpt *Report) Columns() []Column { return rpt.options.Columns }
+
 func abs64(i int64) int64 {
 	if i < 0 {
 		return -i
@@ -16,13 +16,16 @@ package report
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
 	"regexp"
 	"runtime"
+	"strings"
 	"testing"
 
 	"pproflame/internal/binutils"
 	"pproflame/internal/graph"
+	"pproflame/internal/plugin"
 	"pproflame/internal/proftest"
 	"pproflame/profile"
 )
@@ -405,3 +408,180 @@ func TestComputeTotal(t *testing.T) {
 		})
 	}
 }
+
+func TestPrintInlineCallers(t *testing.T) {
+	frames := func(fs ...string) []plugin.Frame {
+		var r []plugin.Frame
+		for _, f := range fs {
+			r = append(r, plugin.Frame{Func: f, File: "/src/" + f + ".go", Line: 1})
+		}
+		return r
+	}
+	var buf bytes.Buffer
+	printInlineCallers(&buf, frames("c", "b", "a"))
+	printInlineCallers(&buf, frames("e", "f", "a"))
+	printInlineCallers(&buf, frames("a"))
+
+	var got []string
+	for _, l := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		got = append(got, strings.TrimLeft(l, " "))
+	}
+	want := []string{
+		";a a.go:1",
+		";  b b.go:1",
+		";a a.go:1",
+		";  f f.go:1",
+	}
+	if !sameFrames(frames("c", "b", "a"), frames("c", "b", "a")) || sameFrames(frames("c", "b", "a"), frames("c", "d", "a")) {
+		t.Errorf("sameFrames: does not compare whole stacks")
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("printInlineCallers: got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// countingObjFile counts the source line lookups of the binary.
+type countingObjFile struct {
+	plugin.ObjFile
+	lookups int
+}
+
+func (f *countingObjFile) SourceLine(addr uint64) ([]plugin.Frame, error) {
+	f.lookups++
+	if addr == 0x1010 {
+		return nil, fmt.Errorf("no line for %#x", addr)
+	}
+	return []plugin.Frame{{Func: "f", File: "f.go", Line: int(addr)}}, nil
+}
+
+func TestObjSymbolSourceLine(t *testing.T) {
+	file := &countingObjFile{}
+	s := &objSymbol{base: 0x1000, file: file}
+	for i := 0; i < 3; i++ {
+		if got := s.sourceLine(0x8); len(got) != 1 || got[0].Line != 0x1008 {
+			t.Errorf("sourceLine(0x8): got %v, want line %d", got, 0x1008)
+		}
+		if got := s.sourceLine(0x10); got != nil {
+			t.Errorf("sourceLine(0x10): got %v, want none", got)
+		}
+	}
+	if file.lookups != 2 {
+		t.Errorf("got %d lookups in the binary, want 2", file.lookups)
+	}
+}
+
+func TestTextColumns(t *testing.T) {
+	for _, tc := range []struct {
+		format     int
+		sortColumn string
+		want       string
+	}{
+		{
+			format:     CSV,
+			sortColumn: "samples.cum",
+			want: `name,inline,flat,flat%,sum%,cum,cum%,samples,samples%,samples.cum,samples.cum%
+main testdata/source1:2,,1,0.01,,11111,100.00,1,20.00,5,100.00
+bar testdata/source1:10,,10,0.09,,110,0.99,1,20.00,2,40.00
+tee /some/path/testdata/source2:2,,1000,9.00,,11000,99.00,1,20.00,2,40.00
+tee /some/path/testdata/source2:8,,10100,90.90,,10100,90.90,2,40.00,2,40.00
+foo testdata/source1:4,,0,0.00,,10,0.09,0,0.00,1,20.00
+`,
+		},
+		{
+			format:     TSV,
+			sortColumn: "name",
+			want: "name\tinline\tflat\tflat%\tsum%\tcum\tcum%\tsamples\tsamples%\tsamples.cum\tsamples.cum%\n" +
+				"bar testdata/source1:10\t\t10\t0.09\t\t110\t0.99\t1\t20.00\t2\t40.00\n" +
+				"foo testdata/source1:4\t\t0\t0.00\t\t10\t0.09\t0\t0.00\t1\t20.00\n" +
+				"main testdata/source1:2\t\t1\t0.01\t\t11111\t100.00\t1\t20.00\t5\t100.00\n" +
+				"tee /some/path/testdata/source2:2\t\t1000\t9.00\t\t11000\t99.00\t1\t20.00\t2\t40.00\n" +
+				"tee /some/path/testdata/source2:8\t\t10100\t90.90\t\t10100\t90.90\t2\t40.00\t2\t40.00\n",
+		},
+		{
+			format: Text,
+			want: `      flat  flat%   sum%        cum   cum%     samples samples.cum
+     10100 90.90% 90.90%      10100 90.90%           2           2  tee /some/path/testdata/source2:8
+      1000  9.00% 99.90%      11000 99.00%           1           2  tee /some/path/testdata/source2:2
+        10  0.09%   100%        110  0.99%           1           2  bar testdata/source1:10
+         1 0.009%   100%      11111   100%           1           5  main testdata/source1:2
+         0     0%   100%         10  0.09%           0           1  foo testdata/source1:4
+`,
+		},
+		{
+			format:     Text,
+			sortColumn: "cum",
+			want: `      flat  flat%   sum%        cum   cum%     samples samples.cum
+         1 0.009%             11111   100%           1           5  main testdata/source1:2
+      1000  9.00%             11000 99.00%           1           2  tee /some/path/testdata/source2:2
+     10100 90.90%             10100 90.90%           2           2  tee /some/path/testdata/source2:8
+        10  0.09%               110  0.99%           1           2  bar testdata/source1:10
+         0     0%                10  0.09%           0           1  foo testdata/source1:4
+`,
+		},
+	} {
+		rpt := New(testProfile.Copy(), &Options{
+			OutputFormat: tc.format,
+			OutputUnit:   "minimum",
+			Columns: []Column{
+				{Name: "samples", SampleValue: func(v []int64) int64 { return v[0] }, SampleUnit: "count"},
+			},
+			SortColumn: tc.sortColumn,
+
+			SampleValue: func(v []int64) int64 { return v[1] },
+			SampleUnit:  testProfile.SampleType[1].Unit,
+		})
+		var b bytes.Buffer
+		if err := Generate(&b, rpt, nil); err != nil {
+			t.Fatalf("%d: %v", tc.format, err)
+		}
+		got := b.String()
+		if tc.format == Text {
+			// Skip the labels of the report.
+			got = got[strings.Index(got, "      flat"):]
+		}
+		if got != tc.want {
+			t.Errorf("%d sorted by %q: got:\n%s\nwant:\n%s", tc.format, tc.sortColumn, got, tc.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	// A profile whose second sample refers to a missing location.
+	f := &profile.Function{ID: 1, Name: "main"}
+	l := &profile.Location{ID: 1, Line: []profile.Line{{Function: f}}}
+	missing := &profile.Location{ID: 5, Line: []profile.Line{{Function: f}}}
+	var buf bytes.Buffer
+	if err := (&profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+		Sample: []*profile.Sample{
+			{Location: []*profile.Location{l}, Value: []int64{1}},
+			{Location: []*profile.Location{missing, l}, Value: []int64{2}},
+		},
+		Location: []*profile.Location{l},
+		Function: []*profile.Function{f},
+	}).WriteUncompressed(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// Load it the way the driver does.
+	p, err := profile.ParseLenient(buf.Bytes())
+	if err != nil {
+		t.Fatalf("ParseLenient: %v", err)
+	}
+	if _, err := profile.Repair(p); err != nil {
+		t.Fatalf("Repair: %v", err)
+	}
+
+	rpt := New(p.Copy(), &Options{
+		OutputFormat: Validate,
+		SampleValue:  func(v []int64) int64 { return v[0] },
+	})
+	var out bytes.Buffer
+	if err := Generate(&out, rpt, nil); err != nil {
+		t.Fatalf("generating validate report: %v", err)
+	}
+	want := "1 problems found:\n  sample #1: dangling location id 5 (repaired when loaded)\n"
+	if got := out.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
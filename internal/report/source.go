@@ -24,12 +24,14 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
 	"pproflame/internal/graph"
 	"pproflame/internal/measurement"
 	"pproflame/internal/plugin"
+	"pproflame/profile"
 )
 
 // printSource prints an annotated source listing, include all
@@ -55,15 +57,10 @@ func printSource(w io.Writer, rpt *Report) error {
 	}
 	functions.Sort(graph.NameOrder)
 
-	sourcePath := o.SourcePath
-	if sourcePath == "" {
-		wd, err := os.Getwd()
-		if err != nil {
-			return fmt.Errorf("Could not stat current dir: %v", err)
-		}
-		sourcePath = wd
+	reader, err := rpt.newSourceReader()
+	if err != nil {
+		return err
 	}
-	reader := newSourceReader(sourcePath, o.TrimPath)
 
 	fmt.Fprintf(w, "Total: %s\n", rpt.formatValue(rpt.total))
 	for _, fn := range functions {
@@ -138,15 +135,10 @@ func PrintWebList(w io.Writer, rpt *Report, obj plugin.ObjTool, maxFiles int) er
 		address = &hex
 	}
 
-	sourcePath := o.SourcePath
-	if sourcePath == "" {
-		wd, err := os.Getwd()
-		if err != nil {
-			return fmt.Errorf("Could not stat current dir: %v", err)
-		}
-		sourcePath = wd
+	reader, err := rpt.newSourceReader()
+	if err != nil {
+		return err
 	}
-	reader := newSourceReader(sourcePath, o.TrimPath)
 
 	type fileFunction struct {
 		fileName, functionName string
@@ -206,6 +198,7 @@ func PrintWebList(w io.Writer, rpt *Report, obj plugin.ObjTool, maxFiles int) er
 		fns := fileNodes[ff]
 
 		asm := assemblyPerSourceLine(symbols, fns, ff.fileName, obj)
+		inlines := inlineTrees(rpt, ff.functionName, ff.fileName)
 		start, end := sourceCoordinates(asm)
 
 		fnodes, path, err := getSourceFromFile(ff.fileName, reader, fns, start, end)
@@ -215,7 +208,7 @@ func PrintWebList(w io.Writer, rpt *Report, obj plugin.ObjTool, maxFiles int) er
 
 		printFunctionHeader(w, ff.functionName, path, n.Flat, n.Cum, rpt)
 		for _, fn := range fnodes {
-			printFunctionSourceLine(w, fn, asm[fn.Info.Lineno], reader, rpt)
+			printFunctionSourceLine(w, fn, asm[fn.Info.Lineno], inlines[fn.Info.Lineno], reader, rpt)
 		}
 		printFunctionClosing(w)
 	}
@@ -268,7 +261,7 @@ func assemblyPerSourceLine(objSyms []*objSymbol, rs graph.Nodes, src string, obj
 		//
 		// So find the outer-most linenumber in the source file.
 		found := false
-		if frames, err := o.file.SourceLine(an.address + o.base); err == nil {
+		if frames := o.sourceLine(an.address); frames != nil {
 			for i := len(frames) - 1; i >= 0; i-- {
 				if filepath.Base(frames[i].File) == srcBase {
 					for j := i - 1; j >= 0; j-- {
@@ -298,6 +291,107 @@ func assemblyPerSourceLine(objSyms []*objSymbol, rs graph.Nodes, src string, obj
 	return assembly
 }
 
+// inlineFrame is a function inlined at a source line, with the samples
+// spent in it and in the functions inlined into it.
+type inlineFrame struct {
+	function, file  string
+	line            int
+	flat, cum       int64
+	flatDiv, cumDiv int64
+	children        []*inlineFrame
+}
+
+func (f *inlineFrame) flatValue() int64 {
+	if f.flatDiv != 0 {
+		return f.flat / f.flatDiv
+	}
+	return f.flat
+}
+
+func (f *inlineFrame) cumValue() int64 {
+	if f.cumDiv != 0 {
+		return f.cum / f.cumDiv
+	}
+	return f.cum
+}
+
+// child returns the frame of the call at line l inlined into f,
+// adding it if needed.
+func (f *inlineFrame) child(l profile.Line) *inlineFrame {
+	var function, file string
+	if l.Function != nil {
+		function, file = l.Function.Name, l.Function.Filename
+	}
+	for _, c := range f.children {
+		if c.function == function && c.file == file && c.line == int(l.Line) {
+			return c
+		}
+	}
+	c := &inlineFrame{function: function, file: file, line: int(l.Line)}
+	f.children = append(f.children, c)
+	return c
+}
+
+// sort orders the calls inlined into f and their own inlined calls by
+// decreasing cum.
+func (f *inlineFrame) sort() {
+	sort.SliceStable(f.children, func(i, j int) bool {
+		return f.children[i].cumValue() > f.children[j].cumValue()
+	})
+	for _, c := range f.children {
+		c.sort()
+	}
+}
+
+// inlineTrees returns, for each line of function in file, the tree of
+// the calls inlined at the line, with the samples of the report split
+// among the inlined frames. Lines without inlined calls are omitted.
+func inlineTrees(rpt *Report, function, file string) map[int]*inlineFrame {
+	o := rpt.options
+	trees := make(map[int]*inlineFrame)
+	for _, s := range rpt.prof.Sample {
+		v := o.SampleValue(s.Value)
+		var div int64
+		if o.SampleMeanDivisor != nil {
+			div = o.SampleMeanDivisor(s.Value)
+		}
+		// Count the cum of each frame once per sample, as for
+		// recursive calls in the graph.
+		seen := make(map[*inlineFrame]bool)
+		for li, loc := range s.Location {
+			// The outer frames of a location come last.
+			for i := len(loc.Line) - 1; i > 0; i-- {
+				fn := loc.Line[i].Function
+				if fn == nil || fn.Name != function || fn.Filename != file {
+					continue
+				}
+				line := int(loc.Line[i].Line)
+				f := trees[line]
+				if f == nil {
+					f = &inlineFrame{function: function, file: file, line: line}
+					trees[line] = f
+				}
+				for j := i - 1; j >= 0; j-- {
+					f = f.child(loc.Line[j])
+					if !seen[f] {
+						seen[f] = true
+						f.cum += v
+						f.cumDiv += div
+					}
+				}
+				if li == 0 {
+					f.flat += v
+					f.flatDiv += div
+				}
+			}
+		}
+	}
+	for _, f := range trees {
+		f.sort()
+	}
+	return trees
+}
+
 // findMatchingSymbol looks for the symbol that corresponds to a set
 // of samples, by comparing their addresses.
 func findMatchingSymbol(objSyms []*objSymbol, ns graph.Nodes) *objSymbol {
@@ -347,9 +441,10 @@ func printFunctionHeader(w io.Writer, name, path string, flatSum, cumSum int64,
 		measurement.Percentage(cumSum, rpt.total))
 }
 
-// printFunctionSourceLine prints a source line and the corresponding assembly.
-func printFunctionSourceLine(w io.Writer, fn *graph.Node, assembly []assemblyInstruction, reader *sourceReader, rpt *Report) {
-	if len(assembly) == 0 {
+// printFunctionSourceLine prints a source line and the corresponding
+// assembly, preceded by the tree of the calls inlined at the line.
+func printFunctionSourceLine(w io.Writer, fn *graph.Node, assembly []assemblyInstruction, inlines *inlineFrame, reader *sourceReader, rpt *Report) {
+	if len(assembly) == 0 && inlines == nil {
 		fmt.Fprintf(w,
 			"<span class=line> %6d</span> <span class=nop>  %10s %10s %8s  %s </span>\n",
 			fn.Info.Lineno,
@@ -365,6 +460,9 @@ func printFunctionSourceLine(w io.Writer, fn *graph.Node, assembly []assemblyIns
 		"", template.HTMLEscapeString(fn.Info.Name))
 	srcIndent := indentation(fn.Info.Name)
 	fmt.Fprint(w, "<span class=asm>")
+	if inlines != nil {
+		printInlineTree(w, inlines, srcIndent+4, reader, rpt)
+	}
 	var curCalls []callID
 	for i, an := range assembly {
 		if an.startsBlock && i != 0 {
@@ -411,6 +509,31 @@ func printFunctionSourceLine(w io.Writer, fn *graph.Node, assembly []assemblyIns
 	fmt.Fprintln(w, "</span>")
 }
 
+// printInlineTree prints the calls inlined into f, each followed by
+// its own inlined calls, which are shown on a click on the call.
+func printInlineTree(w io.Writer, f *inlineFrame, indent int, reader *sourceReader, rpt *Report) {
+	for _, c := range f.children {
+		fline, _ := reader.line(c.file, c.line)
+		if fline = strings.TrimSpace(fline); fline == "" {
+			fline = c.function
+		}
+		text := strings.Repeat(" ", indent) + fline
+		class := "inlinesrc"
+		if len(c.children) > 0 {
+			class = "inlinecall"
+		}
+		fmt.Fprintf(w, " %8s %10s %10s %8s  <span class=%s>%s <span class=unimportant>%s %s:%d</span>\n</span>",
+			"", valueOrDot(c.flatValue(), rpt), valueOrDot(c.cumValue(), rpt), "",
+			class, template.HTMLEscapeString(fmt.Sprintf("%-80s", text)),
+			template.HTMLEscapeString(c.function), template.HTMLEscapeString(filepath.Base(c.file)), c.line)
+		if len(c.children) > 0 {
+			fmt.Fprint(w, "<span class=inlinecalls>")
+			printInlineTree(w, c, indent+4, reader, rpt)
+			fmt.Fprint(w, "</span>")
+		}
+	}
+}
+
 // printFunctionClosing prints the end of a function in a weblist report.
 func printFunctionClosing(w io.Writer) {
 	fmt.Fprintln(w, "</pre>")
@@ -521,6 +644,15 @@ type sourceReader struct {
 	// trimPath is a filepath.ListSeparator-separated list of paths to trim.
 	trimPath string
 
+	// modCache is the Go module cache, searched for the files of
+	// modules not found on searchPath.
+	modCache string
+
+	// gitMirror is a git repository holding the source at revision,
+	// read for the files found nowhere else.
+	gitMirror, revision string
+	gitFiles            map[string]bool // files at revision, once listed
+
 	// files maps from path name to a list of lines.
 	// files[*][0] is unused since line numbering starts at 1.
 	files map[string][]string
@@ -532,11 +664,31 @@ type sourceReader struct {
 
 func newSourceReader(searchPath, trimPath string) *sourceReader {
 	return &sourceReader{
-		searchPath,
-		trimPath,
-		make(map[string][]string),
-		make(map[string]error),
+		searchPath: searchPath,
+		trimPath:   trimPath,
+		files:      make(map[string][]string),
+		errors:     make(map[string]error),
+	}
+}
+
+// newSourceReader returns a reader for the source files of the report,
+// by default searched in the current directory.
+func (rpt *Report) newSourceReader() (*sourceReader, error) {
+	o := rpt.options
+	sourcePath := o.SourcePath
+	if sourcePath == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return nil, fmt.Errorf("Could not stat current dir: %v", err)
+		}
+		sourcePath = wd
+	}
+	reader := newSourceReader(sourcePath, o.TrimPath)
+	reader.modCache = goModCache()
+	if o.GitMirror != "" {
+		reader.gitMirror, reader.revision = o.GitMirror, profileRevision(rpt.prof)
 	}
+	return reader, nil
 }
 
 func (reader *sourceReader) fileError(path string) error {
@@ -548,7 +700,7 @@ func (reader *sourceReader) line(path string, lineno int) (string, bool) {
 	if !ok {
 		// Read and cache file contents.
 		lines = []string{""} // Skip 0th line
-		f, err := openSourceFile(path, reader.searchPath, reader.trimPath)
+		f, err := reader.open(path)
 		if err != nil {
 			reader.errors[path] = err
 		} else {
@@ -38,9 +38,18 @@ h1 {
 .line, .nop, .unimportant {
   color: #aaaaaa;
 }
-.inlinesrc {
+.inlinesrc, .inlinecall {
   color: #000066;
 }
+.inlinecall {
+  cursor: pointer;
+}
+.inlinecall:hover {
+  background-color: #eeeeee;
+}
+.inlinecalls {
+  display: none;
+}
 .deadsrc {
 cursor: pointer;
 }
@@ -67,10 +76,15 @@ function pprof_toggle_asm(e) {
   if (e.target) target = e.target;
   else if (e.srcElement) target = e.srcElement;
 
+  if (target && target.className == "unimportant") target = target.parentNode;
+
   if (target) {
+    // Source lines expand to their assembly and inlined calls to
+    // their own inlined calls.
     var asm = target.nextSibling;
-    if (asm && asm.className == "asm") {
-      asm.style.display = (asm.style.display == "block" ? "" : "block");
+    if (asm && (asm.className == "asm" || asm.className == "inlinecalls")) {
+      var shown = (asm.className == "asm" ? "block" : "inline");
+      asm.style.display = (asm.style.display == shown ? "" : shown);
       e.preventDefault();
       return false;
     }
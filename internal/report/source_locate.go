@@ -0,0 +1,171 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+// This file locates the source files missing from the search path in
+// the Go module cache and in git mirrors.
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"pproflame/profile"
+)
+
+// open opens a source file, searching the search path, then the module
+// cache and then the git mirror. The error of the search path is
+// returned if the file is found nowhere.
+func (reader *sourceReader) open(path string) (io.ReadCloser, error) {
+	f, err := openSourceFile(path, reader.searchPath, reader.trimPath)
+	if err == nil {
+		return f, nil
+	}
+	if name, ok := moduleSourcePath(path, reader.modCache); ok {
+		if f, merr := os.Open(name); merr == nil {
+			return f, nil
+		}
+	}
+	if reader.gitMirror != "" && reader.revision != "" {
+		if data, gerr := reader.gitFile(path); gerr == nil {
+			return ioutil.NopCloser(bytes.NewReader(data)), nil
+		}
+	}
+	return nil, err
+}
+
+// goModCache returns the directory of the Go module cache, as the go
+// command does.
+func goModCache() string {
+	if dir := os.Getenv("GOMODCACHE"); dir != "" {
+		return dir
+	}
+	if gopath := filepath.SplitList(os.Getenv("GOPATH")); len(gopath) > 0 && gopath[0] != "" {
+		return filepath.Join(gopath[0], "pkg", "mod")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, "go", "pkg", "mod")
+}
+
+// moduleSourcePath returns the path in the module cache modCache of a
+// file of a Go module. Go records these files as
+// <module>@<version>/<file>, under the module cache of the build or on
+// their own in binaries built with -trimpath.
+func moduleSourcePath(path, modCache string) (string, bool) {
+	if modCache == "" {
+		return "", false
+	}
+	p := filepath.ToSlash(path)
+	if i := strings.LastIndex(p, "/pkg/mod/"); i >= 0 {
+		p = p[i+len("/pkg/mod/"):]
+	} else if strings.HasPrefix(p, "/") {
+		return "", false
+	}
+	at := strings.Index(p, "@")
+	if at <= 0 {
+		return "", false
+	}
+	slash := strings.Index(p[at:], "/")
+	if slash < 0 {
+		return "", false
+	}
+	mod, version, file := p[:at], p[at+1:at+slash], p[at+slash+1:]
+	if !strings.HasPrefix(version, "v") || file == "" || strings.HasPrefix(mod, "cache/") {
+		return "", false
+	}
+	dir := escapeModulePath(mod) + "@" + escapeModulePath(version)
+	return filepath.Join(modCache, filepath.FromSlash(dir), filepath.FromSlash(file)), true
+}
+
+// escapeModulePath escapes the upper case letters of a module path or
+// version as the module cache does, as '!' followed by the lower case
+// letter. Paths read from the module cache are already escaped.
+func escapeModulePath(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if c >= 'A' && c <= 'Z' {
+			b.WriteByte('!')
+			c += 'a' - 'A'
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+var revisionRx = regexp.MustCompile(`^\s*(?:vcs\.)?revision\s*[:=]\s*([0-9a-fA-F]{7,40})\s*$`)
+
+// profileRevision returns the source revision recorded in the comments
+// of a profile, as "revision: <commit>", or "" if there is none.
+func profileRevision(p *profile.Profile) string {
+	if p == nil {
+		return ""
+	}
+	for _, c := range p.Comments {
+		if m := revisionRx.FindStringSubmatch(c); m != nil {
+			return strings.ToLower(m[1])
+		}
+	}
+	return ""
+}
+
+// gitFile reads a source file from the git mirror at the revision of
+// the profile. Profiles record the files with the path of the checkout
+// they were built in, or with the module path, so the file is the one
+// of the repository with the longest matching path suffix.
+func (reader *sourceReader) gitFile(path string) ([]byte, error) {
+	if reader.gitFiles == nil {
+		reader.gitFiles = make(map[string]bool)
+		out, err := reader.git("ls-tree", "-r", "--name-only", reader.revision)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range strings.Split(string(out), "\n") {
+			if f != "" {
+				reader.gitFiles[f] = true
+			}
+		}
+	}
+	p := strings.TrimPrefix(filepath.ToSlash(path), "/")
+	for {
+		if reader.gitFiles[p] {
+			return reader.git("show", reader.revision+":"+p)
+		}
+		i := strings.Index(p, "/")
+		if i < 0 {
+			return nil, fmt.Errorf("%s not found in %s at %s", path, reader.gitMirror, reader.revision)
+		}
+		p = p[i+1:]
+	}
+}
+
+func (reader *sourceReader) git(args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", reader.gitMirror}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"pproflame/profile"
+)
+
+func TestModuleSourcePath(t *testing.T) {
+	const modCache = "/cache"
+	for _, tc := range []struct {
+		path, want string
+	}{
+		{"/home/ci/go/pkg/mod/github.com/gin-gonic/gin@v1.6.3/context.go", "/cache/github.com/gin-gonic/gin@v1.6.3/context.go"},
+		{"github.com/BurntSushi/toml@v0.3.1/decode.go", "/cache/github.com/!burnt!sushi/toml@v0.3.1/decode.go"},
+		{"/root/go/pkg/mod/github.com/!burnt!sushi/toml@v0.3.1/decode.go", "/cache/github.com/!burnt!sushi/toml@v0.3.1/decode.go"},
+		{"/home/ci/src/service/main.go", ""},
+		{"/home/user@host/src/main.go", ""},
+		{"github.com/gin-gonic/gin@v1.6.3", ""},
+	} {
+		got, ok := moduleSourcePath(tc.path, modCache)
+		if want := filepath.FromSlash(tc.want); ok != (tc.want != "") || got != want {
+			t.Errorf("moduleSourcePath(%q): got %q, %v; want %q", tc.path, got, ok, want)
+		}
+	}
+}
+
+func TestSourceReaderLocate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sourcelocate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string) {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("mod/example.com/!lib@v1.2.0/lib.go", "package lib\n")
+
+	reader := newSourceReader(filepath.Join(dir, "src"), "")
+	reader.modCache = filepath.Join(dir, "mod")
+	if line, ok := reader.line("/build/go/pkg/mod/example.com/!lib@v1.2.0/lib.go", 1); !ok || line != "package lib" {
+		t.Errorf("module file: got %q, %v; want %q", line, ok, "package lib")
+	}
+	if _, ok := reader.line("/build/go/pkg/mod/example.com/!lib@v1.3.0/lib.go", 1); ok {
+		t.Errorf("module file of another version: want no line")
+	}
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	mirror := filepath.Join(dir, "mirror")
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-C", mirror, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	write("mirror/cmd/server/main.go", "package main // old\n")
+	git("init", "-q")
+	git("add", "-A")
+	git("commit", "-q", "-m", "old")
+	rev := git("rev-parse", "HEAD")
+	write("mirror/cmd/server/main.go", "package main // new\n")
+	git("commit", "-q", "-a", "-m", "new")
+
+	p := &profile.Profile{Comments: []string{"collected by ci", "revision: " + rev}}
+	if got := profileRevision(p); got != rev {
+		t.Fatalf("profileRevision: got %q, want %q", got, rev)
+	}
+	rpt := &Report{prof: p, options: &Options{SourcePath: filepath.Join(dir, "src"), GitMirror: mirror}}
+	reader, err = rpt.newSourceReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/builds/ci/service/cmd/server/main.go", "example.com/service/cmd/server/main.go"} {
+		if line, ok := reader.line(path, 1); !ok || line != "package main // old" {
+			t.Errorf("%s: got %q, %v; want the file at the profile revision", path, line, ok)
+		}
+	}
+	if _, ok := reader.line("/builds/ci/service/cmd/client/main.go", 1); ok {
+		t.Errorf("file missing from the mirror: want no line")
+	}
+}
@@ -183,3 +183,66 @@ func readProfile(fname string, t *testing.T) *profile.Profile {
 
 	return p
 }
+
+func TestInlineTrees(t *testing.T) {
+	main := &profile.Function{ID: 1, Name: "main", Filename: "main.go"}
+	parse := &profile.Function{ID: 2, Name: "parse", Filename: "parse.go"}
+	next := &profile.Function{ID: 3, Name: "next", Filename: "parse.go"}
+	// parse and next are inlined into main at line 10, next into
+	// parse at line 20.
+	inner := &profile.Location{ID: 1, Line: []profile.Line{
+		{Function: next, Line: 30}, {Function: parse, Line: 20}, {Function: main, Line: 10},
+	}}
+	outer := &profile.Location{ID: 2, Line: []profile.Line{
+		{Function: parse, Line: 21}, {Function: main, Line: 10},
+	}}
+	prof := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+		Sample: []*profile.Sample{
+			{Location: []*profile.Location{inner}, Value: []int64{5}},
+			{Location: []*profile.Location{outer}, Value: []int64{3}},
+			{Location: []*profile.Location{inner, outer}, Value: []int64{1}},
+		},
+		Location: []*profile.Location{inner, outer},
+		Function: []*profile.Function{main, parse, next},
+	}
+	rpt := New(prof, &Options{
+		OutputFormat: WebList,
+		SampleValue:  func(v []int64) int64 { return v[0] },
+	})
+
+	trees := inlineTrees(rpt, "main", "main.go")
+	if len(trees) != 1 || trees[10] == nil {
+		t.Fatalf("inlineTrees: got lines %v, want line 10", trees)
+	}
+	root := trees[10]
+	if len(root.children) != 2 {
+		t.Fatalf("line 10: got %d inlined calls, want 2", len(root.children))
+	}
+	// The call at line 20 has the most samples, and comes first.
+	p20, p21 := root.children[0], root.children[1]
+	if p20.line != 20 || p20.flat != 0 || p20.cum != 6 {
+		t.Errorf("parse.go:20: got line %d flat %d cum %d, want line 20 flat 0 cum 6", p20.line, p20.flat, p20.cum)
+	}
+	if p21.line != 21 || p21.flat != 3 || p21.cum != 4 {
+		t.Errorf("parse.go:21: got line %d flat %d cum %d, want line 21 flat 3 cum 4", p21.line, p21.flat, p21.cum)
+	}
+	if len(p20.children) != 1 {
+		t.Fatalf("parse.go:20: got %d inlined calls, want 1", len(p20.children))
+	}
+	if n := p20.children[0]; n.function != "next" || n.flat != 6 || n.cum != 6 {
+		t.Errorf("parse.go:30: got %s flat %d cum %d, want next flat 6 cum 6", n.function, n.flat, n.cum)
+	}
+
+	var buf bytes.Buffer
+	printInlineTree(&buf, root, 4, newSourceReader("", ""), rpt)
+	out := buf.String()
+	for _, want := range []string{
+		"<span class=inlinecall>", "<span class=inlinecalls>", "<span class=inlinesrc>",
+		"next parse.go:30", "parse parse.go:21",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("inline tree does not contain %q:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package symbolizer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"pproflame/internal/plugin"
+	"pproflame/profile"
+)
+
+// Cache is an on-disk cache of symbolization results, keyed by the
+// build ID of a binary and an address within it. Addresses are file
+// offsets, so that results remain valid wherever the binary is loaded.
+// Each build ID is kept in its own file, read on first use and written
+// back by Flush.
+type Cache struct {
+	// Dir is the directory holding the cache files.
+	Dir string
+
+	mu      sync.Mutex
+	entries map[string]map[uint64]cacheEntry
+	dirty   map[string]bool
+	hits    int
+	misses  int
+}
+
+// cacheEntry is the symbolization of an address. Partial entries only
+// hold function names, as obtained from symbolz or fast local
+// symbolization, and do not satisfy full local symbolization.
+type cacheEntry struct {
+	Frames  []plugin.Frame `json:"frames"`
+	Partial bool           `json:"partial,omitempty"`
+}
+
+// CacheFromEnv returns the cache selected by the environment, in
+// $PPROF_SYMBOL_CACHE, by default $HOME/pprof/symbolcache. It returns
+// nil, disabling the cache, if $PPROF_SYMBOL_CACHE is "none".
+func CacheFromEnv() *Cache {
+	dir := os.Getenv("PPROF_SYMBOL_CACHE")
+	switch dir {
+	case "none", "off":
+		return nil
+	case "":
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		dir = filepath.Join(home, "pprof", "symbolcache")
+	}
+	return &Cache{Dir: dir}
+}
+
+var cacheBuildIDRx = regexp.MustCompile(`^[0-9a-fA-F]+$`)
+
+// cacheKey returns the build ID and file offset identifying address
+// addr of mapping m, or false if the address cannot be cached.
+func cacheKey(m *profile.Mapping, buildID string, addr uint64) (string, uint64, bool) {
+	if !cacheBuildIDRx.MatchString(buildID) || addr < m.Start {
+		return "", 0, false
+	}
+	return strings.ToLower(buildID), addr - m.Start + m.Offset, true
+}
+
+// Lookup returns the cached frames of address addr of mapping m, for
+// the binary with the given build ID. Partial entries are only returned
+// if partial is set.
+func (c *Cache) Lookup(m *profile.Mapping, buildID string, addr uint64, partial bool) ([]plugin.Frame, bool) {
+	id, off, ok := cacheKey(m, buildID, addr)
+	if c == nil || !ok {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.load(id)[off]
+	if !ok || (e.Partial && !partial) {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	return e.Frames, true
+}
+
+// Add records the frames of address addr of mapping m. A partial entry
+// does not replace a full one.
+func (c *Cache) Add(m *profile.Mapping, buildID string, addr uint64, frames []plugin.Frame, partial bool) {
+	id, off, ok := cacheKey(m, buildID, addr)
+	if c == nil || !ok || len(frames) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries := c.load(id)
+	if e, ok := entries[off]; ok && partial && !e.Partial {
+		return
+	}
+	entries[off] = cacheEntry{Frames: frames, Partial: partial}
+	c.dirty[id] = true
+}
+
+// load returns the entries of a build ID, reading them from disk on
+// first use. A missing or unreadable file yields no entries.
+func (c *Cache) load(buildID string) map[uint64]cacheEntry {
+	if c.entries == nil {
+		c.entries = make(map[string]map[uint64]cacheEntry)
+		c.dirty = make(map[string]bool)
+	}
+	if entries := c.entries[buildID]; entries != nil {
+		return entries
+	}
+	entries := c.read(buildID)
+	c.entries[buildID] = entries
+	return entries
+}
+
+func (c *Cache) path(buildID string) string {
+	return filepath.Join(c.Dir, buildID+".json")
+}
+
+// read returns the entries stored in the file of a build ID.
+func (c *Cache) read(buildID string) map[uint64]cacheEntry {
+	entries := make(map[uint64]cacheEntry)
+	data, err := ioutil.ReadFile(c.path(buildID))
+	if err != nil {
+		return entries
+	}
+	var stored map[string]cacheEntry
+	if json.Unmarshal(data, &stored) != nil {
+		return entries
+	}
+	for a, e := range stored {
+		if off, err := strconv.ParseUint(a, 16, 64); err == nil {
+			entries[off] = e
+		}
+	}
+	return entries
+}
+
+// Flush writes the entries added since the last flush to disk, merged
+// with those written meanwhile by other processes.
+func (c *Cache) Flush() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var errs []string
+	for id := range c.dirty {
+		if err := c.write(id); err != nil {
+			errs = append(errs, err.Error())
+		}
+		delete(c.dirty, id)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("writing symbolization cache: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (c *Cache) write(buildID string) error {
+	entries := c.entries[buildID]
+	for off, e := range c.read(buildID) {
+		if cur, ok := entries[off]; !ok || (cur.Partial && !e.Partial) {
+			entries[off] = e
+		}
+	}
+	stored := make(map[string]cacheEntry, len(entries))
+	for off, e := range entries {
+		stored[strconv.FormatUint(off, 16)] = e
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(c.Dir, buildID)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path(buildID))
+}
+
+// Stats returns the number of cache hits and misses since the last
+// call to Stats.
+func (c *Cache) Stats() (hits, misses int) {
+	if c == nil {
+		return 0, 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	hits, misses = c.hits, c.misses
+	c.hits, c.misses = 0, 0
+	return hits, misses
+}
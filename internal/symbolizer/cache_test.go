@@ -0,0 +1,138 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package symbolizer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"pproflame/internal/plugin"
+	"pproflame/internal/proftest"
+	"pproflame/profile"
+)
+
+func TestCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "symbolcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The same binary loaded at two addresses.
+	m1 := &profile.Mapping{Start: 0x400000, Limit: 0x500000, Offset: 0x1000}
+	m2 := &profile.Mapping{Start: 0x7f0000, Limit: 0x8f0000, Offset: 0x1000}
+	full := []plugin.Frame{{Func: "inlined", File: "a.cc", Line: 3}, {Func: "caller", File: "a.cc", Line: 10}}
+	names := []plugin.Frame{{Func: "caller"}}
+
+	c := &Cache{Dir: dir}
+	c.Add(m1, "ABC123", 0x400010, full, false)
+	c.Add(m1, "abc123", 0x400010, names, true) // Does not replace the full entry.
+	c.Add(m1, "abc123", 0x400020, names, true)
+	c.Add(m1, "", 0x400030, full, false) // Not cached without a build ID.
+	if err := c.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	c = &Cache{Dir: dir}
+	for _, tc := range []struct {
+		addr    uint64
+		partial bool
+		want    []plugin.Frame
+	}{
+		{0x7f0010, false, full},
+		{0x7f0020, true, names},
+		{0x7f0020, false, nil},
+		{0x7f0030, false, nil},
+	} {
+		got, ok := c.Lookup(m2, "abc123", tc.addr, tc.partial)
+		if ok != (tc.want != nil) || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Lookup(%#x, partial=%v): got %v, %v; want %v", tc.addr, tc.partial, got, ok, tc.want)
+		}
+	}
+	if hits, misses := c.Stats(); hits != 2 || misses != 2 {
+		t.Errorf("Stats: got %d hits, %d misses; want 2, 2", hits, misses)
+	}
+}
+
+func TestLocalSymbolizationCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "symbolcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	prof := testProfile.Copy()
+	prof.Mapping[0].Start, prof.Mapping[0].BuildID = 0, "abcd"
+	c := &Cache{Dir: dir}
+	if err := localSymbolize(prof, false, false, mockObjTool{}, &proftest.TestUI{T: t, AllowRx: "^Symbolized "}, c); err != nil {
+		t.Fatalf("localSymbolize(): %v", err)
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	// The binary is gone, but its symbols are cached.
+	prof = testProfile.Copy()
+	prof.Mapping[0].Start, prof.Mapping[0].BuildID = 0, "abcd"
+	c = &Cache{Dir: dir}
+	ui := &proftest.TestUI{T: t, AllowRx: "Local symbolization failed|Some binary filenames not available|^Symbolized "}
+	if err := localSymbolize(prof, false, false, missingObjTool{}, ui, c); err != nil {
+		t.Fatalf("localSymbolize(): %v", err)
+	}
+	for _, loc := range prof.Location {
+		if err := checkSymbolizedLocation(loc.Address, loc.Line); err != nil {
+			t.Errorf("location %d: %v", loc.Address, err)
+		}
+	}
+	if hits, misses := c.Stats(); hits != len(prof.Location) || misses != 0 {
+		t.Errorf("Stats: got %d hits, %d misses; want %d, 0", hits, misses, len(prof.Location))
+	}
+}
+
+type missingObjTool struct {
+	mockObjTool
+}
+
+func (missingObjTool) Open(file string, start, limit, offset uint64) (plugin.ObjFile, error) {
+	return nil, fmt.Errorf("%s: no such file", file)
+}
+
+func TestFillFromCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "symbolcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	prof := testProfile.Copy()
+	m := prof.Mapping[0]
+	m.Start, m.BuildID = 0, "abcd"
+	c := &Cache{Dir: dir}
+	c.Add(m, m.BuildID, 1000, mockAddresses[1000], true)
+
+	missing := fillFromCache(prof, false, c)
+	if len(missing) != len(prof.Location)-1 {
+		t.Errorf("got %d locations left to symbolz, want %d", len(missing), len(prof.Location)-1)
+	}
+	if err := checkSymbolizedLocation(1000, prof.Location[0].Line); err != nil {
+		t.Errorf("cached location: %v", err)
+	}
+	if m.HasFunctions {
+		t.Errorf("partially symbolized mapping marked with HasFunctions")
+	}
+}
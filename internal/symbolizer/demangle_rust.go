@@ -0,0 +1,706 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package symbolizer
+
+// Implements demangling of Rust symbols, in the legacy scheme, which
+// reuses the Itanium C++ nested names with a trailing hash, and in the
+// v0 scheme described in RFC 2603.
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// demangleRust demangles a Rust symbol, simplified according to
+// demanglerMode as C++ names are: the hashes disambiguating crates and
+// symbols are only kept in "full" mode, and generic arguments are only
+// kept in "full" and "templates" modes. It returns false if name is not
+// a Rust symbol.
+func demangleRust(name, demanglerMode string) (string, bool) {
+	// Drop the suffixes added by LLVM and the leading underscore of
+	// Mach-O symbols.
+	if i := strings.Index(name, ".llvm."); i > 0 {
+		name = name[:i]
+	}
+	if strings.HasPrefix(name, "__R") || strings.HasPrefix(name, "__ZN") {
+		name = name[1:]
+	}
+	full := demanglerMode == "full"
+	switch {
+	case strings.HasPrefix(name, "_R"):
+		p := &rustV0{sym: name[2:], hashes: full, generics: full || demanglerMode == "templates"}
+		return p.demangle()
+	case strings.HasPrefix(name, "_ZN"):
+		return demangleRustLegacy(name[3:], full)
+	}
+	return "", false
+}
+
+var rustHashRx = regexp.MustCompile(`^h[0-9a-f]{16}$`)
+
+// rustEscapes are the escapes of legacy symbols.
+var rustEscapes = strings.NewReplacer(
+	"$SP$", "@", "$BP$", "*", "$RF$", "&", "$LT$", "<", "$GT$", ">",
+	"$LP$", "(", "$RP$", ")", "$C$", ",",
+)
+
+var rustCharEscapeRx = regexp.MustCompile(`\$u([0-9a-f]+)\$`)
+
+// demangleRustLegacy demangles the nested name of a legacy symbol,
+// following the "_ZN" prefix. Legacy symbols end with a hash, which is
+// how they are told apart from C++ symbols.
+func demangleRustLegacy(s string, hash bool) (string, bool) {
+	var elems []string
+	for len(s) > 0 && s[0] != 'E' {
+		i := 0
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		n, err := strconv.Atoi(s[:i])
+		if err != nil || n == 0 || i+n > len(s) {
+			return "", false
+		}
+		elems = append(elems, s[i:i+n])
+		s = s[i+n:]
+	}
+	if s != "E" || len(elems) < 2 || !rustHashRx.MatchString(elems[len(elems)-1]) {
+		return "", false
+	}
+	if !hash {
+		elems = elems[:len(elems)-1]
+	}
+	for i, e := range elems {
+		if strings.HasPrefix(e, "_$") {
+			e = e[1:]
+		}
+		e = rustEscapes.Replace(e)
+		e = rustCharEscapeRx.ReplaceAllStringFunc(e, func(esc string) string {
+			c, err := strconv.ParseUint(esc[2:len(esc)-1], 16, 32)
+			if err != nil || !utf8.ValidRune(rune(c)) {
+				return esc
+			}
+			return string(rune(c))
+		})
+		elems[i] = strings.Replace(e, "..", "::", -1)
+	}
+	return strings.Join(elems, "::"), true
+}
+
+// rustV0 demangles a v0 symbol. Parsing and printing are done at once;
+// parts of the symbol are skipped by parsing them with out set to nil.
+type rustV0 struct {
+	sym string // symbol, after the "_R" prefix
+	pos int
+	out *strings.Builder
+	err bool
+
+	hashes   bool // print crate disambiguators
+	generics bool // print generic arguments
+
+	depth    int // nesting of backrefs, bounded against malicious input
+	lifetime int // bound lifetimes in scope
+}
+
+func (p *rustV0) demangle() (string, bool) {
+	var out strings.Builder
+	p.out = &out
+	// Skip the encoding version.
+	if p.peek() >= '0' && p.peek() <= '9' {
+		return "", false
+	}
+	p.path(true)
+	if p.err {
+		return "", false
+	}
+	// Skip the instantiating crate.
+	if p.pos < len(p.sym) && p.peek() >= 'A' && p.peek() <= 'Z' {
+		p.out = nil
+		p.path(false)
+	}
+	// Vendor specific suffixes start with '.' or '$'.
+	if p.err || (p.pos < len(p.sym) && p.peek() != '.' && p.peek() != '$') {
+		return "", false
+	}
+	return out.String(), true
+}
+
+func (p *rustV0) peek() byte {
+	if p.pos >= len(p.sym) {
+		return 0
+	}
+	return p.sym[p.pos]
+}
+
+func (p *rustV0) next() byte {
+	c := p.peek()
+	if c == 0 {
+		p.err = true
+		return 0
+	}
+	p.pos++
+	return c
+}
+
+func (p *rustV0) eat(c byte) bool {
+	if p.peek() == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *rustV0) print(s string) {
+	if p.out != nil && !p.err {
+		p.out.WriteString(s)
+	}
+}
+
+// skipping runs f with printing disabled.
+func (p *rustV0) skipping(f func()) {
+	out := p.out
+	p.out = nil
+	f()
+	p.out = out
+}
+
+// base62 parses a <base-62-number>: "_" is 0, and digits followed by
+// "_" are their value plus one.
+func (p *rustV0) base62() uint64 {
+	if p.eat('_') {
+		return 0
+	}
+	var x uint64
+	for !p.eat('_') {
+		c := p.next()
+		var d uint64
+		switch {
+		case c >= '0' && c <= '9':
+			d = uint64(c - '0')
+		case c >= 'a' && c <= 'z':
+			d = uint64(c-'a') + 10
+		case c >= 'A' && c <= 'Z':
+			d = uint64(c-'A') + 36
+		default:
+			p.err = true
+			return 0
+		}
+		if x > (math.MaxUint64-d)/62 {
+			p.err = true
+			return 0
+		}
+		x = x*62 + d
+	}
+	return x + 1
+}
+
+// optBase62 parses an optional base-62 number following tag, returning
+// 0 if it is missing and the number plus one otherwise.
+func (p *rustV0) optBase62(tag byte) uint64 {
+	if !p.eat(tag) {
+		return 0
+	}
+	return p.base62() + 1
+}
+
+// decimal parses a decimal number. A leading zero is a number on its
+// own, as in the "0" length of the empty identifier of a closure.
+func (p *rustV0) decimal() int {
+	start := p.pos
+	if p.eat('0') {
+		return 0
+	}
+	for c := p.peek(); c >= '0' && c <= '9'; c = p.peek() {
+		p.pos++
+	}
+	n, err := strconv.Atoi(p.sym[start:p.pos])
+	if err != nil {
+		p.err = true
+	}
+	return n
+}
+
+// ident parses an <undisambiguated-identifier>.
+func (p *rustV0) ident() string {
+	puny := p.eat('u')
+	n := p.decimal()
+	p.eat('_')
+	if p.err || p.pos+n > len(p.sym) {
+		p.err = true
+		return ""
+	}
+	s := p.sym[p.pos : p.pos+n]
+	p.pos += n
+	if !puny {
+		return s
+	}
+	d, ok := decodePunycode(s)
+	if !ok {
+		p.err = true
+	}
+	return d
+}
+
+// backref runs f at the position of a backreference, and returns to
+// the current position.
+func (p *rustV0) backref(f func()) {
+	start := p.pos - 1
+	i := p.base62()
+	if p.err || i >= uint64(start) || p.depth > 100 {
+		p.err = true
+		return
+	}
+	pos := p.pos
+	p.pos = int(i)
+	p.depth++
+	f()
+	p.depth--
+	p.pos = pos
+}
+
+// path parses and prints a <path>. inValue is set for paths in value
+// namespace, where generic arguments are preceded by "::".
+func (p *rustV0) path(inValue bool) {
+	if p.err {
+		return
+	}
+	switch tag := p.next(); tag {
+	case 'C':
+		dis := p.optBase62('s')
+		p.print(p.ident())
+		if p.hashes && dis != 0 {
+			p.print(fmt.Sprintf("[%x]", dis))
+		}
+	case 'N':
+		ns := p.next()
+		p.path(inValue)
+		dis := p.optBase62('s')
+		name := p.ident()
+		switch {
+		case ns >= 'A' && ns <= 'Z':
+			p.print("::{")
+			switch ns {
+			case 'C':
+				p.print("closure")
+			case 'S':
+				p.print("shim")
+			default:
+				p.print(string(ns))
+			}
+			if name != "" {
+				p.print(":" + name)
+			}
+			p.print(fmt.Sprintf("#%d}", dis))
+		case ns >= 'a' && ns <= 'z':
+			if name != "" {
+				p.print("::" + name)
+			}
+		default:
+			p.err = true
+		}
+	case 'M', 'X', 'Y':
+		if tag != 'Y' {
+			// Skip the path of the impl.
+			p.skipping(func() {
+				p.optBase62('s')
+				p.path(false)
+			})
+		}
+		p.print("<")
+		p.typ()
+		if tag != 'M' {
+			p.print(" as ")
+			p.path(false)
+		}
+		p.print(">")
+	case 'I':
+		p.path(inValue)
+		if !p.generics {
+			p.skipping(p.genericArgs)
+			return
+		}
+		if inValue {
+			p.print("::")
+		}
+		p.print("<")
+		p.genericArgs()
+		p.print(">")
+	case 'B':
+		p.backref(func() { p.path(inValue) })
+	default:
+		p.err = true
+	}
+}
+
+// genericArgs parses and prints generic arguments, up to the closing
+// "E".
+func (p *rustV0) genericArgs() {
+	for i := 0; !p.err && !p.eat('E'); i++ {
+		if i > 0 {
+			p.print(", ")
+		}
+		switch {
+		case p.eat('L'):
+			p.lifetimeRef(p.base62())
+		case p.eat('K'):
+			p.constant()
+		default:
+			p.typ()
+		}
+	}
+}
+
+// lifetimeRef prints a reference to the i-th innermost bound lifetime.
+func (p *rustV0) lifetimeRef(i uint64) {
+	if i == 0 {
+		p.print("'_")
+		return
+	}
+	if i > uint64(p.lifetime) {
+		p.err = true
+		return
+	}
+	depth := p.lifetime - int(i)
+	if depth < 26 {
+		p.print("'" + string(rune('a'+depth)))
+	} else {
+		p.print(fmt.Sprintf("'_%d", depth))
+	}
+}
+
+// binder parses an optional binder of lifetimes and prints it as a
+// for<...> prefix.
+func (p *rustV0) binder() int {
+	n := int(p.optBase62('G'))
+	if n == 0 {
+		return 0
+	}
+	p.print("for<")
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			p.print(", ")
+		}
+		p.lifetime++
+		p.lifetimeRef(1)
+	}
+	p.print("> ")
+	return n
+}
+
+var rustBasicTypes = map[byte]string{
+	'a': "i8", 'b': "bool", 'c': "char", 'd': "f64", 'e': "str", 'f': "f32",
+	'h': "u8", 'i': "isize", 'j': "usize", 'l': "i32", 'm': "u32", 'n': "i128",
+	'o': "u128", 's': "i16", 't': "u16", 'u': "()", 'v': "...", 'x': "i64",
+	'y': "u64", 'z': "!", 'p': "_",
+}
+
+// typ parses and prints a <type>.
+func (p *rustV0) typ() {
+	if p.err {
+		return
+	}
+	tag := p.next()
+	if t, ok := rustBasicTypes[tag]; ok {
+		p.print(t)
+		return
+	}
+	switch tag {
+	case 'R', 'Q':
+		p.print("&")
+		if p.eat('L') {
+			if lt := p.base62(); lt != 0 {
+				p.lifetimeRef(lt)
+				p.print(" ")
+			}
+		}
+		if tag == 'Q' {
+			p.print("mut ")
+		}
+		p.typ()
+	case 'P':
+		p.print("*const ")
+		p.typ()
+	case 'O':
+		p.print("*mut ")
+		p.typ()
+	case 'A', 'S':
+		p.print("[")
+		p.typ()
+		if tag == 'A' {
+			p.print("; ")
+			p.constant()
+		}
+		p.print("]")
+	case 'T':
+		p.print("(")
+		n := 0
+		for ; !p.err && !p.eat('E'); n++ {
+			if n > 0 {
+				p.print(", ")
+			}
+			p.typ()
+		}
+		if n == 1 {
+			p.print(",")
+		}
+		p.print(")")
+	case 'F':
+		saved := p.lifetime
+		p.binder()
+		if p.eat('U') {
+			p.print("unsafe ")
+		}
+		if p.eat('K') {
+			abi := "C"
+			if !p.eat('C') {
+				abi = strings.Replace(p.ident(), "_", "-", -1)
+			}
+			p.print(`extern "` + abi + `" `)
+		}
+		p.print("fn(")
+		for i := 0; !p.err && !p.eat('E'); i++ {
+			if i > 0 {
+				p.print(", ")
+			}
+			p.typ()
+		}
+		p.print(")")
+		if p.eat('u') {
+			// Unit return type, not printed.
+		} else {
+			p.print(" -> ")
+			p.typ()
+		}
+		p.lifetime = saved
+	case 'D':
+		p.print("dyn ")
+		saved := p.lifetime
+		p.binder()
+		for i := 0; !p.err && !p.eat('E'); i++ {
+			if i > 0 {
+				p.print(" + ")
+			}
+			p.dynTrait()
+		}
+		p.lifetime = saved
+		if !p.eat('L') {
+			p.err = true
+			return
+		}
+		if lt := p.base62(); lt != 0 {
+			p.print(" + ")
+			p.lifetimeRef(lt)
+		}
+	case 'B':
+		p.backref(p.typ)
+	default:
+		// A path.
+		p.pos--
+		p.path(false)
+	}
+}
+
+// dynTrait parses and prints a trait of a dyn type, with its
+// associated type bindings.
+func (p *rustV0) dynTrait() {
+	open := p.pathMaybeOpen()
+	for !p.err && p.eat('p') {
+		if open {
+			p.print(", ")
+		} else {
+			p.print("<")
+			open = true
+		}
+		p.print(p.ident() + " = ")
+		p.typ()
+	}
+	if open {
+		p.print(">")
+	}
+}
+
+// pathMaybeOpen prints a path, leaving its generic arguments open so
+// that associated type bindings can be appended. It reports whether
+// the arguments were left open.
+func (p *rustV0) pathMaybeOpen() bool {
+	if p.eat('B') {
+		open := false
+		p.backref(func() { open = p.pathMaybeOpen() })
+		return open
+	}
+	if !p.eat('I') {
+		p.path(false)
+		return false
+	}
+	p.path(false)
+	if !p.generics {
+		p.skipping(p.genericArgs)
+		return false
+	}
+	p.print("<")
+	for i := 0; !p.err && !p.eat('E'); i++ {
+		if i > 0 {
+			p.print(", ")
+		}
+		switch {
+		case p.eat('L'):
+			p.lifetimeRef(p.base62())
+		case p.eat('K'):
+			p.constant()
+		default:
+			p.typ()
+		}
+	}
+	return true
+}
+
+// constant parses and prints a <const>.
+func (p *rustV0) constant() {
+	if p.err {
+		return
+	}
+	switch tag := p.next(); tag {
+	case 'p':
+		p.print("_")
+	case 'B':
+		p.backref(p.constant)
+	case 'a', 's', 'l', 'x', 'n', 'i', 'h', 't', 'm', 'y', 'o', 'j':
+		if p.eat('n') {
+			p.print("-")
+		}
+		p.print(p.hexDigits())
+		if p.hashes {
+			// Spell out the type, as in 8usize.
+			p.print(rustBasicTypes[tag])
+		}
+	case 'b':
+		switch p.hexDigits() {
+		case "0":
+			p.print("false")
+		case "1":
+			p.print("true")
+		default:
+			p.err = true
+		}
+	case 'c':
+		v := p.hexDigits()
+		c, err := strconv.ParseUint(v, 10, 32)
+		if err != nil || !utf8.ValidRune(rune(c)) {
+			p.err = true
+			return
+		}
+		p.print(strconv.QuoteRune(rune(c)))
+	default:
+		p.err = true
+	}
+}
+
+// hexDigits parses the hex digits of a constant, up to the closing "_",
+// and returns their value in decimal.
+func (p *rustV0) hexDigits() string {
+	start := p.pos
+	for c := p.peek(); (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f'); c = p.peek() {
+		p.pos++
+	}
+	digits := p.sym[start:p.pos]
+	if !p.eat('_') {
+		p.err = true
+		return ""
+	}
+	if digits == "" {
+		return "0"
+	}
+	v, ok := new(big.Int).SetString(digits, 16)
+	if !ok {
+		p.err = true
+		return ""
+	}
+	return v.String()
+}
+
+// decodePunycode decodes an identifier encoded with Punycode (RFC
+// 3492), in which Rust replaces the "-" delimiter with "_".
+func decodePunycode(s string) (string, bool) {
+	const (
+		base, tmin, tmax = 36, 1, 26
+		skew, damp       = 38, 700
+	)
+	var output []rune
+	if i := strings.LastIndexByte(s, '_'); i >= 0 {
+		output = []rune(s[:i])
+		s = s[i+1:]
+	}
+	n, bias, i := 128, 72, 0
+	for len(s) > 0 {
+		oldi, w := i, 1
+		for k := base; ; k += base {
+			if len(s) == 0 {
+				return "", false
+			}
+			c := s[0]
+			s = s[1:]
+			var digit int
+			switch {
+			case c >= 'a' && c <= 'z':
+				digit = int(c - 'a')
+			case c >= '0' && c <= '9':
+				digit = int(c-'0') + 26
+			default:
+				return "", false
+			}
+			i += digit * w
+			t := k - bias
+			if t < tmin {
+				t = tmin
+			} else if t > tmax {
+				t = tmax
+			}
+			if digit < t {
+				break
+			}
+			w *= base - t
+			if i > 1<<24 || w > 1<<24 {
+				return "", false
+			}
+		}
+		// Adapt the bias.
+		delta := i - oldi
+		if oldi == 0 {
+			delta /= damp
+		} else {
+			delta /= 2
+		}
+		delta += delta / (len(output) + 1)
+		k := 0
+		for delta > ((base-tmin)*tmax)/2 {
+			delta /= base - tmin
+			k += base
+		}
+		bias = k + (base-tmin+1)*delta/(delta+skew)
+
+		n += i / (len(output) + 1)
+		i %= len(output) + 1
+		if n > utf8.MaxRune {
+			return "", false
+		}
+		output = append(output[:i], append([]rune{rune(n)}, output[i:]...)...)
+		i++
+	}
+	return string(output), true
+}
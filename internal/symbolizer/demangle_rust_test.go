@@ -0,0 +1,99 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package symbolizer
+
+import (
+	"testing"
+)
+
+func TestDemangleRust(t *testing.T) {
+	for _, tc := range []struct {
+		mangled                     string
+		simplified, templates, full string
+	}{
+		// Legacy symbols.
+		{
+			"_ZN4core3ptr13drop_in_place17h6d7e4a1c2b3f5e8aE",
+			"core::ptr::drop_in_place",
+			"core::ptr::drop_in_place",
+			"core::ptr::drop_in_place::h6d7e4a1c2b3f5e8a",
+		},
+		{
+			"_ZN71_$LT$Test$u20$$u2b$$u20$$u27$static$u20$as$u20$foo..Bar$LT$Test$GT$$GT$3bar17h930b740aa94f1d3aE.llvm.1234",
+			"<Test + 'static as foo::Bar<Test>>::bar",
+			"<Test + 'static as foo::Bar<Test>>::bar",
+			"<Test + 'static as foo::Bar<Test>>::bar::h930b740aa94f1d3a",
+		},
+		// v0 symbols.
+		{
+			"_RNvC6_123foo3bar",
+			"123foo::bar",
+			"123foo::bar",
+			"123foo::bar",
+		},
+		{
+			"_RNqCs4fqI2P2rA04_11utf8_identsu30____7hkackfecea1cbdathfdh9hlq6y",
+			"utf8_idents::საჭმელად_გემრიელი_სადილი",
+			"utf8_idents::საჭმელად_გემრიელი_სადილი",
+			"utf8_idents[317d481089b8c8fe]::საჭმელად_გემრიელი_სადილი",
+		},
+		{
+			"_RNCNCNgCs6DXkGYLi8lr_2cc5spawn00B5_",
+			"cc::spawn::{closure#0}::{closure#0}",
+			"cc::spawn::{closure#0}::{closure#0}",
+			"cc[4d6468d6c9fd4bb3]::spawn::{closure#0}::{closure#0}",
+		},
+		{
+			"_RNCINkXs25_NgCsbmNqQUJIY6D_4core5sliceINyB9_4IterhENuNgNoBb_4iter8iterator8Iterator9rpositionNCNgNpB9_6memchr7memrchrs_0E0Bb_",
+			"<core::slice::Iter as core::iter::iterator::Iterator>::rposition::{closure#0}",
+			"<core::slice::Iter<u8> as core::iter::iterator::Iterator>::rposition::<core::slice::memchr::memrchr::{closure#1}>::{closure#0}",
+			"<core[846817f741e54dfd]::slice::Iter<u8> as core[846817f741e54dfd]::iter::iterator::Iterator>::rposition::<core[846817f741e54dfd]::slice::memchr::memrchr::{closure#1}>::{closure#0}",
+		},
+		{
+			"_RINbNbCskIICzLVDPPb_5alloc5alloc8box_freeDINbNiB4_5boxed5FnBoxuEp6OutputuEL_ECs1iopQbuBiw2_3std",
+			"alloc::alloc::box_free",
+			"alloc::alloc::box_free::<dyn alloc::boxed::FnBox<(), Output = ()>>",
+			"alloc[f15a878b47eb696b]::alloc::box_free::<dyn alloc[f15a878b47eb696b]::boxed::FnBox<(), Output = ()>>",
+		},
+		{
+			"_RMCs4fqI2P2rA04_13const_genericINtB0_8UnsignedKhb_E",
+			"<const_generic::Unsigned>",
+			"<const_generic::Unsigned<11>>",
+			"<const_generic[317d481089b8c8fe]::Unsigned<11u8>>",
+		},
+	} {
+		for _, m := range []struct{ mode, want string }{
+			{"", tc.simplified},
+			{"templates", tc.templates},
+			{"full", tc.full},
+		} {
+			if got, ok := demangleRust(tc.mangled, m.mode); !ok || got != m.want {
+				t.Errorf("demangleRust(%q, %q): got %q, %v; want %q", tc.mangled, m.mode, got, ok, m.want)
+			}
+		}
+	}
+
+	for _, name := range []string{
+		"_ZN3foo3barE",          // C++
+		"_ZN3foo17h0123456789E", // not a hash
+		"_RNvC6_123foo3barX",    // trailing garbage
+		"_RNvB_3bar",            // invalid backref
+		"main.main",
+	} {
+		if got, ok := demangleRust(name, ""); ok {
+			t.Errorf("demangleRust(%q): got %q, want no demangling", name, got)
+		}
+	}
+}
@@ -0,0 +1,948 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package symbolizer
+
+// Implements demangling of the Swift symbols of functions, methods,
+// accessors and closures, in the mangling scheme of Swift 5. Swift
+// mangling is postfix: operands are pushed on a stack and popped by
+// the operators that follow them. Symbols using parts of the scheme
+// not supported here are left mangled.
+
+import (
+	"fmt"
+	"strings"
+)
+
+// demangleSwift demangles a Swift symbol, simplified according to
+// demanglerMode as C++ names are: "full" mode spells out the parameter
+// and result types, "templates" mode keeps the generic parameters, and
+// the default mode only keeps the qualified name. It returns false if
+// name is not a supported Swift symbol.
+func demangleSwift(name, demanglerMode string) (string, bool) {
+	for _, prefix := range []string{"_$s", "$s", "_$S", "$S", "_$e", "$e"} {
+		if strings.HasPrefix(name, prefix) {
+			// Suffixes, as in .resume.0 for async functions, are
+			// not mangled.
+			sym := strings.SplitN(name[len(prefix):], ".", 2)[0]
+			d := &swiftDemangler{sym: sym}
+			n := d.demangle()
+			if n == nil {
+				return "", false
+			}
+			pr := &swiftPrinter{
+				signatures: demanglerMode == "full",
+				generics:   demanglerMode == "full" || demanglerMode == "templates",
+			}
+			return pr.entity(n), true
+		}
+	}
+	return "", false
+}
+
+type swiftKind int
+
+const (
+	swiftIdentifier swiftKind = iota
+	swiftModule
+	swiftEmptyList
+	swiftFirstElement
+	swiftNominal      // text is the name, children are the context
+	swiftBoundGeneric // children are the nominal type and the arguments
+	swiftTuple        // children are swiftTupleElement
+	swiftTupleElement // text is the label, child is the type
+	swiftFunctionType // children are the parameters and the result
+	swiftGenericParam // index and depth
+	swiftMetatype
+	swiftProtocolList
+	swiftExtension // children are the module and the extended type
+	swiftThrows
+	swiftAsync
+	swiftGenericSignature // index is the number of parameters
+	swiftLabelList        // children are identifiers or swiftFirstElement
+	swiftPrivateName      // text is the name
+	swiftLocalName        // text is the name, index the discriminator
+	swiftEntity           // text is the kind of entity
+	swiftStatic
+	swiftThunk // text is the description
+)
+
+// swiftNode is a node of the tree of a demangled symbol.
+type swiftNode struct {
+	kind     swiftKind
+	text     string
+	index    int
+	depth    int
+	typ      bool // the node is a type
+	op       byte // operator of a nominal type, as in 'C' for classes
+	children []*swiftNode
+}
+
+// child returns the i-th child of n, or nil.
+func (n *swiftNode) child(i int) *swiftNode {
+	if n == nil || i >= len(n.children) {
+		return nil
+	}
+	return n.children[i]
+}
+
+// Kinds of entities.
+const (
+	swiftFunction     = "function"
+	swiftVariable     = "variable"
+	swiftAllocator    = "__allocating_init"
+	swiftConstructor  = "init"
+	swiftDeallocator  = "__deallocating_deinit"
+	swiftDestructor   = "deinit"
+	swiftClosure      = "closure"
+	swiftImplicitExpr = "implicit closure"
+)
+
+// swiftDemangler builds the tree of a symbol.
+type swiftDemangler struct {
+	sym   string
+	pos   int
+	stack []*swiftNode
+	subst []*swiftNode
+	words []string
+}
+
+func (d *swiftDemangler) demangle() *swiftNode {
+	for d.pos < len(d.sym) {
+		n := d.operator()
+		if n == nil {
+			return nil
+		}
+		d.push(n)
+	}
+	if len(d.stack) != 1 {
+		return nil
+	}
+	switch n := d.stack[0]; n.kind {
+	case swiftEntity, swiftStatic, swiftThunk:
+		return n
+	}
+	return nil
+}
+
+func (d *swiftDemangler) peek() byte {
+	if d.pos >= len(d.sym) {
+		return 0
+	}
+	return d.sym[d.pos]
+}
+
+func (d *swiftDemangler) next() byte {
+	c := d.peek()
+	if c != 0 {
+		d.pos++
+	}
+	return c
+}
+
+func (d *swiftDemangler) nextIf(c byte) bool {
+	if d.peek() == c {
+		d.pos++
+		return true
+	}
+	return false
+}
+
+func (d *swiftDemangler) push(n *swiftNode) {
+	d.stack = append(d.stack, n)
+}
+
+// pop pops the top of the stack if it satisfies match.
+func (d *swiftDemangler) pop(match func(*swiftNode) bool) *swiftNode {
+	if len(d.stack) == 0 {
+		return nil
+	}
+	n := d.stack[len(d.stack)-1]
+	if !match(n) {
+		return nil
+	}
+	d.stack = d.stack[:len(d.stack)-1]
+	return n
+}
+
+func (d *swiftDemangler) popKind(k swiftKind) *swiftNode {
+	return d.pop(func(n *swiftNode) bool { return n.kind == k })
+}
+
+func (d *swiftDemangler) popType() *swiftNode {
+	return d.pop(func(n *swiftNode) bool { return n.typ })
+}
+
+func isSwiftDeclName(n *swiftNode) bool {
+	switch n.kind {
+	case swiftIdentifier, swiftPrivateName, swiftLocalName:
+		return true
+	}
+	return false
+}
+
+// popContext pops the context of an entity: a module, a type, an
+// extension or another entity.
+func (d *swiftDemangler) popContext() *swiftNode {
+	if n := d.popKind(swiftIdentifier); n != nil {
+		return &swiftNode{kind: swiftModule, text: n.text}
+	}
+	return d.pop(func(n *swiftNode) bool {
+		switch n.kind {
+		case swiftModule, swiftExtension, swiftEntity, swiftStatic:
+			return true
+		case swiftNominal, swiftBoundGeneric:
+			return n.typ
+		}
+		return false
+	})
+}
+
+func (d *swiftDemangler) natural() (int, bool) {
+	start := d.pos
+	n := 0
+	for c := d.peek(); c >= '0' && c <= '9'; c = d.peek() {
+		n = n*10 + int(c-'0')
+		if n > 1<<20 {
+			return 0, false
+		}
+		d.pos++
+	}
+	return n, d.pos > start
+}
+
+// index parses an index: "_" is 0, and a number followed by "_" is the
+// number plus one.
+func (d *swiftDemangler) index() (int, bool) {
+	if d.nextIf('_') {
+		return 0, true
+	}
+	n, ok := d.natural()
+	if !ok || !d.nextIf('_') {
+		return 0, false
+	}
+	return n + 1, true
+}
+
+func (d *swiftDemangler) operator() *swiftNode {
+	c := d.next()
+	if c >= '0' && c <= '9' {
+		d.pos--
+		return d.identifier()
+	}
+	switch c {
+	case 'A':
+		return d.substitutions()
+	case 'C', 'V', 'O', 'P', 'a':
+		name := d.pop(isSwiftDeclName)
+		ctx := d.popContext()
+		if name == nil || ctx == nil {
+			return nil
+		}
+		n := &swiftNode{kind: swiftNominal, text: name.text, typ: true, op: c, children: []*swiftNode{ctx}}
+		d.subst = append(d.subst, n)
+		return n
+	case 'E':
+		d.popKind(swiftGenericSignature)
+		mod := d.popContext()
+		t := d.popType()
+		if mod == nil || mod.kind != swiftModule || t == nil {
+			return nil
+		}
+		return &swiftNode{kind: swiftExtension, children: []*swiftNode{mod, t}}
+	case 'F':
+		return d.function()
+	case 'f':
+		return d.functionEntity()
+	case 'G':
+		return d.boundGeneric()
+	case 'K':
+		return &swiftNode{kind: swiftThrows}
+	case 'L':
+		return d.localIdentifier()
+	case 'l':
+		return d.genericSignature()
+	case 'm':
+		t := d.popType()
+		if t == nil {
+			return nil
+		}
+		return &swiftNode{kind: swiftMetatype, typ: true, children: []*swiftNode{t}}
+	case 'p':
+		return d.protocolList()
+	case 'q':
+		return d.genericParam()
+	case 'S':
+		return d.standardSubstitution()
+	case 's':
+		return &swiftNode{kind: swiftModule, text: "Swift"}
+	case 'T':
+		return d.thunk()
+	case 't':
+		return d.tuple()
+	case 'v':
+		return d.variable()
+	case 'X':
+		switch d.next() {
+		case 'E', 'B', 'C':
+			return d.functionType()
+		}
+		return nil
+	case 'x':
+		return &swiftNode{kind: swiftGenericParam, typ: true}
+	case 'Y':
+		if d.nextIf('a') {
+			return &swiftNode{kind: swiftAsync}
+		}
+		return nil
+	case 'y':
+		return &swiftNode{kind: swiftEmptyList}
+	case 'Z':
+		e := d.popKind(swiftEntity)
+		if e == nil {
+			return nil
+		}
+		return &swiftNode{kind: swiftStatic, children: []*swiftNode{e}}
+	case '_':
+		return &swiftNode{kind: swiftFirstElement}
+	case 'c':
+		return d.functionType()
+	}
+	return nil
+}
+
+// identifier parses an identifier, which may refer to the words of
+// the previous identifiers. Identifiers are substitutable, as types are.
+func (d *swiftDemangler) identifier() *swiftNode {
+	words := false
+	if d.nextIf('0') {
+		if d.peek() == '0' {
+			// Punycode is not supported.
+			return nil
+		}
+		words = true
+	}
+	var id strings.Builder
+	for {
+		for words && isSwiftLetter(d.peek()) {
+			c := d.next()
+			var i int
+			if c >= 'a' && c <= 'z' {
+				i = int(c - 'a')
+			} else {
+				i = int(c - 'A')
+				words = false
+			}
+			if i >= len(d.words) {
+				return nil
+			}
+			id.WriteString(d.words[i])
+		}
+		if d.nextIf('0') {
+			break
+		}
+		n, ok := d.natural()
+		if !ok || n <= 0 || d.pos+n > len(d.sym) {
+			return nil
+		}
+		s := d.sym[d.pos : d.pos+n]
+		d.pos += n
+		id.WriteString(s)
+		d.addWords(s)
+		if !words {
+			break
+		}
+	}
+	n := &swiftNode{kind: swiftIdentifier, text: id.String()}
+	d.subst = append(d.subst, n)
+	return n
+}
+
+// addWords records the words of an identifier, for later identifiers
+// to refer to. Words start with a letter and end before an uppercase
+// letter following a non-uppercase one, an underscore or the end.
+func (d *swiftDemangler) addWords(s string) {
+	start := -1
+	for i := 0; i <= len(s); i++ {
+		var c byte
+		if i < len(s) {
+			c = s[i]
+		}
+		if start >= 0 && (c == '_' || c == 0 || (!isSwiftUpper(s[i-1]) && isSwiftUpper(c))) {
+			if i-start >= 2 && len(d.words) < 26 {
+				d.words = append(d.words, s[start:i])
+			}
+			start = -1
+		}
+		if start < 0 && c != 0 && c != '_' && !(c >= '0' && c <= '9') {
+			start = i
+		}
+	}
+}
+
+func isSwiftUpper(c byte) bool {
+	return c >= 'A' && c <= 'Z'
+}
+
+func isSwiftLetter(c byte) bool {
+	return isSwiftUpper(c) || (c >= 'a' && c <= 'z')
+}
+
+// substitutions parses references to previously demangled types.
+func (d *swiftDemangler) substitutions() *swiftNode {
+	repeat := -1
+	for {
+		c := d.next()
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+			i := int(c - 'a')
+			if isSwiftUpper(c) {
+				i = int(c - 'A')
+			}
+			if i >= len(d.subst) || repeat > 2048 {
+				return nil
+			}
+			n := d.subst[i]
+			for ; repeat > 1; repeat-- {
+				d.push(n)
+			}
+			if isSwiftUpper(c) {
+				return n
+			}
+			d.push(n)
+			repeat = -1
+		case c == '_':
+			i := repeat + 27
+			if i >= len(d.subst) {
+				return nil
+			}
+			return d.subst[i]
+		case c >= '0' && c <= '9':
+			d.pos--
+			repeat, _ = d.natural()
+		default:
+			return nil
+		}
+	}
+}
+
+// swiftStandardTypes are the standard substitutions of the types of
+// the Swift module.
+var swiftStandardTypes = map[byte]string{
+	'A': "AutoreleasingUnsafeMutablePointer", 'a': "Array", 'b': "Bool",
+	'D': "Dictionary", 'd': "Double", 'f': "Float", 'h': "Set",
+	'I': "DefaultIndices", 'i': "Int", 'J': "Character", 'N': "ClosedRange",
+	'n': "Range", 'O': "ObjectIdentifier", 'P': "UnsafePointer",
+	'p': "UnsafeMutablePointer", 'R': "UnsafeBufferPointer",
+	'r': "UnsafeMutableBufferPointer", 'S': "String", 's': "Substring",
+	'u': "UInt", 'V': "UnsafeRawPointer", 'v': "UnsafeMutableRawPointer",
+	'W': "UnsafeRawBufferPointer", 'w': "UnsafeMutableRawBufferPointer",
+	'q': "Optional", 'B': "BinaryFloatingPoint", 'E': "Encodable",
+	'e': "Decodable", 'F': "FloatingPoint", 'G': "RandomNumberGenerator",
+	'H': "Hashable", 'j': "Numeric", 'K': "BidirectionalCollection",
+	'k': "RandomAccessCollection", 'L': "Comparable", 'l': "Collection",
+	'M': "MutableCollection", 'm': "RangeReplaceableCollection",
+	'Q': "Equatable", 'T': "Sequence", 't': "IteratorProtocol",
+	'U': "UnsignedInteger", 'X': "RangeExpression", 'x': "Strideable",
+	'Y': "RawRepresentable", 'y': "StringProtocol", 'Z': "SignedInteger",
+	'z': "BinaryInteger",
+}
+
+func (d *swiftDemangler) standardSubstitution() *swiftNode {
+	switch d.peek() {
+	case 'o':
+		d.pos++
+		return &swiftNode{kind: swiftModule, text: "__C"}
+	case 'C':
+		d.pos++
+		return &swiftNode{kind: swiftModule, text: "__C_Synthesized"}
+	case 'g':
+		d.pos++
+		t := d.popType()
+		if t == nil {
+			return nil
+		}
+		n := &swiftNode{kind: swiftBoundGeneric, typ: true, children: []*swiftNode{swiftStdlibType("Optional"), t}}
+		d.subst = append(d.subst, n)
+		return n
+	}
+	repeat, ok := d.natural()
+	if !ok {
+		repeat = 1
+	}
+	name, ok := swiftStandardTypes[d.next()]
+	if !ok || repeat > 2048 {
+		return nil
+	}
+	n := swiftStdlibType(name)
+	for ; repeat > 1; repeat-- {
+		d.push(n)
+	}
+	return n
+}
+
+func swiftStdlibType(name string) *swiftNode {
+	return &swiftNode{kind: swiftNominal, text: name, typ: true, children: []*swiftNode{{kind: swiftModule, text: "Swift"}}}
+}
+
+// localIdentifier parses the name of a private or local declaration.
+func (d *swiftDemangler) localIdentifier() *swiftNode {
+	if d.nextIf('L') {
+		// Private declaration, with a discriminator.
+		d.popKind(swiftIdentifier)
+		name := d.pop(isSwiftDeclName)
+		if name == nil {
+			return nil
+		}
+		return &swiftNode{kind: swiftPrivateName, text: name.text}
+	}
+	i, ok := d.index()
+	name := d.pop(isSwiftDeclName)
+	if !ok || name == nil {
+		return nil
+	}
+	return &swiftNode{kind: swiftLocalName, text: name.text, index: i}
+}
+
+// genericParam parses a reference to a generic parameter.
+func (d *swiftDemangler) genericParam() *swiftNode {
+	n := &swiftNode{kind: swiftGenericParam, typ: true}
+	switch {
+	case d.nextIf('d'):
+		depth, ok1 := d.index()
+		i, ok2 := d.index()
+		if !ok1 || !ok2 {
+			return nil
+		}
+		n.depth, n.index = depth+1, i
+	case d.nextIf('z'):
+	default:
+		i, ok := d.index()
+		if !ok {
+			return nil
+		}
+		n.index = i + 1
+	}
+	return n
+}
+
+// genericSignature parses the signature of a generic entity with a
+// single generic parameter. Requirements are not supported.
+func (d *swiftDemangler) genericSignature() *swiftNode {
+	return &swiftNode{kind: swiftGenericSignature, index: 1}
+}
+
+func (d *swiftDemangler) tuple() *swiftNode {
+	t := &swiftNode{kind: swiftTuple, typ: true}
+	if d.popKind(swiftEmptyList) != nil {
+		return t
+	}
+	for first := false; !first; {
+		first = d.popKind(swiftFirstElement) != nil
+		e := &swiftNode{kind: swiftTupleElement}
+		if id := d.popKind(swiftIdentifier); id != nil {
+			e.text = id.text
+		}
+		ty := d.popType()
+		if ty == nil {
+			return nil
+		}
+		e.children = []*swiftNode{ty}
+		t.children = append([]*swiftNode{e}, t.children...)
+	}
+	return t
+}
+
+func (d *swiftDemangler) protocolList() *swiftNode {
+	l := &swiftNode{kind: swiftProtocolList, typ: true}
+	if d.popKind(swiftEmptyList) != nil {
+		return l
+	}
+	for first := false; !first; {
+		first = d.popKind(swiftFirstElement) != nil
+		p := d.popType()
+		if p == nil {
+			return nil
+		}
+		l.children = append([]*swiftNode{p}, l.children...)
+	}
+	return l
+}
+
+func (d *swiftDemangler) boundGeneric() *swiftNode {
+	var lists [][]*swiftNode
+	for {
+		var args []*swiftNode
+		for t := d.popType(); t != nil; t = d.popType() {
+			args = append([]*swiftNode{t}, args...)
+		}
+		lists = append(lists, args)
+		if d.popKind(swiftEmptyList) != nil {
+			break
+		}
+		if d.popKind(swiftFirstElement) == nil {
+			return nil
+		}
+	}
+	nominal := d.popType()
+	if nominal == nil || nominal.kind != swiftNominal {
+		return nil
+	}
+	// The first list holds the arguments of the nominal type, the
+	// others those of its enclosing types, which are not printed.
+	n := &swiftNode{kind: swiftBoundGeneric, typ: true, children: append([]*swiftNode{nominal}, lists[0]...)}
+	d.subst = append(d.subst, n)
+	return n
+}
+
+// popParams pops the parameters or the result of a function type.
+func (d *swiftDemangler) popParams() *swiftNode {
+	if d.popKind(swiftEmptyList) != nil {
+		return &swiftNode{kind: swiftTuple, typ: true}
+	}
+	return d.popType()
+}
+
+// functionType pops a function type: its parameters, result and
+// effects.
+func (d *swiftDemangler) functionType() *swiftNode {
+	f := &swiftNode{kind: swiftFunctionType, typ: true}
+	throws := d.popKind(swiftThrows)
+	async := d.popKind(swiftAsync)
+	params := d.popParams()
+	result := d.popParams()
+	if params == nil || result == nil {
+		return nil
+	}
+	f.children = []*swiftNode{params, result}
+	if async != nil {
+		f.text = "async"
+	}
+	if throws != nil {
+		f.text = strings.TrimSpace(f.text + " throws")
+	}
+	return f
+}
+
+// labels pops the argument labels of a function of type t.
+func (d *swiftDemangler) labels(t *swiftNode) *swiftNode {
+	if d.popKind(swiftEmptyList) != nil {
+		return &swiftNode{kind: swiftLabelList}
+	}
+	params := t.child(0)
+	n := 1
+	if params.kind == swiftTuple {
+		n = len(params.children)
+	}
+	if n == 0 {
+		return nil
+	}
+	l := &swiftNode{kind: swiftLabelList}
+	for i := 0; i < n; i++ {
+		label := d.pop(func(n *swiftNode) bool { return n.kind == swiftIdentifier || n.kind == swiftFirstElement })
+		if label == nil {
+			return nil
+		}
+		l.children = append([]*swiftNode{label}, l.children...)
+	}
+	return l
+}
+
+// function parses a function entity.
+func (d *swiftDemangler) function() *swiftNode {
+	sig := d.popKind(swiftGenericSignature)
+	t := d.functionType()
+	if t == nil {
+		return nil
+	}
+	labels := d.labels(t)
+	name := d.pop(isSwiftDeclName)
+	ctx := d.popContext()
+	if name == nil || ctx == nil {
+		return nil
+	}
+	return &swiftNode{kind: swiftEntity, text: swiftFunction, children: []*swiftNode{ctx, name, t, labels, sig}}
+}
+
+// functionEntity parses initializers, deinitializers and closures.
+func (d *swiftDemangler) functionEntity() *swiftNode {
+	e := &swiftNode{kind: swiftEntity}
+	var t, labels *swiftNode
+	switch d.next() {
+	case 'D':
+		e.text = swiftDeallocator
+	case 'd':
+		e.text = swiftDestructor
+	case 'C', 'c':
+		e.text = swiftAllocator
+		if d.sym[d.pos-1] == 'c' {
+			e.text = swiftConstructor
+		}
+		if t = d.popType(); t == nil || t.kind != swiftFunctionType {
+			return nil
+		}
+		labels = d.labels(t)
+	case 'U', 'u':
+		e.text = swiftClosure
+		if d.sym[d.pos-1] == 'u' {
+			e.text = swiftImplicitExpr
+		}
+		i, ok := d.index()
+		if !ok {
+			return nil
+		}
+		e.index = i + 1
+		t = d.popType()
+	default:
+		return nil
+	}
+	ctx := d.popContext()
+	if ctx == nil {
+		return nil
+	}
+	e.children = []*swiftNode{ctx, nil, t, labels}
+	return e
+}
+
+// variable parses a variable and its accessor.
+func (d *swiftDemangler) variable() *swiftNode {
+	t := d.popType()
+	name := d.pop(isSwiftDeclName)
+	ctx := d.popContext()
+	if t == nil || name == nil || ctx == nil {
+		return nil
+	}
+	accessors := map[byte]string{
+		'g': "getter", 's': "setter", 'G': "getter", 'w': "willset",
+		'W': "didset", 'r': "read", 'M': "modify", 'm': "materializeForSet",
+		'p': "",
+	}
+	accessor, ok := accessors[d.next()]
+	if !ok {
+		return nil
+	}
+	e := &swiftNode{kind: swiftEntity, text: swiftVariable, children: []*swiftNode{ctx, name, t}}
+	if accessor != "" {
+		e.children = append(e.children, &swiftNode{kind: swiftIdentifier, text: accessor})
+	}
+	return e
+}
+
+var swiftThunks = map[byte]string{
+	'A': "partial apply forwarder for ",
+	'm': "merged ",
+	'o': "@objc ",
+	'O': "@nonobjc ",
+	'j': "dispatch thunk of ",
+	'D': "dynamic ",
+	'q': "method descriptor for ",
+}
+
+func (d *swiftDemangler) thunk() *swiftNode {
+	desc, ok := swiftThunks[d.next()]
+	if !ok {
+		return nil
+	}
+	e := d.pop(func(n *swiftNode) bool { return n.kind == swiftEntity || n.kind == swiftStatic || n.kind == swiftThunk })
+	if e == nil {
+		return nil
+	}
+	return &swiftNode{kind: swiftThunk, text: desc, children: []*swiftNode{e}}
+}
+
+// swiftPrinter prints a demangled symbol.
+type swiftPrinter struct {
+	signatures bool // print the types of entities
+	generics   bool // print generic parameters
+}
+
+// entity prints an entity, or the context of an entity.
+func (pr *swiftPrinter) entity(n *swiftNode) string {
+	switch n.kind {
+	case swiftModule:
+		return n.text
+	case swiftNominal, swiftBoundGeneric:
+		return pr.typ(n)
+	case swiftExtension:
+		if pr.signatures {
+			return "(extension in " + n.child(0).text + "):" + pr.typ(n.child(1))
+		}
+		return pr.typ(n.child(1))
+	case swiftStatic:
+		return "static " + pr.entity(n.child(0))
+	case swiftThunk:
+		return n.text + pr.entity(n.child(0))
+	case swiftEntity:
+		return pr.entityName(n)
+	}
+	return "?"
+}
+
+func (pr *swiftPrinter) entityName(n *swiftNode) string {
+	ctx, name, t, labels, sig := n.child(0), n.child(1), n.child(2), n.child(3), n.child(4)
+	var s string
+	switch n.text {
+	case swiftClosure, swiftImplicitExpr:
+		s = fmt.Sprintf("%s #%d", n.text, n.index)
+		if pr.signatures && t != nil {
+			s += " " + pr.typ(t)
+		}
+		return s + " in " + pr.entity(ctx)
+	case swiftFunction, swiftVariable:
+		s = pr.entity(ctx) + "." + pr.declName(name)
+	case swiftAllocator:
+		// Only classes tell allocation and initialization apart.
+		if ctx.kind == swiftNominal && ctx.op == 'C' {
+			s = pr.entity(ctx) + "." + n.text
+		} else {
+			s = pr.entity(ctx) + "." + swiftConstructor
+		}
+	default:
+		s = pr.entity(ctx) + "." + n.text
+	}
+	if pr.generics && sig != nil {
+		var params []string
+		for i := 0; i < sig.index; i++ {
+			params = append(params, swiftGenericParamName(0, i))
+		}
+		s += "<" + strings.Join(params, ", ") + ">"
+	}
+	if n.text == swiftVariable {
+		if accessor := n.child(3); accessor != nil {
+			s += "." + accessor.text
+		}
+		if pr.signatures {
+			s += " : " + pr.typ(t)
+		}
+		return s
+	}
+	if pr.signatures && t != nil {
+		s += pr.params(t.child(0), labels) + pr.effects(t) + " -> " + pr.typ(t.child(1))
+	}
+	return s
+}
+
+func (pr *swiftPrinter) declName(n *swiftNode) string {
+	if n.kind == swiftLocalName {
+		return fmt.Sprintf("%s #%d", n.text, n.index+1)
+	}
+	return n.text
+}
+
+// params prints the parameters of a function, with their labels.
+func (pr *swiftPrinter) params(params, labels *swiftNode) string {
+	var types []*swiftNode
+	if params.kind == swiftTuple {
+		for _, e := range params.children {
+			types = append(types, e.child(0))
+		}
+	} else {
+		types = []*swiftNode{params}
+	}
+	var out []string
+	for i, t := range types {
+		p := pr.typ(t)
+		if label := labels.child(i); label != nil {
+			if label.kind == swiftIdentifier {
+				p = label.text + ": " + p
+			} else {
+				p = "_: " + p
+			}
+		}
+		out = append(out, p)
+	}
+	return "(" + strings.Join(out, ", ") + ")"
+}
+
+func (pr *swiftPrinter) effects(t *swiftNode) string {
+	if t.text == "" {
+		return ""
+	}
+	return " " + t.text
+}
+
+// typ prints a type.
+func (pr *swiftPrinter) typ(n *swiftNode) string {
+	if n == nil {
+		return "?"
+	}
+	switch n.kind {
+	case swiftNominal:
+		return pr.entity(n.child(0)) + "." + n.text
+	case swiftBoundGeneric:
+		nominal := n.child(0)
+		args := n.children[1:]
+		if nominal.child(0).kind == swiftModule && nominal.child(0).text == "Swift" {
+			// Sugared types.
+			switch {
+			case nominal.text == "Optional" && len(args) == 1:
+				return pr.typ(args[0]) + "?"
+			case nominal.text == "Array" && len(args) == 1:
+				return "[" + pr.typ(args[0]) + "]"
+			case nominal.text == "Dictionary" && len(args) == 2:
+				return "[" + pr.typ(args[0]) + " : " + pr.typ(args[1]) + "]"
+			}
+		}
+		var s []string
+		for _, a := range args {
+			s = append(s, pr.typ(a))
+		}
+		return pr.typ(nominal) + "<" + strings.Join(s, ", ") + ">"
+	case swiftTuple:
+		var s []string
+		for _, e := range n.children {
+			t := pr.typ(e.child(0))
+			if e.text != "" {
+				t = e.text + ": " + t
+			}
+			s = append(s, t)
+		}
+		return "(" + strings.Join(s, ", ") + ")"
+	case swiftFunctionType:
+		return pr.params(n.child(0), nil) + pr.effects(n) + " -> " + pr.typ(n.child(1))
+	case swiftGenericParam:
+		return swiftGenericParamName(n.depth, n.index)
+	case swiftMetatype:
+		return pr.typ(n.child(0)) + ".Type"
+	case swiftProtocolList:
+		if len(n.children) == 0 {
+			return "Any"
+		}
+		var s []string
+		for _, p := range n.children {
+			s = append(s, pr.typ(p))
+		}
+		return strings.Join(s, " & ")
+	}
+	return "?"
+}
+
+// swiftGenericParamName returns the name of a generic parameter, as
+// Swift prints it: A, B, ... for the outermost ones, followed by the
+// depth for the others.
+func swiftGenericParamName(depth, index int) string {
+	var name string
+	for {
+		name = string(rune('A'+index%26)) + name
+		index /= 26
+		if index == 0 {
+			break
+		}
+		index--
+	}
+	if depth != 0 {
+		name += fmt.Sprint(depth)
+	}
+	return name
+}
@@ -0,0 +1,87 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package symbolizer
+
+import (
+	"testing"
+)
+
+func TestDemangleSwift(t *testing.T) {
+	for _, tc := range []struct {
+		mangled          string
+		simplified, full string
+	}{
+		{
+			"$s4main5helloyyF",
+			"main.hello",
+			"main.hello() -> ()",
+		},
+		{
+			"_$sSS7cStringSSSPys4Int8VG_tcfC",
+			"Swift.String.init",
+			"Swift.String.init(cString: Swift.UnsafePointer<Swift.Int8>) -> Swift.String",
+		},
+		{
+			"$s4main3FooV1xSivg",
+			"main.Foo.x.getter",
+			"main.Foo.x.getter : Swift.Int",
+		},
+		{
+			"$s4main3fooyyFyycfU_",
+			"closure #1 in main.foo",
+			"closure #1 () -> () in main.foo() -> ()",
+		},
+		{
+			"$s4main3BarC5countSivs",
+			"main.Bar.count.setter",
+			"main.Bar.count.setter : Swift.Int",
+		},
+		{
+			"$s4main3BarCACycfC",
+			"main.Bar.__allocating_init",
+			"main.Bar.__allocating_init() -> main.Bar",
+		},
+		{
+			"$s4main3sum_1ys5Int64VAE_AEtF.cold.1",
+			"main.sum",
+			"main.sum(_: Swift.Int64, y: Swift.Int64) -> Swift.Int64",
+		},
+		{
+			"$s4main4keysySaySSGSDySSSiGF",
+			"main.keys",
+			"main.keys([Swift.String : Swift.Int]) -> [Swift.String]",
+		},
+	} {
+		for _, m := range []struct{ mode, want string }{
+			{"", tc.simplified},
+			{"full", tc.full},
+		} {
+			if got, ok := demangleSwift(tc.mangled, m.mode); !ok || got != m.want {
+				t.Errorf("demangleSwift(%q, %q): got %q, %v; want %q", tc.mangled, m.mode, got, ok, m.want)
+			}
+		}
+	}
+
+	for _, name := range []string{
+		"$s4main",           // incomplete
+		"$s4main5helloyy",   // missing the function
+		"_T04main5helloyyF", // old mangling
+		"main.main",
+	} {
+		if got, ok := demangleSwift(name, ""); ok {
+			t.Errorf("demangleSwift(%q): got %q, want no demangling", name, got)
+		}
+	}
+}
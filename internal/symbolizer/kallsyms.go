@@ -0,0 +1,207 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package symbolizer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"pproflame/internal/plugin"
+	"pproflame/internal/symbolstore"
+	"pproflame/profile"
+)
+
+// kallsyms is a kernel symbol table, as read from /proc/kallsyms.
+type kallsyms struct {
+	syms []kernelSymbol // sorted by address
+}
+
+type kernelSymbol struct {
+	addr uint64
+	name string
+}
+
+// parseKallsyms reads the function symbols of a kallsyms file. Lines
+// have the form "<address> <type> <name> [<module>]"; the module of
+// a symbol is not kept.
+func parseKallsyms(r io.Reader) (*kallsyms, error) {
+	k := &kallsyms{}
+	hidden := true
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 3 || len(fields[1]) != 1 {
+			return nil, fmt.Errorf("line %d: malformed kallsyms entry %q", n, s.Text())
+		}
+		addr, err := strconv.ParseUint(fields[0], 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		if addr != 0 {
+			hidden = false
+		}
+		switch fields[1] {
+		case "t", "T", "w", "W":
+		default:
+			// Not a function.
+			continue
+		}
+		k.syms = append(k.syms, kernelSymbol{addr: addr, name: fields[2]})
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if hidden {
+		return nil, fmt.Errorf("kernel addresses are hidden; read /proc/kallsyms as root or with kernel.kptr_restrict=0")
+	}
+	sort.SliceStable(k.syms, func(i, j int) bool { return k.syms[i].addr < k.syms[j].addr })
+	return k, nil
+}
+
+// readKallsyms reads a kallsyms file.
+func readKallsyms(name string) (*kallsyms, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	k, err := parseKallsyms(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return k, nil
+}
+
+// lookup returns the function symbol holding addr. A symbol extends up
+// to the next one; addresses past the last symbol are not resolved.
+func (k *kallsyms) lookup(addr uint64) (kernelSymbol, bool) {
+	i := sort.Search(len(k.syms), func(i int) bool { return k.syms[i].addr > addr })
+	if i == 0 || i == len(k.syms) {
+		return kernelSymbol{}, false
+	}
+	return k.syms[i-1], true
+}
+
+// isKernelMapping reports whether m is the kernel mapping of a profile
+// converted from perf.
+func isKernelMapping(m *profile.Mapping) bool {
+	return m != nil && (strings.HasPrefix(m.File, "[kernel.kallsyms]") || m.File == "[vmlinux]")
+}
+
+// snapshotComment is the prefix of the profile comment naming the
+// snapshot the profile belongs to.
+const snapshotComment = "snapshot: "
+
+// snapshotID returns the ID of the snapshot of the profile, set by a
+// comment like "snapshot: <id>", or "" if it has none.
+func snapshotID(prof *profile.Profile) string {
+	for _, c := range prof.Comments {
+		if strings.HasPrefix(c, snapshotComment) {
+			return strings.TrimSpace(strings.TrimPrefix(c, snapshotComment))
+		}
+	}
+	return ""
+}
+
+// lookupKallsyms returns the path of the kallsyms file in store for the
+// kernel mapping m: the one for its build ID, or else the one for the
+// snapshot of the profile.
+func lookupKallsyms(store *symbolstore.Store, m *profile.Mapping, snapshot string) (string, error) {
+	if m.BuildID != "" {
+		name, err := store.LookupKallsyms(m.BuildID)
+		if err == nil || snapshot == "" {
+			return name, err
+		}
+	}
+	return store.LookupSnapshotKallsyms(snapshot)
+}
+
+// kernelSymbolize symbolizes the locations of kernel mappings with a
+// kallsyms file: the one named by path if set, or otherwise the one in
+// store for the build ID of the mapping or for the snapshot of the
+// profile. Kernel addresses are not relocated, so the kallsyms file must
+// come from the boot the profile was collected in.
+func kernelSymbolize(prof *profile.Profile, force bool, path string, store *symbolstore.Store, ui plugin.UI) {
+	tables := make(map[*profile.Mapping]*kallsyms)
+	snapshot := snapshotID(prof)
+	var fromPath *kallsyms
+	for _, m := range prof.Mapping {
+		if !isKernelMapping(m) || (!force && m.HasFunctions) {
+			continue
+		}
+		var k *kallsyms
+		var err error
+		switch {
+		case path != "":
+			if fromPath == nil {
+				if fromPath, err = readKallsyms(path); err != nil {
+					ui.PrintErr("Kernel symbolization failed: ", err)
+					return
+				}
+			}
+			k = fromPath
+		case store != nil && (m.BuildID != "" || snapshot != ""):
+			name, err := lookupKallsyms(store, m, snapshot)
+			if err != nil {
+				continue
+			}
+			if k, err = readKallsyms(name); err != nil {
+				ui.PrintErr("Kernel symbolization failed: ", err)
+				continue
+			}
+		default:
+			continue
+		}
+		tables[m] = k
+	}
+	if len(tables) == 0 {
+		return
+	}
+
+	functions := make(map[profile.Function]*profile.Function)
+	for _, f := range prof.Function {
+		functions[profile.Function{Name: f.Name, SystemName: f.SystemName, Filename: f.Filename}] = f
+	}
+	total := make(map[*profile.Mapping]int)
+	resolved := make(map[*profile.Mapping]int)
+	for _, l := range prof.Location {
+		k := tables[l.Mapping]
+		if k == nil || l.Address == 0 || (!force && len(l.Line) != 0) {
+			continue
+		}
+		total[l.Mapping]++
+		sym, ok := k.lookup(l.Address)
+		if !ok {
+			continue
+		}
+		resolved[l.Mapping]++
+		setFrames(prof, functions, l, []plugin.Frame{{Func: sym.name}})
+	}
+	for _, m := range prof.Mapping {
+		if tables[m] == nil {
+			continue
+		}
+		ui.PrintErr(fmt.Sprintf("Symbolized %s with kallsyms: %d of %d addresses", m.File, resolved[m], total[m]))
+		m.HasFunctions = resolved[m] == total[m]
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package symbolizer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"pproflame/internal/proftest"
+	"pproflame/internal/symbolstore"
+	"pproflame/profile"
+)
+
+const testKallsyms = `ffffffff81000000 T _stext
+ffffffff81000000 T _text
+ffffffff81001000 t do_one_initcall
+ffffffff81002000 D some_data
+ffffffff81003000 T entry_SYSCALL_64
+ffffffff81004000 W __x64_sys_read
+ffffffffc0001000 t ext4_file_write_iter	[ext4]
+ffffffffc0002000 T _etext
+`
+
+func TestParseKallsyms(t *testing.T) {
+	k, err := parseKallsyms(strings.NewReader(testKallsyms))
+	if err != nil {
+		t.Fatalf("parseKallsyms: %v", err)
+	}
+	for _, tc := range []struct {
+		addr uint64
+		want string
+	}{
+		{0xffffffff80000000, ""},
+		{0xffffffff81000010, "_text"},
+		{0xffffffff81002010, "do_one_initcall"}, // Data symbols are skipped.
+		{0xffffffff81003000, "entry_SYSCALL_64"},
+		{0xffffffff81004abc, "__x64_sys_read"},
+		{0xffffffffc0001234, "ext4_file_write_iter"},
+		{0xffffffffc0003000, ""},
+	} {
+		sym, ok := k.lookup(tc.addr)
+		if ok != (tc.want != "") || sym.name != tc.want {
+			t.Errorf("lookup(%#x): got %q, %v; want %q", tc.addr, sym.name, ok, tc.want)
+		}
+	}
+
+	hidden := "0000000000000000 T _stext\n0000000000000000 t do_one_initcall\n"
+	if _, err := parseKallsyms(strings.NewReader(hidden)); err == nil {
+		t.Errorf("parseKallsyms of hidden addresses: want error")
+	}
+	if _, err := parseKallsyms(strings.NewReader("ffffffff81000000\n")); err == nil {
+		t.Errorf("parseKallsyms of a malformed file: want error")
+	}
+}
+
+func kernelProfile() *profile.Profile {
+	m := &profile.Mapping{ID: 1, Start: 0xffffffff81000000, Limit: 0xffffffffffffffff, File: "[kernel.kallsyms]", BuildID: "abcd1234"}
+	return &profile.Profile{
+		Mapping: []*profile.Mapping{m},
+		Location: []*profile.Location{
+			{ID: 1, Mapping: m, Address: 0xffffffff81003010},
+			{ID: 2, Mapping: m, Address: 0xffffffff81004010},
+			{ID: 3, Mapping: m, Address: 0xffffffffc0003000},
+		},
+	}
+}
+
+func TestKernelSymbolize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kallsyms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "kallsyms")
+	if err := ioutil.WriteFile(path, []byte(testKallsyms), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store := &symbolstore.Store{Dir: filepath.Join(dir, "store")}
+	if err := store.AddKallsyms(strings.NewReader(testKallsyms), "ABCD1234"); err != nil {
+		t.Fatalf("AddKallsyms: %v", err)
+	}
+	if err := store.AddSnapshotKallsyms(strings.NewReader(testKallsyms), "host1"); err != nil {
+		t.Fatalf("AddSnapshotKallsyms: %v", err)
+	}
+
+	for _, tc := range []struct {
+		desc     string
+		path     string
+		store    *symbolstore.Store
+		snapshot string
+	}{
+		{"path", path, nil, ""},
+		{"store", "", store, ""},
+		{"snapshot", "", store, "host1"},
+	} {
+		p := kernelProfile()
+		if tc.snapshot != "" {
+			// A kernel without a build ID, found by the snapshot of the profile.
+			p.Mapping[0].BuildID = ""
+			p.Comments = append(p.Comments, "snapshot: "+tc.snapshot)
+		}
+		kernelSymbolize(p, false, tc.path, tc.store, &proftest.TestUI{T: t, AllowRx: "^Symbolized "})
+		var got []string
+		for _, l := range p.Location {
+			name := ""
+			if len(l.Line) == 1 {
+				name = l.Line[0].Function.Name
+			}
+			got = append(got, name)
+		}
+		if want := "entry_SYSCALL_64,__x64_sys_read,"; strings.Join(got, ",") != want {
+			t.Errorf("%s: got functions %q, want %q", tc.desc, strings.Join(got, ","), want)
+		}
+		if p.Mapping[0].HasFunctions {
+			t.Errorf("%s: partially symbolized kernel mapping marked with HasFunctions", tc.desc)
+		}
+	}
+
+	// Kernels of other builds are not symbolized from the store.
+	p := kernelProfile()
+	p.Mapping[0].BuildID = "ffff"
+	kernelSymbolize(p, false, "", store, &proftest.TestUI{T: t, AllowRx: "^Symbolized "})
+	if len(p.Location[0].Line) != 0 {
+		t.Errorf("kernel symbolized with the kallsyms of another build")
+	}
+}
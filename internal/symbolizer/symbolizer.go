@@ -24,10 +24,15 @@ import (
 	"net/http"
 	"net/url"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"pproflame/internal/binutils"
 	"pproflame/internal/plugin"
+	"pproflame/internal/symbolstore"
 	"pproflame/internal/symbolz"
 	"pproflame/profile"
 	"github.com/ianlancetaylor/demangle"
@@ -37,6 +42,15 @@ import (
 type Symbolizer struct {
 	Obj plugin.ObjTool
 	UI  plugin.UI
+	// Cache holds the results of previous symbolizations. If nil,
+	// every address is symbolized again.
+	Cache *Cache
+	// Kallsyms is the path of a kallsyms file, as read from
+	// /proc/kallsyms, resolving the addresses of the kernel mappings.
+	// If empty, the kallsyms files added to Store for the build ID of
+	// the kernel are used.
+	Kallsyms string
+	Store    *symbolstore.Store
 }
 
 // test taps for dependency injection
@@ -49,6 +63,8 @@ var demangleFunction = Demangle
 // missed entries using symbolz.
 func (s *Symbolizer) Symbolize(mode string, sources plugin.MappingSources, p *profile.Profile) error {
 	remote, local, fast, force, demanglerMode := true, true, false, false, ""
+	native := false
+	remoteOptions := symbolz.DefaultOptions()
 	for _, o := range strings.Split(strings.ToLower(mode), ":") {
 		switch o {
 		case "":
@@ -59,11 +75,21 @@ func (s *Symbolizer) Symbolize(mode string, sources plugin.MappingSources, p *pr
 			remote, local = false, true
 		case "fastlocal":
 			remote, local, fast = false, true, true
+		case "native":
+			remote, local, native = false, true, true
 		case "remote":
 			remote, local = true, false
 		case "force":
 			force = true
 		default:
+			if n, ok := intOption(o, "batch="); ok {
+				remoteOptions.BatchSize = n
+				continue
+			}
+			if n, ok := intOption(o, "retries="); ok {
+				remoteOptions.Retries = n
+				continue
+			}
 			switch d := strings.TrimPrefix(o, "demangle="); d {
 			case "full", "none", "templates":
 				demanglerMode = d
@@ -73,27 +99,57 @@ func (s *Symbolizer) Symbolize(mode string, sources plugin.MappingSources, p *pr
 				continue
 			}
 			s.UI.PrintErr("ignoring unrecognized symbolization option: " + mode)
-			s.UI.PrintErr("expecting -symbolize=[local|fastlocal|remote|none][:force][:demangle=[none|full|templates|default]")
+			s.UI.PrintErr("expecting -symbolize=[local|fastlocal|native|remote|none][:force][:demangle=[none|full|templates|default]][:batch=n][:retries=n]")
 		}
 	}
 
+	// The object tool is shared, so the mode is set for every call.
+	if bu, ok := s.Obj.(*binutils.Binutils); ok {
+		bu.SetNativeSymbolization(native)
+	}
+
+	// Kernel mappings are skipped by the other symbolizations.
+	kernelSymbolize(p, force, s.Kallsyms, s.Store, s.UI)
+
 	var err error
 	if local {
 		// Symbolize locally using binutils.
-		if err = localSymbolize(p, fast, force, s.Obj, s.UI); err != nil {
+		if err = localSymbolize(p, fast, force, s.Obj, s.UI, s.Cache); err != nil {
 			s.UI.PrintErr("local symbolization: " + err.Error())
 		}
 	}
 	if remote {
-		if err = symbolzSymbolize(p, force, sources, postURL, s.UI); err != nil {
+		missing := fillFromCache(p, force, s.Cache)
+		if err = symbolzSymbolize(p, force, sources, postURL, s.UI, remoteOptions); err != nil {
 			return err // Ran out of options.
 		}
+		for _, l := range missing {
+			s.Cache.Add(l.Mapping, l.Mapping.BuildID, l.Address, lineFrames(l), true)
+		}
+	}
+	if s.Cache != nil {
+		if hits, misses := s.Cache.Stats(); hits+misses > 0 {
+			s.UI.PrintErr(fmt.Sprintf("Symbolization cache: %d hits, %d misses", hits, misses))
+		}
+		if err := s.Cache.Flush(); err != nil {
+			s.UI.PrintErr(err.Error())
+		}
 	}
 
 	demangleFunction(p, force, demanglerMode)
 	return nil
 }
 
+// intOption returns the value of a non-negative integer option of the
+// form prefix<n>.
+func intOption(o, prefix string) (int, bool) {
+	if !strings.HasPrefix(o, prefix) {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(o, prefix))
+	return n, err == nil && n >= 0
+}
+
 // postURL issues a POST to a URL over HTTP.
 func postURL(source, post string) ([]byte, error) {
 	url, err := url.Parse(source)
@@ -117,11 +173,12 @@ func postURL(source, post string) ([]byte, error) {
 	}
 	resp, err := client.Post(source, "application/octet-stream", strings.NewReader(post))
 	if err != nil {
-		return nil, fmt.Errorf("http post %s: %v", source, err)
+		// A *url.Error, which names the source and tells timeouts apart.
+		return nil, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("http post %s: %v", source, statusCodeError(resp))
+		return nil, &symbolz.StatusError{StatusCode: resp.StatusCode, Err: fmt.Errorf("http post %s: %v", source, statusCodeError(resp))}
 	}
 	return ioutil.ReadAll(resp.Body)
 }
@@ -138,8 +195,9 @@ func statusCodeError(resp *http.Response) error {
 
 // doLocalSymbolize adds symbol and line number information to all locations
 // in a profile. mode enables some options to control
-// symbolization.
-func doLocalSymbolize(prof *profile.Profile, fast, force bool, obj plugin.ObjTool, ui plugin.UI) error {
+// symbolization. Results are looked up in and added to cache, if not
+// nil.
+func doLocalSymbolize(prof *profile.Profile, fast, force bool, obj plugin.ObjTool, ui plugin.UI, cache *Cache) error {
 	if fast {
 		if bu, ok := obj.(*binutils.Binutils); ok {
 			bu.SetFastSymbolization(true)
@@ -152,62 +210,186 @@ func doLocalSymbolize(prof *profile.Profile, fast, force bool, obj plugin.ObjToo
 	}
 	defer mt.close()
 
-	functions := make(map[profile.Function]*profile.Function)
-	for _, l := range mt.prof.Location {
+	// Group the locations by mapping, in order of first appearance.
+	var mappings []*profile.Mapping
+	locations := make(map[*profile.Mapping][]int)
+	for i, l := range mt.prof.Location {
 		m := l.Mapping
-		segment := mt.segments[m]
-		if segment == nil {
+		if mt.segments[m] == nil && !mt.missing[m] {
 			// Nothing to do.
 			continue
 		}
+		if locations[m] == nil {
+			mappings = append(mappings, m)
+		}
+		locations[m] = append(locations[m], i)
+	}
 
-		stack, err := segment.SourceLine(l.Address)
-		if err != nil || len(stack) == 0 {
+	// Symbolize the mappings in parallel. Each worker only writes the
+	// stacks of the locations of its mapping.
+	stacks := make([][]plugin.Frame, len(mt.prof.Location))
+	elapsed := make([]time.Duration, len(mappings))
+	parallel(len(mappings), maxWorkers, func(i int) {
+		start := time.Now()
+		m := mappings[i]
+		segment := mt.segments[m]
+		buildID := m.BuildID
+		if buildID == "" && segment != nil {
+			buildID = segment.BuildID()
+		}
+		for _, li := range locations[m] {
+			l := mt.prof.Location[li]
+			stack, ok := cache.Lookup(m, buildID, l.Address, fast)
+			if !ok && segment != nil {
+				var err error
+				if stack, err = segment.SourceLine(l.Address); err != nil {
+					stack = nil
+				}
+				cache.Add(m, buildID, l.Address, stack, fast)
+			}
+			stacks[li] = stack
+		}
+		elapsed[i] = time.Since(start)
+	})
+	for i, m := range mappings {
+		ui.PrintErr(fmt.Sprintf("Symbolized %s: %d addresses in %v", filepath.Base(m.File), len(locations[m]), elapsed[i].Round(time.Millisecond)))
+	}
+
+	// Add the frames in location order, so that function IDs do not
+	// depend on scheduling.
+	functions := make(map[profile.Function]*profile.Function)
+	for i, l := range mt.prof.Location {
+		if len(stacks[i]) == 0 {
 			// No answers from addr2line.
 			continue
 		}
+		setFrames(mt.prof, functions, l, stacks[i])
+	}
 
-		l.Line = make([]profile.Line, len(stack))
-		l.IsFolded = false
-		for i, frame := range stack {
-			if frame.Func != "" {
-				m.HasFunctions = true
-			}
-			if frame.File != "" {
-				m.HasFilenames = true
-			}
-			if frame.Line != 0 {
-				m.HasLineNumbers = true
-			}
-			f := &profile.Function{
-				Name:       frame.Func,
-				SystemName: frame.Func,
-				Filename:   frame.File,
-			}
-			if fp := functions[*f]; fp != nil {
-				f = fp
-			} else {
-				functions[*f] = f
-				f.ID = uint64(len(mt.prof.Function)) + 1
-				mt.prof.Function = append(mt.prof.Function, f)
-			}
-			l.Line[i] = profile.Line{
-				Function: f,
-				Line:     int64(frame.Line),
-			}
+	return nil
+}
+
+// maxWorkers bounds the number of mappings symbolized at once.
+var maxWorkers = runtime.NumCPU()
+
+// parallel calls f(0) to f(n-1), running up to workers calls at once,
+// and returns when all calls have returned.
+func parallel(n, workers int, f func(int)) {
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, workers)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			f(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// setFrames replaces the lines of location l with the frames of stack.
+// functions holds the functions of the profile, to be reused.
+func setFrames(prof *profile.Profile, functions map[profile.Function]*profile.Function, l *profile.Location, stack []plugin.Frame) {
+	m := l.Mapping
+	l.Line = make([]profile.Line, len(stack))
+	l.IsFolded = false
+	for i, frame := range stack {
+		if frame.Func != "" {
+			m.HasFunctions = true
+		}
+		if frame.File != "" {
+			m.HasFilenames = true
+		}
+		if frame.Line != 0 {
+			m.HasLineNumbers = true
+		}
+		f := &profile.Function{
+			Name:       frame.Func,
+			SystemName: frame.Func,
+			Filename:   frame.File,
+		}
+		if fp := functions[*f]; fp != nil {
+			f = fp
+		} else {
+			functions[*f] = f
+			f.ID = uint64(len(prof.Function)) + 1
+			prof.Function = append(prof.Function, f)
+		}
+		l.Line[i] = profile.Line{
+			Function: f,
+			Line:     int64(frame.Line),
 		}
+	}
 
-		if len(stack) > 0 {
-			m.HasInlineFrames = true
+	if len(stack) > 0 {
+		m.HasInlineFrames = true
+	}
+}
+
+// lineFrames returns the frames of the lines of location l.
+func lineFrames(l *profile.Location) []plugin.Frame {
+	frames := make([]plugin.Frame, 0, len(l.Line))
+	for _, ln := range l.Line {
+		if ln.Function == nil {
+			continue
 		}
+		frames = append(frames, plugin.Frame{Func: ln.Function.SystemName, File: ln.Function.Filename, Line: int(ln.Line)})
 	}
+	return frames
+}
 
-	return nil
+// fillFromCache symbolizes the locations of mappings with a build ID
+// from the cache before symbolz is queried, as symbolz only queries
+// locations without lines. It returns the locations left to symbolz.
+func fillFromCache(prof *profile.Profile, force bool, cache *Cache) []*profile.Location {
+	if cache == nil {
+		return nil
+	}
+	functions := make(map[profile.Function]*profile.Function)
+	for _, f := range prof.Function {
+		functions[profile.Function{Name: f.Name, SystemName: f.SystemName, Filename: f.Filename}] = f
+	}
+	// Mappings left with unsymbolized locations must still look
+	// unsymbolized to symbolz.
+	type flags struct{ functions, filenames, lineNumbers, inlineFrames bool }
+	saved := make(map[*profile.Mapping]flags)
+	var missing []*profile.Location
+	for _, l := range prof.Location {
+		m := l.Mapping
+		if m == nil || m.BuildID == "" || l.Address == 0 || len(l.Line) != 0 || m.Unsymbolizable() {
+			continue
+		}
+		f, ok := saved[m]
+		if !ok {
+			if !force && m.HasFunctions {
+				continue
+			}
+			f = flags{m.HasFunctions, m.HasFilenames, m.HasLineNumbers, m.HasInlineFrames}
+			saved[m] = f
+		}
+		if stack, ok := cache.Lookup(m, m.BuildID, l.Address, true); ok {
+			setFrames(prof, functions, l, stack)
+			continue
+		}
+		missing = append(missing, l)
+	}
+	for _, l := range missing {
+		f := saved[l.Mapping]
+		l.Mapping.HasFunctions, l.Mapping.HasFilenames, l.Mapping.HasLineNumbers, l.Mapping.HasInlineFrames = f.functions, f.filenames, f.lineNumbers, f.inlineFrames
+	}
+	return missing
 }
 
-// Demangle updates the function names in a profile with demangled C++
-// names, simplified according to demanglerMode. If force is set,
-// overwrite any names that appear already demangled.
+// Demangle updates the function names in a profile with demangled C++,
+// Rust and Swift names, simplified according to demanglerMode. If force
+// is set, overwrite any names that appear already demangled.
 func Demangle(prof *profile.Profile, force bool, demanglerMode string) {
 	if force {
 		// Remove the current demangled names to force demangling
@@ -236,6 +418,15 @@ func Demangle(prof *profile.Profile, force bool, demanglerMode string) {
 		if fn.Name != "" && fn.SystemName != fn.Name {
 			continue // Already demangled.
 		}
+		// Rust and Swift symbols are told apart by their prefix.
+		if demangled, ok := demangleRust(fn.SystemName, demanglerMode); ok {
+			fn.Name = demangled
+			continue
+		}
+		if demangled, ok := demangleSwift(fn.SystemName, demanglerMode); ok {
+			fn.Name = demangled
+			continue
+		}
 		copy(o, options)
 		if demangled := demangle.Filter(fn.SystemName, o...); demangled != fn.SystemName {
 			fn.Name = demangled
@@ -297,6 +488,7 @@ func newMapping(prof *profile.Profile, obj plugin.ObjTool, ui plugin.UI, force b
 	mt := &mappingTable{
 		prof:     prof,
 		segments: make(map[*profile.Mapping]plugin.ObjFile),
+		missing:  make(map[*profile.Mapping]bool),
 	}
 
 	// Identify used mappings
@@ -342,6 +534,8 @@ func newMapping(prof *profile.Profile, obj plugin.ObjTool, ui plugin.UI, force b
 		if err != nil {
 			ui.PrintErr("Local symbolization failed for ", name, ": ", err)
 			missingBinaries = true
+			// Previous symbolizations may still be cached.
+			mt.missing[m] = m.BuildID != ""
 			continue
 		}
 		if fid := f.BuildID(); m.BuildID != "" && fid != "" && fid != m.BuildID {
@@ -364,6 +558,9 @@ func newMapping(prof *profile.Profile, obj plugin.ObjTool, ui plugin.UI, force b
 type mappingTable struct {
 	prof     *profile.Profile
 	segments map[*profile.Mapping]plugin.ObjFile
+	// missing holds the mappings to symbolize whose binaries could
+	// not be opened.
+	missing map[*profile.Mapping]bool
 }
 
 // Close releases any external processes being used for the mapping.
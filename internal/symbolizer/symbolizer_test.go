@@ -21,8 +21,10 @@ import (
 	"strings"
 	"testing"
 
+	"pproflame/internal/binutils"
 	"pproflame/internal/plugin"
 	"pproflame/internal/proftest"
+	"pproflame/internal/symbolz"
 	"pproflame/profile"
 )
 
@@ -114,8 +116,8 @@ func TestSymbolization(t *testing.T) {
 	}
 
 	s := Symbolizer{
-		mockObjTool{},
-		&proftest.TestUI{T: t},
+		Obj: mockObjTool{},
+		UI:  &proftest.TestUI{T: t},
 	}
 	for i, tc := range []testcase{
 		{
@@ -150,6 +152,10 @@ func TestSymbolization(t *testing.T) {
 			"force:remote",
 			"force:symbolz=[force]",
 		},
+		{
+			"remote:batch=100:retries=0",
+			"symbolz=[batch=100,retries=0]",
+		},
 	} {
 		prof := testProfile.Copy()
 		if err := s.Symbolize(tc.mode, nil, prof); err != nil {
@@ -164,16 +170,19 @@ func TestSymbolization(t *testing.T) {
 	}
 }
 
-func symbolzMock(p *profile.Profile, force bool, sources plugin.MappingSources, syms func(string, string) ([]byte, error), ui plugin.UI) error {
+func symbolzMock(p *profile.Profile, force bool, sources plugin.MappingSources, syms func(string, string) ([]byte, error), ui plugin.UI, o *symbolz.Options) error {
 	var args []string
 	if force {
 		args = append(args, "force")
 	}
+	if d := symbolz.DefaultOptions(); o.BatchSize != d.BatchSize || o.Retries != d.Retries {
+		args = append(args, fmt.Sprintf("batch=%d", o.BatchSize), fmt.Sprintf("retries=%d", o.Retries))
+	}
 	p.Comments = append(p.Comments, "symbolz=["+strings.Join(args, ",")+"]")
 	return nil
 }
 
-func localMock(p *profile.Profile, fast, force bool, obj plugin.ObjTool, ui plugin.UI) error {
+func localMock(p *profile.Profile, fast, force bool, obj plugin.ObjTool, ui plugin.UI, cache *Cache) error {
 	var args []string
 	if fast {
 		args = append(args, "fast")
@@ -205,7 +214,7 @@ func TestLocalSymbolization(t *testing.T) {
 	}
 
 	b := mockObjTool{}
-	if err := localSymbolize(prof, false, false, b, &proftest.TestUI{T: t}); err != nil {
+	if err := localSymbolize(prof, false, false, b, &proftest.TestUI{T: t, AllowRx: "^Symbolized "}, nil); err != nil {
 		t.Fatalf("localSymbolize(): %v", err)
 	}
 
@@ -298,3 +307,86 @@ func (mockObjFile) Symbols(r *regexp.Regexp, addr uint64) ([]*plugin.Sym, error)
 func (mockObjFile) Close() error {
 	return nil
 }
+
+func TestLocalSymbolizationParallel(t *testing.T) {
+	// Many mappings of the same mock binary, symbolized in parallel,
+	// yield the same profile every time.
+	var want string
+	for run := 0; run < 5; run++ {
+		prof := testProfile.Copy()
+		for i := 1; i < 10; i++ {
+			m := &profile.Mapping{ID: uint64(i + 1), Start: 0x1000, Limit: 0x5000, File: fmt.Sprintf("mapping%d", i)}
+			prof.Mapping = append(prof.Mapping, m)
+			for _, l := range testL {
+				prof.Location = append(prof.Location, &profile.Location{ID: uint64(len(prof.Location) + 1), Mapping: m, Address: l.Address})
+			}
+		}
+		if err := localSymbolize(prof, false, false, mockObjTool{}, &proftest.TestUI{T: t, AllowRx: "^Symbolized "}, nil); err != nil {
+			t.Fatalf("localSymbolize(): %v", err)
+		}
+		for _, loc := range prof.Location {
+			if err := checkSymbolizedLocation(loc.Address, loc.Line); err != nil {
+				t.Fatalf("location %d: %v", loc.ID, err)
+			}
+		}
+		if got := prof.String(); run == 0 {
+			want = got
+		} else if got != want {
+			t.Fatalf("run %d: profile differs from the first run", run)
+		}
+	}
+}
+
+func TestNativeModePerCall(t *testing.T) {
+	lSym := localSymbolize
+	defer func() {
+		localSymbolize = lSym
+		demangleFunction = Demangle
+	}()
+	localSymbolize = localMock
+	demangleFunction = demangleMock
+
+	// The native mode of the shared object tool follows each call.
+	bu := &binutils.Binutils{}
+	s := Symbolizer{Obj: bu, UI: &proftest.TestUI{T: t}}
+	for _, tc := range []struct {
+		mode string
+		want string
+	}{
+		{"native", "native=true"},
+		{"local", "native=false"},
+	} {
+		if err := s.Symbolize(tc.mode, nil, testProfile.Copy()); err != nil {
+			t.Fatalf("Symbolize(%q): %v", tc.mode, err)
+		}
+		if got := bu.String(); !strings.Contains(got, tc.want) {
+			t.Errorf("Symbolize(%q): got %s, want %s", tc.mode, got, tc.want)
+		}
+	}
+}
+
+func TestDemangle(t *testing.T) {
+	rust := "_ZN4core3ptr13drop_in_place17h6d7e4a1c2b3f5e8aE"
+	swift := "$s4main5helloyyF"
+	for _, tc := range []struct {
+		mode string
+		want []string
+	}{
+		{"", []string{"core::ptr::drop_in_place", "main.hello"}},
+		{"full", []string{"core::ptr::drop_in_place::h6d7e4a1c2b3f5e8a", "main.hello() -> ()"}},
+		{"none", []string{rust, swift}},
+	} {
+		p := &profile.Profile{
+			Function: []*profile.Function{
+				{ID: 1, Name: rust, SystemName: rust},
+				{ID: 2, Name: swift, SystemName: swift},
+			},
+		}
+		Demangle(p, false, tc.mode)
+		for i, fn := range p.Function {
+			if fn.Name != tc.want[i] {
+				t.Errorf("Demangle(%q) of %s: got %q, want %q", tc.mode, fn.SystemName, fn.Name, tc.want[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,414 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package symbolstore implements a store of binaries and debug files
+// indexed by their GNU build ID. Files are laid out as in debuginfod,
+// under buildid/<build id>/executable or buildid/<build id>/debuginfo,
+// so the store can be served to and filled from debuginfod servers.
+// The store also keeps the symbol tables of kernels, read from
+// /proc/kallsyms, under buildid/<build id>/kallsyms, or under
+// snapshot/<snapshot id>/kallsyms for kernels without a build ID.
+package symbolstore
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"pproflame/internal/elfexec"
+)
+
+// Kinds of files in the store.
+const (
+	Executable = "executable"
+	DebugInfo  = "debuginfo"
+	// Kallsyms files have no build ID of their own, and are added
+	// under the build ID of the kernel they describe, or under the ID
+	// of the snapshot of profiles collected on it.
+	Kallsyms = "kallsyms"
+)
+
+// Store is a directory of binaries and debug files indexed by build
+// ID, backed by a list of remote debuginfod servers.
+type Store struct {
+	// Dir is the local directory holding the store.
+	Dir string
+	// URLs are the base URLs of debuginfod servers to fetch missing
+	// files from. Fetched files are kept in Dir.
+	URLs []string
+	// Client is used to fetch files from URLs. If nil, a client with
+	// a one minute timeout is used.
+	Client *http.Client
+
+	// UploadTokens are the bearer tokens that allow adding files
+	// through ServeHTTP. If empty, files cannot be added through it.
+	UploadTokens []string
+	// MaxUpload is the size limit of the files added through
+	// ServeHTTP, in bytes. If zero, DefaultMaxUpload is used.
+	MaxUpload int64
+}
+
+// DefaultMaxUpload is the default size limit of uploaded files.
+const DefaultMaxUpload = 1 << 30
+
+// FromEnv returns the store selected by the environment. The local
+// directory is $PPROF_SYMBOL_STORE, by default $HOME/pprof/symbols, and
+// the remote servers are listed in $DEBUGINFOD_URLS, separated by
+// spaces.
+func FromEnv() *Store {
+	dir := os.Getenv("PPROF_SYMBOL_STORE")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		dir = filepath.Join(home, "pprof", "symbols")
+	}
+	return &Store{
+		Dir:  dir,
+		URLs: strings.Fields(os.Getenv("DEBUGINFOD_URLS")),
+	}
+}
+
+var buildIDRx = regexp.MustCompile(`^[0-9a-f]{2,}$`)
+
+// IsBuildID reports whether s has the form of a hex-encoded build ID.
+func IsBuildID(s string) bool {
+	return buildIDRx.MatchString(s)
+}
+
+var snapshotIDRx = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// IsSnapshotID reports whether s is a valid snapshot ID: up to 128
+// letters, digits, dots, dashes and underscores, not starting with a
+// dot, dash or underscore.
+func IsSnapshotID(s string) bool {
+	return snapshotIDRx.MatchString(s)
+}
+
+// path returns the location of a file in the store.
+func (s *Store) path(buildID, kind string) string {
+	return filepath.Join(s.Dir, "buildid", buildID, kind)
+}
+
+// Lookup returns the local path of the executable with the given build
+// ID, or of its debug file if the store has no executable. Files missing
+// from the local directory are fetched from the remote servers.
+func (s *Store) Lookup(buildID string) (string, error) {
+	if s == nil {
+		return "", fmt.Errorf("no symbol store")
+	}
+	buildID = strings.ToLower(buildID)
+	if !IsBuildID(buildID) {
+		return "", fmt.Errorf("invalid build id %q", buildID)
+	}
+	for _, kind := range []string{Executable, DebugInfo} {
+		if p := s.path(buildID, kind); fileExists(p) {
+			return p, nil
+		}
+	}
+	var errs []string
+	for _, u := range s.URLs {
+		for _, kind := range []string{Executable, DebugInfo} {
+			p, err := s.fetch(u, buildID, kind)
+			if err == nil {
+				return p, nil
+			}
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return "", fmt.Errorf("build id %s not found: %s", buildID, strings.Join(errs, "; "))
+	}
+	return "", fmt.Errorf("build id %s not found in %s", buildID, s.Dir)
+}
+
+// fetch downloads a file from a debuginfod server into the store.
+func (s *Store) fetch(baseURL, buildID, kind string) (string, error) {
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: time.Minute}
+	}
+	u := strings.TrimSuffix(baseURL, "/") + "/buildid/" + buildID + "/" + kind
+	resp, err := client.Get(u)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	id, err := s.add(resp.Body, kind, buildID)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", u, err)
+	}
+	return s.path(id, kind), nil
+}
+
+// Add copies a binary or debug file into the store, indexed by its
+// build ID, and returns the build ID.
+func (s *Store) Add(r io.Reader, kind string) (string, error) {
+	return s.add(r, kind, "")
+}
+
+// add is Add, rejecting the file without storing it if want is set and
+// differs from its build ID.
+func (s *Store) add(r io.Reader, kind, want string) (string, error) {
+	if kind != Executable && kind != DebugInfo {
+		return "", fmt.Errorf("unknown file kind %q", kind)
+	}
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return "", err
+	}
+	tmp, err := ioutil.TempFile(s.Dir, "upload")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	var id []byte
+	if err == nil {
+		id, err = elfexec.GetBuildID(tmp)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	if id == nil {
+		return "", fmt.Errorf("file has no build id")
+	}
+
+	buildID := fmt.Sprintf("%x", id)
+	if want != "" && buildID != want {
+		return "", fmt.Errorf("got build id %s", buildID)
+	}
+	dst := s.path(buildID, kind)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return "", err
+	}
+	return buildID, os.Rename(tmp.Name(), dst)
+}
+
+// AddKallsyms copies the symbol table of the kernel with the given build
+// ID, in the format of /proc/kallsyms, into the store.
+func (s *Store) AddKallsyms(r io.Reader, buildID string) error {
+	buildID = strings.ToLower(buildID)
+	if !IsBuildID(buildID) {
+		return fmt.Errorf("invalid build id %q", buildID)
+	}
+	return addKallsyms(r, s.path(buildID, Kallsyms))
+}
+
+// AddSnapshotKallsyms copies the symbol table of the kernel the profiles
+// of the given snapshot were collected on, in the format of
+// /proc/kallsyms, into the store.
+func (s *Store) AddSnapshotKallsyms(r io.Reader, snapshot string) error {
+	if !IsSnapshotID(snapshot) {
+		return fmt.Errorf("invalid snapshot id %q", snapshot)
+	}
+	return addKallsyms(r, s.snapshotPath(snapshot))
+}
+
+// snapshotPath returns the location of the kallsyms file of a snapshot.
+func (s *Store) snapshotPath(snapshot string) string {
+	return filepath.Join(s.Dir, "snapshot", snapshot, Kallsyms)
+}
+
+// addKallsyms copies a kallsyms file to dst.
+func addKallsyms(r io.Reader, dst string) error {
+	br := bufio.NewReader(r)
+	line, err := br.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if !kallsymsRx.MatchString(line) {
+		return fmt.Errorf("not a kallsyms file")
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), "upload")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, io.MultiReader(strings.NewReader(line), br))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+var kallsymsRx = regexp.MustCompile(`^[0-9a-fA-F]+ [a-zA-Z] \S`)
+
+// LookupKallsyms returns the local path of the symbol table of the
+// kernel with the given build ID. Symbol tables are not fetched from
+// the remote servers, which do not serve them.
+func (s *Store) LookupKallsyms(buildID string) (string, error) {
+	if s == nil {
+		return "", fmt.Errorf("no symbol store")
+	}
+	buildID = strings.ToLower(buildID)
+	if !IsBuildID(buildID) {
+		return "", fmt.Errorf("invalid build id %q", buildID)
+	}
+	if p := s.path(buildID, Kallsyms); fileExists(p) {
+		return p, nil
+	}
+	return "", fmt.Errorf("no kallsyms for build id %s in %s", buildID, s.Dir)
+}
+
+// LookupSnapshotKallsyms returns the local path of the symbol table of
+// the kernel the profiles of the given snapshot were collected on.
+func (s *Store) LookupSnapshotKallsyms(snapshot string) (string, error) {
+	if s == nil {
+		return "", fmt.Errorf("no symbol store")
+	}
+	if !IsSnapshotID(snapshot) {
+		return "", fmt.Errorf("invalid snapshot id %q", snapshot)
+	}
+	if p := s.snapshotPath(snapshot); fileExists(p) {
+		return p, nil
+	}
+	return "", fmt.Errorf("no kallsyms for snapshot %s in %s", snapshot, s.Dir)
+}
+
+// AddFile copies the named binary or debug file into the store.
+func (s *Store) AddFile(name, kind string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return s.Add(f, kind)
+}
+
+// authorized reports whether the request carries one of the upload
+// tokens of the store, as a bearer token.
+func (s *Store) authorized(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := []byte(strings.TrimPrefix(auth, "Bearer "))
+	for _, t := range s.UploadTokens {
+		if t != "" && subtle.ConstantTimeCompare([]byte(t), token) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// ServeHTTP serves the local files of the store with the debuginfod
+// URL layout, as GET <prefix>/buildid/<build id>/<kind>. A POST to
+// <prefix>/buildid adds the request body to the store, as an executable
+// unless the type parameter is debuginfo, and responds with its build
+// ID. Kallsyms files are posted with type kallsyms and the build ID of
+// their kernel in the buildid parameter, or the ID of their snapshot in
+// the snapshot parameter. Posts must carry one of the
+// UploadTokens as a bearer token, and bodies over MaxUpload are
+// rejected.
+func (s *Store) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	i := strings.LastIndex(r.URL.Path, "/buildid")
+	if i < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path[i+len("/buildid"):], "/"), "/")
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		parts[0] = strings.ToLower(parts[0])
+		if len(parts) != 2 || !IsBuildID(parts[0]) || (parts[1] != Executable && parts[1] != DebugInfo && parts[1] != Kallsyms) {
+			http.NotFound(w, r)
+			return
+		}
+		p := s.path(parts[0], parts[1])
+		if !fileExists(p) {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, p)
+	case http.MethodPost, http.MethodPut:
+		if len(parts) != 1 || parts[0] != "" {
+			http.NotFound(w, r)
+			return
+		}
+		if !s.authorized(r) {
+			http.Error(w, "missing or invalid upload token", http.StatusUnauthorized)
+			return
+		}
+		max := s.MaxUpload
+		if max == 0 {
+			max = DefaultMaxUpload
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, max)
+		kind := r.URL.Query().Get("type")
+		if kind == "" {
+			kind = Executable
+		}
+		if kind == Kallsyms {
+			if snapshot := r.URL.Query().Get("snapshot"); snapshot != "" {
+				if err := s.AddSnapshotKallsyms(r.Body, snapshot); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				fmt.Fprintln(w, snapshot)
+				return
+			}
+			id := r.URL.Query().Get("buildid")
+			if err := s.AddKallsyms(r.Body, id); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			fmt.Fprintln(w, strings.ToLower(id))
+			return
+		}
+		id, err := s.Add(r.Body, kind)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintln(w, id)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func fileExists(name string) bool {
+	fi, err := os.Stat(name)
+	return err == nil && fi.Mode().IsRegular()
+}
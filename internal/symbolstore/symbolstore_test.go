@@ -0,0 +1,237 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package symbolstore
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	testExe     = "../binutils/testdata/exe_linux_64"
+	testBuildID = "910b52eaddce54ae8bbeb49f93c04ded113fcf4d"
+)
+
+func tempStore(t *testing.T) (*Store, func()) {
+	dir, err := ioutil.TempDir("", "symbolstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Store{Dir: dir, UploadTokens: []string{testToken}}, func() { os.RemoveAll(dir) }
+}
+
+const testToken = "secret"
+
+// post uploads body to url with the given bearer token.
+func post(t *testing.T, url, token string, body io.Reader) *http.Response {
+	req, err := http.NewRequest(http.MethodPost, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestAddLookup(t *testing.T) {
+	s, cleanup := tempStore(t)
+	defer cleanup()
+
+	if _, err := s.Lookup(testBuildID); err == nil {
+		t.Fatalf("Lookup in empty store: want error")
+	}
+	id, err := s.AddFile(testExe, DebugInfo)
+	if err != nil {
+		t.Fatalf("AddFile: %v", err)
+	}
+	if id != testBuildID {
+		t.Errorf("AddFile: got build id %s, want %s", id, testBuildID)
+	}
+	path, err := s.Lookup(strings.ToUpper(testBuildID))
+	if err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+	if want := filepath.Join(s.Dir, "buildid", testBuildID, DebugInfo); path != want {
+		t.Errorf("Lookup: got %s, want %s", path, want)
+	}
+
+	if _, err := s.Add(strings.NewReader("not an ELF file"), Executable); err == nil {
+		t.Errorf("Add of a non-ELF file: want error")
+	}
+	if _, err := s.Lookup("../../etc"); err == nil {
+		t.Errorf("Lookup of an invalid build id: want error")
+	}
+}
+
+func TestServeAndFetch(t *testing.T) {
+	server, cleanupServer := tempStore(t)
+	defer cleanupServer()
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	exe, err := ioutil.ReadFile(testExe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := post(t, ts.URL+"/buildid", testToken, bytes.NewReader(exe))
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || strings.TrimSpace(string(body)) != testBuildID {
+		t.Fatalf("upload: got %s %q", resp.Status, body)
+	}
+	resp, err = http.Get(ts.URL + "/buildid/" + strings.ToUpper(testBuildID) + "/" + Executable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET upper-case build id: got %s, want 200", resp.Status)
+	}
+	resp, err = http.Get(ts.URL + "/buildid/" + testBuildID + "/" + DebugInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET missing debuginfo: got %s, want 404", resp.Status)
+	}
+
+	client, cleanupClient := tempStore(t)
+	defer cleanupClient()
+	client.URLs = []string{ts.URL}
+	path, err := client.Lookup(testBuildID)
+	if err != nil {
+		t.Fatalf("Lookup through server: %v", err)
+	}
+	got, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, exe) {
+		t.Errorf("fetched executable differs from the uploaded one")
+	}
+
+	// A server answering with the file of another build ID does not
+	// fill the store.
+	wrong := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(exe)
+	}))
+	defer wrong.Close()
+	other, cleanupOther := tempStore(t)
+	defer cleanupOther()
+	other.URLs = []string{wrong.URL}
+	if _, err := other.Lookup("0123456789abcdef"); err == nil {
+		t.Errorf("Lookup of a mismatched download: want error")
+	}
+	if _, err := os.Stat(other.path(testBuildID, Executable)); err == nil {
+		t.Errorf("mismatched download added to the store")
+	}
+}
+
+func TestKallsyms(t *testing.T) {
+	s, cleanup := tempStore(t)
+	defer cleanup()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	const kallsyms = "ffffffff81000000 T _stext\nffffffff81001000 t do_one_initcall\n"
+	resp := post(t, ts.URL+"/buildid?type=kallsyms&buildid=ABCD1234", testToken, strings.NewReader(kallsyms))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST kallsyms: %s", resp.Status)
+	}
+	path, err := s.LookupKallsyms("abcd1234")
+	if err != nil {
+		t.Fatalf("LookupKallsyms: %v", err)
+	}
+	if got, err := ioutil.ReadFile(path); err != nil || string(got) != kallsyms {
+		t.Errorf("stored kallsyms: got %q, %v; want %q", got, err, kallsyms)
+	}
+
+	for _, tc := range []struct{ body, buildID string }{
+		{"not a kallsyms file", "abcd1234"},
+		{kallsyms, "../etc"},
+	} {
+		if err := s.AddKallsyms(strings.NewReader(tc.body), tc.buildID); err == nil {
+			t.Errorf("AddKallsyms(%q, %q): want error", tc.body, tc.buildID)
+		}
+	}
+	if _, err := s.LookupKallsyms("ffff"); err == nil {
+		t.Errorf("LookupKallsyms of a missing build id: want error")
+	}
+
+	// Kernels without a build ID are stored by snapshot ID.
+	resp = post(t, ts.URL+"/buildid?type=kallsyms&snapshot=host1-20191021", testToken, strings.NewReader(kallsyms))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST kallsyms by snapshot: %s", resp.Status)
+	}
+	path, err = s.LookupSnapshotKallsyms("host1-20191021")
+	if err != nil {
+		t.Fatalf("LookupSnapshotKallsyms: %v", err)
+	}
+	if got, err := ioutil.ReadFile(path); err != nil || string(got) != kallsyms {
+		t.Errorf("stored snapshot kallsyms: got %q, %v; want %q", got, err, kallsyms)
+	}
+	if err := s.AddSnapshotKallsyms(strings.NewReader(kallsyms), "../etc"); err == nil {
+		t.Errorf("AddSnapshotKallsyms of an invalid snapshot id: want error")
+	}
+	if _, err := s.LookupSnapshotKallsyms("host2"); err == nil {
+		t.Errorf("LookupSnapshotKallsyms of a missing snapshot: want error")
+	}
+}
+
+func TestUploadRestrictions(t *testing.T) {
+	s, cleanup := tempStore(t)
+	defer cleanup()
+	s.MaxUpload = 1024
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	exe, err := ioutil.ReadFile(testExe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"wrong token", "guess", http.StatusUnauthorized},
+		{"too large", testToken, http.StatusBadRequest},
+	} {
+		resp := post(t, ts.URL+"/buildid", tc.token, bytes.NewReader(exe))
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Errorf("%s: got %s, want %d", tc.name, resp.Status, tc.want)
+		}
+	}
+	if _, err := s.Lookup(testBuildID); err == nil {
+		t.Errorf("rejected upload added to the store")
+	}
+}
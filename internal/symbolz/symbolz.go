@@ -20,11 +20,14 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"net"
 	"net/url"
 	"path"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"pproflame/internal/plugin"
 	"pproflame/profile"
@@ -34,13 +37,51 @@ var (
 	symbolzRE = regexp.MustCompile(`(0x[[:xdigit:]]+)\s+(.*)`)
 )
 
+// Options controls how symbolz handlers are queried.
+type Options struct {
+	// BatchSize is the maximum number of addresses sent in a request.
+	// If not positive, all the addresses of a mapping are sent at once.
+	BatchSize int
+	// Retries is the number of times a request is retried after a
+	// timeout or a server error. Other failures are not retried.
+	Retries int
+	// Backoff is the delay before the first retry of a request, doubled
+	// for each later retry.
+	Backoff time.Duration
+}
+
+// Defaults for the Options.
+const (
+	DefaultBatchSize = 1000
+	DefaultRetries   = 3
+	DefaultBackoff   = 250 * time.Millisecond
+)
+
+// DefaultOptions returns the Options used when none are given.
+func DefaultOptions() *Options {
+	return &Options{BatchSize: DefaultBatchSize, Retries: DefaultRetries, Backoff: DefaultBackoff}
+}
+
 // Symbolize symbolizes profile p by parsing data returned by a symbolz
 // handler. syms receives the symbolz query (hex addresses separated by '+')
 // and returns the symbolz output in a string. If force is false, it will only
 // symbolize locations from mappings not already marked as HasFunctions. Never
 // attempts symbolization of addresses from unsymbolizable system
 // mappings as those may look negative - e.g. "[vsyscall]".
-func Symbolize(p *profile.Profile, force bool, sources plugin.MappingSources, syms func(string, string) ([]byte, error), ui plugin.UI) error {
+//
+// Mappings are queried in parallel, up to maxRequests at once, and the
+// results are added to the profile in mapping order. The addresses of a
+// mapping are sent in batches, as set by o, and failed requests are
+// retried. Symbolize succeeds if any request does; the addresses left
+// unresolved are recorded in the profile comments, with a summary of
+// the requests, and their mappings are not marked as HasFunctions so
+// that they are queried again by later symbolizations. If o is nil,
+// DefaultOptions are used.
+func Symbolize(p *profile.Profile, force bool, sources plugin.MappingSources, syms func(string, string) ([]byte, error), ui plugin.UI, o *Options) error {
+	if o == nil {
+		o = DefaultOptions()
+	}
+	var queries []*query
 	for _, m := range p.Mapping {
 		if !force && m.HasFunctions {
 			// Only check for HasFunctions as symbolz only populates function names.
@@ -56,82 +97,171 @@ func Symbolize(p *profile.Profile, force bool, sources plugin.MappingSources, sy
 		}
 		for _, source := range mappingSources {
 			if symz := symbolz(source.Source); symz != "" {
-				if err := symbolizeMapping(symz, int64(source.Start)-int64(m.Start), syms, m, p); err != nil {
-					return err
-				}
-				m.HasFunctions = true
+				queries = append(queries, &query{m: m, source: symz, offset: int64(source.Start) - int64(m.Start)})
 				break
 			}
 		}
 	}
 
-	return nil
-}
-
-// Check whether path ends with one of the suffixes listed in
-// pprof_remote_servers.html from the gperftools distribution
-func hasGperftoolsSuffix(path string) bool {
-	suffixes := []string{
-		"/pprof/heap",
-		"/pprof/growth",
-		"/pprof/profile",
-		"/pprof/pmuprofile",
-		"/pprof/contention",
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxRequests)
+	for _, q := range queries {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(q *query) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			start := time.Now()
+			q.run(syms, o, p)
+			q.elapsed = time.Since(start)
+		}(q)
 	}
-	for _, s := range suffixes {
-		if strings.HasSuffix(path, s) {
-			return true
-		}
-	}
-	return false
-}
+	wg.Wait()
 
-// symbolz returns the corresponding symbolz source for a profile URL.
-func symbolz(source string) string {
-	if url, err := url.Parse(source); err == nil && url.Host != "" {
-		// All paths in the net/http/pprof Go package contain /debug/pprof/
-		if strings.Contains(url.Path, "/debug/pprof/") || hasGperftoolsSuffix(url.Path) {
-			url.Path = path.Clean(url.Path + "/../symbol")
+	var addresses, resolved, requests, failed int
+	var unresolved []string
+	var lastErr error
+	for _, q := range queries {
+		if q.err != nil {
+			return q.err
+		}
+		if len(q.addrs) == 0 {
+			// No addresses to symbolize.
+			q.m.HasFunctions = true
+			continue
+		}
+		if ui != nil {
+			ui.PrintErr(fmt.Sprintf("Symbolized %s with symbolz: %d of %d addresses in %v", mappingName(q.m), len(q.names), len(q.addrs), q.elapsed.Round(time.Millisecond)))
+		}
+		applyNames(q.names, q.m, p)
+		if q.failed == 0 {
+			q.m.HasFunctions = true
 		} else {
-			url.Path = "/symbolz"
+			lastErr = q.lastErr
 		}
-		url.RawQuery = ""
-		return url.String()
+		addresses += len(q.addrs)
+		resolved += len(q.names)
+		requests += q.requests
+		failed += q.failed
+		if missing := q.unresolved(); len(missing) > 0 {
+			unresolved = append(unresolved, fmt.Sprintf("symbolz: %d unresolved addresses in %s: %s", len(missing), mappingName(q.m), abbreviate(missing, 10)))
+		}
+	}
+	if requests == 0 {
+		return nil
+	}
+	if failed == requests {
+		return fmt.Errorf("symbolz: all %d requests failed: %v", requests, lastErr)
+	}
+	summary := fmt.Sprintf("symbolz: resolved %d of %d addresses in %d requests", resolved, addresses, requests)
+	if failed > 0 {
+		summary += fmt.Sprintf(", %d failed: %v", failed, lastErr)
 	}
+	p.Comments = append(p.Comments, summary)
+	p.Comments = append(p.Comments, unresolved...)
+	return nil
+}
 
-	return ""
+// maxRequests bounds the number of mappings queried at once.
+var maxRequests = 8
+
+// query is the symbolization of a mapping through a symbolz handler.
+type query struct {
+	m      *profile.Mapping
+	source string
+	offset int64
+
+	addrs    []uint64          // addresses to symbolize
+	names    map[uint64]string // function names, by address
+	requests int               // requests sent
+	failed   int               // requests failed after all retries
+	lastErr  error             // error of the last failed request
+	err      error             // error aborting the symbolization
+	elapsed  time.Duration     // time taken by the requests
 }
 
-// symbolizeMapping symbolizes locations belonging to a Mapping by querying
-// a symbolz handler. An offset is applied to all addresses to take care of
-// normalization occurred for merged Mappings.
-func symbolizeMapping(source string, offset int64, syms func(string, string) ([]byte, error), m *profile.Mapping, p *profile.Profile) error {
+// run queries the symbolz handler for the names of the locations
+// belonging to the mapping, in batches. An offset is applied to all
+// addresses to take care of normalization occurred for merged
+// Mappings.
+func (q *query) run(syms func(string, string) ([]byte, error), o *Options, p *profile.Profile) {
 	// Construct query of addresses to symbolize.
 	var a []string
 	for _, l := range p.Location {
-		if l.Mapping == m && l.Address != 0 && len(l.Line) == 0 {
+		if l.Mapping == q.m && l.Address != 0 && len(l.Line) == 0 {
 			// Compensate for normalization.
-			addr := int64(l.Address) + offset
+			addr := int64(l.Address) + q.offset
 			if addr < 0 {
-				return fmt.Errorf("unexpected negative adjusted address, mapping %v source %d, offset %d", l.Mapping, l.Address, offset)
+				q.err = fmt.Errorf("unexpected negative adjusted address, mapping %v source %d, offset %d", l.Mapping, l.Address, q.offset)
+				return
 			}
+			q.addrs = append(q.addrs, l.Address)
 			a = append(a, fmt.Sprintf("%#x", addr))
 		}
 	}
 
-	if len(a) == 0 {
-		// No addresses to symbolize.
-		return nil
+	q.names = make(map[uint64]string)
+	for len(a) > 0 {
+		batch := a
+		if o.BatchSize > 0 && len(batch) > o.BatchSize {
+			batch = a[:o.BatchSize]
+		}
+		a = a[len(batch):]
+
+		q.requests++
+		b, err := post(syms, q.source, strings.Join(batch, "+"), o)
+		if err == nil {
+			err = parseSymbolz(b, q.offset, q.names)
+		}
+		if err != nil {
+			q.failed++
+			q.lastErr = err
+		}
 	}
+}
 
-	lines := make(map[uint64]profile.Line)
-	functions := make(map[string]*profile.Function)
+// StatusError is the error of a request that a symbolz handler
+// answered with an HTTP status other than OK.
+type StatusError struct {
+	StatusCode int
+	Err        error
+}
 
-	b, err := syms(source, strings.Join(a, "+"))
-	if err != nil {
-		return err
+func (e *StatusError) Error() string {
+	return e.Err.Error()
+}
+
+// transient reports whether a failed request may succeed if retried,
+// as for timeouts and server errors.
+func transient(err error) bool {
+	switch err := err.(type) {
+	case *StatusError:
+		return err.StatusCode >= 500
+	case net.Error:
+		return err.Timeout()
+	}
+	return false
+}
+
+// post sends a query to a symbolz handler, retrying the requests that
+// failed with a transient error with exponential backoff.
+func post(syms func(string, string) ([]byte, error), source, query string, o *Options) ([]byte, error) {
+	delay := o.Backoff
+	for retry := 0; ; retry++ {
+		b, err := syms(source, query)
+		if err == nil || retry >= o.Retries || !transient(err) {
+			return b, err
+		}
+		time.Sleep(delay)
+		delay *= 2
 	}
+}
 
+// parseSymbolz adds the function names in the output of a symbolz
+// handler to names, by profile address.
+func parseSymbolz(b []byte, offset int64, names map[uint64]string) error {
 	buf := bytes.NewBuffer(b)
 	for {
 		l, err := buf.ReadString('\n')
@@ -154,30 +284,98 @@ func symbolizeMapping(source string, offset int64, syms func(string, string) ([]
 			// Reapply offset expected by the profile.
 			addr -= offset
 
-			name := symbol[2]
-			fn := functions[name]
-			if fn == nil {
-				fn = &profile.Function{
-					ID:         uint64(len(p.Function) + 1),
-					Name:       name,
-					SystemName: name,
-				}
-				functions[name] = fn
-				p.Function = append(p.Function, fn)
-			}
+			names[uint64(addr)] = symbol[2]
+		}
+	}
+	return nil
+}
 
-			lines[uint64(addr)] = profile.Line{Function: fn}
+// unresolved returns the addresses left without a name, in hex.
+func (q *query) unresolved() []string {
+	var missing []string
+	for _, a := range q.addrs {
+		if _, ok := q.names[a]; !ok {
+			missing = append(missing, fmt.Sprintf("%#x", a))
 		}
 	}
+	return missing
+}
+
+// abbreviate joins the first n elements of s, followed by the count of
+// the others.
+func abbreviate(s []string, n int) string {
+	if len(s) <= n {
+		return strings.Join(s, " ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(s[:n], " "), len(s)-n)
+}
+
+func mappingName(m *profile.Mapping) string {
+	switch {
+	case m.File != "":
+		return path.Base(m.File)
+	case m.BuildID != "":
+		return "build id " + m.BuildID
+	}
+	return fmt.Sprintf("mapping %d", m.ID)
+}
 
+// Check whether path ends with one of the suffixes listed in
+// pprof_remote_servers.html from the gperftools distribution
+func hasGperftoolsSuffix(path string) bool {
+	suffixes := []string{
+		"/pprof/heap",
+		"/pprof/growth",
+		"/pprof/profile",
+		"/pprof/pmuprofile",
+		"/pprof/contention",
+	}
+	for _, s := range suffixes {
+		if strings.HasSuffix(path, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// symbolz returns the corresponding symbolz source for a profile URL.
+func symbolz(source string) string {
+	if url, err := url.Parse(source); err == nil && url.Host != "" {
+		// All paths in the net/http/pprof Go package contain /debug/pprof/
+		if strings.Contains(url.Path, "/debug/pprof/") || hasGperftoolsSuffix(url.Path) {
+			url.Path = path.Clean(url.Path + "/../symbol")
+		} else {
+			url.Path = "/symbolz"
+		}
+		url.RawQuery = ""
+		return url.String()
+	}
+
+	return ""
+}
+
+// applyNames sets the function names returned by the symbolz handler
+// on the locations belonging to a Mapping.
+func applyNames(names map[uint64]string, m *profile.Mapping, p *profile.Profile) {
+	functions := make(map[string]*profile.Function)
 	for _, l := range p.Location {
 		if l.Mapping != m {
 			continue
 		}
-		if line, ok := lines[l.Address]; ok {
-			l.Line = []profile.Line{line}
+		name, ok := names[l.Address]
+		if !ok {
+			continue
 		}
+		fn := functions[name]
+		if fn == nil {
+			fn = &profile.Function{
+				ID:         uint64(len(p.Function) + 1),
+				Name:       name,
+				SystemName: name,
+			}
+			functions[name] = fn
+			p.Function = append(p.Function, fn)
+		}
+		l.Line = []profile.Line{{Function: fn}}
 	}
-
-	return nil
 }
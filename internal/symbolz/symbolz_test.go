@@ -16,8 +16,12 @@ package symbolz
 
 import (
 	"fmt"
+	"net/http"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"pproflame/internal/plugin"
 	"pproflame/internal/proftest"
@@ -66,17 +70,23 @@ func TestSymbolize(t *testing.T) {
 		for _, force := range []bool{false, true} {
 			p := testProfile(hasFunctions)
 
-			if err := Symbolize(p, force, s, fetchSymbols, &proftest.TestUI{T: t}); err != nil {
+			ui := &proftest.TestUI{T: t, AllowRx: "^Symbolized build id buildid with symbolz: .* in "}
+			if err := Symbolize(p, force, s, fetchSymbols, ui, nil); err != nil {
 				t.Errorf("symbolz: %v", err)
 				continue
 			}
 			var wantSym, wantNoSym []*profile.Location
+			wantTimings := 0
 			if force || !hasFunctions {
 				wantNoSym = p.Location[:1]
 				wantSym = p.Location[1:]
+				wantTimings = 1
 			} else {
 				wantNoSym = p.Location
 			}
+			if ui.NumAllowRxMatches != wantTimings {
+				t.Errorf("symbolz hasFns=%v force=%v: got %d mapping timings, want %d", hasFunctions, force, ui.NumAllowRxMatches, wantTimings)
+			}
 
 			if err := checkSymbolized(wantSym, true); err != nil {
 				t.Errorf("symbolz hasFns=%v force=%v: %v", hasFunctions, force, err)
@@ -139,3 +149,171 @@ func fetchSymbols(source, post string) ([]byte, error) {
 	}
 	return []byte(symbolz), nil
 }
+
+func TestSymbolizeParallel(t *testing.T) {
+	s := plugin.MappingSources{}
+	p := &profile.Profile{}
+	for i := 0; i < 20; i++ {
+		id := fmt.Sprintf("buildid%d", i)
+		m := &profile.Mapping{ID: uint64(i + 1), Start: 0x1000, Limit: 0x5000, BuildID: id}
+		s[id] = append(s[id], struct {
+			Source string
+			Start  uint64
+		}{Source: fmt.Sprintf("http://host%d/profilez", i)})
+		p.Mapping = append(p.Mapping, m)
+		for a := uint64(0x1000); a < 0x5000; a += 0x1000 {
+			p.Location = append(p.Location, &profile.Location{ID: uint64(len(p.Location) + 1), Mapping: m, Address: a})
+		}
+	}
+
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	syms := func(source, post string) ([]byte, error) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		if source == "http://host7/symbolz" {
+			return nil, fmt.Errorf("host7 unavailable")
+		}
+		return fetchSymbols(source, post)
+	}
+	if err := Symbolize(p, false, s, syms, &proftest.TestUI{T: t, AllowRx: "^Symbolized "}, &Options{Retries: 1}); err != nil {
+		t.Errorf("Symbolize: %v", err)
+	}
+	if maxRunning < 2 || maxRunning > maxRequests {
+		t.Errorf("got %d concurrent requests, want between 2 and %d", maxRunning, maxRequests)
+	}
+	// The other mappings are symbolized.
+	for _, m := range p.Mapping {
+		if want := m.ID != 8; m.HasFunctions != want {
+			t.Errorf("mapping %d: got HasFunctions %v, want %v", m.ID, m.HasFunctions, want)
+		}
+	}
+	for i, f := range p.Function {
+		if f.ID != uint64(i+1) {
+			t.Fatalf("function %d has id %d", i, f.ID)
+		}
+	}
+	if err := checkSymbolized(p.Location[1:4], true); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSymbolizeBatches(t *testing.T) {
+	s := plugin.MappingSources{
+		"buildid": []struct {
+			Source string
+			Start  uint64
+		}{
+			{Source: "http://localhost:80/profilez"},
+		},
+	}
+	p := testProfile(false)
+
+	// Two batches of two addresses; the second batch fails once, then
+	// succeeds on retry. The first address of each batch is unknown.
+	var posts []string
+	syms := func(source, post string) ([]byte, error) {
+		posts = append(posts, post)
+		if len(posts) == 2 {
+			return nil, &StatusError{StatusCode: http.StatusServiceUnavailable, Err: fmt.Errorf("server busy")}
+		}
+		return fetchSymbols(source, post)
+	}
+	o := &Options{BatchSize: 2, Retries: 1, Backoff: time.Millisecond}
+	if err := Symbolize(p, false, s, syms, &proftest.TestUI{T: t, AllowRx: "^Symbolized "}, o); err != nil {
+		t.Fatalf("Symbolize: %v", err)
+	}
+	if want := []string{"0x0+0x1000", "0x2000+0x3000", "0x2000+0x3000"}; !reflect.DeepEqual(posts, want) {
+		t.Errorf("got requests %q, want %q", posts, want)
+	}
+	if err := checkSymbolized([]*profile.Location{p.Location[1], p.Location[3]}, true); err != nil {
+		t.Error(err)
+	}
+	if err := checkSymbolized([]*profile.Location{p.Location[0], p.Location[2]}, false); err != nil {
+		t.Error(err)
+	}
+	want := []string{
+		"symbolz: resolved 2 of 4 addresses in 2 requests",
+		"symbolz: 2 unresolved addresses in build id buildid: 0x1000 0x3000",
+	}
+	if !reflect.DeepEqual(p.Comments, want) {
+		t.Errorf("got comments %q, want %q", p.Comments, want)
+	}
+	if !p.Mapping[0].HasFunctions {
+		t.Errorf("mapping not marked as symbolized")
+	}
+
+	// Permanent errors are not retried.
+	p = testProfile(false)
+	posts = nil
+	notFound := func(source, post string) ([]byte, error) {
+		posts = append(posts, post)
+		return nil, &StatusError{StatusCode: http.StatusNotFound, Err: fmt.Errorf("no symbolz handler")}
+	}
+	if err := Symbolize(p, false, s, notFound, &proftest.TestUI{T: t, AllowRx: "^Symbolized "}, &Options{BatchSize: 2, Retries: 3, Backoff: time.Millisecond}); err == nil {
+		t.Errorf("Symbolize: want error when all requests fail")
+	}
+	if len(posts) != 2 {
+		t.Errorf("got %d requests for 2 batches, want no retries", len(posts))
+	}
+
+	// Failed requests leave the mapping to be symbolized again.
+	p = testProfile(false)
+	posts = nil
+	fail := func(source, post string) ([]byte, error) {
+		posts = append(posts, post)
+		if len(posts) == 1 {
+			return nil, fmt.Errorf("server busy")
+		}
+		return fetchSymbols(source, post)
+	}
+	o.Retries = 0
+	if err := Symbolize(p, false, s, fail, &proftest.TestUI{T: t, AllowRx: "^Symbolized "}, o); err != nil {
+		t.Fatalf("Symbolize: %v", err)
+	}
+	if p.Mapping[0].HasFunctions {
+		t.Errorf("mapping with failed requests marked as symbolized")
+	}
+	if got := p.Comments[0]; !strings.Contains(got, "1 failed: server busy") {
+		t.Errorf("got summary %q, want a failed request", got)
+	}
+
+	// Symbolize fails if all requests do.
+	p = testProfile(false)
+	down := func(source, post string) ([]byte, error) {
+		return nil, fmt.Errorf("server down")
+	}
+	if err := Symbolize(p, false, s, down, &proftest.TestUI{T: t, AllowRx: "^Symbolized "}, o); err == nil {
+		t.Errorf("Symbolize: want error when all requests fail")
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestTransient(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{&StatusError{StatusCode: http.StatusBadGateway, Err: fmt.Errorf("bad gateway")}, true},
+		{&StatusError{StatusCode: http.StatusForbidden, Err: fmt.Errorf("forbidden")}, false},
+		{timeoutError{}, true},
+		{fmt.Errorf("malformed symbolz response"), false},
+	} {
+		if got := transient(tc.err); got != tc.want {
+			t.Errorf("transient(%v): got %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
@@ -24,7 +24,7 @@ import (
 	"pproflame/config"
 	"pproflame/driver"
 	internaldriver "pproflame/internal/driver"
-	"strconv"
+	"pproflame/internal/symbolstore"
 	"sync"
 
 	"github.com/gin-gonic/gin"
@@ -47,84 +47,45 @@ func main() {
 
 	router.GET("/", getPProfRoot)
 	router.GET("/top", getPProfTop)
+	router.GET("/top.csv", getPProfTopExport)
+	router.GET("/top.tsv", getPProfTopExport)
 	router.GET("/disasm", getPProfDisasm)
 	router.GET("/source", getPProfSource)
 	router.GET("/peek", getPProfPeek)
 	router.GET("/flamegraph", getPProfFlamegraph)
+	router.GET("/flamegraph.svg", getPProfFlamegraphSVG)
+	router.GET("/graph", getPProfGraph)
+	router.GET("/graph.json", getPProfGraphJSON)
+	router.GET("/sandwich", getPProfSandwich)
+	router.GET("/paths", getPProfPaths)
+	router.GET("/regressions", getPProfRegressions)
+	router.GET("/regressions.json", getPProfRegressionsJSON)
+
+	// 符号仓库, 按 build ID 存放 CI 上传的二进制和调试文件, 路径格式与 debuginfod 一致.
+	// 内核的 kallsyms 文件也按内核 build ID 上传: POST /buildid?type=kallsyms&buildid=<build id>
+	// 没有 build ID 的内核按快照 ID 上传: POST /buildid?type=kallsyms&snapshot=<snapshot id>,
+	// profile 中带有 "snapshot: <snapshot id>" 注释时使用.
+	// 上传需要带上配置的 upload_tokens 之一: Authorization: Bearer <token>
+	if store := symbolstore.FromEnv(); store != nil {
+		store.UploadTokens = config.Config.UploadTokens
+		store.MaxUpload = config.Config.MaxUpload
+		router.GET("/buildid/:id/:kind", gin.WrapH(store))
+		router.POST("/buildid", gin.WrapH(store))
+	}
 
 	router.Run(":" + config.Config.Port)
 }
 
-// getPProfRoot 渲染 ui.Root
-func getPProfRoot(c *gin.Context) {
+// withWebUI 取出服务的 WebInterface 对象交给 render 渲染.
+// 对象不存在时先重新采样, 未指定服务名称时返回 400.
+func withWebUI(c *gin.Context, render func(*internaldriver.WebInterface, *gin.Context)) {
 	serviceName := c.Query("servicename") // 获取服务名称, 对应配置文件的 source(host, port)
 
 	if len(serviceName) == 0 {
 		log.Println("请指定需要采集的服务名称")
 		c.String(http.StatusBadRequest, "请指定需要采集的服务名称")
-	}
-	log.Println("查询服务: ", serviceName)
-
-	seconds, _ := strconv.Atoi(c.Query("seconds"))
-
-	if seconds == 0 {
-		seconds = 30
-	}
-	log.Println("采样时间: ", seconds)
-
-	reset, _ := strconv.Atoi(c.Query("reset")) // 1 表示重置采样, 0 表示不需要重置
-
-	source, err := config.GetServiceSource(serviceName)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "获取服务 pprof 接口错误: %v\n", err)
 		return
 	}
-
-	log.Println("请求源地址: ", c.ClientIP())
-	log.Println("服务名称: ", serviceName, "的 pprof 地址是: ", source)
-	log.Println("是否重置采样: ", reset == 1)
-
-	// 指定服务的 UI 对象已经存在, 直接给 top/disasm/dot/source/peek/flamegraph 复用, 否则重新采样拉取
-	// Load returns the value stored in the map for a key, or nil if no
-	// value is present.
-	reSample := false
-	if value, ok := mapUIObj.Load(serviceName); ok {
-		webUI, valid := value.(*internaldriver.WebInterface)
-		if valid {
-			driver.SMMPProfRoot(webUI, c)
-		} else {
-			reSample = true
-		}
-
-	} else {
-		reSample = true
-	}
-
-	// 重采样
-	if reSample {
-		driver.SMMCleanTempFiles()   // 清临时文件
-		mapUIObj.Delete(serviceName) // 删旧 WebInterface 对象
-
-		// NOTE: 服务不存在则重新采样
-		ui, err := driver.SMMPProf(&driver.Options{}, source, 30)
-		if err != nil {
-			log.Println("采样失败: ", serviceName)
-			return
-		}
-
-		mapUIObj.Store(serviceName, ui)
-		driver.SMMPProfRoot(ui, c)
-	}
-}
-
-// getPProfTop 渲染 ui.Top
-func getPProfTop(c *gin.Context) {
-	serviceName := c.Query("servicename") // 获取服务名称, 对应配置文件的 source(host, port)
-
-	if len(serviceName) == 0 {
-		log.Println("请指定需要采集的服务名称")
-		c.String(400, "请指定需要采集的服务名称")
-	}
 	log.Println("查询服务: ", serviceName)
 
 	source, err := config.GetServiceSource(serviceName)
@@ -139,240 +100,94 @@ func getPProfTop(c *gin.Context) {
 	// 指定服务的 UI 对象已经存在, 直接使用, 否则重新采样拉取
 	// Load returns the value stored in the map for a key, or nil if no
 	// value is present.
-	reSample := false
 	if value, ok := mapUIObj.Load(serviceName); ok {
-		webUI, valid := value.(*internaldriver.WebInterface)
-		if valid {
-			driver.SMMPProfTop(webUI, c)
-		} else {
-			reSample = true
-		}
-
-	} else {
-		reSample = true
-	}
-
-	// 重采样
-	if reSample {
-		driver.SMMCleanTempFiles()   // 清临时文件
-		mapUIObj.Delete(serviceName) // 删旧 WebInterface 对象
-
-		// NOTE: 服务不存在则重新采样
-		ui, err := driver.SMMPProf(&driver.Options{}, source, 30)
-		if err != nil {
-			log.Println("采样失败: ", serviceName)
+		if webUI, valid := value.(*internaldriver.WebInterface); valid {
+			render(webUI, c)
 			return
 		}
-
-		mapUIObj.Store(serviceName, ui)
-		driver.SMMPProfTop(ui, c)
 	}
-}
-
-// getPProfDisasm 渲染 ui.disasm
-func getPProfDisasm(c *gin.Context) {
-	serviceName := c.Query("servicename") // 获取服务名称, 对应配置文件的 source(host, port)
 
-	if len(serviceName) == 0 {
-		log.Println("请指定需要采集的服务名称")
-		c.String(400, "请指定需要采集的服务名称")
-	}
-	log.Println("查询服务: ", serviceName)
+	// 重采样
+	driver.SMMCleanTempFiles()   // 清临时文件
+	mapUIObj.Delete(serviceName) // 删旧 WebInterface 对象
 
-	source, err := config.GetServiceSource(serviceName)
+	// NOTE: 服务不存在则重新采样
+	ui, err := driver.SMMPProf(&driver.Options{}, serviceName, source, 30, config.GetServiceRules(serviceName), config.GetServiceVariables(serviceName), config.GetServiceDiffBase(serviceName))
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "获取服务 pprof 接口错误: %v\n", err)
+		log.Println("采样失败: ", serviceName)
 		return
 	}
 
-	log.Println("请求源地址: ", c.ClientIP())
-	log.Println("服务: ", serviceName, "的 pprof 地址是: ", source)
-
-	// 指定服务的 UI 对象已经存在, 直接使用, 否则重新采样拉取
-	// Load returns the value stored in the map for a key, or nil if no
-	// value is present.
-	reSample := false
-	if value, ok := mapUIObj.Load(serviceName); ok {
-		webUI, valid := value.(*internaldriver.WebInterface)
-		if valid {
-			driver.SMMPProfDisasm(webUI, c)
-		} else {
-			reSample = true
-		}
+	mapUIObj.Store(serviceName, ui)
+	render(ui, c)
+}
 
-	} else {
-		reSample = true
-	}
+// getPProfRoot 渲染 ui.Root
+func getPProfRoot(c *gin.Context) {
+	withWebUI(c, driver.SMMPProfRoot)
+}
 
-	// 重采样
-	if reSample {
-		driver.SMMCleanTempFiles()   // 清临时文件
-		mapUIObj.Delete(serviceName) // 删旧 WebInterface 对象
-
-		// NOTE: 服务不存在则重新采样
-		ui, err := driver.SMMPProf(&driver.Options{}, source, 30)
-		if err != nil {
-			log.Println("采样失败: ", serviceName)
-			return
-		}
+// getPProfTop 渲染 ui.Top
+func getPProfTop(c *gin.Context) {
+	withWebUI(c, driver.SMMPProfTop)
+}
 
-		mapUIObj.Store(serviceName, ui)
-		driver.SMMPProfDisasm(ui, c)
-	}
+// getPProfDisasm 渲染 ui.disasm
+func getPProfDisasm(c *gin.Context) {
+	withWebUI(c, driver.SMMPProfDisasm)
 }
 
 // getPProfSource 渲染 ui.Source
 func getPProfSource(c *gin.Context) {
-	serviceName := c.Query("servicename") // 获取服务名称, 对应配置文件的 source(host, port)
-
-	if len(serviceName) == 0 {
-		log.Println("请指定需要采集的服务名称")
-		c.String(400, "请指定需要采集的服务名称")
-	}
-	log.Println("查询服务: ", serviceName)
-
-	source, err := config.GetServiceSource(serviceName)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "获取服务 pprof 接口错误: %v\n", err)
-		return
-	}
-
-	log.Println("请求源地址: ", c.ClientIP())
-	log.Println("服务: ", serviceName, "的 pprof 地址是: ", source)
-
-	// 指定服务的 UI 对象已经存在, 直接使用, 否则重新采样拉取
-	// Load returns the value stored in the map for a key, or nil if no
-	// value is present.
-	reSample := false
-	if value, ok := mapUIObj.Load(serviceName); ok {
-		webUI, valid := value.(*internaldriver.WebInterface)
-		if valid {
-			driver.SMMPProfSource(webUI, c)
-		} else {
-			reSample = true
-		}
-
-	} else {
-		reSample = true
-	}
-
-	// 重采样
-	if reSample {
-		driver.SMMCleanTempFiles()   // 清临时文件
-		mapUIObj.Delete(serviceName) // 删旧 WebInterface 对象
-
-		// NOTE: 服务不存在则重新采样
-		ui, err := driver.SMMPProf(&driver.Options{}, source, 30)
-		if err != nil {
-			log.Println("采样失败: ", serviceName)
-			return
-		}
-
-		mapUIObj.Store(serviceName, ui)
-		driver.SMMPProfSource(ui, c)
-	}
+	withWebUI(c, driver.SMMPProfSource)
 }
 
 // getPProfPeek 渲染 ui.Peek
 func getPProfPeek(c *gin.Context) {
-	serviceName := c.Query("servicename") // 获取服务名称, 对应配置文件的 source(host, port)
-
-	if len(serviceName) == 0 {
-		log.Println("请指定需要采集的服务名称")
-		c.String(400, "请指定需要采集的服务名称")
-	}
-	log.Println("查询服务: ", serviceName)
-
-	source, err := config.GetServiceSource(serviceName)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "获取服务 pprof 接口错误: %v\n", err)
-		return
-	}
-
-	log.Println("请求源地址: ", c.ClientIP())
-	log.Println("服务: ", serviceName, "的 pprof 地址是: ", source)
-
-	// 指定服务的 UI 对象已经存在, 直接使用, 否则重新采样拉取
-	// Load returns the value stored in the map for a key, or nil if no
-	// value is present.
-	reSample := false
-	if value, ok := mapUIObj.Load(serviceName); ok {
-		webUI, valid := value.(*internaldriver.WebInterface)
-		if valid {
-			driver.SMMPProfPeek(webUI, c)
-		} else {
-			reSample = true
-		}
-
-	} else {
-		reSample = true
-	}
-
-	// 重采样
-	if reSample {
-		driver.SMMCleanTempFiles()   // 清临时文件
-		mapUIObj.Delete(serviceName) // 删旧 WebInterface 对象
-
-		// NOTE: 服务不存在则重新采样
-		ui, err := driver.SMMPProf(&driver.Options{}, source, 30)
-		if err != nil {
-			log.Println("采样失败: ", serviceName)
-			return
-		}
-
-		mapUIObj.Store(serviceName, ui)
-		driver.SMMPProfPeek(ui, c)
-	}
+	withWebUI(c, driver.SMMPProfPeek)
 }
 
 // getPProfFlamegraph 渲染 ui.Flamegraph
 func getPProfFlamegraph(c *gin.Context) {
-	serviceName := c.Query("servicename") // 获取服务名称, 对应配置文件的 source(host, port)
+	withWebUI(c, driver.SMMPProfFlamegraph)
+}
 
-	if len(serviceName) == 0 {
-		log.Println("请指定需要采集的服务名称")
-		c.String(400, "请指定需要采集的服务名称")
-	}
-	log.Println("查询服务: ", serviceName)
+// getPProfGraph 渲染 ui.InteractiveGraph
+func getPProfGraph(c *gin.Context) {
+	withWebUI(c, driver.SMMPProfGraph)
+}
 
-	source, err := config.GetServiceSource(serviceName)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "获取服务 pprof 接口错误: %v\n", err)
-		return
-	}
+// getPProfGraphJSON 返回 ui.GraphJSON
+func getPProfGraphJSON(c *gin.Context) {
+	withWebUI(c, driver.SMMPProfGraphJSON)
+}
 
-	log.Println("请求源地址: ", c.ClientIP())
-	log.Println("服务: ", serviceName, "的 pprof 地址是: ", source)
+// getPProfSandwich 渲染 ui.Sandwich
+func getPProfSandwich(c *gin.Context) {
+	withWebUI(c, driver.SMMPProfSandwich)
+}
 
-	// 指定服务的 UI 对象已经存在, 直接使用, 否则重新采样拉取
-	// Load returns the value stored in the map for a key, or nil if no
-	// value is present.
-	reSample := false
-	if value, ok := mapUIObj.Load(serviceName); ok {
-		webUI, valid := value.(*internaldriver.WebInterface)
-		if valid {
-			driver.SMMPProfFlamegraph(webUI, c)
-		} else {
-			reSample = true
-		}
+// getPProfFlamegraphSVG 返回 ui.FlamegraphSVG
+func getPProfFlamegraphSVG(c *gin.Context) {
+	withWebUI(c, driver.SMMPProfFlamegraphSVG)
+}
 
-	} else {
-		reSample = true
-	}
+// getPProfTopExport 导出 ui.TopExport
+func getPProfTopExport(c *gin.Context) {
+	withWebUI(c, driver.SMMPProfTopExport)
+}
 
-	// 重采样
-	if reSample {
-		driver.SMMCleanTempFiles()   // 清临时文件
-		mapUIObj.Delete(serviceName) // 删旧 WebInterface 对象
-
-		// NOTE: 服务不存在则重新采样
-		ui, err := driver.SMMPProf(&driver.Options{}, source, 30)
-		if err != nil {
-			log.Println("采样失败: ", serviceName)
-			return
-		}
+// getPProfPaths 渲染 ui.Paths
+func getPProfPaths(c *gin.Context) {
+	withWebUI(c, driver.SMMPProfPaths)
+}
 
-		mapUIObj.Store(serviceName, ui)
-		driver.SMMPProfFlamegraph(ui, c)
-	}
+// getPProfRegressions 渲染 ui.Regressions
+func getPProfRegressions(c *gin.Context) {
+	withWebUI(c, driver.SMMPProfRegressions)
+}
+
+// getPProfRegressionsJSON 返回 ui.RegressionsJSON
+func getPProfRegressionsJSON(c *gin.Context) {
+	withWebUI(c, driver.SMMPProfRegressionsJSON)
 }
@@ -0,0 +1,220 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+// Implements aggregation at granularities coarser than functions.
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// AggregatePackages merges the frames of the profile into one per Go
+// package, named after the import path of the package. Frames of
+// symbols that are not Go symbols keep the name of their function, and
+// frames without a function are named after their mapping.
+func (p *Profile) AggregatePackages() error {
+	return p.aggregateFrames(func(l *Location, ln Line) string {
+		if ln.Function == nil || ln.Function.Name == "" {
+			return mappingName(l.Mapping)
+		}
+		return GoPackage(ln.Function.Name)
+	})
+}
+
+// AggregateModules merges the frames of the profile into one per Go
+// module, guessed from the import path of the package of their
+// function by GoModule. Frames without a function are named after
+// their mapping, and those of functions that are not Go symbols after
+// the function.
+func (p *Profile) AggregateModules() error {
+	return p.aggregateFrames(func(l *Location, ln Line) string {
+		if ln.Function == nil || ln.Function.Name == "" {
+			return mappingName(l.Mapping)
+		}
+		return goModuleName(ln.Function.Name)
+	})
+}
+
+// AggregateMappings merges the frames of the profile into one per
+// mapping, named after the base name of the mapped file, such as the
+// binary or a shared object.
+func (p *Profile) AggregateMappings() error {
+	return p.aggregateFrames(func(l *Location, ln Line) string {
+		return mappingName(l.Mapping)
+	})
+}
+
+// aggregateFrames replaces the frames of every sample, inlined ones
+// included, by a frame named by name, merging consecutive frames of the
+// same name. The profile is left with a single location and function
+// per name.
+func (p *Profile) aggregateFrames(name func(*Location, Line) string) error {
+	locations := make(map[string]*Location)
+	p.Location, p.Function = nil, nil
+	for _, s := range p.Sample {
+		var stack []*Location
+		// Locations and their lines are both listed leaf first.
+		for _, l := range s.Location {
+			lines := l.Line
+			if len(lines) == 0 {
+				lines = []Line{{}}
+			}
+			for _, ln := range lines {
+				n := name(l, ln)
+				if len(stack) > 0 && stack[len(stack)-1].Line[0].Function.Name == n {
+					continue
+				}
+				loc := locations[n]
+				if loc == nil {
+					f := &Function{ID: uint64(len(p.Function) + 1), Name: n, SystemName: n}
+					p.Function = append(p.Function, f)
+					loc = &Location{ID: uint64(len(p.Location) + 1), Mapping: l.Mapping, Line: []Line{{Function: f}}}
+					p.Location = append(p.Location, loc)
+					locations[n] = loc
+				}
+				stack = append(stack, loc)
+			}
+		}
+		s.Location = stack
+	}
+	for _, m := range p.Mapping {
+		m.HasInlineFrames = false
+		m.HasFilenames = false
+		m.HasLineNumbers = false
+	}
+	return p.CheckValid()
+}
+
+// mappingName returns the name of the frames of mapping m, when
+// aggregating by mapping.
+func mappingName(m *Mapping) string {
+	if m == nil || m.File == "" {
+		return "unknown"
+	}
+	return filepath.Base(m.File)
+}
+
+// GoPackage returns the import path of the package of the Go symbol
+// name, such as "net/http" for "net/http.(*Server).Serve". Names that
+// are not Go symbols are returned unchanged.
+func GoPackage(name string) string {
+	head := name
+	// Type parameters may be import paths too.
+	if i := strings.IndexByte(head, '['); i >= 0 {
+		head = head[:i]
+	}
+	slash := strings.LastIndexByte(head, '/')
+	dot := strings.IndexByte(head[slash+1:], '.')
+	if dot <= 0 {
+		return name
+	}
+	pkg := head[:slash+1+dot]
+	if strings.ContainsAny(pkg, " ()<>:") {
+		return name
+	}
+	return pkg
+}
+
+// StdModule is the module GoModule returns for the packages of the
+// standard library.
+const StdModule = "std"
+
+// stdRoots are the first elements of the import paths of the packages
+// of the standard library, vendored ones included.
+var stdRoots = []string{
+	"archive", "bufio", "builtin", "bytes", "cmd", "cmp", "compress",
+	"container", "context", "crypto", "database", "debug", "embed",
+	"encoding", "errors", "expvar", "flag", "fmt", "go", "hash", "html",
+	"image", "index", "internal", "io", "iter", "log", "maps", "math",
+	"mime", "net", "os", "path", "plugin", "reflect", "regexp", "runtime",
+	"slices", "sort", "strconv", "strings", "structs", "sync", "syscall",
+	"testing", "text", "time", "unicode", "unique", "unsafe", "vendor",
+	"weak",
+}
+
+// isStdPackage reports whether pkg is a package of the standard
+// library.
+func isStdPackage(pkg string) bool {
+	root := pkg
+	if i := strings.IndexByte(root, '/'); i >= 0 {
+		root = root[:i]
+	}
+	for _, r := range stdRoots {
+		if root == r {
+			return true
+		}
+	}
+	return false
+}
+
+// StdModuleFocus returns a regular expression matching the names of
+// the functions of the packages GoModule groups under StdModule.
+func StdModuleFocus() string {
+	return "^(" + strings.Join(stdRoots, "|") + ")[./]"
+}
+
+// GoModule guesses the path of the Go module of the package pkg from
+// the conventions of module paths: three elements for the code hosting
+// sites and golang.org/x, and two for other domains. The packages of
+// the standard library are all grouped under StdModule. Other packages
+// without a domain in their first element, like those of package main
+// or of a module named "example", are grouped by that element. A major
+// version suffix, as in "example.com/mod/v2", is part of the module.
+func GoModule(pkg string) string {
+	elems := strings.Split(pkg, "/")
+	if !strings.Contains(elems[0], ".") {
+		if isStdPackage(pkg) {
+			return StdModule
+		}
+		return elems[0]
+	}
+	n := 2
+	switch elems[0] {
+	case "github.com", "gitlab.com", "bitbucket.org", "golang.org":
+		n = 3
+	}
+	if n > len(elems) {
+		n = len(elems)
+	}
+	if n < len(elems) && isMajorVersion(elems[n]) {
+		n++
+	}
+	return strings.Join(elems[:n], "/")
+}
+
+// goModuleName returns the Go module of the function name, or name
+// itself if it is not a Go symbol.
+func goModuleName(name string) string {
+	pkg := GoPackage(name)
+	if pkg == name {
+		return name
+	}
+	return GoModule(pkg)
+}
+
+// isMajorVersion reports whether elem is a major version suffix of a
+// module path, such as "v2".
+func isMajorVersion(elem string) bool {
+	if len(elem) < 2 || elem[0] != 'v' || elem == "v0" || elem == "v1" {
+		return false
+	}
+	for _, c := range elem[1:] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,165 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestGoPackageAndModule(t *testing.T) {
+	for _, tc := range []struct {
+		name, pkg, module string
+	}{
+		{"main.main", "main", "main"},
+		{"main.(*server).run.func1", "main", "main"},
+		{"runtime.mallocgc", "runtime", "std"},
+		{"net/http.(*Server).Serve", "net/http", "std"},
+		{"internal/poll.(*FD).Read", "internal/poll", "std"},
+		{"vendor/golang.org/x/net/http2/hpack.(*Decoder).Write", "vendor/golang.org/x/net/http2/hpack", "std"},
+		{"pproflame/internal/driver.(*WebInterface).Top", "pproflame/internal/driver", "pproflame"},
+		{"example/cmd/tool.Run", "example/cmd/tool", "example"},
+		{"github.com/foo/bar/pkg/x.(*T).M.func1", "github.com/foo/bar/pkg/x", "github.com/foo/bar"},
+		{"github.com/foo/bar/v2/x.F", "github.com/foo/bar/v2/x", "github.com/foo/bar/v2"},
+		{"golang.org/x/net/http2.(*Framer).ReadFrame", "golang.org/x/net/http2", "golang.org/x/net"},
+		{"go.uber.org/zap/zapcore.(*ioCore).Write", "go.uber.org/zap/zapcore", "go.uber.org/zap"},
+		{"example.com/m.Map[example.com/k.Key,int]", "example.com/m", "example.com/m"},
+		{"std::vector<int>::push_back(int)", "std::vector<int>::push_back(int)", "std::vector<int>::push_back(int)"},
+		{"__libc_start_main", "__libc_start_main", "__libc_start_main"},
+	} {
+		if got := GoPackage(tc.name); got != tc.pkg {
+			t.Errorf("GoPackage(%q) = %q, want %q", tc.name, got, tc.pkg)
+		}
+		if got := goModuleName(tc.name); got != tc.module {
+			t.Errorf("goModuleName(%q) = %q, want %q", tc.name, got, tc.module)
+		}
+	}
+}
+
+func TestStdModuleFocus(t *testing.T) {
+	focus := regexp.MustCompile(StdModuleFocus())
+	for name, want := range map[string]bool{
+		"runtime.mallocgc":              true,
+		"net/http.(*Server).Serve":      true,
+		"internal/poll.(*FD).Read":      true,
+		"main.main":                     false,
+		"pproflame/internal/driver.Top": false,
+		"github.com/foo/bar/api.Handle": false,
+		"runtimeextra.F":                false,
+	} {
+		if got := focus.MatchString(name); got != want {
+			t.Errorf("StdModuleFocus() matches %q: got %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestAggregateFrames(t *testing.T) {
+	m1 := &Mapping{ID: 1, Start: 0x1000, Limit: 0x2000, File: "/bin/server", HasFunctions: true, HasLineNumbers: true}
+	m2 := &Mapping{ID: 2, Start: 0x3000, Limit: 0x4000, File: "/lib/libc.so.6", HasFunctions: true}
+	function := func(id uint64, name string) *Function {
+		return &Function{ID: id, Name: name, SystemName: name, Filename: name + ".go"}
+	}
+	fMain := function(1, "main.main")
+	fServe := function(2, "net/http.(*Server).Serve")
+	fConn := function(3, "net/http.(*conn).serve")
+	fHandler := function(4, "github.com/foo/bar/api.Handle")
+	fStore := function(5, "github.com/foo/bar/store.Get")
+	fWrite := function(6, "write")
+	lMain := &Location{ID: 1, Mapping: m1, Address: 0x1010, Line: []Line{{Function: fMain, Line: 3}}}
+	lServe := &Location{ID: 2, Mapping: m1, Address: 0x1020, Line: []Line{{Function: fConn, Line: 8}, {Function: fServe, Line: 5}}}
+	lHandler := &Location{ID: 3, Mapping: m1, Address: 0x1030, Line: []Line{{Function: fStore, Line: 9}, {Function: fHandler, Line: 7}}}
+	lWrite := &Location{ID: 4, Mapping: m2, Address: 0x3010, Line: []Line{{Function: fWrite}}}
+	lUnknown := &Location{ID: 5, Address: 0x5000}
+	newProfile := func() *Profile {
+		return &Profile{
+			SampleType: []*ValueType{{Type: "samples", Unit: "count"}},
+			Sample: []*Sample{
+				{Location: []*Location{lWrite, lHandler, lServe, lMain}, Value: []int64{1}},
+				{Location: []*Location{lHandler, lServe, lMain}, Value: []int64{2}},
+				{Location: []*Location{lUnknown, lMain}, Value: []int64{4}},
+			},
+			Mapping:  []*Mapping{m1, m2},
+			Location: []*Location{lMain, lServe, lHandler, lWrite, lUnknown},
+			Function: []*Function{fMain, fServe, fConn, fHandler, fStore, fWrite},
+		}
+	}
+
+	for _, tc := range []struct {
+		name      string
+		aggregate func(*Profile) error
+		want      []string
+	}{
+		{
+			name:      "packages",
+			aggregate: (*Profile).AggregatePackages,
+			want: []string{
+				"write github.com/foo/bar/store github.com/foo/bar/api net/http main",
+				"github.com/foo/bar/store github.com/foo/bar/api net/http main",
+				"unknown main",
+			},
+		},
+		{
+			name:      "modules",
+			aggregate: (*Profile).AggregateModules,
+			want: []string{
+				"write github.com/foo/bar std main",
+				"github.com/foo/bar std main",
+				"unknown main",
+			},
+		},
+		{
+			name:      "mappings",
+			aggregate: (*Profile).AggregateMappings,
+			want: []string{
+				"libc.so.6 server",
+				"server",
+				"unknown server",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// The aggregation rewrites the locations of the samples, so
+			// use a fresh copy of the profile.
+			p := newProfile().Copy()
+			if err := tc.aggregate(p); err != nil {
+				t.Fatalf("aggregating: %v", err)
+			}
+			var got []string
+			for _, s := range p.Sample {
+				var names []string
+				for _, l := range s.Location {
+					if len(l.Line) != 1 {
+						t.Fatalf("location %d has %d lines, want 1", l.ID, len(l.Line))
+					}
+					names = append(names, l.Line[0].Function.Name)
+				}
+				got = append(got, strings.Join(names, " "))
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got stacks %q, want %q", got, tc.want)
+			}
+			if len(p.Location) != len(p.Function) {
+				t.Errorf("got %d locations and %d functions, want one location per function", len(p.Location), len(p.Function))
+			}
+			for _, m := range p.Mapping {
+				if m.HasLineNumbers || m.HasFilenames || m.HasInlineFrames {
+					t.Errorf("mapping %s still claims line information", m.File)
+				}
+			}
+		})
+	}
+}
@@ -275,15 +275,20 @@ func (p *Profile) postDecode() error {
 		} else {
 			l.Mapping = mappings[id]
 		}
-		l.mappingIDX = 0
+		// Keep unresolved IDs around so that Validate can report them.
+		if l.Mapping != nil {
+			l.mappingIDX = 0
+		}
 		for i, ln := range l.Line {
 			if id := ln.functionIDX; id != 0 {
-				l.Line[i].functionIDX = 0
 				if id < uint64(len(functionIds)) {
 					l.Line[i].Function = functionIds[id]
 				} else {
 					l.Line[i].Function = functions[id]
 				}
+				if l.Line[i].Function != nil {
+					l.Line[i].functionIDX = 0
+				}
 			}
 		}
 		if l.ID < uint64(len(locationIds)) {
@@ -333,14 +338,18 @@ func (p *Profile) postDecode() error {
 			s.NumUnit = numUnits
 		}
 		s.Location = make([]*Location, len(s.locationIDX))
+		resolved := true
 		for i, lid := range s.locationIDX {
 			if lid < uint64(len(locationIds)) {
 				s.Location[i] = locationIds[lid]
 			} else {
 				s.Location[i] = locations[lid]
 			}
+			resolved = resolved && s.Location[i] != nil
+		}
+		if resolved {
+			s.locationIDX = nil
 		}
-		s.locationIDX = nil
 	}
 
 	p.DropFrames, err = getString(p.stringTable, &p.dropFramesX, err)
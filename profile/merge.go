@@ -413,6 +413,7 @@ func combineHeaders(srcs []*Profile) (*Profile, error) {
 
 	var timeNanos, durationNanos, period int64
 	var comments []string
+	var repaired []error
 	seenComments := map[string]bool{}
 	var defaultSampleType string
 	for _, s := range srcs {
@@ -432,6 +433,7 @@ func combineHeaders(srcs []*Profile) (*Profile, error) {
 		if defaultSampleType == "" {
 			defaultSampleType = s.DefaultSampleType
 		}
+		repaired = append(repaired, s.repaired...)
 	}
 
 	p := &Profile{
@@ -447,6 +449,8 @@ func combineHeaders(srcs []*Profile) (*Profile, error) {
 
 		Comments:          comments,
 		DefaultSampleType: defaultSampleType,
+
+		repaired: repaired,
 	}
 	copy(p.SampleType, srcs[0].SampleType)
 	return p, nil
@@ -57,6 +57,12 @@ type Profile struct {
 	keepFramesX        int64
 	stringTable        []string
 	defaultSampleTypeX int64
+
+	// Problems found by Validate in the profile before Repair fixed
+	// them, kept through Copy and Merge.
+	repaired []error
+	// Rules applied by ApplyRules, kept through Copy.
+	rules []Rule
 }
 
 // ValueType corresponds to Profile.ValueType
@@ -787,6 +793,8 @@ func (p *Profile) Copy() *Profile {
 	if err := pp.postDecode(); err != nil {
 		panic(err)
 	}
+	pp.repaired = p.repaired
+	pp.rules = p.rules
 
 	return pp
 }
@@ -0,0 +1,140 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+// Implements redaction of sensitive data from profiles.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+// RedactOptions selects the data removed from a profile by Redact.
+type RedactOptions struct {
+	// Functions, Files and Mappings select the parts of function
+	// names, source file names and mapping file names to redact.
+	// Nil selects nothing.
+	Functions *regexp.Regexp
+	Files     *regexp.Regexp
+	Mappings  *regexp.Regexp
+	// Labels selects the keys of the string labels whose values are
+	// redacted. NumLabels selects the numeric labels whose keys and
+	// units are redacted; their values are kept. Nil selects none.
+	Labels    *regexp.Regexp
+	NumLabels *regexp.Regexp
+
+	// Replacement is substituted for each redacted part, and may
+	// refer to submatches as in regexp.Expand. If empty, each part is
+	// replaced by a hash of its text instead, so that equal names
+	// remain equal and different names remain different. Hashes keep
+	// the trailing slash of a redacted directory.
+	Replacement string
+	// Salt is mixed into the hashes so that names cannot be recovered
+	// by hashing guesses.
+	Salt string
+
+	// BuildIDs and Comments remove the build IDs of the mappings and
+	// the profile comments.
+	BuildIDs bool
+	Comments bool
+}
+
+// Redact removes sensitive data from a profile before sharing it,
+// according to the options. It only rewrites names, labels and
+// annotations, so the stacks and values of the redacted profile yield
+// the same analysis as the original profile.
+func Redact(p *Profile, o *RedactOptions) {
+	redact := func(rx *regexp.Regexp, s string) string {
+		if rx == nil || s == "" {
+			return s
+		}
+		if o.Replacement != "" {
+			return rx.ReplaceAllString(s, o.Replacement)
+		}
+		return rx.ReplaceAllStringFunc(s, o.hash)
+	}
+
+	for _, f := range p.Function {
+		f.Name = redact(o.Functions, f.Name)
+		f.SystemName = redact(o.Functions, f.SystemName)
+		f.Filename = redact(o.Files, f.Filename)
+	}
+	if o.Functions != nil {
+		// Frame filters may spell out function names.
+		p.DropFrames, p.KeepFrames = "", ""
+	}
+	for _, m := range p.Mapping {
+		m.File = redact(o.Mappings, m.File)
+		if o.BuildIDs {
+			m.BuildID = ""
+		}
+	}
+	for _, s := range p.Sample {
+		if o.Labels != nil {
+			for key, values := range s.Label {
+				if !o.Labels.MatchString(key) {
+					continue
+				}
+				redacted := make([]string, len(values))
+				for i, v := range values {
+					redacted[i] = redact(matchAll, v)
+				}
+				s.Label[key] = redacted
+			}
+		}
+		if o.NumLabels != nil && len(s.NumLabel) != 0 {
+			numLabel := make(map[string][]int64, len(s.NumLabel))
+			numUnit := make(map[string][]string, len(s.NumUnit))
+			for key, values := range s.NumLabel {
+				units, hasUnits := s.NumUnit[key]
+				if o.NumLabels.MatchString(key) {
+					key = redact(matchAll, key)
+					redacted := make([]string, len(units))
+					for i, u := range units {
+						redacted[i] = redact(matchAll, u)
+					}
+					units = redacted
+				}
+				numLabel[key] = values
+				if hasUnits {
+					numUnit[key] = units
+				}
+			}
+			s.NumLabel = numLabel
+			if s.NumUnit != nil {
+				s.NumUnit = numUnit
+			}
+		}
+	}
+	if o.Comments {
+		p.Comments = nil
+	}
+}
+
+var matchAll = regexp.MustCompile(`^(?s:.*)$`)
+
+// hash returns a short salted hash of s. A trailing slash is kept, so
+// that redacted directories still read as directories.
+func (o *RedactOptions) hash(s string) string {
+	dir := strings.HasSuffix(s, "/")
+	s = strings.TrimSuffix(s, "/")
+	sum := sha256.Sum256([]byte(o.Salt + s))
+	if dir {
+		return hex.EncodeToString(sum[:6]) + "/"
+	}
+	return hex.EncodeToString(sum[:6])
+}
@@ -0,0 +1,113 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	m := &Mapping{ID: 1, Start: 0x1000, Limit: 0x2000, File: "/home/alice/corp/bin/server", BuildID: "abc123"}
+	fs := []*Function{
+		{ID: 1, Name: "corp.com/billing.Charge", SystemName: "corp.com/billing.Charge", Filename: "/home/alice/corp/billing/charge.go"},
+		{ID: 2, Name: "corp.com/billing.Refund", SystemName: "corp.com/billing.Refund", Filename: "/home/alice/corp/billing/refund.go"},
+		{ID: 3, Name: "runtime.mallocgc", SystemName: "runtime.mallocgc", Filename: "/usr/lib/go/src/runtime/malloc.go"},
+	}
+	var locs []*Location
+	for i, f := range fs {
+		locs = append(locs, &Location{ID: uint64(i + 1), Mapping: m, Address: 0x1000 + uint64(i), Line: []Line{{Function: f, Line: 10}}})
+	}
+	p := &Profile{
+		SampleType: []*ValueType{{Type: "alloc_space", Unit: "bytes"}},
+		Sample: []*Sample{
+			{Location: []*Location{locs[2], locs[0]}, Value: []int64{100}, Label: map[string][]string{"host": {"db-7.corp"}, "mode": {"fast"}},
+				NumLabel: map[string][]int64{"host_db7_bytes": {512}}, NumUnit: map[string][]string{"host_db7_bytes": {"db7_bytes"}}},
+			{Location: []*Location{locs[2], locs[1]}, Value: []int64{200}, Label: map[string][]string{"host": {"db-8.corp"}},
+				NumLabel: map[string][]int64{"requests": {3}}},
+		},
+		Mapping:    []*Mapping{m},
+		Function:   fs,
+		Location:   locs,
+		Comments:   []string{"collected on db-7.corp"},
+		DropFrames: "corp.com/billing.Charge",
+	}
+	Redact(p, &RedactOptions{
+		Functions: regexp.MustCompile(`^corp\.com/.*`),
+		Files:     regexp.MustCompile(`^/home/alice/corp/`),
+		Mappings:  regexp.MustCompile(`.*`),
+		Labels:    regexp.MustCompile(`^host`),
+		NumLabels: regexp.MustCompile(`^host`),
+		Salt:      "s",
+		BuildIDs:  true,
+		Comments:  true,
+	})
+
+	if fs[0].Name == fs[1].Name || strings.Contains(fs[0].Name, "corp") || fs[0].Name != fs[0].SystemName {
+		t.Errorf("function names not hashed consistently: %q, %q", fs[0].Name, fs[1].Name)
+	}
+	if fs[2].Name != "runtime.mallocgc" || fs[2].Filename != "/usr/lib/go/src/runtime/malloc.go" {
+		t.Errorf("unselected function redacted: %v", fs[2])
+	}
+	if !strings.HasSuffix(fs[0].Filename, "billing/charge.go") || strings.Contains(fs[0].Filename, "alice") {
+		t.Errorf("file name: got %q", fs[0].Filename)
+	}
+	if strings.Contains(m.File, "server") || m.BuildID != "" {
+		t.Errorf("mapping: got file %q, build id %q", m.File, m.BuildID)
+	}
+	if h := p.Sample[0].Label["host"]; reflect.DeepEqual(h, []string{"db-7.corp"}) || reflect.DeepEqual(h, p.Sample[1].Label["host"]) {
+		t.Errorf("host labels: got %q and %q", h, p.Sample[1].Label["host"])
+	}
+	if got := p.Sample[0].Label["mode"]; !reflect.DeepEqual(got, []string{"fast"}) {
+		t.Errorf("unselected label redacted: %q", got)
+	}
+	for key, values := range p.Sample[0].NumLabel {
+		if strings.Contains(key, "db7") || !reflect.DeepEqual(values, []int64{512}) {
+			t.Errorf("numeric label: got %q: %v", key, values)
+		}
+		if units := p.Sample[0].NumUnit[key]; len(units) != 1 || strings.Contains(units[0], "db7") {
+			t.Errorf("numeric label units: got %q", units)
+		}
+	}
+	if got := p.Sample[1].NumLabel["requests"]; !reflect.DeepEqual(got, []int64{3}) {
+		t.Errorf("unselected numeric label redacted: %v", p.Sample[1].NumLabel)
+	}
+	if len(p.Comments) != 0 || p.DropFrames != "" {
+		t.Errorf("comments %q, drop frames %q left", p.Comments, p.DropFrames)
+	}
+	if err := p.CheckValid(); err != nil {
+		t.Fatalf("CheckValid: %v", err)
+	}
+
+	// The stacks and values are unchanged.
+	if len(p.Sample) != 2 || p.Sample[1].Value[0] != 200 || p.Sample[1].Location[1] != locs[1] {
+		t.Errorf("samples changed by Redact")
+	}
+
+	// Numeric labels are only redacted when selected by NumLabels.
+	s := &Sample{NumLabel: map[string][]int64{"timestamp": {5}}, NumUnit: map[string][]string{"timestamp": {"nanoseconds"}}}
+	Redact(&Profile{Sample: []*Sample{s}}, &RedactOptions{Labels: regexp.MustCompile(`.*`)})
+	if !reflect.DeepEqual(s.NumUnit, map[string][]string{"timestamp": {"nanoseconds"}}) {
+		t.Errorf("numeric labels redacted without NumLabels: %v", s.NumUnit)
+	}
+
+	// Replacement text is used instead of hashes.
+	Redact(p, &RedactOptions{Files: regexp.MustCompile(`^.*/`), Replacement: "<redacted>/"})
+	if fs[2].Filename != "<redacted>/malloc.go" {
+		t.Errorf("Replacement: got %q", fs[2].Filename)
+	}
+}
@@ -0,0 +1,414 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+// Implements validation and repair of malformed profiles.
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"sort"
+)
+
+// ParseLenient parses a profile like ParseData, but without rejecting
+// profiles that fail CheckValid. If the encoded protobuf is truncated
+// or corrupt, it keeps every message decoded before the damage and
+// treats missing strings as empty. The returned profile may reference
+// missing locations, so it should be checked with Validate or fixed with
+// Repair before being used. It returns an error only if nothing could be
+// salvaged from data. Legacy profile formats are parsed as in ParseData.
+func ParseLenient(data []byte) (*Profile, error) {
+	if len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b {
+		gz, err := gzip.NewReader(bytes.NewBuffer(data))
+		if err != nil {
+			return nil, fmt.Errorf("decompressing profile: %v", err)
+		}
+		// Keep whatever could be decompressed from a truncated stream.
+		data, _ = ioutil.ReadAll(gz)
+	}
+	if len(data) == 0 {
+		return nil, errNoData
+	}
+
+	p := &Profile{}
+	if err := unmarshal(data, p); err != nil {
+		if len(p.SampleType) == 0 && len(p.Sample) == 0 {
+			if lp, lerr := parseLegacy(data); lerr == nil {
+				return lp, nil
+			}
+			return nil, fmt.Errorf("parsing profile: %v", err)
+		}
+	}
+	if len(p.stringTable) == 0 {
+		p.stringTable = []string{""}
+	}
+	if n := p.maxStringIndex() + 1; n > int64(len(p.stringTable)) {
+		p.stringTable = padStringArray(p.stringTable, int(n))
+	}
+	if err := p.postDecode(); err != nil {
+		return nil, fmt.Errorf("parsing profile: %v", err)
+	}
+	return p, nil
+}
+
+// maxStringIndex returns the largest string table index referenced by
+// the undecoded fields of the profile.
+func (p *Profile) maxStringIndex() int64 {
+	var max int64
+	use := func(xs ...int64) {
+		for _, x := range xs {
+			if x > max {
+				max = x
+			}
+		}
+	}
+	for _, st := range p.SampleType {
+		use(st.typeX, st.unitX)
+	}
+	if pt := p.PeriodType; pt != nil {
+		use(pt.typeX, pt.unitX)
+	}
+	for _, s := range p.Sample {
+		for _, l := range s.labelX {
+			use(l.keyX, l.strX, l.unitX)
+		}
+	}
+	for _, m := range p.Mapping {
+		use(m.fileX, m.buildIDX)
+	}
+	for _, f := range p.Function {
+		use(f.nameX, f.systemNameX, f.filenameX)
+	}
+	use(p.commentX...)
+	use(p.dropFramesX, p.keepFramesX, p.defaultSampleTypeX)
+	return max
+}
+
+// Validate checks the profile for structural problems and returns all
+// of them, unlike CheckValid, which stops at the first one. Besides the
+// problems detected by CheckValid, it reports references to missing
+// locations, functions or mappings, and mappings with overlapping
+// address ranges.
+func (p *Profile) Validate() []error {
+	var errs []error
+	report := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	sampleLen := len(p.SampleType)
+	if sampleLen == 0 && len(p.Sample) != 0 {
+		report("missing sample type information")
+	}
+	for i, s := range p.Sample {
+		if s == nil {
+			report("sample #%d: nil sample", i)
+			continue
+		}
+		if sampleLen != 0 && len(s.Value) != sampleLen {
+			report("sample #%d: mismatch: sample has %d values vs. %d types", i, len(s.Value), sampleLen)
+		}
+		for j, l := range s.Location {
+			if l != nil {
+				continue
+			}
+			if j < len(s.locationIDX) {
+				report("sample #%d: dangling location id %d", i, s.locationIDX[j])
+			} else {
+				report("sample #%d: nil location", i)
+			}
+		}
+	}
+
+	mappings := make(map[uint64]*Mapping, len(p.Mapping))
+	for _, m := range p.Mapping {
+		switch {
+		case m == nil:
+			report("profile has nil mapping")
+		case m.ID == 0:
+			report("found mapping with reserved ID=0")
+		case mappings[m.ID] != nil:
+			report("multiple mappings with same id: %d", m.ID)
+		default:
+			mappings[m.ID] = m
+		}
+		if m != nil && m.Limit < m.Start {
+			report("mapping %d: limit %#x is below start %#x", m.ID, m.Limit, m.Start)
+		}
+	}
+	for _, o := range overlappingMappings(p.Mapping) {
+		report("mappings %d [%#x-%#x) and %d [%#x-%#x) overlap",
+			o[0].ID, o[0].Start, o[0].Limit, o[1].ID, o[1].Start, o[1].Limit)
+	}
+
+	functions := make(map[uint64]*Function, len(p.Function))
+	for _, f := range p.Function {
+		switch {
+		case f == nil:
+			report("profile has nil function")
+		case f.ID == 0:
+			report("found function with reserved ID=0")
+		case functions[f.ID] != nil:
+			report("multiple functions with same id: %d", f.ID)
+		default:
+			functions[f.ID] = f
+		}
+	}
+
+	locations := make(map[uint64]*Location, len(p.Location))
+	for _, l := range p.Location {
+		switch {
+		case l == nil:
+			report("profile has nil location")
+			continue
+		case l.ID == 0:
+			report("found location with reserved id=0")
+		case locations[l.ID] != nil:
+			report("multiple locations with same id: %d", l.ID)
+		default:
+			locations[l.ID] = l
+		}
+		if m := l.Mapping; m != nil {
+			if m.ID == 0 || mappings[m.ID] != m {
+				report("location %d: inconsistent mapping %d", l.ID, m.ID)
+			}
+		} else if l.mappingIDX != 0 {
+			report("location %d: dangling mapping id %d", l.ID, l.mappingIDX)
+		}
+		for _, ln := range l.Line {
+			if f := ln.Function; f != nil {
+				if f.ID == 0 || functions[f.ID] != f {
+					report("location %d: inconsistent function %d", l.ID, f.ID)
+				}
+			} else if ln.functionIDX != 0 {
+				report("location %d: dangling function id %d", l.ID, ln.functionIDX)
+			}
+		}
+	}
+	return errs
+}
+
+// RepairedProblems returns the problems that Validate found in the
+// profile, or in the profiles merged into it, before Repair fixed them.
+func (p *Profile) RepairedProblems() []error {
+	return p.repaired
+}
+
+// overlappingMappings returns the pairs of mappings whose address
+// ranges intersect. Mappings with an empty range are ignored.
+func overlappingMappings(ms []*Mapping) [][2]*Mapping {
+	var sorted []*Mapping
+	for _, m := range ms {
+		if m != nil && m.Limit > m.Start {
+			sorted = append(sorted, m)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Start < sorted[j].Start
+	})
+	var overlaps [][2]*Mapping
+	for i, m := range sorted {
+		for _, next := range sorted[i+1:] {
+			if next.Start >= m.Limit {
+				break
+			}
+			overlaps = append(overlaps, [2]*Mapping{m, next})
+		}
+	}
+	return overlaps
+}
+
+// Repair fixes the structural problems in a profile that can be fixed
+// without guessing: it drops nil entries and references to missing
+// locations, functions and mappings, pads or truncates sample values to
+// the number of sample types, assigns fresh IDs to entries with a zero
+// or duplicate ID, and adds mappings and functions referenced by
+// locations to the profile tables. Overlapping mappings are left as they
+// are. Repair returns a description of each change made, and an error if
+// the profile is still not valid afterwards. The problems Validate found
+// before the repair and no longer finds after it are kept, see
+// RepairedProblems.
+func Repair(p *Profile) ([]string, error) {
+	before := p.Validate()
+	fixes, err := repair(p)
+	remaining := make(map[string]int)
+	for _, e := range p.Validate() {
+		remaining[e.Error()]++
+	}
+	for _, e := range before {
+		if remaining[e.Error()] > 0 {
+			remaining[e.Error()]--
+			continue
+		}
+		p.repaired = append(p.repaired, e)
+	}
+	return fixes, err
+}
+
+// repair makes the changes of Repair.
+func repair(p *Profile) ([]string, error) {
+	var fixes []string
+	fixed := func(format string, args ...interface{}) {
+		fixes = append(fixes, fmt.Sprintf(format, args...))
+	}
+
+	// Samples.
+	sampleLen := len(p.SampleType)
+	samples := p.Sample[:0]
+	for i, s := range p.Sample {
+		if s == nil {
+			fixed("sample #%d: dropped nil sample", i)
+			continue
+		}
+		if sampleLen != 0 && len(s.Value) != sampleLen {
+			fixed("sample #%d: resized values from %d to %d", i, len(s.Value), sampleLen)
+			v := make([]int64, sampleLen)
+			copy(v, s.Value)
+			s.Value = v
+		}
+		locs := s.Location[:0]
+		for j, l := range s.Location {
+			if l != nil {
+				locs = append(locs, l)
+				continue
+			}
+			if j < len(s.locationIDX) {
+				fixed("sample #%d: dropped dangling location id %d", i, s.locationIDX[j])
+			} else {
+				fixed("sample #%d: dropped nil location", i)
+			}
+		}
+		s.Location = locs
+		s.locationIDX = nil
+		samples = append(samples, s)
+	}
+	p.Sample = samples
+
+	// Mappings.
+	var maxMappingID uint64
+	for _, m := range p.Mapping {
+		if m != nil && m.ID > maxMappingID {
+			maxMappingID = m.ID
+		}
+	}
+	mappings := make(map[uint64]*Mapping, len(p.Mapping))
+	addMapping := func(m *Mapping, what string) {
+		if m.ID == 0 || mappings[m.ID] != nil && mappings[m.ID] != m {
+			maxMappingID++
+			fixed("%s mapping %d: assigned id %d", what, m.ID, maxMappingID)
+			m.ID = maxMappingID
+		}
+		mappings[m.ID] = m
+	}
+	ms := p.Mapping[:0]
+	for _, m := range p.Mapping {
+		if m == nil {
+			fixed("dropped nil mapping")
+			continue
+		}
+		addMapping(m, "renumbered")
+		ms = append(ms, m)
+	}
+	p.Mapping = ms
+
+	// Functions.
+	var maxFunctionID uint64
+	for _, f := range p.Function {
+		if f != nil && f.ID > maxFunctionID {
+			maxFunctionID = f.ID
+		}
+	}
+	functions := make(map[uint64]*Function, len(p.Function))
+	addFunction := func(f *Function, what string) {
+		if f.ID == 0 || functions[f.ID] != nil && functions[f.ID] != f {
+			maxFunctionID++
+			fixed("%s function %d: assigned id %d", what, f.ID, maxFunctionID)
+			f.ID = maxFunctionID
+		}
+		functions[f.ID] = f
+	}
+	fs := p.Function[:0]
+	for _, f := range p.Function {
+		if f == nil {
+			fixed("dropped nil function")
+			continue
+		}
+		addFunction(f, "renumbered")
+		fs = append(fs, f)
+	}
+	p.Function = fs
+
+	// Locations, including those referenced by samples but missing
+	// from the table.
+	inTable := make(map[*Location]bool, len(p.Location))
+	for _, l := range p.Location {
+		inTable[l] = true
+	}
+	for _, s := range p.Sample {
+		for _, l := range s.Location {
+			if !inTable[l] {
+				fixed("added location %d referenced by samples", l.ID)
+				inTable[l] = true
+				p.Location = append(p.Location, l)
+			}
+		}
+	}
+	var maxLocationID uint64
+	for _, l := range p.Location {
+		if l != nil && l.ID > maxLocationID {
+			maxLocationID = l.ID
+		}
+	}
+	locations := make(map[uint64]*Location, len(p.Location))
+	ls := p.Location[:0]
+	for _, l := range p.Location {
+		if l == nil {
+			fixed("dropped nil location")
+			continue
+		}
+		if l.ID == 0 || locations[l.ID] != nil {
+			maxLocationID++
+			fixed("renumbered location %d: assigned id %d", l.ID, maxLocationID)
+			l.ID = maxLocationID
+		}
+		locations[l.ID] = l
+		ls = append(ls, l)
+
+		if m := l.Mapping; m != nil {
+			if mappings[m.ID] != m {
+				addMapping(m, "added")
+				p.Mapping = append(p.Mapping, m)
+			}
+		} else if l.mappingIDX != 0 {
+			fixed("location %d: dropped dangling mapping id %d", l.ID, l.mappingIDX)
+		}
+		l.mappingIDX = 0
+		for i, ln := range l.Line {
+			if f := ln.Function; f != nil {
+				if functions[f.ID] != f {
+					addFunction(f, "added")
+					p.Function = append(p.Function, f)
+				}
+			} else if ln.functionIDX != 0 {
+				fixed("location %d: dropped dangling function id %d", l.ID, ln.functionIDX)
+			}
+			l.Line[i].functionIDX = 0
+		}
+	}
+	p.Location = ls
+
+	return fixes, p.CheckValid()
+}
@@ -0,0 +1,216 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// danglingProfile returns an encoded profile whose location 2 refers to
+// a mapping and a function missing from the profile tables, and whose
+// second sample refers to a missing location.
+func danglingProfile(t *testing.T) []byte {
+	m1 := &Mapping{ID: 1, Start: 0x1000, Limit: 0x2000, File: "/bin/main"}
+	m2 := &Mapping{ID: 7, Start: 0x3000, Limit: 0x4000, File: "/lib/missing.so"}
+	f1 := &Function{ID: 1, Name: "main"}
+	f2 := &Function{ID: 9, Name: "missing"}
+	l1 := &Location{ID: 1, Mapping: m1, Address: 0x1010, Line: []Line{{Function: f1, Line: 3}}}
+	l2 := &Location{ID: 2, Mapping: m2, Address: 0x3010, Line: []Line{{Function: f2, Line: 5}}}
+	l3 := &Location{ID: 5, Mapping: m1, Address: 0x1020}
+	p := &Profile{
+		SampleType: []*ValueType{{Type: "samples", Unit: "count"}, {Type: "cpu", Unit: "nanoseconds"}},
+		Sample: []*Sample{
+			{Location: []*Location{l2, l1}, Value: []int64{1, 10}},
+			{Location: []*Location{l3, l1}, Value: []int64{2}},
+		},
+		Mapping:  []*Mapping{m1},
+		Function: []*Function{f1},
+		Location: []*Location{l1, l2},
+	}
+	var buf bytes.Buffer
+	if err := p.WriteUncompressed(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestValidate(t *testing.T) {
+	data := danglingProfile(t)
+	if _, err := ParseData(data); err == nil {
+		t.Fatalf("ParseData: want error for malformed profile")
+	}
+	p, err := ParseLenient(data)
+	if err != nil {
+		t.Fatalf("ParseLenient: %v", err)
+	}
+	var got []string
+	for _, e := range p.Validate() {
+		got = append(got, e.Error())
+	}
+	want := []string{
+		"sample #1: mismatch: sample has 1 values vs. 2 types",
+		"sample #1: dangling location id 5",
+		"location 2: dangling mapping id 7",
+		"location 2: dangling function id 9",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Validate() got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestValidateOverlapAndIDs(t *testing.T) {
+	m1 := &Mapping{ID: 1, Start: 0x1000, Limit: 0x3000}
+	m2 := &Mapping{ID: 1, Start: 0x2000, Limit: 0x4000}
+	f := &Function{ID: 0, Name: "f"}
+	p := &Profile{
+		Mapping:  []*Mapping{m1, m2},
+		Function: []*Function{f},
+		Location: []*Location{{ID: 1, Mapping: m1, Line: []Line{{Function: f}}}},
+	}
+	var got []string
+	for _, e := range p.Validate() {
+		got = append(got, e.Error())
+	}
+	want := []string{
+		"multiple mappings with same id: 1",
+		"mappings 1 [0x1000-0x3000) and 1 [0x2000-0x4000) overlap",
+		"found function with reserved ID=0",
+		"location 1: inconsistent function 0",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Validate() got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	fixes, err := Repair(p)
+	if err != nil {
+		t.Fatalf("Repair: %v", err)
+	}
+	if len(fixes) != 2 {
+		t.Errorf("Repair: got fixes %q, want 2", fixes)
+	}
+	if m2.ID == m1.ID || f.ID == 0 {
+		t.Errorf("Repair: IDs not reassigned: mappings %d, %d, function %d", m1.ID, m2.ID, f.ID)
+	}
+}
+
+func TestRepair(t *testing.T) {
+	p, err := ParseLenient(danglingProfile(t))
+	if err != nil {
+		t.Fatalf("ParseLenient: %v", err)
+	}
+	fixes, err := Repair(p)
+	if err != nil {
+		t.Fatalf("Repair: %v", err)
+	}
+	want := []string{
+		"sample #1: resized values from 1 to 2",
+		"sample #1: dropped dangling location id 5",
+		"location 2: dropped dangling mapping id 7",
+		"location 2: dropped dangling function id 9",
+	}
+	if strings.Join(fixes, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Repair() got:\n%s\nwant:\n%s", strings.Join(fixes, "\n"), strings.Join(want, "\n"))
+	}
+	if errs := p.Validate(); len(errs) != 0 {
+		t.Errorf("Validate() after Repair: %v", errs)
+	}
+	// The repaired profile must survive an encoding round trip.
+	var buf bytes.Buffer
+	if err := p.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseData(buf.Bytes()); err != nil {
+		t.Errorf("ParseData of repaired profile: %v", err)
+	}
+}
+
+func TestParseLenientTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testProfile1.WriteUncompressed(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	// Drop the string table and everything after it.
+	truncated := data[:len(data)/2]
+	if _, err := ParseData(truncated); err == nil {
+		t.Fatalf("ParseData: want error for truncated profile")
+	}
+	p, err := ParseLenient(truncated)
+	if err != nil {
+		t.Fatalf("ParseLenient: %v", err)
+	}
+	if _, err := Repair(p); err != nil {
+		t.Fatalf("Repair: %v", err)
+	}
+	if len(p.Sample) == 0 {
+		t.Errorf("no samples salvaged from truncated profile")
+	}
+}
+
+func TestRepairedProblems(t *testing.T) {
+	p, err := ParseLenient(danglingProfile(t))
+	if err != nil {
+		t.Fatalf("ParseLenient: %v", err)
+	}
+	if _, err := Repair(p); err != nil {
+		t.Fatalf("Repair: %v", err)
+	}
+	// The problems outlive the copies and merges of the profile loading.
+	merged, err := Merge([]*Profile{p.Copy()})
+	if err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	var got []string
+	for _, e := range merged.Copy().RepairedProblems() {
+		got = append(got, e.Error())
+	}
+	want := []string{
+		"sample #1: mismatch: sample has 1 values vs. 2 types",
+		"sample #1: dangling location id 5",
+		"location 2: dangling mapping id 7",
+		"location 2: dangling function id 9",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("RepairedProblems() got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if errs := merged.Validate(); len(errs) != 0 {
+		t.Errorf("Validate() after Repair: %v", errs)
+	}
+
+	// Overlapping mappings are left as they are, so they are not
+	// repaired problems.
+	m1 := &Mapping{ID: 1, Start: 0x1000, Limit: 0x3000}
+	m2 := &Mapping{ID: 2, Start: 0x2000, Limit: 0x4000}
+	l := &Location{ID: 1, Mapping: m1, Address: 0x1010}
+	p = &Profile{
+		SampleType: []*ValueType{{Type: "samples", Unit: "count"}},
+		Sample:     []*Sample{{Location: []*Location{l}, Value: []int64{1, 2}}},
+		Mapping:    []*Mapping{m1, m2},
+		Location:   []*Location{l},
+	}
+	Repair(p)
+	got = nil
+	for _, e := range p.RepairedProblems() {
+		got = append(got, e.Error())
+	}
+	if want := "sample #0: mismatch: sample has 2 values vs. 1 types"; strings.Join(got, "\n") != want {
+		t.Errorf("RepairedProblems() with overlapping mappings got:\n%s\nwant:\n%s", strings.Join(got, "\n"), want)
+	}
+	if errs := p.Validate(); len(errs) != 1 {
+		t.Errorf("Validate() after Repair: got %v, want the overlapping mappings", errs)
+	}
+}
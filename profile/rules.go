@@ -0,0 +1,219 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+// Implements declarative rules to rewrite stacks and labels.
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// Rule actions.
+const (
+	// RuleRename replaces the matches of Match in function names with
+	// Replace, which may refer to submatches as in regexp.Expand.
+	RuleRename = "rename"
+	// RuleCollapse collapses runs of consecutive frames matching Match
+	// into the outermost frame of the run.
+	RuleCollapse = "collapse"
+	// RuleDrop removes the frames matching Match from every stack.
+	RuleDrop = "drop"
+	// RuleLabel replaces the matches of Match in the values of the
+	// string label Label with Replace. Values left empty are removed.
+	RuleLabel = "label"
+)
+
+// Rule is a regexp-based rewrite of the stacks or labels of a profile.
+type Rule struct {
+	Action  string `json:"action"`
+	Match   string `json:"match"`
+	Replace string `json:"replace,omitempty"`
+	Label   string `json:"label,omitempty"`
+}
+
+// ParseRules parses a list of rules encoded as a JSON array, and checks
+// that they are well formed.
+func ParseRules(data []byte) ([]Rule, error) {
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("parsing rules: %v", err)
+	}
+	if err := CheckRules(rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// CheckRules checks that the rules are well formed.
+func CheckRules(rules []Rule) error {
+	_, err := compileRules(rules)
+	return err
+}
+
+type compiledRule struct {
+	Rule
+	rx *regexp.Regexp
+}
+
+func compileRules(rules []Rule) ([]compiledRule, error) {
+	compiled := make([]compiledRule, 0, len(rules))
+	for i, r := range rules {
+		switch r.Action {
+		case RuleRename, RuleCollapse, RuleDrop:
+		case RuleLabel:
+			if r.Label == "" {
+				return nil, fmt.Errorf("rule %d: missing label key", i)
+			}
+		default:
+			return nil, fmt.Errorf("rule %d: unknown action %q", i, r.Action)
+		}
+		if r.Match == "" {
+			return nil, fmt.Errorf("rule %d: missing match regexp", i)
+		}
+		rx, err := regexp.Compile(r.Match)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: parsing match regexp: %v", i, err)
+		}
+		compiled = append(compiled, compiledRule{r, rx})
+	}
+	return compiled, nil
+}
+
+// ApplyRules rewrites the profile with each of the rules in turn, so a
+// rule sees the function names and labels produced by the rules before
+// it. Frames are matched on their function name.
+func (p *Profile) ApplyRules(rules []Rule) error {
+	compiled, err := compileRules(rules)
+	if err != nil {
+		return err
+	}
+	for _, r := range compiled {
+		switch r.Action {
+		case RuleRename:
+			p.renameFunctions(r.rx, r.Replace)
+		case RuleCollapse:
+			p.collapseFrames(r.rx)
+		case RuleDrop:
+			p.dropFrames(r.rx)
+		case RuleLabel:
+			p.rewriteLabel(r.Label, r.rx, r.Replace)
+		}
+	}
+	p.rules = append(p.rules[:len(p.rules):len(p.rules)], rules...)
+	return nil
+}
+
+// AppliedRules returns the rules ApplyRules rewrote the profile with,
+// so that other profiles can be rewritten the same way to compare them.
+func (p *Profile) AppliedRules() []Rule {
+	return p.rules
+}
+
+func (p *Profile) renameFunctions(rx *regexp.Regexp, replace string) {
+	for _, f := range p.Function {
+		if rx.MatchString(f.Name) {
+			f.Name = rx.ReplaceAllString(f.Name, replace)
+		}
+	}
+}
+
+// collapseFrames keeps only the outermost location of each run of
+// consecutive locations in a sample whose frames all match rx.
+func (p *Profile) collapseFrames(rx *regexp.Regexp) {
+	matches := make(map[*Location]bool, len(p.Location))
+	for _, l := range p.Location {
+		matches[l] = len(l.Line) > 0
+		for _, ln := range l.Line {
+			if ln.Function == nil || !rx.MatchString(ln.Function.Name) {
+				matches[l] = false
+				break
+			}
+		}
+	}
+	for _, s := range p.Sample {
+		locs := s.Location[:0]
+		for i, l := range s.Location {
+			// Locations are ordered leaf first, so a matching location
+			// is kept only if its caller does not match too.
+			if !matches[l] || i+1 == len(s.Location) || !matches[s.Location[i+1]] {
+				locs = append(locs, l)
+			}
+		}
+		s.Location = locs
+	}
+}
+
+// dropFrames removes the frames matching rx from every location, and
+// the locations left without frames from every sample.
+func (p *Profile) dropFrames(rx *regexp.Regexp) {
+	dropped := make(map[*Location]bool)
+	locs := p.Location[:0]
+	for _, l := range p.Location {
+		if len(l.Line) == 0 {
+			locs = append(locs, l)
+			continue
+		}
+		lines := l.Line[:0]
+		for _, ln := range l.Line {
+			if ln.Function == nil || !rx.MatchString(ln.Function.Name) {
+				lines = append(lines, ln)
+			}
+		}
+		l.Line = lines
+		if len(lines) == 0 {
+			dropped[l] = true
+			continue
+		}
+		locs = append(locs, l)
+	}
+	p.Location = locs
+	if len(dropped) == 0 {
+		return
+	}
+	for _, s := range p.Sample {
+		sl := s.Location[:0]
+		for _, l := range s.Location {
+			if !dropped[l] {
+				sl = append(sl, l)
+			}
+		}
+		s.Location = sl
+	}
+}
+
+func (p *Profile) rewriteLabel(key string, rx *regexp.Regexp, replace string) {
+	for _, s := range p.Sample {
+		values, ok := s.Label[key]
+		if !ok {
+			continue
+		}
+		var rewritten []string
+		for _, v := range values {
+			if rx.MatchString(v) {
+				v = rx.ReplaceAllString(v, replace)
+			}
+			if v != "" {
+				rewritten = append(rewritten, v)
+			}
+		}
+		if len(rewritten) == 0 {
+			delete(s.Label, key)
+			continue
+		}
+		s.Label[key] = rewritten
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func rulesTestProfile() *Profile {
+	fns := map[string]*Function{}
+	var p Profile
+	loc := func(names ...string) *Location {
+		l := &Location{ID: uint64(len(p.Location) + 1)}
+		for _, n := range names {
+			f := fns[n]
+			if f == nil {
+				f = &Function{ID: uint64(len(p.Function) + 1), Name: n}
+				fns[n] = f
+				p.Function = append(p.Function, f)
+			}
+			l.Line = append(l.Line, Line{Function: f})
+		}
+		p.Location = append(p.Location, l)
+		return l
+	}
+	main := loc("main.main")
+	handler := loc("main.handle.func1.2")
+	w1, w2 := loc("vendor/rpc.(*Server).call"), loc("vendor/rpc.wrap")
+	gen := loc("main.work", "pb.Unmarshal")
+	p.SampleType = []*ValueType{{Type: "cpu", Unit: "nanoseconds"}}
+	p.Sample = []*Sample{
+		{Location: []*Location{gen, handler, w1, w2, main}, Value: []int64{1},
+			Label: map[string][]string{"path": {"/users/42"}}},
+		{Location: []*Location{w1, main}, Value: []int64{2},
+			Label: map[string][]string{"path": {"/health"}}},
+	}
+	return &p
+}
+
+func stacks(p *Profile) []string {
+	var got []string
+	for _, s := range p.Sample {
+		var frames []string
+		for _, l := range s.Location {
+			var names []string
+			for _, ln := range l.Line {
+				names = append(names, ln.Function.Name)
+			}
+			frames = append(frames, strings.Join(names, "+"))
+		}
+		got = append(got, strings.Join(frames, " "))
+	}
+	return got
+}
+
+func TestApplyRules(t *testing.T) {
+	rules, err := ParseRules([]byte(`[
+		{"action": "rename", "match": "\\.func\\d+(\\.\\d+)*$", "replace": ".func"},
+		{"action": "rename", "match": "^vendor/rpc\\..*", "replace": "rpc"},
+		{"action": "collapse", "match": "^rpc$"},
+		{"action": "drop", "match": "^pb\\."},
+		{"action": "label", "label": "path", "match": "/\\d+$", "replace": "/:id"},
+		{"action": "label", "label": "path", "match": "^/health$", "replace": ""}
+	]`))
+	if err != nil {
+		t.Fatalf("ParseRules: %v", err)
+	}
+	p := rulesTestProfile()
+	if err := p.ApplyRules(rules); err != nil {
+		t.Fatalf("ApplyRules: %v", err)
+	}
+	want := []string{
+		"main.work main.handle.func rpc main.main",
+		"rpc main.main",
+	}
+	if got := stacks(p); !reflect.DeepEqual(got, want) {
+		t.Errorf("stacks: got %q, want %q", got, want)
+	}
+	if got := p.Sample[0].Label["path"]; !reflect.DeepEqual(got, []string{"/users/:id"}) {
+		t.Errorf("label path: got %q, want /users/:id", got)
+	}
+	if _, ok := p.Sample[1].Label["path"]; ok {
+		t.Errorf("label path: want emptied label removed, got %q", p.Sample[1].Label["path"])
+	}
+	if err := p.CheckValid(); err != nil {
+		t.Errorf("CheckValid after ApplyRules: %v", err)
+	}
+}
+
+func TestDropWholeLocation(t *testing.T) {
+	p := rulesTestProfile()
+	if err := p.ApplyRules([]Rule{{Action: RuleDrop, Match: "^main\\.main$"}}); err != nil {
+		t.Fatalf("ApplyRules: %v", err)
+	}
+	for _, l := range p.Location {
+		if len(l.Line) == 0 {
+			t.Errorf("location %d left without frames", l.ID)
+		}
+	}
+	want := []string{
+		"main.work+pb.Unmarshal main.handle.func1.2 vendor/rpc.(*Server).call vendor/rpc.wrap",
+		"vendor/rpc.(*Server).call",
+	}
+	if got := stacks(p); !reflect.DeepEqual(got, want) {
+		t.Errorf("stacks: got %q, want %q", got, want)
+	}
+}
+
+func TestParseRulesErrors(t *testing.T) {
+	for _, tc := range []struct {
+		rules, err string
+	}{
+		{`[{"action": "explode", "match": "x"}]`, `rule 0: unknown action "explode"`},
+		{`[{"action": "drop"}]`, "rule 0: missing match regexp"},
+		{`[{"action": "rename", "match": "("}]`, "rule 0: parsing match regexp"},
+		{`[{"action": "drop", "match": "x"}, {"action": "label", "match": "x"}]`, "rule 1: missing label key"},
+		{`{"action": "drop"}`, "parsing rules"},
+	} {
+		_, err := ParseRules([]byte(tc.rules))
+		if err == nil || !strings.HasPrefix(err.Error(), tc.err) {
+			t.Errorf("ParseRules(%s): got error %v, want %q", tc.rules, err, tc.err)
+		}
+	}
+}
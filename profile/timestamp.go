@@ -0,0 +1,121 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+// Implements per-sample timestamps and time-based filtering.
+
+import (
+	"time"
+)
+
+// TimestampLabel is the numeric label key holding the time at which a
+// sample was collected. Its unit may be nanoseconds (the default when no
+// unit is recorded), microseconds, milliseconds or seconds since the
+// Unix epoch. Since it is an ordinary numeric label, samples taken at
+// different times are kept apart by Merge and Compact.
+const TimestampLabel = "timestamp"
+
+// Timestamp returns the time at which the sample was collected, in
+// nanoseconds since the Unix epoch, and whether the sample has a
+// timestamp at all.
+func (s *Sample) Timestamp() (int64, bool) {
+	vals := s.NumLabel[TimestampLabel]
+	if len(vals) == 0 {
+		return 0, false
+	}
+	var unit string
+	if units := s.NumUnit[TimestampLabel]; len(units) > 0 {
+		unit = units[0]
+	}
+	switch unit {
+	case "", "ns", "nanosecond", "nanoseconds":
+		return vals[0], true
+	case "us", "microsecond", "microseconds":
+		return vals[0] * int64(time.Microsecond), true
+	case "ms", "millisecond", "milliseconds":
+		return vals[0] * int64(time.Millisecond), true
+	case "s", "second", "seconds":
+		return vals[0] * int64(time.Second), true
+	}
+	return 0, false
+}
+
+// SetTimestamp records the time at which the sample was collected,
+// replacing any existing timestamp.
+func (s *Sample) SetTimestamp(nanos int64) {
+	if s.NumLabel == nil {
+		s.NumLabel = make(map[string][]int64)
+	}
+	if s.NumUnit == nil {
+		s.NumUnit = make(map[string][]string)
+	}
+	s.NumLabel[TimestampLabel] = []int64{nanos}
+	s.NumUnit[TimestampLabel] = []string{"nanoseconds"}
+}
+
+// HasTimestamps returns true if at least one sample in the profile has
+// a timestamp.
+func (p *Profile) HasTimestamps() bool {
+	for _, s := range p.Sample {
+		if _, ok := s.Timestamp(); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// TimeRange returns the earliest and latest sample timestamps in the
+// profile, in nanoseconds since the Unix epoch. It returns false if no
+// sample has a timestamp.
+func (p *Profile) TimeRange() (start, end int64, ok bool) {
+	for _, s := range p.Sample {
+		t, has := s.Timestamp()
+		if !has {
+			continue
+		}
+		if !ok || t < start {
+			start = t
+		}
+		if !ok || t > end {
+			end = t
+		}
+		ok = true
+	}
+	return start, end, ok
+}
+
+// SliceTime removes all samples from the profile except those with a
+// timestamp in the half-open interval [from, to). A zero from or to
+// leaves that side of the interval unbounded. Samples without a
+// timestamp are always removed. Returns true if at least one sample
+// was kept.
+func (p *Profile) SliceTime(from, to time.Time) (matched bool) {
+	samples := make([]*Sample, 0, len(p.Sample))
+	for _, s := range p.Sample {
+		t, ok := s.Timestamp()
+		if !ok {
+			continue
+		}
+		if !from.IsZero() && t < from.UnixNano() {
+			continue
+		}
+		if !to.IsZero() && t >= to.UnixNano() {
+			continue
+		}
+		samples = append(samples, s)
+	}
+	p.Sample = samples
+	return len(samples) > 0
+}
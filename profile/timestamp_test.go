@@ -0,0 +1,124 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func timestampedProfile() *Profile {
+	m := &Mapping{ID: 1, Start: 0x1000, Limit: 0x2000, File: "/bin/main"}
+	f := &Function{ID: 1, Name: "main"}
+	l := &Location{ID: 1, Mapping: m, Address: 0x1010, Line: []Line{{Function: f, Line: 3}}}
+	p := &Profile{
+		SampleType: []*ValueType{{Type: "samples", Unit: "count"}},
+		Mapping:    []*Mapping{m},
+		Function:   []*Function{f},
+		Location:   []*Location{l},
+	}
+	for _, ts := range []struct {
+		value int64
+		unit  string
+	}{
+		{1000000000, ""},
+		{2000000, "microseconds"},
+		{3000, "milliseconds"},
+		{4, "seconds"},
+	} {
+		p.Sample = append(p.Sample, &Sample{
+			Location: []*Location{l},
+			Value:    []int64{1},
+			NumLabel: map[string][]int64{TimestampLabel: {ts.value}},
+			NumUnit:  map[string][]string{TimestampLabel: {ts.unit}},
+		})
+	}
+	p.Sample = append(p.Sample, &Sample{
+		Location: []*Location{l},
+		Value:    []int64{1},
+	})
+	return p
+}
+
+func sampleTimestamps(p *Profile) []int64 {
+	var got []int64
+	for _, s := range p.Sample {
+		if t, ok := s.Timestamp(); ok {
+			got = append(got, t)
+		}
+	}
+	return got
+}
+
+func TestTimestamp(t *testing.T) {
+	p := timestampedProfile()
+	want := []int64{1e9, 2e9, 3e9, 4e9}
+	if got := sampleTimestamps(p); !reflect.DeepEqual(got, want) {
+		t.Errorf("timestamps: got %v, want %v", got, want)
+	}
+	start, end, ok := p.TimeRange()
+	if !ok || start != 1e9 || end != 4e9 {
+		t.Errorf("TimeRange() = %d, %d, %v; want %d, %d, true", start, end, ok, int64(1e9), int64(4e9))
+	}
+
+	// Timestamps are numeric labels, so they must survive merging.
+	merged := p.Compact()
+	if got := sampleTimestamps(merged); !reflect.DeepEqual(got, want) {
+		t.Errorf("timestamps after Compact: got %v, want %v", got, want)
+	}
+}
+
+func TestSliceTime(t *testing.T) {
+	for _, tc := range []struct {
+		desc     string
+		from, to time.Time
+		want     []int64
+	}{
+		{
+			desc: "unbounded keeps timestamped samples",
+			want: []int64{1e9, 2e9, 3e9, 4e9},
+		},
+		{
+			desc: "half open interval",
+			from: time.Unix(2, 0),
+			to:   time.Unix(4, 0),
+			want: []int64{2e9, 3e9},
+		},
+		{
+			desc: "open upper bound",
+			from: time.Unix(3, 0),
+			want: []int64{3e9, 4e9},
+		},
+		{
+			desc: "no samples in range",
+			from: time.Unix(10, 0),
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			p := timestampedProfile()
+			matched := p.SliceTime(tc.from, tc.to)
+			if got := sampleTimestamps(p); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+			if len(p.Sample) != len(tc.want) {
+				t.Errorf("got %d samples, want %d", len(p.Sample), len(tc.want))
+			}
+			if matched != (len(tc.want) > 0) {
+				t.Errorf("matched = %v, want %v", matched, len(tc.want) > 0)
+			}
+		})
+	}
+}